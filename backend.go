@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/stores"
+
+	// Pure Go SQLite driver for the sql backend
+	_ "modernc.org/sqlite"
+)
+
+// Store backends selectable with STORE_BACKEND
+const (
+	backendMemory = "memory"
+	backendDisk   = "disk"
+	backendSQL    = "sql"
+)
+
+// backend holds the stores selected by configuration
+type backend struct {
+	bookStore     interfaces.BookStore
+	authorStore   interfaces.AuthorStore
+	customerStore interfaces.CustomerStore
+	orderStore    interfaces.OrderStore
+
+	// persistence saves the in-memory stores in the background; it is nil
+	// for backends that write every change through to disk
+	persistence *stores.PersistenceManager
+
+	// archive backs up and restores all stores at a single point in time
+	archive *stores.Archive
+
+	// gate brackets every write to the stores
+	gate *stores.WriteGate
+
+	close func() error
+}
+
+// openBackend creates the stores for the configured backend, enforcing the
+// references between them
+func openBackend(cfg config) (*backend, error) {
+	var b *backend
+	var err error
+	switch cfg.StoreBackend {
+	case backendMemory:
+		b, err = openMemoryBackend(cfg)
+	case backendDisk:
+		b, err = openDiskBackend(cfg)
+	case backendSQL:
+		b, err = openSQLBackend(cfg)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.StoreBackend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Orders take their books out of stock. Stock is checked after the
+	// references, so an order for a missing book is reported as such.
+	b.orderStore = stores.ReserveStockOrderStore(b.orderStore, b.bookStore, b.gate)
+
+	// Integrity checks sit above the gate, so each store write a cascade
+	// makes passes through it
+	integrity := stores.NewIntegrity(cfg.Integrity, b.bookStore, b.authorStore, b.customerStore, b.orderStore)
+	b.bookStore = integrity.BookStore()
+	b.authorStore = integrity.AuthorStore()
+	b.customerStore = integrity.CustomerStore()
+	b.orderStore = integrity.OrderStore()
+
+	// The lifecycle wraps the stores above, so status changes pass through
+	// the integrity checks and restocking through the gate
+	b.orderStore = stores.LifecycleOrderStore(b.orderStore, b.bookStore, b.gate)
+
+	// Pricing comes last, so line edits are held to the lifecycle and
+	// reserve stock like any other update
+	b.orderStore = stores.PricingOrderStore(b.orderStore, b.bookStore, b.authorStore)
+	return b, nil
+}
+
+// openMemoryBackend creates in-memory stores loaded from the database file,
+// with a write-ahead log and background snapshots
+func openMemoryBackend(cfg config) (*backend, error) {
+	bookStore := stores.NewInMemoryBookStore()
+	authorStore := stores.NewInMemoryAuthorStore()
+	customerStore := stores.NewInMemoryCustomerStore()
+	orderStore := stores.NewInMemoryOrderStore()
+
+	// Load data from persistence if it exists
+	sequence, err := stores.LoadDatabase(cfg.DatabaseFile, bookStore, authorStore, customerStore, orderStore)
+	if err != nil {
+		if errors.Is(err, stores.ErrUnsupportedVersion) || errors.Is(err, stores.ErrWALMismatch) {
+			return nil, fmt.Errorf("cannot load %s: %w", cfg.DatabaseFile, err)
+		}
+		log.Printf("Warning: Failed to load database: %v", err)
+	}
+
+	// Log every mutation before it is applied
+	wal, err := stores.OpenWAL(stores.WALFilename(cfg.DatabaseFile), sequence)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+
+	// Save in the background on an interval and after bursts of writes;
+	// each successful snapshot truncates the write-ahead log. Writes are
+	// held off only while the stores are captured, not while the file is
+	// written.
+	gate := stores.NewWriteGate()
+	persistence := stores.NewPersistenceManager(func() error {
+		return wal.Checkpoint(func() error {
+			var data stores.DatabaseData
+			err := gate.Exclusive(func() error {
+				var err error
+				data, err = stores.CaptureDatabase(bookStore, authorStore, customerStore, orderStore)
+				data.Sequence = wal.Sequence()
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return stores.WriteDatabase(cfg.DatabaseFile, data)
+		})
+	}, cfg.AutosaveInterval, cfg.AutosaveMutations)
+
+	mutationHook := stores.ChainMutationHooks(wal.Append, persistence.Notify)
+	bookStore.SetMutationHook(mutationHook)
+	authorStore.SetMutationHook(mutationHook)
+	customerStore.SetMutationHook(mutationHook)
+	orderStore.SetMutationHook(mutationHook)
+
+	// Restores bypass the mutation hooks, so save them right away
+	archive := stores.NewArchive(gate, bookStore, authorStore, customerStore, orderStore)
+	archive.AfterRestore = persistence.SaveNow
+
+	b := newGatedBackend(gate, archive, bookStore, authorStore, customerStore, orderStore)
+	b.persistence = persistence
+	b.close = wal.Close
+	return b, nil
+}
+
+// openDiskBackend creates stores kept in the embedded key-value database
+func openDiskBackend(cfg config) (*backend, error) {
+	db, err := kvstore.Open(cfg.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using disk store %s", cfg.StorePath)
+
+	bookStore := stores.NewDiskBookStore(db)
+	authorStore := stores.NewDiskAuthorStore(db)
+	customerStore := stores.NewDiskCustomerStore(db)
+	orderStore := stores.NewDiskOrderStore(db)
+
+	gate := stores.NewWriteGate()
+	archive := stores.NewArchive(gate, bookStore, authorStore, customerStore, orderStore)
+	b := newGatedBackend(gate, archive, bookStore, authorStore, customerStore, orderStore)
+	b.close = db.Close
+	return b, nil
+}
+
+// openSQLBackend creates stores in an SQLite database file, applying any
+// pending schema migrations first
+func openSQLBackend(cfg config) (*backend, error) {
+	// WAL journaling lets reports read while orders are written; the busy
+	// timeout makes concurrent writers wait for each other instead of failing.
+	// Transactions take the write lock when they begin, since one that reads
+	// before writing cannot wait for a lock taken in between.
+	dsn := "file:" + cfg.StorePath + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQL database %s: %w", cfg.StorePath, err)
+	}
+
+	applied, err := stores.MigrateSQL(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if applied > 0 {
+		log.Printf("Applied %d SQL schema migrations", applied)
+	}
+	log.Printf("Using SQL store %s", cfg.StorePath)
+
+	bookStore := stores.NewSQLBookStore(db)
+	authorStore := stores.NewSQLAuthorStore(db)
+	customerStore := stores.NewSQLCustomerStore(db)
+	orderStore := stores.NewSQLOrderStore(db)
+
+	gate := stores.NewWriteGate()
+	archive := stores.NewArchive(gate, bookStore, authorStore, customerStore, orderStore)
+	b := newGatedBackend(gate, archive, bookStore, authorStore, customerStore, orderStore)
+	b.close = db.Close
+	return b, nil
+}
+
+// newGatedBackend routes every write through gate, so the archive can take
+// and restore backups that are consistent across stores
+func newGatedBackend(
+	gate *stores.WriteGate,
+	archive *stores.Archive,
+	bookStore interfaces.BookStore,
+	authorStore interfaces.AuthorStore,
+	customerStore interfaces.CustomerStore,
+	orderStore interfaces.OrderStore,
+) *backend {
+	return &backend{
+		bookStore:     stores.GateBookStore(bookStore, gate),
+		authorStore:   stores.GateAuthorStore(authorStore, gate),
+		customerStore: stores.GateCustomerStore(customerStore, gate),
+		orderStore:    stores.GateOrderStore(orderStore, gate),
+		archive:       archive,
+		gate:          gate,
+	}
+}
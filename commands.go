@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"online-bookstore-api/stores"
+	"os"
+)
+
+// commandUsage describes the maintenance subcommands
+const commandUsage = `Usage: bookstore [command]
+
+Without a command the API server is started.
+
+Commands:
+  migrate [-file path]   rewrite a database file in the latest format
+  check [-file path]     report references to missing records and unknown order
+                         statuses in a database file
+`
+
+// runCommand runs the maintenance subcommand in args. It returns false when
+// args name no command and the server should start.
+func runCommand(cfg config, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "migrate":
+		return true, runMigrate(cfg, args[1:])
+	case "check":
+		return true, runCheck(cfg, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(commandUsage)
+		return true, nil
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return true, fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runMigrate upgrades a database file to the current format version
+func runMigrate(cfg config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	file := flags.String("file", cfg.DatabaseFile, "database file to migrate")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	storedVersion, err := stores.MigrateDatabaseFile(*file)
+	if err != nil {
+		return err
+	}
+	if storedVersion == stores.DatabaseVersion {
+		fmt.Printf("%s is already at format version %d\n", *file, stores.DatabaseVersion)
+		return nil
+	}
+	fmt.Printf("Migrated %s from format version %d to %d (previous file kept as %s)\n",
+		*file, storedVersion, stores.DatabaseVersion, stores.BackupFilename(*file))
+	return nil
+}
+
+// runCheck loads a database file, including its pending write-ahead log
+// records, and lists the references to records that do not exist and the
+// orders whose status is not a lifecycle status. It fails when any are
+// found, so it can gate scripts.
+func runCheck(cfg config, args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	file := flags.String("file", cfg.DatabaseFile, "database file to check")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// LoadDatabase starts empty stores for a missing file
+	if _, err := os.Stat(*file); err != nil {
+		return err
+	}
+	bookStore := stores.NewInMemoryBookStore()
+	authorStore := stores.NewInMemoryAuthorStore()
+	customerStore := stores.NewInMemoryCustomerStore()
+	orderStore := stores.NewInMemoryOrderStore()
+	if _, err := stores.LoadDatabase(*file, bookStore, authorStore, customerStore, orderStore); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	dangling, err := stores.CheckIntegrity(ctx, bookStore, authorStore, customerStore, orderStore)
+	if err != nil {
+		return err
+	}
+	unknown, err := stores.CheckOrderStatuses(ctx, orderStore)
+	if err != nil {
+		return err
+	}
+	if len(dangling) == 0 && len(unknown) == 0 {
+		fmt.Printf("%s has no dangling references or unknown order statuses\n", *file)
+		return nil
+	}
+	for _, ref := range dangling {
+		fmt.Println(ref)
+	}
+	for _, order := range unknown {
+		fmt.Println(order)
+	}
+	return fmt.Errorf("%s has %d dangling references and %d orders with unknown statuses", *file, len(dangling), len(unknown))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"online-bookstore-api/reports"
+	"online-bookstore-api/stores"
+	"os"
+	"strconv"
+	"time"
+)
+
+// config holds runtime settings read from the environment
+type config struct {
+	StoreBackend string
+	StorePath    string
+
+	DatabaseFile      string
+	AutosaveInterval  time.Duration
+	AutosaveMutations int
+
+	Integrity stores.IntegrityPolicy
+
+	AdminToken string
+
+	ReportDir       string
+	ReportInterval  time.Duration
+	ReportCompare   reports.Comparison
+	ReportBasis     reports.Basis
+	ReportRetention reports.RetentionPolicy
+}
+
+// loadConfig reads configuration from environment variables, applying defaults
+func loadConfig() (config, error) {
+	cfg := config{
+		StoreBackend:      backendMemory,
+		DatabaseFile:      "database.json",
+		AutosaveInterval:  stores.DefaultAutosaveInterval,
+		AutosaveMutations: stores.DefaultAutosaveMutations,
+		Integrity:         stores.DefaultIntegrityPolicy,
+		ReportDir:         reports.DefaultOutputDir,
+		ReportInterval:    reports.DefaultInterval,
+		ReportRetention: reports.RetentionPolicy{
+			KeepDays:  30,
+			KeepWeeks: 12,
+		},
+	}
+
+	if backend := os.Getenv("STORE_BACKEND"); backend != "" {
+		cfg.StoreBackend = backend
+	}
+	switch cfg.StoreBackend {
+	case backendMemory:
+	case backendDisk:
+		cfg.StorePath = "bookstore.db"
+	case backendSQL:
+		cfg.StorePath = "bookstore.sqlite"
+	default:
+		return config{}, fmt.Errorf("STORE_BACKEND must be %q, %q or %q, got %q",
+			backendMemory, backendDisk, backendSQL, cfg.StoreBackend)
+	}
+	if path := os.Getenv("STORE_PATH"); path != "" {
+		cfg.StorePath = path
+	}
+
+	if file := os.Getenv("DATABASE_FILE"); file != "" {
+		cfg.DatabaseFile = file
+	}
+
+	if err := durationFromEnv("AUTOSAVE_INTERVAL", &cfg.AutosaveInterval); err != nil {
+		return config{}, err
+	}
+	if err := intFromEnv("AUTOSAVE_MUTATIONS", &cfg.AutosaveMutations); err != nil {
+		return config{}, err
+	}
+	if cfg.AutosaveInterval <= 0 || cfg.AutosaveMutations <= 0 {
+		return config{}, fmt.Errorf("AUTOSAVE_INTERVAL and AUTOSAVE_MUTATIONS must be positive")
+	}
+
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+
+	for name, action := range map[string]*stores.DeleteAction{
+		"ON_DELETE_AUTHOR":   &cfg.Integrity.AuthorBooks,
+		"ON_DELETE_CUSTOMER": &cfg.Integrity.CustomerOrders,
+		"ON_DELETE_BOOK":     &cfg.Integrity.BookOrders,
+	} {
+		if err := deleteActionFromEnv(name, action); err != nil {
+			return config{}, err
+		}
+	}
+
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		cfg.ReportDir = dir
+	}
+
+	if err := durationFromEnv("REPORT_INTERVAL", &cfg.ReportInterval); err != nil {
+		return config{}, err
+	}
+	// Report file names have minute resolution
+	if cfg.ReportInterval < time.Minute {
+		return config{}, fmt.Errorf("REPORT_INTERVAL must be at least 1m, got %v", cfg.ReportInterval)
+	}
+
+	compare, err := reports.ParseComparison(os.Getenv("REPORT_COMPARE"))
+	if err != nil {
+		return config{}, fmt.Errorf("invalid REPORT_COMPARE: %w", err)
+	}
+	cfg.ReportCompare = compare
+
+	basis, err := reports.ParseBasis(os.Getenv("REPORT_BASIS"))
+	if err != nil {
+		return config{}, fmt.Errorf("invalid REPORT_BASIS: %w", err)
+	}
+	cfg.ReportBasis = basis
+
+	if err := intFromEnv("REPORT_KEEP_DAYS", &cfg.ReportRetention.KeepDays); err != nil {
+		return config{}, err
+	}
+	if err := intFromEnv("REPORT_KEEP_WEEKS", &cfg.ReportRetention.KeepWeeks); err != nil {
+		return config{}, err
+	}
+	if err := cfg.ReportRetention.Validate(); err != nil {
+		return config{}, fmt.Errorf("invalid report retention: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// intFromEnv overrides *value with the integer in the named variable, if set
+func intFromEnv(name string, value *int) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	*value = parsed
+	return nil
+}
+
+// durationFromEnv overrides *value with the duration in the named variable, if set
+func durationFromEnv(name string, value *time.Duration) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	*value = parsed
+	return nil
+}
+
+// deleteActionFromEnv overrides *value with the delete action in the named variable, if set
+func deleteActionFromEnv(name string, value *stores.DeleteAction) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	parsed, err := stores.ParseDeleteAction(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*value = parsed
+	return nil
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"online-bookstore-api/stores"
+	"strings"
+	"time"
+)
+
+// maxRestoreSize limits the size of an uploaded backup
+const maxRestoreSize = 256 << 20
+
+// GetPersistenceStatus handles GET /admin/persistence, reporting the last
+// successful save, the last save error and the number of unsaved mutations
+func (h *Handler) GetPersistenceStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if h.Persistence == nil {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Persistence monitoring is not enabled")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.Persistence.PersistenceStatus())
+}
+
+// authorizeBackup reports whether a backup or restore request may go ahead,
+// responding with an error when it may not. Backups are disabled unless an
+// admin token is configured, and requests must send it as a bearer token.
+func (h *Handler) authorizeBackup(w http.ResponseWriter, r *http.Request) bool {
+	if h.Archive == nil || h.AdminToken == "" {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Backups are not enabled")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		respondWithErrorCode(w, http.StatusUnauthorized, CodeUnauthorized, "A valid admin token is required")
+		return false
+	}
+	return true
+}
+
+// GetBackup handles GET /admin/backup, streaming a snapshot of every store
+// taken at a single point in time. The optional entities parameter limits
+// the export to a comma-separated list of collections, e.g. books,authors.
+func (h *Handler) GetBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !h.authorizeBackup(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	if checkContext(ctx, w) {
+		return
+	}
+
+	var entities []string
+	if value := r.URL.Query().Get("entities"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				entities = append(entities, strings.TrimSuffix(name, "s"))
+			}
+		}
+	}
+
+	backup, err := h.Archive.Backup(entities)
+	if errors.Is(err, stores.ErrInvalidBackup) {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBackup, err.Error())
+		return
+	}
+	if err != nil {
+		LogError("GetBackup", "Failed to capture backup", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to capture backup")
+		return
+	}
+
+	filename := fmt.Sprintf("bookstore-backup-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	if _, err := backup.WriteTo(w); err != nil {
+		// The status is already sent; the client sees a truncated body
+		LogError("GetBackup", "Failed to write backup", err)
+	}
+}
+
+// RestoreBackup handles POST /admin/restore, loading a backup produced by
+// GET /admin/backup. The mode parameter is "replace" (default), which
+// replaces every store, or "merge", which only imports records whose IDs
+// are not in use.
+func (h *Handler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !h.authorizeBackup(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	if checkContext(ctx, w) {
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxRestoreSize)
+	result, err := h.Archive.Restore(body, r.URL.Query().Get("mode"))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Backup is too large")
+		return
+	case errors.Is(err, stores.ErrInvalidBackup):
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBackup, err.Error())
+		return
+	case err != nil:
+		LogError("RestoreBackup", "Failed to restore backup", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to restore backup")
+		return
+	}
+
+	LogEvent("DATABASE_RESTORED", "Backup restored", map[string]interface{}{
+		"mode":     result.Mode,
+		"imported": result.Imported,
+		"skipped":  result.Skipped,
+	})
+	respondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"strings"
+	"testing"
+)
+
+func TestBackupAuthorization(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "no admin token", authorization: "Bearer ", wantStatus: http.StatusNotFound, wantCode: CodeNotEnabled},
+		{name: "no credentials", token: "secret", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer guess", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "basic credentials", token: "secret", authorization: "Basic secret", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "admin token", token: "secret", authorization: "Bearer secret", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			h.AdminToken = tt.token
+
+			w := serve(h, http.MethodGet, "/admin/backup", "", "Authorization", tt.authorization)
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+
+			w = serve(h, http.MethodPost, "/admin/restore?mode=merge", `{"version": 2, "authors": {}}`, "Authorization", tt.authorization)
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+		})
+	}
+}
+
+func TestGetBackup(t *testing.T) {
+	h := newTestHandler(t)
+	h.AdminToken = "secret"
+	placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 1}]`)
+
+	w := serve(h, http.MethodGet, "/admin/backup", "", "Authorization", "Bearer secret")
+	wantStatus(t, w, http.StatusOK, "")
+	body := w.Body.String()
+	if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment; filename=\"bookstore-backup-") {
+		t.Errorf("Content-Disposition = %q, want a backup attachment", disposition)
+	}
+	backup := decode[stores.DatabaseData](t, w)
+	if want := []string{stores.EntityAuthor, stores.EntityBook, stores.EntityCustomer, stores.EntityOrder}; strings.Join(backup.Entities, ",") != strings.Join(want, ",") {
+		t.Errorf("backup collections = %v, want %v", backup.Entities, want)
+	}
+	if backup.NextIDs[stores.EntityOrder] != 2 {
+		t.Errorf("next order ID = %d, want 2", backup.NextIDs[stores.EntityOrder])
+	}
+
+	// The backup restores as it was taken
+	w = serve(h, http.MethodPost, "/admin/restore", body, "Authorization", "Bearer secret")
+	if w.Code != http.StatusOK {
+		t.Errorf("restoring the backup status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func TestRestoreBackupIntegrity(t *testing.T) {
+	h := newTestHandler(t)
+	h.AdminToken = "secret"
+
+	backup := `{"version": 2, "authors": {}, "customers": {}, "orders": {},
+		"books": {"1": {"id": 1, "title": "Orphan", "author": {"id": 9}, "price": 5}}}`
+	w := serve(h, http.MethodPost, "/admin/restore", backup, "Authorization", "Bearer secret")
+	wantStatus(t, w, http.StatusBadRequest, CodeInvalidBackup)
+
+	// The replace is rolled back
+	w = serve(h, http.MethodGet, "/books/1", "")
+	if book := decode[models.Book](t, w); book.Title != "Notes" {
+		t.Errorf("book 1 = %q after a rejected restore, want \"Notes\"", book.Title)
+	}
+	w = serve(h, http.MethodGet, "/authors/1", "")
+	wantStatus(t, w, http.StatusOK, "")
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"online-bookstore-api/models"
-	"strings"
 	"time"
 )
 
@@ -25,13 +24,20 @@ func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
 
 	var author models.Author
 	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate required fields
-	if author.FirstName == "" || author.LastName == "" {
-		respondWithError(w, http.StatusBadRequest, "First name and last name are required")
+	var fields []models.FieldError
+	if author.FirstName == "" {
+		fields = append(fields, requiredField("first_name", "First name is required"))
+	}
+	if author.LastName == "" {
+		fields = append(fields, requiredField("last_name", "Last name is required"))
+	}
+	if len(fields) > 0 {
+		respondWithValidationError(w, "First name and last name are required", fields...)
 		return
 	}
 
@@ -39,10 +45,9 @@ func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createdAuthor, err := h.AuthorStore.CreateAuthor(author)
+	createdAuthor, err := h.AuthorStore.CreateAuthor(ctx, author)
 	if err != nil {
-		LogError("CreateAuthor", "Failed to create author", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create author")
+		respondWithStoreError(w, "CreateAuthor", err, "Failed to create author")
 		return
 	}
 
@@ -64,19 +69,13 @@ func (h *Handler) GetAuthor(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/authors/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid author ID")
 		return
 	}
 
-	author, err := h.AuthorStore.GetAuthor(id)
+	author, err := h.AuthorStore.GetAuthor(ctx, id)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("GetAuthor", "Author not found", map[string]interface{}{"author_id": id})
-			respondWithError(w, http.StatusNotFound, "Author not found")
-		} else {
-			LogError("GetAuthor", "Failed to retrieve author", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve author")
-		}
+		respondWithStoreError(w, "GetAuthor", err, "Failed to retrieve author")
 		return
 	}
 
@@ -99,13 +98,13 @@ func (h *Handler) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/authors/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid author ID")
 		return
 	}
 
 	var author models.Author
 	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -113,15 +112,9 @@ func (h *Handler) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedAuthor, err := h.AuthorStore.UpdateAuthor(id, author)
+	updatedAuthor, err := h.AuthorStore.UpdateAuthor(ctx, id, author)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("UpdateAuthor", "Author not found", map[string]interface{}{"author_id": id})
-			respondWithError(w, http.StatusNotFound, "Author not found")
-		} else {
-			LogError("UpdateAuthor", "Failed to update author", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to update author")
-		}
+		respondWithStoreError(w, "UpdateAuthor", err, "Failed to update author")
 		return
 	}
 
@@ -147,7 +140,7 @@ func (h *Handler) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/authors/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid author ID")
 		return
 	}
 
@@ -155,14 +148,8 @@ func (h *Handler) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.AuthorStore.DeleteAuthor(id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("DeleteAuthor", "Author not found", map[string]interface{}{"author_id": id})
-			respondWithError(w, http.StatusNotFound, "Author not found")
-		} else {
-			LogError("DeleteAuthor", "Failed to delete author", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to delete author")
-		}
+	if err := h.AuthorStore.DeleteAuthor(ctx, id); err != nil {
+		respondWithStoreError(w, "DeleteAuthor", err, "Failed to delete author")
 		return
 	}
 
@@ -182,10 +169,9 @@ func (h *Handler) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authors, err := h.AuthorStore.GetAllAuthors()
+	authors, err := h.AuthorStore.GetAllAuthors(ctx)
 	if err != nil {
-		LogError("GetAllAuthors", "Failed to retrieve authors", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve authors")
+		respondWithStoreError(w, "GetAllAuthors", err, "Failed to retrieve authors")
 		return
 	}
 
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"online-bookstore-api/models"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -26,13 +25,20 @@ func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
 
 	var book models.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate required fields
+	var fields []models.FieldError
 	if book.Title == "" {
-		respondWithError(w, http.StatusBadRequest, "Title is required")
+		fields = append(fields, requiredField("title", "Title is required"))
+	}
+	if book.Author.ID == 0 {
+		fields = append(fields, requiredField("author.id", "Author is required"))
+	}
+	if len(fields) > 0 {
+		respondWithValidationError(w, fields[0].Message, fields...)
 		return
 	}
 
@@ -40,10 +46,9 @@ func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createdBook, err := h.BookStore.CreateBook(book)
+	createdBook, err := h.BookStore.CreateBook(ctx, book)
 	if err != nil {
-		LogError("CreateBook", "Failed to create book", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create book")
+		respondWithStoreError(w, "CreateBook", err, "Failed to create book")
 		return
 	}
 
@@ -65,19 +70,13 @@ func (h *Handler) GetBook(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/books/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid book ID")
 		return
 	}
 
-	book, err := h.BookStore.GetBook(id)
+	book, err := h.BookStore.GetBook(ctx, id)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("GetBook", "Book not found", map[string]interface{}{"book_id": id})
-			respondWithError(w, http.StatusNotFound, "Book not found")
-		} else {
-			LogError("GetBook", "Failed to retrieve book", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve book")
-		}
+		respondWithStoreError(w, "GetBook", err, "Failed to retrieve book")
 		return
 	}
 
@@ -87,31 +86,25 @@ func (h *Handler) GetBook(w http.ResponseWriter, r *http.Request) {
 // UpdateBook handles PUT /books/{id}
 func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	id, err := extractID(r.URL.Path, "/books/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid book ID")
 		return
 	}
 
 	var book models.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	updatedBook, err := h.BookStore.UpdateBook(id, book)
+	updatedBook, err := h.BookStore.UpdateBook(r.Context(), id, book)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("UpdateBook", "Book not found", map[string]interface{}{"book_id": id})
-			respondWithError(w, http.StatusNotFound, "Book not found")
-		} else {
-			LogError("UpdateBook", "Failed to update book", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to update book")
-		}
+		respondWithStoreError(w, "UpdateBook", err, "Failed to update book")
 		return
 	}
 
@@ -135,7 +128,7 @@ func (h *Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/books/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid book ID")
 		return
 	}
 
@@ -143,14 +136,8 @@ func (h *Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.BookStore.DeleteBook(id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("DeleteBook", "Book not found", map[string]interface{}{"book_id": id})
-			respondWithError(w, http.StatusNotFound, "Book not found")
-		} else {
-			LogError("DeleteBook", "Failed to delete book", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to delete book")
-		}
+	if err := h.BookStore.DeleteBook(ctx, id); err != nil {
+		respondWithStoreError(w, "DeleteBook", err, "Failed to delete book")
 		return
 	}
 
@@ -185,16 +172,19 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Price bounds are in currency, the default currency when not given
+	currency := r.URL.Query().Get("currency")
+
 	// Parse min_price
 	if minPriceStr := r.URL.Query().Get("min_price"); minPriceStr != "" {
-		if minPrice, err := strconv.ParseFloat(minPriceStr, 64); err == nil {
+		if minPrice, err := models.ParseMoney(minPriceStr, currency); err == nil {
 			criteria.MinPrice = minPrice
 		}
 	}
 
 	// Parse max_price
 	if maxPriceStr := r.URL.Query().Get("max_price"); maxPriceStr != "" {
-		if maxPrice, err := strconv.ParseFloat(maxPriceStr, 64); err == nil {
+		if maxPrice, err := models.ParseMoney(maxPriceStr, currency); err == nil {
 			criteria.MaxPrice = maxPrice
 		}
 	}
@@ -206,11 +196,10 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 
 	// If no search criteria provided, return all books
 	if criteria.Title == "" && criteria.AuthorID == 0 && criteria.Genre == "" &&
-		criteria.MinPrice == 0 && criteria.MaxPrice == 0 {
-		books, err := h.BookStore.GetAllBooks()
+		criteria.MinPrice.IsZero() && criteria.MaxPrice.IsZero() {
+		books, err := h.BookStore.GetAllBooks(ctx)
 		if err != nil {
-			LogError("SearchBooks", "Failed to retrieve all books", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve books")
+			respondWithStoreError(w, "SearchBooks", err, "Failed to retrieve books")
 			return
 		}
 		LogInfo("SearchBooks", "Retrieved all books", map[string]interface{}{"count": len(books)})
@@ -218,10 +207,9 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	books, err := h.BookStore.SearchBooks(criteria)
+	books, err := h.BookStore.SearchBooks(ctx, criteria)
 	if err != nil {
-		LogError("SearchBooks", "Failed to search books", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to search books")
+		respondWithStoreError(w, "SearchBooks", err, "Failed to search books")
 		return
 	}
 	
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"online-bookstore-api/models"
-	"strings"
 	"time"
 )
 
@@ -25,13 +24,20 @@ func (h *Handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
 
 	var customer models.Customer
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate required fields
-	if customer.Name == "" || customer.Email == "" {
-		respondWithError(w, http.StatusBadRequest, "Name and email are required")
+	var fields []models.FieldError
+	if customer.Name == "" {
+		fields = append(fields, requiredField("name", "Name is required"))
+	}
+	if customer.Email == "" {
+		fields = append(fields, requiredField("email", "Email is required"))
+	}
+	if len(fields) > 0 {
+		respondWithValidationError(w, "Name and email are required", fields...)
 		return
 	}
 
@@ -44,10 +50,9 @@ func (h *Handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createdCustomer, err := h.CustomerStore.CreateCustomer(customer)
+	createdCustomer, err := h.CustomerStore.CreateCustomer(ctx, customer)
 	if err != nil {
-		LogError("CreateCustomer", "Failed to create customer", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create customer")
+		respondWithStoreError(w, "CreateCustomer", err, "Failed to create customer")
 		return
 	}
 
@@ -58,19 +63,19 @@ func (h *Handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
 // GetCustomer handles GET /customers/{id}
 func (h *Handler) GetCustomer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	id, err := extractID(r.URL.Path, "/customers/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid customer ID")
 		return
 	}
 
-	customer, err := h.CustomerStore.GetCustomer(id)
+	customer, err := h.CustomerStore.GetCustomer(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+		respondWithStoreError(w, "GetCustomer", err, "Failed to retrieve customer")
 		return
 	}
 
@@ -93,13 +98,13 @@ func (h *Handler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/customers/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid customer ID")
 		return
 	}
 
 	var customer models.Customer
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -107,15 +112,9 @@ func (h *Handler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedCustomer, err := h.CustomerStore.UpdateCustomer(id, customer)
+	updatedCustomer, err := h.CustomerStore.UpdateCustomer(ctx, id, customer)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("UpdateCustomer", "Customer not found", map[string]interface{}{"customer_id": id})
-			respondWithError(w, http.StatusNotFound, "Customer not found")
-		} else {
-			LogError("UpdateCustomer", "Failed to update customer", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to update customer")
-		}
+		respondWithStoreError(w, "UpdateCustomer", err, "Failed to update customer")
 		return
 	}
 
@@ -142,7 +141,7 @@ func (h *Handler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/customers/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid customer ID")
 		return
 	}
 
@@ -150,14 +149,8 @@ func (h *Handler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.CustomerStore.DeleteCustomer(id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("DeleteCustomer", "Customer not found", map[string]interface{}{"customer_id": id})
-			respondWithError(w, http.StatusNotFound, "Customer not found")
-		} else {
-			LogError("DeleteCustomer", "Failed to delete customer", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to delete customer")
-		}
+	if err := h.CustomerStore.DeleteCustomer(ctx, id); err != nil {
+		respondWithStoreError(w, "DeleteCustomer", err, "Failed to delete customer")
 		return
 	}
 
@@ -177,10 +170,9 @@ func (h *Handler) GetAllCustomers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customers, err := h.CustomerStore.GetAllCustomers()
+	customers, err := h.CustomerStore.GetAllCustomers(ctx)
 	if err != nil {
-		LogError("GetAllCustomers", "Failed to retrieve customers", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve customers")
+		respondWithStoreError(w, "GetAllCustomers", err, "Failed to retrieve customers")
 		return
 	}
 
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"strings"
+)
+
+// Error codes sent in ErrorResponse.Code. Clients should branch on these
+// rather than on the message, which may change.
+const (
+	CodeBadRequest        = "bad_request"
+	CodeInvalidBody       = "invalid_body"
+	CodeInvalidID         = "invalid_id"
+	CodeValidation        = "validation_failed"
+	CodeNotFound          = "not_found"
+	CodeNotEnabled        = "not_enabled"
+	CodeUnauthorized      = "unauthorized"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeConflict          = "conflict"
+	CodeInvalidTransition = "invalid_transition"
+	CodeInsufficientStock = "insufficient_stock"
+	CodeInvalidBackup     = "invalid_backup"
+	CodeTooLarge          = "payload_too_large"
+	CodeTimeout           = "request_timeout"
+	CodeInternal          = "internal_error"
+)
+
+// Field error codes sent in FieldError.Code
+const (
+	FieldRequired          = "required"
+	FieldInvalid           = "invalid"
+	FieldInsufficientStock = "insufficient_stock"
+)
+
+// statusErrorCode returns the error code used for a status when the call
+// site gives none
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusRequestTimeout:
+		return CodeTimeout
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusInternalServerError:
+		return CodeInternal
+	default:
+		return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+}
+
+// requiredField reports a missing field
+func requiredField(field, message string) models.FieldError {
+	return models.FieldError{Field: field, Code: FieldRequired, Message: message}
+}
+
+// storeErrorStatus maps a store error to an HTTP status and error code
+func storeErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, stores.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.Is(err, stores.ErrInvalidTransition):
+		return http.StatusConflict, CodeInvalidTransition
+	case errors.Is(err, stores.ErrConflict):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, stores.ErrValidation):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, stores.ErrInsufficientStock):
+		return http.StatusConflict, CodeInsufficientStock
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusRequestTimeout, CodeTimeout
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+// respondWithStoreError answers a failed store call. Errors the client can
+// act on are reported with their message; anything else is logged and
+// answered with fallback so internals do not leak.
+func respondWithStoreError(w http.ResponseWriter, operation string, err error, fallback string) {
+	status, code := storeErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		LogError(operation, fallback, err)
+		respondWithErrorCode(w, status, code, fallback)
+		return
+	}
+
+	message := err.Error()
+	var entityErr *stores.EntityError
+	if errors.As(err, &entityErr) && entityErr.Err == stores.ErrNotFound {
+		// Keep the established "Book not found" wording
+		message = strings.ToUpper(entityErr.Entity[:1]) + entityErr.Entity[1:] + " not found"
+	}
+	LogInfo(operation, message, map[string]interface{}{"error": err.Error(), "code": code})
+	respondWithErrorCode(w, status, code, message)
+}
+
+// respondWithStockError rejects an order naming every item whose book does
+// not have enough copies in stock. Without items, as for a single line sent
+// to the order item endpoints, shortages are reported on the quantity field.
+func respondWithStockError(w http.ResponseWriter, operation string, items []models.OrderItem, stockErr *stores.StockError) {
+	var fields []models.FieldError
+	for _, shortage := range stockErr.Shortages {
+		if items == nil {
+			fields = append(fields, models.FieldError{
+				Field: "quantity",
+				Code:  FieldInsufficientStock,
+				Message: fmt.Sprintf("Book %d has %d copies in stock, %d needed",
+					shortage.BookID, shortage.Available, shortage.Requested),
+			})
+			continue
+		}
+		for i, item := range items {
+			if item.Book.ID != shortage.BookID {
+				continue
+			}
+			fields = append(fields, models.FieldError{
+				Field: fmt.Sprintf("items[%d].quantity", i),
+				Code:  FieldInsufficientStock,
+				Message: fmt.Sprintf("Book %d has %d copies in stock, %d needed",
+					shortage.BookID, shortage.Available, shortage.Requested),
+			})
+		}
+	}
+	LogInfo(operation, "Insufficient stock", map[string]interface{}{"error": stockErr.Error()})
+	writeErrorResponse(w, http.StatusConflict, models.ErrorResponse{
+		Code:   CodeInsufficientStock,
+		Error:  "Insufficient stock",
+		Fields: fields,
+	})
+}
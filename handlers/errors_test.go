@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"reflect"
+	"testing"
+)
+
+func TestStoreErrorStatus(t *testing.T) {
+	notFound := &stores.EntityError{Entity: stores.EntityBook, ID: 3, Err: stores.ErrNotFound}
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "not found", err: notFound, wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("loading order: %w", notFound), wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "conflict", err: stores.ErrConflict, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "invalid transition", err: &stores.EntityError{Entity: stores.EntityOrder, ID: 1, Err: stores.ErrInvalidTransition},
+			wantStatus: http.StatusConflict, wantCode: CodeInvalidTransition},
+		{name: "validation", err: &stores.EntityError{Entity: stores.EntityOrder, Err: stores.ErrValidation},
+			wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "insufficient stock", err: &stores.StockError{Shortages: []stores.StockShortage{{BookID: 1, Requested: 2}}},
+			wantStatus: http.StatusConflict, wantCode: CodeInsufficientStock},
+		{name: "canceled", err: context.Canceled, wantStatus: http.StatusRequestTimeout, wantCode: CodeTimeout},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), wantStatus: http.StatusRequestTimeout, wantCode: CodeTimeout},
+		{name: "other", err: errors.New("disk on fire"), wantStatus: http.StatusInternalServerError, wantCode: CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code := storeErrorStatus(tt.err)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("storeErrorStatus(%v) = %d %q, want %d %q", tt.err, status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRespondWithStoreError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "not found", err: &stores.EntityError{Entity: stores.EntityBook, ID: 3, Err: stores.ErrNotFound},
+			wantStatus: http.StatusNotFound, wantMessage: "Book not found"},
+		{name: "conflict", err: &stores.EntityError{Entity: stores.EntityAuthor, ID: 1, Err: stores.ErrConflict, Reason: "referenced by 2 books"},
+			wantStatus: http.StatusConflict, wantMessage: "author with ID 1: conflict: referenced by 2 books"},
+		{name: "internal", err: errors.New("disk on fire"), wantStatus: http.StatusInternalServerError, wantMessage: "Failed to load"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			respondWithStoreError(w, "Test", tt.err, "Failed to load")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := decode[models.ErrorResponse](t, w); got.Error != tt.wantMessage {
+				t.Errorf("message = %q, want %q", got.Error, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestErrorResponseCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "missing record", method: http.MethodGet, path: "/books/9", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "invalid ID", method: http.MethodGet, path: "/books/abc", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidID},
+		{name: "invalid body", method: http.MethodPost, path: "/books", body: `{`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidBody},
+		{name: "validation", method: http.MethodPost, path: "/books", body: `{}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "method", method: http.MethodPatch, path: "/books", wantStatus: http.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed},
+		{name: "unknown path", method: http.MethodGet, path: "/shelves", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "invalid transition", method: http.MethodPost, path: "/orders/1/deliver", wantStatus: http.StatusConflict, wantCode: CodeInvalidTransition},
+		{name: "insufficient stock", method: http.MethodPost, path: "/orders/1/items", body: `{"book": {"id": 1}, "quantity": 9}`,
+			wantStatus: http.StatusConflict, wantCode: CodeInsufficientStock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 1}]`)
+
+			w := serve(h, tt.method, tt.path, tt.body)
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+		})
+	}
+}
+
+func TestErrorResponseFormats(t *testing.T) {
+	wantFields := []models.FieldError{
+		{Field: "title", Code: FieldRequired, Message: "Title is required"},
+		{Field: "author.id", Code: FieldRequired, Message: "Author is required"},
+	}
+	tests := []struct {
+		name        string
+		accept      string
+		wantType    string
+		wantProblem bool
+	}{
+		{name: "default", wantType: "application/json"},
+		{name: "json", accept: "application/json", wantType: "application/json"},
+		{name: "problem", accept: "application/problem+json", wantType: problemJSONType, wantProblem: true},
+		{name: "problem among others", accept: "application/problem+json, application/json;q=0.9", wantType: problemJSONType, wantProblem: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			w := serve(h, http.MethodPost, "/books", `{}`, "Accept", tt.accept, RequestIDHeader, "trace-1")
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get(RequestIDHeader); got != "trace-1" {
+				t.Errorf("%s = %q, want the client's ID", RequestIDHeader, got)
+			}
+
+			if !tt.wantProblem {
+				want := models.ErrorResponse{Code: CodeValidation, Error: "Title is required", Fields: wantFields, RequestID: "trace-1"}
+				if got := decode[models.ErrorResponse](t, w); !reflect.DeepEqual(got, want) {
+					t.Errorf("body = %+v, want %+v", got, want)
+				}
+				return
+			}
+			want := models.ProblemDetails{
+				Type:      "about:blank",
+				Title:     "Bad Request",
+				Status:    http.StatusBadRequest,
+				Detail:    "Title is required",
+				Instance:  "/books",
+				Code:      CodeValidation,
+				Fields:    wantFields,
+				RequestID: "trace-1",
+			}
+			if got := decode[models.ProblemDetails](t, w); !reflect.DeepEqual(got, want) {
+				t.Errorf("body = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	h := newTestHandler(t)
+	for _, sent := range []string{"", "has space", string(make([]byte, maxRequestIDLength+1))} {
+		w := serve(h, http.MethodGet, "/books/9", "", RequestIDHeader, sent)
+		id := w.Header().Get(RequestIDHeader)
+		if len(id) != 16 || id == sent {
+			t.Errorf("request ID for %q = %q, want a generated one", sent, id)
+		}
+		if got := decode[models.ErrorResponse](t, w); got.RequestID != id {
+			t.Errorf("body request ID = %q, want %q", got.RequestID, id)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"online-bookstore-api/interfaces"
+	"online-bookstore-api/reports"
 )
 
 // Handler holds references to all stores
@@ -10,6 +11,14 @@ type Handler struct {
 	AuthorStore   interfaces.AuthorStore
 	CustomerStore interfaces.CustomerStore
 	OrderStore    interfaces.OrderStore
+	ReportDir     string
+
+	ReportRetention reports.RetentionPolicy
+	Persistence     interfaces.PersistenceMonitor
+	Archive         interfaces.DatabaseArchive
+	// AdminToken enables backups and restores for requests sending it as a
+	// bearer token; they are disabled while it is empty
+	AdminToken string
 }
 
 // NewHandler creates a new handler instance
@@ -24,6 +33,6 @@ func NewHandler(
 		AuthorStore:   authorStore,
 		CustomerStore: customerStore,
 		OrderStore:    orderStore,
+		ReportDir:     reports.DefaultOutputDir,
 	}
 }
-
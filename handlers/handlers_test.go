@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Handlers log every request and error; keep the test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a handler over in-memory stores, decorated as the
+// server decorates them, holding author 1, books 1 and 2 with five copies
+// each, and customer 1. Backups are set up but disabled, as no admin token
+// is set.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	ctx := context.Background()
+	books := stores.NewInMemoryBookStore()
+	authors := stores.NewInMemoryAuthorStore()
+	customers := stores.NewInMemoryCustomerStore()
+	if _, err := authors.CreateAuthor(ctx, models.Author{FirstName: "Ada", LastName: "Lovelace"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range []models.Book{
+		{Title: "Notes", Author: models.Author{ID: 1}, Genres: []string{"Science"}, Price: models.Cents(1000), Stock: 5},
+		{Title: "Letters", Author: models.Author{ID: 1}, Price: models.Cents(250), Stock: 5},
+	} {
+		if _, err := books.CreateBook(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	customer := models.Customer{Name: "Reader", Email: "reader@example.com", Address: models.Address{Country: "FR"}}
+	if _, err := customers.CreateCustomer(ctx, customer); err != nil {
+		t.Fatal(err)
+	}
+
+	base := stores.NewInMemoryOrderStore()
+	orders := stores.ReserveStockOrderStore(base, books, nil)
+	orders = stores.LifecycleOrderStore(orders, books, nil)
+	orders = stores.PricingOrderStore(orders, books, authors)
+	h := NewHandler(books, authors, customers, orders)
+	h.ReportDir = t.TempDir()
+	h.Archive = stores.NewArchive(stores.NewWriteGate(), books, authors, customers, base)
+	return h
+}
+
+// serve sends a request through the handler's routes. Headers are given as
+// name, value pairs.
+func serve(h *Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.SetupRoutes().ServeHTTP(w, r)
+	return w
+}
+
+// decode reads a JSON response body into a value of type T
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.NewDecoder(w.Body).Decode(&value); err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+// wantStatus fails the test unless the response has the given status and,
+// for errors, the given error code
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body.String())
+	}
+	if code == "" {
+		return
+	}
+	if got := decode[models.ErrorResponse](t, w); got.Code != code {
+		t.Errorf("error code = %q, want %q; message %q", got.Code, code, got.Error)
+	}
+}
+
+// placeOrder creates an order for customer 1 through the API
+func placeOrder(t *testing.T, h *Handler, items string) models.Order {
+	t.Helper()
+	w := serve(h, http.MethodPost, "/orders", `{"customer": {"id": 1}, "items": `+items+`}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /orders status = %d, want %d; body %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	return decode[models.Order](t, w)
+}
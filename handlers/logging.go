@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"log"
+	"online-bookstore-api/models"
 	"time"
 )
 
@@ -28,7 +29,7 @@ func LogInfo(operation, message string, details ...interface{}) {
 }
 
 // LogOrderPlaced logs when an order is successfully placed
-func LogOrderPlaced(orderID int, customerID int, totalPrice float64, itemCount int) {
+func LogOrderPlaced(orderID int, customerID int, totalPrice models.Money, itemCount int) {
 	LogEvent("ORDER_PLACED", 
 		"Order successfully created",
 		map[string]interface{}{
@@ -3,13 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"io"
+	"online-bookstore-api/interfaces"
 	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// CreateOrder handles POST /orders with context support and concurrent processing
+// CreateOrder handles POST /orders with context support
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -27,13 +33,14 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	var order models.Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate required fields
 	if len(order.Items) == 0 {
-		respondWithError(w, http.StatusBadRequest, "Order must contain at least one item")
+		respondWithValidationError(w, "Order must contain at least one item",
+			requiredField("items", "Order must contain at least one item"))
 		return
 	}
 
@@ -41,40 +48,59 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	if checkContext(ctx, w) {
 		return
 	}
-	customer, err := h.CustomerStore.GetCustomer(order.Customer.ID)
-	if err != nil {
+	customer, err := h.CustomerStore.GetCustomer(ctx, order.Customer.ID)
+	if errors.Is(err, stores.ErrNotFound) {
 		LogInfo("CreateOrder", "Customer not found", map[string]interface{}{"customer_id": order.Customer.ID})
-		respondWithError(w, http.StatusBadRequest, "Customer not found")
+		respondWithValidationError(w, "Customer not found", models.FieldError{
+			Field: "customer.id", Code: CodeNotFound, Message: "Customer not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, "CreateOrder", err, "Failed to retrieve customer")
 		return
 	}
 	order.Customer = customer
 
-	// Verify all books exist and calculate total price (with context checks)
-	totalPrice := 0.0
+	// Verify all books exist (with context checks); the store prices the lines
 	for i, item := range order.Items {
+		if item.Quantity <= 0 {
+			respondWithValidationError(w, "Quantity must be positive", models.FieldError{
+				Field: fmt.Sprintf("items[%d].quantity", i), Code: FieldInvalid, Message: "Quantity must be positive",
+			})
+			return
+		}
+
 		// Check context before each book lookup
 		if checkContext(ctx, w) {
 			return
 		}
 
-		book, err := h.BookStore.GetBook(item.Book.ID)
-		if err != nil {
+		book, err := h.BookStore.GetBook(ctx, item.Book.ID)
+		if errors.Is(err, stores.ErrNotFound) {
 			LogInfo("CreateOrder", "Book not found in order", map[string]interface{}{"book_id": item.Book.ID})
-			respondWithError(w, http.StatusBadRequest, "Book not found")
+			respondWithValidationError(w, "Book not found", models.FieldError{
+				Field: fmt.Sprintf("items[%d].book.id", i), Code: CodeNotFound, Message: "Book not found",
+			})
+			return
+		}
+		if err != nil {
+			respondWithStoreError(w, "CreateOrder", err, "Failed to retrieve book")
 			return
 		}
 		// Update the book in the item with full book details
 		order.Items[i].Book = book
-		totalPrice += book.Price * float64(item.Quantity)
 	}
 
 	// Set order details
-	order.TotalPrice = totalPrice
 	if order.CreatedAt.IsZero() {
 		order.CreatedAt = time.Now()
 	}
-	if order.Status == "" {
-		order.Status = "pending"
+	if order.Status != "" && order.Status != models.OrderPending {
+		respondWithValidationError(w, "New orders must be pending", models.FieldError{
+			Field: "status", Code: FieldInvalid, Message: "New orders must be pending",
+		})
+		return
 	}
 
 	// Final context check before creating order
@@ -82,32 +108,20 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create order in a goroutine for concurrent processing
-	orderChan := make(chan models.Order, 1)
-	errChan := make(chan error, 1)
-
-	go func() {
-		createdOrder, err := h.OrderStore.CreateOrder(order)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		orderChan <- createdOrder
-	}()
-
-	// Wait for order creation or context cancellation
-	select {
-	case <-ctx.Done():
-		respondWithError(w, http.StatusRequestTimeout, "Request timeout while processing order")
+	// The store gives up with the context's error if the deadline passes
+	createdOrder, err := h.OrderStore.CreateOrder(ctx, order)
+	var stockErr *stores.StockError
+	if errors.As(err, &stockErr) {
+		respondWithStockError(w, "CreateOrder", order.Items, stockErr)
 		return
-	case err := <-errChan:
-		LogError("CreateOrder", "Failed to create order", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create order")
+	}
+	if err != nil {
+		respondWithStoreError(w, "CreateOrder", err, "Failed to create order")
 		return
-	case createdOrder := <-orderChan:
-		LogOrderPlaced(createdOrder.ID, createdOrder.Customer.ID, createdOrder.TotalPrice, len(createdOrder.Items))
-		respondWithJSON(w, http.StatusCreated, createdOrder)
 	}
+
+	LogOrderPlaced(createdOrder.ID, createdOrder.Customer.ID, createdOrder.TotalPrice, len(createdOrder.Items))
+	respondWithJSON(w, http.StatusCreated, createdOrder)
 }
 
 // GetOrder handles GET /orders/{id} with context support
@@ -124,42 +138,21 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/orders/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
 		return
 	}
 
-	// Use goroutine for concurrent order retrieval
-	orderChan := make(chan models.Order, 1)
-	errChan := make(chan error, 1)
-
-	go func() {
-		order, err := h.OrderStore.GetOrder(id)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		orderChan <- order
-	}()
-
-	select {
-	case <-ctx.Done():
-		respondWithError(w, http.StatusRequestTimeout, "Request was canceled")
-		return
-	case err := <-errChan:
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("GetOrder", "Order not found", map[string]interface{}{"order_id": id})
-			respondWithError(w, http.StatusNotFound, "Order not found")
-		} else {
-			LogError("GetOrder", "Failed to retrieve order", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve order")
-		}
+	order, err := h.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		respondWithStoreError(w, "GetOrder", err, "Failed to retrieve order")
 		return
-	case order := <-orderChan:
-		respondWithJSON(w, http.StatusOK, order)
 	}
+
+	respondWithJSON(w, http.StatusOK, order)
 }
 
-// UpdateOrder handles PUT /orders/{id} with context support
+// UpdateOrder handles PUT /orders/{id} with context support. Items cannot be
+// changed here; they go through the /orders/{id}/items endpoints.
 func (h *Handler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -175,13 +168,13 @@ func (h *Handler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/orders/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
 		return
 	}
 
 	var order models.Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -189,15 +182,14 @@ func (h *Handler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedOrder, err := h.OrderStore.UpdateOrder(id, order)
+	updatedOrder, err := h.OrderStore.UpdateOrder(ctx, id, order)
+	var stockErr *stores.StockError
+	if errors.As(err, &stockErr) {
+		respondWithStockError(w, "UpdateOrder", order.Items, stockErr)
+		return
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("UpdateOrder", "Order not found", map[string]interface{}{"order_id": id})
-			respondWithError(w, http.StatusNotFound, "Order not found")
-		} else {
-			LogError("UpdateOrder", "Failed to update order", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to update order")
-		}
+		respondWithStoreError(w, "UpdateOrder", err, "Failed to update order")
 		return
 	}
 
@@ -224,7 +216,7 @@ func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 
 	id, err := extractID(r.URL.Path, "/orders/")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
 		return
 	}
 
@@ -232,14 +224,8 @@ func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.OrderStore.DeleteOrder(id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			LogInfo("DeleteOrder", "Order not found", map[string]interface{}{"order_id": id})
-			respondWithError(w, http.StatusNotFound, "Order not found")
-		} else {
-			LogError("DeleteOrder", "Failed to delete order", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to delete order")
-		}
+	if err := h.OrderStore.DeleteOrder(ctx, id); err != nil {
+		respondWithStoreError(w, "DeleteOrder", err, "Failed to delete order")
 		return
 	}
 
@@ -261,30 +247,273 @@ func (h *Handler) GetAllOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use goroutine for concurrent order retrieval
-	ordersChan := make(chan []models.Order, 1)
-	errChan := make(chan error, 1)
+	orders, err := h.OrderStore.GetAllOrders(ctx)
+	if err != nil {
+		respondWithStoreError(w, "GetAllOrders", err, "Failed to retrieve orders")
+		return
+	}
+
+	LogInfo("GetAllOrders", "Retrieved all orders", map[string]interface{}{"count": len(orders)})
+	respondWithJSON(w, http.StatusOK, orders)
+}
 
-	go func() {
-		orders, err := h.OrderStore.GetAllOrders()
-		if err != nil {
-			errChan <- err
-			return
-		}
-		ordersChan <- orders
-	}()
 
-	select {
-	case <-ctx.Done():
-		respondWithError(w, http.StatusRequestTimeout, "Request timeout while retrieving orders")
+// orderActions maps the actions of POST /orders/{id}/{action} to the status
+// they move an order to
+var orderActions = map[string]string{
+	"pay":     models.OrderPaid,
+	"ship":    models.OrderShipped,
+	"deliver": models.OrderDelivered,
+	"cancel":  models.OrderCancelled,
+	"refund":  models.OrderRefunded,
+}
+
+// TransitionOrder handles POST /orders/{id}/{action}, moving an order along
+// its lifecycle. The body may name the actor and add a note for the history.
+func (h *Handler) TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	id, action, err := parseOrderAction(r.URL.Path)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
+		return
+	}
+	status, known := orderActions[action]
+	if !known {
+		respondWithError(w, http.StatusNotFound, "Unknown order action")
+		return
+	}
+
+	// The body is optional
+	var request models.OrderTransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	transitioner, ok := h.OrderStore.(interfaces.OrderTransitioner)
+	if !ok {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Order transitions are not enabled")
+		return
+	}
+
+	order, err := transitioner.TransitionOrder(ctx, id, models.OrderStatusChange{
+		NewStatus: status,
+		Actor:     request.Actor,
+		Note:      request.Note,
+	})
+	if err != nil {
+		respondWithStoreError(w, "TransitionOrder", err, "Failed to update order status")
+		return
+	}
+
+	LogUpdate("Order", order.ID, map[string]interface{}{"status": order.Status})
+	respondWithJSON(w, http.StatusOK, order)
+}
+
+// GetOrderHistory handles GET /orders/{id}/history, listing the status
+// changes of an order oldest first
+func (h *Handler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+	if checkContext(ctx, w) {
+		return
+	}
+
+	id, action, err := parseOrderAction(r.URL.Path)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
+		return
+	}
+	if action != "history" {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	order, err := h.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		respondWithStoreError(w, "GetOrderHistory", err, "Failed to retrieve order")
+		return
+	}
+
+	history := order.History
+	if history == nil {
+		history = []models.OrderStatusChange{}
+	}
+	respondWithJSON(w, http.StatusOK, history)
+}
+
+// parseOrderAction splits /orders/{id}/{action} into the order ID and action
+func parseOrderAction(path string) (int, string, error) {
+	idStr, action, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(path, "/orders/"), "/"), "/")
+	id, err := strconv.Atoi(idStr)
+	return id, action, err
+}
+
+// AddOrderItem handles POST /orders/{id}/items, adding a line priced at the
+// book's current price to a pending order
+func (h *Handler) AddOrderItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	id, index, err := parseOrderItemPath(r.URL.Path)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
+		return
+	}
+	if index != "" {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var item models.OrderItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if item.Book.ID == 0 {
+		respondWithValidationError(w, "Book is required", requiredField("book.id", "Book is required"))
+		return
+	}
+
+	editor, ok := h.OrderStore.(interfaces.OrderItemEditor)
+	if !ok {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Order item editing is not enabled")
+		return
+	}
+
+	order, err := editor.AddOrderItem(ctx, id, item)
+	h.respondWithEditedOrder(w, "AddOrderItem", order, err)
+}
+
+// UpdateOrderItem handles PUT /orders/{id}/items/{index}, changing the
+// quantity of a line of a pending order and pricing it again
+func (h *Handler) UpdateOrderItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	id, index, ok := parseOrderItemIndex(w, r)
+	if !ok {
+		return
+	}
+
+	var update models.OrderItemUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
 		return
-	case err := <-errChan:
-		LogError("GetAllOrders", "Failed to retrieve orders", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve orders")
+	}
+
+	editor, ok := h.OrderStore.(interfaces.OrderItemEditor)
+	if !ok {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Order item editing is not enabled")
 		return
-	case orders := <-ordersChan:
-		LogInfo("GetAllOrders", "Retrieved all orders", map[string]interface{}{"count": len(orders)})
-		respondWithJSON(w, http.StatusOK, orders)
 	}
+
+	order, err := editor.UpdateOrderItem(ctx, id, index, update.Quantity)
+	h.respondWithEditedOrder(w, "UpdateOrderItem", order, err)
 }
 
+// RemoveOrderItem handles DELETE /orders/{id}/items/{index}, removing a line
+// from a pending order
+func (h *Handler) RemoveOrderItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	id, index, ok := parseOrderItemIndex(w, r)
+	if !ok {
+		return
+	}
+
+	editor, ok := h.OrderStore.(interfaces.OrderItemEditor)
+	if !ok {
+		respondWithErrorCode(w, http.StatusNotFound, CodeNotEnabled, "Order item editing is not enabled")
+		return
+	}
+
+	order, err := editor.RemoveOrderItem(ctx, id, index)
+	h.respondWithEditedOrder(w, "RemoveOrderItem", order, err)
+}
+
+// respondWithEditedOrder writes the result of an order item edit
+func (h *Handler) respondWithEditedOrder(w http.ResponseWriter, operation string, order models.Order, err error) {
+	var stockErr *stores.StockError
+	if errors.As(err, &stockErr) {
+		respondWithStockError(w, operation, nil, stockErr)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, operation, err, "Failed to update order")
+		return
+	}
+
+	LogUpdate("Order", order.ID, map[string]interface{}{
+		"items":       len(order.Items),
+		"total_price": order.TotalPrice,
+	})
+	respondWithJSON(w, http.StatusOK, order)
+}
+
+// parseOrderItemPath splits /orders/{id}/items/{index} into the order ID and
+// the raw item index, which is empty for /orders/{id}/items
+func parseOrderItemPath(path string) (int, string, error) {
+	id, action, err := parseOrderAction(path)
+	_, index, _ := strings.Cut(action, "/")
+	return id, index, err
+}
+
+// parseOrderItemIndex reads the order ID and item index of a request for a
+// single order line, writing the error response when either is invalid
+func parseOrderItemIndex(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	id, rawIndex, err := parseOrderItemPath(r.URL.Path)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid order ID")
+		return 0, 0, false
+	}
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, CodeInvalidID, "Invalid item index")
+		return 0, 0, false
+	}
+	return id, index, true
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"online-bookstore-api/models"
+	"reflect"
+	"testing"
+)
+
+// itemQuantities returns the book IDs and quantities of an order's lines
+func itemQuantities(order models.Order) [][2]int {
+	pairs := make([][2]int, len(order.Items))
+	for i, item := range order.Items {
+		pairs[i] = [2]int{item.Book.ID, item.Quantity}
+	}
+	return pairs
+}
+
+func TestCreateOrderPricing(t *testing.T) {
+	h := newTestHandler(t)
+	order := placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 2, "unit_price": 0.01, "title": "Free"}]`)
+
+	item := order.Items[0]
+	if item.UnitPrice != models.Cents(1000) || item.Title != "Notes" || item.AuthorName != "Ada Lovelace" {
+		t.Errorf("line = %v %q %q, want 10.00 \"Notes\" \"Ada Lovelace\"", item.UnitPrice, item.Title, item.AuthorName)
+	}
+	if order.TotalPrice != models.Cents(2000) {
+		t.Errorf("total = %v, want 20.00", order.TotalPrice)
+	}
+}
+
+func TestOrderItemEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantItems  [][2]int
+		wantTotal  int64
+	}{
+		{
+			name:       "add",
+			method:     http.MethodPost,
+			path:       "/orders/1/items",
+			body:       `{"book": {"id": 2}, "quantity": 1, "unit_price": 0.01}`,
+			wantStatus: http.StatusOK,
+			wantItems:  [][2]int{{1, 2}, {2, 1}},
+			wantTotal:  2250,
+		},
+		{
+			name:       "add without a book",
+			method:     http.MethodPost,
+			path:       "/orders/1/items",
+			body:       `{"quantity": 1}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidation,
+		},
+		{
+			name:       "add beyond stock",
+			method:     http.MethodPost,
+			path:       "/orders/1/items",
+			body:       `{"book": {"id": 1}, "quantity": 4}`,
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeInsufficientStock,
+		},
+		{
+			name:       "add to a missing order",
+			method:     http.MethodPost,
+			path:       "/orders/9/items",
+			body:       `{"book": {"id": 2}, "quantity": 1}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeNotFound,
+		},
+		{
+			name:       "add at an index",
+			method:     http.MethodPost,
+			path:       "/orders/1/items/0",
+			body:       `{"book": {"id": 2}, "quantity": 1}`,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   CodeMethodNotAllowed,
+		},
+		{
+			name:       "update",
+			method:     http.MethodPut,
+			path:       "/orders/1/items/0",
+			body:       `{"quantity": 3}`,
+			wantStatus: http.StatusOK,
+			wantItems:  [][2]int{{1, 3}},
+			wantTotal:  3000,
+		},
+		{
+			name:       "update to no copies",
+			method:     http.MethodPut,
+			path:       "/orders/1/items/0",
+			body:       `{"quantity": 0}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidation,
+		},
+		{
+			name:       "update a missing line",
+			method:     http.MethodPut,
+			path:       "/orders/1/items/4",
+			body:       `{"quantity": 1}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeNotFound,
+		},
+		{
+			name:       "update with an invalid index",
+			method:     http.MethodPut,
+			path:       "/orders/1/items/first",
+			body:       `{"quantity": 1}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidID,
+		},
+		{
+			name:       "remove the last line",
+			method:     http.MethodDelete,
+			path:       "/orders/1/items/0",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidation,
+		},
+		{
+			name:       "remove from a missing order",
+			method:     http.MethodDelete,
+			path:       "/orders/9/items/0",
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 2}]`)
+
+			w := serve(h, tt.method, tt.path, tt.body)
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			order := decode[models.Order](t, w)
+			if got := itemQuantities(order); !reflect.DeepEqual(got, tt.wantItems) {
+				t.Errorf("items = %v, want %v", got, tt.wantItems)
+			}
+			if order.TotalPrice != models.Cents(tt.wantTotal) {
+				t.Errorf("total = %v, want %v", order.TotalPrice, models.Cents(tt.wantTotal))
+			}
+		})
+	}
+}
+
+func TestRemoveOrderItem(t *testing.T) {
+	h := newTestHandler(t)
+	placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 2}, {"book": {"id": 2}, "quantity": 1}]`)
+
+	w := serve(h, http.MethodDelete, "/orders/1/items/0", "")
+	wantStatus(t, w, http.StatusOK, "")
+	order := decode[models.Order](t, w)
+	if got, want := itemQuantities(order), [][2]int{{2, 1}}; !reflect.DeepEqual(got, want) || order.TotalPrice != models.Cents(250) {
+		t.Errorf("order = %v total %v, want %v total 2.50", got, order.TotalPrice, want)
+	}
+
+	// The removed copies are back in stock
+	w = serve(h, http.MethodGet, "/books/1", "")
+	if book := decode[models.Book](t, w); book.Stock != 5 {
+		t.Errorf("stock = %d, want 5", book.Stock)
+	}
+}
+
+func TestUpdateOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "unchanged", body: `{"customer": {"id": 1}, "items": [{"book": {"id": 1}, "quantity": 2}], "total_price": 20}`, wantStatus: http.StatusOK},
+		{name: "empty", body: `{}`, wantStatus: http.StatusOK},
+		{name: "customer", body: `{"customer": {"id": 2}}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "items", body: `{"items": [{"book": {"id": 2}, "quantity": 1}]}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "total", body: `{"total_price": 1}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "creation time", body: `{"created_at": "2020-01-01T00:00:00Z"}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{name: "status", body: `{"status": "paid"}`, wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			placed := placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 2}]`)
+
+			w := serve(h, http.MethodPut, "/orders/1", tt.body)
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+
+			w = serve(h, http.MethodGet, "/orders/1", "")
+			order := decode[models.Order](t, w)
+			if order.Customer.ID != 1 || !reflect.DeepEqual(itemQuantities(order), itemQuantities(placed)) || order.TotalPrice != placed.TotalPrice {
+				t.Errorf("stored order = %+v, want it unchanged", order)
+			}
+		})
+	}
+}
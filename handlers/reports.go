@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"online-bookstore-api/models"
+	"online-bookstore-api/reports"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportDateLayout is the format of the start_date and end_date parameters
+const reportDateLayout = "2006-01-02"
+
+// GetSalesReports handles GET /reports/sales?start_date=YYYY-MM-DD&end_date=YYYY-MM-DD
+func (h *Handler) GetSalesReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	start, end, ok := parseReportDateRange(w, r)
+	if !ok {
+		return
+	}
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	format, ok := negotiateExportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	sections, sectionsGiven, ok := parseReportSections(w, r)
+	if !ok {
+		return
+	}
+
+	top, ok := parseTopBooks(w, r)
+	if !ok {
+		return
+	}
+	if top == 0 {
+		top = reports.DefaultTopBooks
+	}
+
+	if !sectionsGiven {
+		sections = reports.AllBreakdowns
+	}
+
+	if format != formatJSON {
+		h.streamSalesReportsCSV(w, start, end, top, sections, format)
+		return
+	}
+
+	salesReports, err := reports.LoadReports(h.ReportDir, start, end)
+	if err != nil {
+		LogError("GetSalesReports", "Failed to load sales reports", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to load sales reports")
+		return
+	}
+
+	// Stored reports carry every book and section; trim them to the requested ones
+	for i := range salesReports {
+		salesReports[i] = reports.SelectBreakdowns(reports.LimitTopBooks(salesReports[i], top), sections)
+	}
+
+	LogInfo("GetSalesReports", "Retrieved sales reports", map[string]interface{}{
+		"start_date": r.URL.Query().Get("start_date"),
+		"end_date":   r.URL.Query().Get("end_date"),
+		"count":      len(salesReports),
+	})
+	respondWithJSON(w, http.StatusOK, salesReports)
+}
+
+// parseReportDateRange parses the optional start_date and end_date parameters.
+// The returned range is [start, end) with end_date covering its whole day.
+func parseReportDateRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	var start, end time.Time
+
+	if value := r.URL.Query().Get("start_date"); value != "" {
+		parsed, err := time.Parse(reportDateLayout, value)
+		if err != nil {
+			respondWithInvalidParameter(w, "start_date", "Invalid start_date, expected YYYY-MM-DD")
+			return time.Time{}, time.Time{}, false
+		}
+		start = parsed
+	}
+
+	if value := r.URL.Query().Get("end_date"); value != "" {
+		parsed, err := time.Parse(reportDateLayout, value)
+		if err != nil {
+			respondWithInvalidParameter(w, "end_date", "Invalid end_date, expected YYYY-MM-DD")
+			return time.Time{}, time.Time{}, false
+		}
+		end = parsed.AddDate(0, 0, 1)
+	}
+
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		respondWithInvalidParameter(w, "end_date", "end_date must not be before start_date")
+		return time.Time{}, time.Time{}, false
+	}
+
+	return start, end, true
+}
+
+// defaultAdHocWindow is the window used when no bounds are given
+const defaultAdHocWindow = 24 * time.Hour
+
+// GetAdHocSalesReport handles GET /reports/sales/adhoc, computing a report live
+// from the order store. The window is given either as last=24h|7d or as
+// start=<RFC3339>&end=<RFC3339>; dry_run=true only counts the matching orders.
+func (h *Handler) GetAdHocSalesReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	start, end, ok := parseAdHocWindow(w, r, time.Now().UTC())
+	if !ok {
+		return
+	}
+
+	format, ok := negotiateExportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	top, ok := parseTopBooks(w, r)
+	if !ok {
+		return
+	}
+	opts := reports.Options{TopBooks: top}
+
+	sections, _, ok := parseReportSections(w, r)
+	if !ok {
+		return
+	}
+	opts.Breakdowns = sections
+
+	compare, err := reports.ParseComparison(r.URL.Query().Get("compare"))
+	if err != nil {
+		respondWithInvalidParameter(w, "compare", "Invalid compare, expected previous or week")
+		return
+	}
+	opts.Compare = compare
+
+	basis, err := reports.ParseBasis(r.URL.Query().Get("basis"))
+	if err != nil {
+		respondWithInvalidParameter(w, "basis", "Invalid basis, expected created, paid or delivered")
+		return
+	}
+	opts.Basis = basis
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			respondWithInvalidParameter(w, "dry_run", "Invalid dry_run, expected true or false")
+			return
+		}
+	}
+
+	if checkContext(ctx, w) {
+		return
+	}
+
+	if dryRun {
+		matched, err := reports.CountOrders(ctx, h.OrderStore, start, end, opts.Basis)
+		if err != nil {
+			respondWithStoreError(w, "GetAdHocSalesReport", err, "Failed to count orders")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, models.SalesReportPreview{
+			PeriodStart:   start,
+			PeriodEnd:     end,
+			MatchedOrders: matched,
+		})
+		return
+	}
+
+	report, err := reports.GenerateSalesReport(ctx, h.OrderStore, start, end, opts)
+	if err != nil {
+		respondWithStoreError(w, "GetAdHocSalesReport", err, "Failed to generate sales report")
+		return
+	}
+
+	if format != formatJSON {
+		writeCSVHeaders(w, "sales-report.csv")
+		csvWriter := reports.NewReportCSVWriter(w, format.csvOptions())
+		err = csvWriter.Write(report)
+		if err == nil {
+			err = csvWriter.Flush()
+		}
+		if err != nil {
+			LogError("GetAdHocSalesReport", "Failed to write CSV report", err)
+		}
+		return
+	}
+
+	LogInfo("GetAdHocSalesReport", "Generated ad-hoc sales report", map[string]interface{}{
+		"period_start": start,
+		"period_end":   end,
+		"total_orders": report.TotalOrders,
+	})
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// parseAdHocWindow resolves the report window from last, or start and end
+func parseAdHocWindow(w http.ResponseWriter, r *http.Request, now time.Time) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+	last, startStr, endStr := query.Get("last"), query.Get("start"), query.Get("end")
+
+	if last != "" && (startStr != "" || endStr != "") {
+		respondWithInvalidParameter(w, "last", "Use either last or start/end, not both")
+		return time.Time{}, time.Time{}, false
+	}
+
+	if startStr == "" && endStr == "" {
+		lookback := defaultAdHocWindow
+		if last != "" {
+			parsed, err := reports.ParseLookback(last)
+			if err != nil {
+				respondWithInvalidParameter(w, "last", "Invalid last, expected a duration such as 24h or 7d")
+				return time.Time{}, time.Time{}, false
+			}
+			lookback = parsed
+		}
+		return now.Add(-lookback), now, true
+	}
+
+	if startStr == "" {
+		respondWithInvalidParameter(w, "start", "start is required when end is given")
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := reports.ParseTimeBound(startStr)
+	if err != nil {
+		respondWithInvalidParameter(w, "start", "Invalid start, expected RFC3339 time")
+		return time.Time{}, time.Time{}, false
+	}
+
+	end := now
+	if endStr != "" {
+		end, err = reports.ParseTimeBound(endStr)
+		if err != nil {
+			respondWithInvalidParameter(w, "end", "Invalid end, expected RFC3339 time")
+			return time.Time{}, time.Time{}, false
+		}
+	}
+
+	if !end.After(start) {
+		respondWithInvalidParameter(w, "end", "end must be after start")
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// parseReportSections parses the optional sections parameter listing the
+// breakdowns to include, e.g. sections=genre,author,country
+func parseReportSections(w http.ResponseWriter, r *http.Request) ([]reports.Breakdown, bool, bool) {
+	values, given := r.URL.Query()["sections"]
+	if !given {
+		return nil, false, true
+	}
+
+	sections, err := reports.ParseBreakdowns(strings.Join(values, ","))
+	if err != nil {
+		respondWithInvalidParameter(w, "sections", "Invalid sections, expected a list of genre, author, country")
+		return nil, false, false
+	}
+	return sections, true, true
+}
+
+// GetSalesLineItems handles GET /reports/sales/items, streaming every order
+// line in the window as CSV. The window parameters match the ad-hoc report.
+func (h *Handler) GetSalesLineItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+	if checkContext(ctx, w) {
+		return
+	}
+
+	start, end, ok := parseAdHocWindow(w, r, time.Now().UTC())
+	if !ok {
+		return
+	}
+
+	format, ok := negotiateExportFormat(w, r)
+	if !ok {
+		return
+	}
+	if format == formatJSON {
+		format = formatCSV
+	}
+
+	writeCSVHeaders(w, "sales-items.csv")
+	if err := reports.WriteOrderLinesCSV(ctx, w, h.OrderStore, start, end, format.csvOptions()); err != nil {
+		// Headers are already sent, so the error can only be logged
+		LogError("GetSalesLineItems", "Failed to stream order lines", err)
+	}
+}
+
+// streamSalesReportsCSV writes stored reports as CSV one file at a time,
+// trimmed to the requested books and sections as the JSON response is
+func (h *Handler) streamSalesReportsCSV(w http.ResponseWriter, start, end time.Time, top int, sections []reports.Breakdown, format exportFormat) {
+	writeCSVHeaders(w, "sales-reports.csv")
+	csvWriter := reports.NewReportCSVWriter(w, format.csvOptions())
+
+	err := reports.WalkReports(h.ReportDir, start, end, func(report models.SalesReport) error {
+		return csvWriter.Write(reports.SelectBreakdowns(reports.LimitTopBooks(report, top), sections))
+	})
+	if err == nil {
+		err = csvWriter.Flush()
+	}
+	if err != nil {
+		// Headers are already sent, so the error can only be logged
+		LogError("GetSalesReports", "Failed to stream sales reports", err)
+	}
+}
+
+// exportFormat is the representation requested for report responses
+type exportFormat int
+
+const (
+	formatJSON exportFormat = iota
+	formatCSV
+	formatExcel
+)
+
+// csvOptions returns the CSV dialect for the format
+func (f exportFormat) csvOptions() reports.CSVOptions {
+	return reports.CSVOptions{Excel: f == formatExcel}
+}
+
+// negotiateExportFormat picks the response format from the format parameter
+// (json, csv or excel) or, failing that, from an Accept header of text/csv
+func negotiateExportFormat(w http.ResponseWriter, r *http.Request) (exportFormat, bool) {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "json":
+		return formatJSON, true
+	case "csv":
+		return formatCSV, true
+	case "excel":
+		return formatExcel, true
+	case "":
+	default:
+		respondWithInvalidParameter(w, "format", "Invalid format, expected json, csv or excel")
+		return formatJSON, false
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		return formatCSV, true
+	}
+	return formatJSON, true
+}
+
+// writeCSVHeaders prepares a CSV attachment response
+func writeCSVHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseTopBooks parses the optional top parameter limiting the top-selling
+// books; top=all keeps every book and 0 means the default was not overridden
+func parseTopBooks(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("top")
+	switch value {
+	case "":
+		return 0, true
+	case "all":
+		return -1, true
+	}
+
+	top, err := strconv.Atoi(value)
+	if err != nil || top <= 0 {
+		respondWithInvalidParameter(w, "top", "Invalid top, expected a positive integer or all")
+		return 0, false
+	}
+	return top, true
+}
+
+// CompactSalesReports handles POST /admin/reports/compact, applying the
+// report retention policy immediately
+func (h *Handler) CompactSalesReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+	if checkContext(ctx, w) {
+		return
+	}
+
+	result, err := reports.Compact(h.ReportDir, h.ReportRetention, time.Now().UTC())
+	if err != nil {
+		LogError("CompactSalesReports", "Failed to compact sales reports", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to compact sales reports")
+		return
+	}
+
+	LogEvent("REPORTS_COMPACTED", "Report retention applied", map[string]interface{}{
+		"reports_merged":    result.ReportsMerged,
+		"reports_deleted":   result.ReportsDeleted,
+		"summaries_written": result.SummariesWritten,
+	})
+	respondWithJSON(w, http.StatusOK, result)
+}
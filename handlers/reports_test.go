@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"online-bookstore-api/models"
+	"online-bookstore-api/reports"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdHocSalesReportDryRun(t *testing.T) {
+	tests := []struct {
+		dryRun      string
+		wantStatus  int
+		wantPreview bool
+	}{
+		{dryRun: "", wantStatus: http.StatusOK},
+		{dryRun: "false", wantStatus: http.StatusOK},
+		{dryRun: "true", wantStatus: http.StatusOK, wantPreview: true},
+		{dryRun: "1", wantStatus: http.StatusOK, wantPreview: true},
+		{dryRun: "yes", wantStatus: http.StatusBadRequest},
+		{dryRun: "preview", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dryRun, func(t *testing.T) {
+			h := newTestHandler(t)
+			placeOrder(t, h, `[{"book": {"id": 1}, "quantity": 2}]`)
+
+			w := serve(h, http.MethodGet, "/reports/sales/adhoc?last=24h&dry_run="+tt.dryRun, "")
+			if tt.wantStatus != http.StatusOK {
+				wantStatus(t, w, tt.wantStatus, CodeValidation)
+				return
+			}
+			wantStatus(t, w, tt.wantStatus, "")
+			body := w.Body.String()
+			if preview := strings.Contains(body, `"matched_orders"`); preview != tt.wantPreview {
+				t.Fatalf("response %s, want preview %v", body, tt.wantPreview)
+			}
+			if tt.wantPreview {
+				if got := decode[models.SalesReportPreview](t, w); got.MatchedOrders != 1 {
+					t.Errorf("matched orders = %d, want 1", got.MatchedOrders)
+				}
+			} else if got := decode[models.SalesReport](t, w); got.TotalOrders != 1 {
+				t.Errorf("total orders = %d, want 1", got.TotalOrders)
+			}
+		})
+	}
+}
+
+func TestGetSalesReportsDateRange(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantCode   string
+		wantEnds   []string
+	}{
+		{query: "", wantStatus: http.StatusOK, wantEnds: []string{"2024-03-01", "2024-03-02", "2024-03-03"}},
+		{query: "start_date=2024-03-02", wantStatus: http.StatusOK, wantEnds: []string{"2024-03-02", "2024-03-03"}},
+		{query: "end_date=2024-03-02", wantStatus: http.StatusOK, wantEnds: []string{"2024-03-01", "2024-03-02"}},
+		{query: "start_date=2024-03-02&end_date=2024-03-02", wantStatus: http.StatusOK, wantEnds: []string{"2024-03-02"}},
+		{query: "start_date=2024-04-01", wantStatus: http.StatusOK, wantEnds: []string{}},
+		{query: "start_date=03/02/2024", wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{query: "end_date=2024-03-32", wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+		{query: "start_date=2024-03-03&end_date=2024-03-02", wantStatus: http.StatusBadRequest, wantCode: CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			h := newTestHandler(t)
+			for day := 1; day <= 3; day++ {
+				end := time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)
+				report := models.SalesReport{Timestamp: end, PeriodStart: end.Add(-24 * time.Hour), PeriodEnd: end}
+				if _, err := reports.SaveReport(h.ReportDir, report); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := serve(h, http.MethodGet, "/reports/sales?"+tt.query, "")
+			wantStatus(t, w, tt.wantStatus, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			ends := []string{}
+			for _, report := range decode[[]models.SalesReport](t, w) {
+				ends = append(ends, report.PeriodEnd.Format(time.DateOnly))
+			}
+			if strings.Join(ends, ",") != strings.Join(tt.wantEnds, ",") {
+				t.Errorf("reports ending %v, want %v", ends, tt.wantEnds)
+			}
+		})
+	}
+}
+
+func TestGetSalesReportsMethodNotAllowed(t *testing.T) {
+	h := newTestHandler(t)
+	w := serve(h, http.MethodPost, "/reports/sales", "")
+	wantStatus(t, w, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+}
+
+func TestGetSalesReportsCSVSections(t *testing.T) {
+	tests := []struct {
+		query        string
+		wantSections []string
+	}{
+		{query: "format=csv", wantSections: []string{"top_books", "genre", "author"}},
+		{query: "format=csv&sections=genre", wantSections: []string{"top_books", "genre"}},
+		{query: "format=csv&sections=", wantSections: []string{"top_books"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			h := newTestHandler(t)
+			start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+			report := models.SalesReport{
+				Timestamp:       start.Add(24 * time.Hour),
+				PeriodStart:     start,
+				PeriodEnd:       start.Add(24 * time.Hour),
+				TotalOrders:     1,
+				TopSellingBooks: []models.BookSales{{Book: models.Book{ID: 1, Title: "Notes"}, Quantity: 1}},
+				ByGenre:         []models.SalesBreakdown{{Key: "Science", Units: 1}},
+				ByAuthor:        []models.SalesBreakdown{{Key: "1", Name: "Ada Lovelace", Units: 1}},
+			}
+			if _, err := reports.SaveReport(h.ReportDir, report); err != nil {
+				t.Fatal(err)
+			}
+
+			w := serve(h, http.MethodGet, "/reports/sales?"+tt.query, "")
+			wantStatus(t, w, http.StatusOK, "")
+			rows, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("invalid CSV: %v", err)
+			}
+			var sections []string
+			for _, row := range rows[1:] {
+				sections = append(sections, row[10])
+			}
+			if strings.Join(sections, ",") != strings.Join(tt.wantSections, ",") {
+				t.Errorf("row sections = %v, want %v", sections, tt.wantSections)
+			}
+		})
+	}
+}
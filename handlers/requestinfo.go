@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the ID of a request. A client-supplied ID is
+// kept so requests can be traced across services; otherwise one is
+// generated. The ID is echoed in the response and in error bodies.
+const RequestIDHeader = "X-Request-ID"
+
+// problemJSONType is the media type of RFC 7807 error responses
+const problemJSONType = "application/problem+json"
+
+// maxRequestIDLength bounds client-supplied request IDs
+const maxRequestIDLength = 128
+
+// requestWriter carries details of the request to the error helpers, which
+// only see the ResponseWriter
+type requestWriter struct {
+	http.ResponseWriter
+	requestID string
+	path      string
+	problem   bool
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (rw *requestWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// withRequestInfo assigns every request an ID and records whether the
+// client asked for problem+json errors
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		next.ServeHTTP(&requestWriter{
+			ResponseWriter: w,
+			requestID:      id,
+			path:           r.URL.Path,
+			problem:        strings.Contains(r.Header.Get("Accept"), problemJSONType),
+		}, r)
+	})
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 16 character hex ID
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
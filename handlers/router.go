@@ -25,7 +25,26 @@ func (h *Handler) SetupRoutes() http.Handler {
 	mux.HandleFunc("/orders", h.handleOrders)
 	mux.HandleFunc("/orders/", h.handleOrderByID)
 
-	return mux
+	// Reports routes
+	mux.HandleFunc("/reports/sales", h.handleSalesReports)
+	mux.HandleFunc("/reports/sales/adhoc", h.handleAdHocSalesReport)
+	mux.HandleFunc("/reports/sales/items", h.handleSalesLineItems)
+
+	// Admin routes
+	mux.HandleFunc("/admin/reports/compact", h.handleCompactSalesReports)
+	mux.HandleFunc("/admin/persistence", h.handlePersistenceStatus)
+	mux.HandleFunc("/admin/backup", h.handleBackup)
+	mux.HandleFunc("/admin/restore", h.handleRestore)
+
+	// Anything else gets the same structured error body as other failures
+	mux.HandleFunc("/", h.handleNotFound)
+
+	return withRequestInfo(mux)
+}
+
+// handleNotFound answers requests for paths no route matches
+func (h *Handler) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	respondWithErrorCode(w, http.StatusNotFound, CodeNotFound, "Not found")
 }
 
 // handleBooks routes requests to /books
@@ -36,7 +55,7 @@ func (h *Handler) handleBooks(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.SearchBooks(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -50,7 +69,7 @@ func (h *Handler) handleBookByID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		h.DeleteBook(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -62,7 +81,7 @@ func (h *Handler) handleAuthors(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.GetAllAuthors(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -76,7 +95,7 @@ func (h *Handler) handleAuthorByID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		h.DeleteAuthor(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -88,7 +107,7 @@ func (h *Handler) handleCustomers(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.GetAllCustomers(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -102,7 +121,7 @@ func (h *Handler) handleCustomerByID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		h.DeleteCustomer(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -114,12 +133,30 @@ func (h *Handler) handleOrders(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.GetAllOrders(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-// handleOrderByID routes requests to /orders/{id}
+// handleOrderByID routes requests to /orders/{id}, /orders/{id}/history,
+// /orders/{id}/items and /orders/{id}/{action}
 func (h *Handler) handleOrderByID(w http.ResponseWriter, r *http.Request) {
+	if _, action, found := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/orders/"), "/"), "/"); found {
+		if action == "items" || strings.HasPrefix(action, "items/") {
+			h.handleOrderItems(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			h.GetOrderHistory(w, r)
+		case http.MethodPost:
+			h.TransitionOrder(w, r)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.GetOrder(w, r)
@@ -128,7 +165,92 @@ func (h *Handler) handleOrderByID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		h.DeleteOrder(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleOrderItems routes requests to /orders/{id}/items and
+// /orders/{id}/items/{index}
+func (h *Handler) handleOrderItems(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.AddOrderItem(w, r)
+	case http.MethodPut:
+		h.UpdateOrderItem(w, r)
+	case http.MethodDelete:
+		h.RemoveOrderItem(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleSalesReports routes requests to /reports/sales
+func (h *Handler) handleSalesReports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetSalesReports(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleAdHocSalesReport routes requests to /reports/sales/adhoc
+func (h *Handler) handleAdHocSalesReport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetAdHocSalesReport(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleSalesLineItems routes requests to /reports/sales/items
+func (h *Handler) handleSalesLineItems(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetSalesLineItems(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleCompactSalesReports routes requests to /admin/reports/compact
+func (h *Handler) handleCompactSalesReports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.CompactSalesReports(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handlePersistenceStatus routes requests to /admin/persistence
+func (h *Handler) handlePersistenceStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetPersistenceStatus(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleBackup routes requests to /admin/backup
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetBackup(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleRestore routes requests to /admin/restore
+func (h *Handler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.RestoreBackup(w, r)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
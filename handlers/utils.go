@@ -18,17 +18,70 @@ func respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{})
 	}
 }
 
-// respondWithError sends an error response with consistent structure
+// respondWithError sends an error response with consistent structure. The
+// code is derived from the status; use respondWithErrorCode for a more
+// specific one.
 func respondWithError(w http.ResponseWriter, statusCode int, message string) {
+	respondWithErrorCode(w, statusCode, statusErrorCode(statusCode), message)
+}
+
+// respondWithErrorCode sends an error response carrying a machine-readable code
+func respondWithErrorCode(w http.ResponseWriter, statusCode int, code, message string) {
+	writeErrorResponse(w, statusCode, models.ErrorResponse{Code: code, Error: message})
+}
+
+// respondWithValidationError rejects a request naming the offending fields
+func respondWithValidationError(w http.ResponseWriter, message string, fields ...models.FieldError) {
+	writeErrorResponse(w, http.StatusBadRequest, models.ErrorResponse{
+		Code:   CodeValidation,
+		Error:  message,
+		Fields: fields,
+	})
+}
+
+// respondWithInvalidParameter rejects a malformed query parameter
+func respondWithInvalidParameter(w http.ResponseWriter, parameter, message string) {
+	respondWithValidationError(w, message, models.FieldError{
+		Field:   parameter,
+		Code:    FieldInvalid,
+		Message: message,
+	})
+}
+
+// writeErrorResponse completes an error response with the request ID and
+// sends it as JSON, or as problem+json when the client asked for it
+func writeErrorResponse(w http.ResponseWriter, statusCode int, response models.ErrorResponse) {
 	// Log error responses (4xx and 5xx)
 	if statusCode >= 400 {
 		LogError("HTTP", "Error response", nil)
 		LogInfo("HTTP", "Error details", map[string]interface{}{
 			"status_code": statusCode,
-			"message": message,
+			"code": response.Code,
+			"message": response.Error,
 		})
 	}
-	respondWithJSON(w, statusCode, models.ErrorResponse{Error: message})
+
+	info, _ := w.(*requestWriter)
+	if info != nil {
+		response.RequestID = info.requestID
+	}
+	if info == nil || !info.problem {
+		respondWithJSON(w, statusCode, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemJSONType)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(models.ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    response.Error,
+		Instance:  info.path,
+		Code:      response.Code,
+		Fields:    response.Fields,
+		RequestID: response.RequestID,
+	})
 }
 
 // checkContext checks if context is done and responds appropriately
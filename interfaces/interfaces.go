@@ -1,44 +1,130 @@
 package interfaces
 
 import (
+	"context"
+	"encoding/json"
+	"io"
 	"online-bookstore-api/models"
 	"time"
 )
 
 // BookStore defines operations for book management
 type BookStore interface {
-	CreateBook(book models.Book) (models.Book, error)
-	GetBook(id int) (models.Book, error)
-	UpdateBook(id int, book models.Book) (models.Book, error)
-	DeleteBook(id int) error
-	SearchBooks(criteria models.SearchCriteria) ([]models.Book, error)
-	GetAllBooks() ([]models.Book, error)
+	CreateBook(ctx context.Context, book models.Book) (models.Book, error)
+	GetBook(ctx context.Context, id int) (models.Book, error)
+	UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error)
+	DeleteBook(ctx context.Context, id int) error
+	SearchBooks(ctx context.Context, criteria models.SearchCriteria) ([]models.Book, error)
+	GetAllBooks(ctx context.Context) ([]models.Book, error)
+	// AdjustStock atomically adds each change to the stock of the book with
+	// that ID. Nothing changes if a book does not exist or would be left
+	// with negative stock.
+	AdjustStock(ctx context.Context, changes map[int]int) error
 }
 
 // AuthorStore defines operations for author management
 type AuthorStore interface {
-	CreateAuthor(author models.Author) (models.Author, error)
-	GetAuthor(id int) (models.Author, error)
-	UpdateAuthor(id int, author models.Author) (models.Author, error)
-	DeleteAuthor(id int) error
-	GetAllAuthors() ([]models.Author, error)
+	CreateAuthor(ctx context.Context, author models.Author) (models.Author, error)
+	GetAuthor(ctx context.Context, id int) (models.Author, error)
+	UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error)
+	DeleteAuthor(ctx context.Context, id int) error
+	GetAllAuthors(ctx context.Context) ([]models.Author, error)
 }
 
 // CustomerStore defines operations for customer management
 type CustomerStore interface {
-	CreateCustomer(customer models.Customer) (models.Customer, error)
-	GetCustomer(id int) (models.Customer, error)
-	UpdateCustomer(id int, customer models.Customer) (models.Customer, error)
-	DeleteCustomer(id int) error
-	GetAllCustomers() ([]models.Customer, error)
+	CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error)
+	GetCustomer(ctx context.Context, id int) (models.Customer, error)
+	UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error)
+	DeleteCustomer(ctx context.Context, id int) error
+	GetAllCustomers(ctx context.Context) ([]models.Customer, error)
 }
 
 // OrderStore defines operations for order management
 type OrderStore interface {
-	CreateOrder(order models.Order) (models.Order, error)
-	GetOrder(id int) (models.Order, error)
-	UpdateOrder(id int, order models.Order) (models.Order, error)
-	DeleteOrder(id int) error
-	GetAllOrders() ([]models.Order, error)
-	GetOrdersInTimeRange(start, end time.Time) ([]models.Order, error)
+	CreateOrder(ctx context.Context, order models.Order) (models.Order, error)
+	GetOrder(ctx context.Context, id int) (models.Order, error)
+	UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error)
+	DeleteOrder(ctx context.Context, id int) error
+	GetAllOrders(ctx context.Context) ([]models.Order, error)
+	GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error)
+}
+
+// OrderIterator is implemented by order stores that can stream orders
+// without materializing the whole result set
+type OrderIterator interface {
+	// ForEachOrderInTimeRange calls fn for every order created within
+	// [start, end], oldest first, stopping at the first error fn returns
+	ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error
+}
+
+// OrderStatusIndex is implemented by order stores that can find orders by
+// the time of their status changes without reading every order
+type OrderStatusIndex interface {
+	// GetOrdersChangedInTimeRange returns the orders whose history records
+	// a change to one of statuses within [start, end), ordered by ID
+	GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error)
+}
+
+// OrderTransitioner is implemented by order stores that enforce the order
+// lifecycle
+type OrderTransitioner interface {
+	// TransitionOrder moves an order to change.NewStatus, applying the side
+	// effects of the transition and recording the change in the order's
+	// history with its time and previous status filled in, and returns the
+	// updated order
+	TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error)
+}
+
+// OrderItemEditor is implemented by order stores that price order lines
+// against the catalog. Lines are addressed by their position in the order.
+type OrderItemEditor interface {
+	// AddOrderItem appends a line for item.Book.ID, priced at the book's
+	// current price, and returns the updated order
+	AddOrderItem(ctx context.Context, id int, item models.OrderItem) (models.Order, error)
+	// UpdateOrderItem changes the quantity of a line and prices it again
+	UpdateOrderItem(ctx context.Context, id, index, quantity int) (models.Order, error)
+	// RemoveOrderItem removes a line; an order keeps at least one
+	RemoveOrderItem(ctx context.Context, id, index int) (models.Order, error)
+}
+
+// PersistenceMonitor reports the state of background persistence
+type PersistenceMonitor interface {
+	PersistenceStatus() models.PersistenceStatus
+}
+
+// DatabaseArchive backs up and restores all stores at once
+type DatabaseArchive interface {
+	// Backup captures the named entities, or every entity when none are
+	// named, at a single point in time
+	Backup(entities []string) (io.WriterTo, error)
+	// Restore loads a backup in "replace" or "merge" mode
+	Restore(r io.Reader, mode string) (models.RestoreResult, error)
+}
+
+// Snapshot is the persisted state of a store
+type Snapshot struct {
+	// Records is a JSON object mapping record IDs to records
+	Records json.RawMessage
+	// NextID is the ID the store assigns to the next record it creates
+	NextID int
+}
+
+// Snapshotter is implemented by stores that can be saved to and loaded from
+// a database file
+type Snapshotter interface {
+	// Entity names the kind of record the store holds, e.g. "book"
+	Entity() string
+	// Snapshot captures the contents of the store
+	Snapshot() (Snapshot, error)
+	// Restore replaces the contents of the store
+	Restore(snapshot Snapshot) error
+}
+
+// MutationReplayer is implemented by stores that can apply records from
+// the write-ahead log
+type MutationReplayer interface {
+	// ReplayMutation applies a logged create, update or delete. Value holds
+	// the entity as JSON and is empty for deletes.
+	ReplayMutation(op string, id int, value json.RawMessage) error
 }
@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// The data file is a sequence of frames, one per batch. A frame is a header
+// holding the payload length and its CRC-32, followed by the payload: the
+// batch operations back to back. A put is encoded as the op byte, the key
+// length, the key, the value length and the value; a delete omits the value.
+const (
+	frameHeaderSize = 8
+
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+// errCorruptFrame is returned when a frame payload cannot be decoded
+var errCorruptFrame = errors.New("corrupt frame")
+
+// op is a single operation in a batch
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// decodedOp is an operation read back from a frame together with the
+// position of its value within the payload
+type decodedOp struct {
+	kind        byte
+	key         string
+	valueOffset int
+	valueLength int
+	size        int
+}
+
+// encodeFrame encodes ops as a frame. It returns the frame and, for every
+// op, the offset of its value and its encoded size.
+func encodeFrame(ops []op) ([]byte, []decodedOp) {
+	size := frameHeaderSize
+	for _, o := range ops {
+		size += 1 + binary.MaxVarintLen64 + len(o.key)
+		if o.kind == opPut {
+			size += binary.MaxVarintLen64 + len(o.value)
+		}
+	}
+
+	frame := make([]byte, frameHeaderSize, size)
+	decoded := make([]decodedOp, len(ops))
+	for i, o := range ops {
+		start := len(frame)
+		frame = append(frame, o.kind)
+		frame = binary.AppendUvarint(frame, uint64(len(o.key)))
+		frame = append(frame, o.key...)
+		d := decodedOp{kind: o.kind, key: o.key}
+		if o.kind == opPut {
+			frame = binary.AppendUvarint(frame, uint64(len(o.value)))
+			d.valueOffset = len(frame)
+			d.valueLength = len(o.value)
+			frame = append(frame, o.value...)
+		}
+		d.size = len(frame) - start
+		decoded[i] = d
+	}
+
+	payload := frame[frameHeaderSize:]
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	return frame, decoded
+}
+
+// decodePayload decodes the operations of a frame payload. Value offsets
+// are relative to the start of the frame.
+func decodePayload(payload []byte) ([]decodedOp, error) {
+	var ops []decodedOp
+	pos := 0
+	for pos < len(payload) {
+		start := pos
+		kind := payload[pos]
+		pos++
+		if kind != opPut && kind != opDelete {
+			return nil, fmt.Errorf("%w: unknown op %d", errCorruptFrame, kind)
+		}
+
+		keyLength, n := binary.Uvarint(payload[pos:])
+		if n <= 0 || uint64(len(payload)-pos-n) < keyLength {
+			return nil, fmt.Errorf("%w: bad key length", errCorruptFrame)
+		}
+		pos += n
+		d := decodedOp{kind: kind, key: string(payload[pos : pos+int(keyLength)])}
+		pos += int(keyLength)
+
+		if kind == opPut {
+			valueLength, n := binary.Uvarint(payload[pos:])
+			if n <= 0 || uint64(len(payload)-pos-n) < valueLength {
+				return nil, fmt.Errorf("%w: bad value length", errCorruptFrame)
+			}
+			pos += n
+			d.valueOffset = frameHeaderSize + pos
+			d.valueLength = int(valueLength)
+			pos += int(valueLength)
+		}
+		d.size = pos - start
+		ops = append(ops, d)
+	}
+	return ops, nil
+}
@@ -0,0 +1,407 @@
+// Package kvstore is an embedded, log-structured key-value store.
+//
+// Every write is appended to a single data file and synced before it is
+// acknowledged. An in-memory index maps each live key to the location of its
+// latest value, so a read costs one disk access and only the keys have to
+// fit in memory. Space held by overwritten and deleted values is reclaimed
+// by compaction, which rewrites the live values into a fresh file.
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Automatic compaction runs once superseded data exceeds both
+// compactMinGarbage bytes and half of the file
+const compactMinGarbage = 4 << 20
+
+// ErrNotFound is returned when a key does not exist
+var ErrNotFound = errors.New("kvstore: key not found")
+
+// ErrClosed is returned when the database has been closed
+var ErrClosed = errors.New("kvstore: database is closed")
+
+// location is where the latest value of a key is stored
+type location struct {
+	offset int64
+	length int
+	// size is the encoded size of the operation that wrote the value
+	size int
+}
+
+// DB is an open key-value database. It is safe for concurrent use.
+type DB struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	size    int64
+	index   map[string]location
+	keys    []string // live keys, sorted
+	garbage int64    // bytes held by superseded operations
+	broken  error
+}
+
+// Batch collects operations that are written atomically
+type Batch struct {
+	ops []op
+}
+
+// Put sets key to value when the batch is written
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, op{kind: opPut, key: key, value: value})
+}
+
+// Delete removes key when the batch is written
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, op{kind: opDelete, key: key})
+}
+
+// Len returns the number of operations in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Open opens the database at path, creating it if needed. A torn batch at
+// the end of the file, left by a crash during a write, is discarded.
+func Open(path string) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db := &DB{path: path, file: file, index: make(map[string]location)}
+	if err := db.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// load rebuilds the index from the data file
+func (db *DB) load() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+	fileSize := info.Size()
+
+	reader := bufio.NewReader(io.NewSectionReader(db.file, 0, fileSize))
+	header := make([]byte, frameHeaderSize)
+	var offset int64
+	for offset < fileSize {
+		if fileSize-offset < frameHeaderSize {
+			break // torn header
+		}
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		end := offset + frameHeaderSize + length
+		if end > fileSize {
+			break // torn payload
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+		var ops []decodedOp
+		if crc32.ChecksumIEEE(payload) == checksum {
+			ops, err = decodePayload(payload)
+		} else {
+			err = errCorruptFrame
+		}
+		if err != nil {
+			if end == fileSize {
+				break // corrupt final batch
+			}
+			return fmt.Errorf("%s: %w at offset %d", db.path, err, offset)
+		}
+
+		db.apply(offset, ops)
+		offset = end
+	}
+
+	if offset < fileSize {
+		if err := db.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to discard torn batch: %w", err)
+		}
+	}
+	db.size = offset
+
+	db.keys = make([]string, 0, len(db.index))
+	for key := range db.index {
+		db.keys = append(db.keys, key)
+	}
+	sort.Strings(db.keys)
+	return nil
+}
+
+// apply updates the index for a batch written at frameOffset. The sorted key
+// list is maintained by the caller.
+func (db *DB) apply(frameOffset int64, ops []decodedOp) (added, removed []string) {
+	for _, o := range ops {
+		old, exists := db.index[o.key]
+		if exists {
+			db.garbage += int64(old.size)
+		}
+		if o.kind == opDelete {
+			db.garbage += int64(o.size)
+			if exists {
+				delete(db.index, o.key)
+				removed = append(removed, o.key)
+			}
+			continue
+		}
+		db.index[o.key] = location{
+			offset: frameOffset + int64(o.valueOffset),
+			length: o.valueLength,
+			size:   o.size,
+		}
+		if !exists {
+			added = append(added, o.key)
+		}
+	}
+	return added, removed
+}
+
+// Get returns the value stored under key
+func (db *DB) Get(key string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	loc, exists := db.index[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return db.read(loc)
+}
+
+// read loads a value from the data file; the caller must hold the lock
+func (db *DB) read(loc location) ([]byte, error) {
+	value := make([]byte, loc.length)
+	if _, err := db.file.ReadAt(value, loc.offset); err != nil {
+		return nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	return value, nil
+}
+
+// Scan calls fn for every key in [start, end) in key order, stopping at the
+// first error fn returns. An empty end scans to the last key. fn must not
+// write to the database.
+func (db *DB) Scan(start, end string, fn func(key string, value []byte) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+	for i := sort.SearchStrings(db.keys, start); i < len(db.keys); i++ {
+		key := db.keys[i]
+		if end != "" && key >= end {
+			break
+		}
+		value, err := db.read(db.index[key])
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanPrefix calls fn for every key starting with prefix, in key order
+func (db *DB) ScanPrefix(prefix string, fn func(key string, value []byte) error) error {
+	return db.Scan(prefix, prefixEnd(prefix), fn)
+}
+
+// prefixEnd returns the first key after every key starting with prefix
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
+// Put sets key to value
+func (db *DB) Put(key string, value []byte) error {
+	var batch Batch
+	batch.Put(key, value)
+	return db.Write(&batch)
+}
+
+// Delete removes key; deleting a missing key is not an error
+func (db *DB) Delete(key string) error {
+	var batch Batch
+	batch.Delete(key)
+	return db.Write(&batch)
+}
+
+// Write applies every operation in batch atomically and syncs it to disk
+func (db *DB) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	frame, ops := encodeFrame(batch.ops)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+	if db.broken != nil {
+		return fmt.Errorf("kvstore: database unavailable: %w", db.broken)
+	}
+
+	_, err := db.file.WriteAt(frame, db.size)
+	if err == nil {
+		err = db.file.Sync()
+	}
+	if err != nil {
+		// Drop the partial batch so later writes do not follow garbage
+		if truncErr := db.file.Truncate(db.size); truncErr != nil {
+			db.broken = truncErr
+		}
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+
+	added, removed := db.apply(db.size, ops)
+	db.size += int64(len(frame))
+	for _, key := range added {
+		db.insertKey(key)
+	}
+	for _, key := range removed {
+		db.removeKey(key)
+	}
+
+	if db.garbage > compactMinGarbage && db.garbage*2 > db.size {
+		if err := db.compact(); err != nil {
+			log.Printf("kvstore: compaction of %s failed: %v", db.path, err)
+		}
+	}
+	return nil
+}
+
+// insertKey adds a new key to the sorted key list
+func (db *DB) insertKey(key string) {
+	i := sort.SearchStrings(db.keys, key)
+	db.keys = append(db.keys, "")
+	copy(db.keys[i+1:], db.keys[i:])
+	db.keys[i] = key
+}
+
+// removeKey drops a key from the sorted key list
+func (db *DB) removeKey(key string) {
+	i := sort.SearchStrings(db.keys, key)
+	if i < len(db.keys) && db.keys[i] == key {
+		db.keys = append(db.keys[:i], db.keys[i+1:]...)
+	}
+}
+
+// Compact rewrites the data file keeping only live values
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.compact()
+}
+
+// compact rewrites the data file; the caller must hold the write lock
+func (db *DB) compact() error {
+	dir := filepath.Dir(db.path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(db.path)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("failed to create compaction file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	index := make(map[string]location, len(db.index))
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, key := range db.keys {
+		value, err := db.read(db.index[key])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		frame, ops := encodeFrame([]op{{kind: opPut, key: key, value: value}})
+		if _, err := writer.Write(frame); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write compaction file: %w", err)
+		}
+		index[key] = location{offset: size + int64(ops[0].valueOffset), length: ops[0].valueLength, size: ops[0].size}
+		size += int64(len(frame))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write compaction file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync compaction file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), db.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to replace data file: %w", err)
+	}
+	syncDir(dir)
+
+	// The renamed temp file is now the data file; keep its handle open
+	db.file.Close()
+	db.file = tmp
+	db.size = size
+	db.index = index
+	db.garbage = 0
+	return nil
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+// syncDir flushes directory entries so renames survive a crash. Not every
+// platform supports syncing a directory, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	_ = d.Sync()
+}
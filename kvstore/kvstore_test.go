@@ -0,0 +1,382 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// contents returns every key and value in db
+func contents(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := db.Scan("", "", func(key string, value []byte) error {
+		got[key] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	return got
+}
+
+func openTemp(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.kv")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func reopen(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close error = %v", err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches []func(*Batch)
+		want    map[string]string
+	}{
+		{
+			name:    "empty",
+			batches: []func(*Batch){func(b *Batch) {}},
+			want:    map[string]string{},
+		},
+		{
+			name: "put",
+			batches: []func(*Batch){func(b *Batch) {
+				b.Put("a", []byte("1"))
+				b.Put("b", []byte("2"))
+			}},
+			want: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "overwrite",
+			batches: []func(*Batch){
+				func(b *Batch) { b.Put("a", []byte("1")) },
+				func(b *Batch) { b.Put("a", []byte("longer value")) },
+			},
+			want: map[string]string{"a": "longer value"},
+		},
+		{
+			name: "later op in a batch wins",
+			batches: []func(*Batch){func(b *Batch) {
+				b.Put("a", []byte("1"))
+				b.Delete("a")
+				b.Put("b", []byte("2"))
+				b.Put("b", []byte("3"))
+			}},
+			want: map[string]string{"b": "3"},
+		},
+		{
+			name: "delete",
+			batches: []func(*Batch){
+				func(b *Batch) {
+					b.Put("a", []byte("1"))
+					b.Put("b", []byte("2"))
+				},
+				func(b *Batch) {
+					b.Delete("a")
+					b.Delete("missing")
+				},
+			},
+			want: map[string]string{"b": "2"},
+		},
+		{
+			name: "empty key and value",
+			batches: []func(*Batch){func(b *Batch) {
+				b.Put("", nil)
+				b.Put("k", []byte{})
+			}},
+			want: map[string]string{"": "", "k": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTemp(t)
+			for _, fill := range tt.batches {
+				var batch Batch
+				fill(&batch)
+				if err := db.Write(&batch); err != nil {
+					t.Fatalf("Write error = %v", err)
+				}
+			}
+			if got := contents(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contents = %v, want %v", got, tt.want)
+			}
+
+			db = reopen(t, db, path)
+			if got := contents(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contents after reopen = %v, want %v", got, tt.want)
+			}
+
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact error = %v", err)
+			}
+			if got := contents(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contents after compaction = %v, want %v", got, tt.want)
+			}
+			db = reopen(t, db, path)
+			if got := contents(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contents after compaction and reopen = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	db, _ := openTemp(t)
+	if err := db.Put("a", []byte("1")); err != nil {
+		t.Fatalf("Put error = %v", err)
+	}
+
+	if value, err := db.Get("a"); err != nil || string(value) != "1" {
+		t.Errorf("Get(a) = %q, %v; want 1", value, err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("Delete error = %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(a) after delete error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestScan(t *testing.T) {
+	db, _ := openTemp(t)
+	var batch Batch
+	for _, key := range []string{"a/1", "a/2", "a/3", "b", "b/1", "c\xff", "c\xff\xff"} {
+		batch.Put(key, []byte(key))
+	}
+	if err := db.Write(&batch); err != nil {
+		t.Fatalf("Write error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		start  string
+		end    string
+		prefix string
+		want   []string
+	}{
+		{name: "all", want: []string{"a/1", "a/2", "a/3", "b", "b/1", "c\xff", "c\xff\xff"}},
+		{name: "range", start: "a/2", end: "b/1", want: []string{"a/2", "a/3", "b"}},
+		{name: "open end", start: "b/", want: []string{"b/1", "c\xff", "c\xff\xff"}},
+		{name: "empty range", start: "a/4", end: "a/9"},
+		{name: "prefix", prefix: "a/", want: []string{"a/1", "a/2", "a/3"}},
+		{name: "prefix is a key", prefix: "b", want: []string{"b", "b/1"}},
+		{name: "prefix ending in 0xff", prefix: "c\xff", want: []string{"c\xff", "c\xff\xff"}},
+		{name: "missing prefix", prefix: "d"},
+	}
+	for _, tt := range tests {
+		var got []string
+		collect := func(key string, value []byte) error {
+			if string(value) != key {
+				t.Errorf("%s: value of %q = %q", tt.name, key, value)
+			}
+			got = append(got, key)
+			return nil
+		}
+		var err error
+		if tt.prefix != "" {
+			err = db.ScanPrefix(tt.prefix, collect)
+		} else {
+			err = db.Scan(tt.start, tt.end, collect)
+		}
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: keys = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanStopsAtError(t *testing.T) {
+	db, _ := openTemp(t)
+	db.Put("a", nil)
+	db.Put("b", nil)
+
+	errStop := errors.New("stop")
+	var seen []string
+	err := db.Scan("", "", func(key string, _ []byte) error {
+		seen = append(seen, key)
+		return errStop
+	})
+	if !errors.Is(err, errStop) || len(seen) != 1 {
+		t.Errorf("Scan = %v after %q, want %v after one key", err, seen, errStop)
+	}
+}
+
+func TestOpenDiscardsTornBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(data []byte) []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "torn header",
+			damage: func(data []byte) []byte { return append(data, 9, 0, 0) },
+			want:   map[string]string{"a": "1", "b": "2", "c": "3"},
+		},
+		{
+			name:   "torn payload",
+			damage: func(data []byte) []byte { return data[:len(data)-1] },
+			want:   map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "corrupt final batch",
+			damage: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			want: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "corrupt batch followed by data",
+			damage: func(data []byte) []byte {
+				data[frameHeaderSize] ^= 0xff
+				return data
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTemp(t)
+			db.Put("a", []byte("1"))
+			db.Put("b", []byte("2"))
+			db.Put("c", []byte("3"))
+			db.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Open(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer db.Close()
+			if got := contents(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contents = %v, want %v", got, tt.want)
+			}
+
+			// The torn bytes are cut off, so new batches stay readable
+			if err := db.Put("d", []byte("4")); err != nil {
+				t.Fatalf("Put error = %v", err)
+			}
+			db = reopen(t, db, path)
+			if value, err := db.Get("d"); err != nil || string(value) != "4" {
+				t.Errorf("Get(d) after reopen = %q, %v; want 4", value, err)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db, path := openTemp(t)
+	value := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 100; i++ {
+		if err := db.Put("overwritten", value); err != nil {
+			t.Fatalf("Put error = %v", err)
+		}
+	}
+	db.Put("deleted", value)
+	db.Delete("deleted")
+	db.Put("kept", []byte("kept"))
+
+	before, _ := os.Stat(path)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact error = %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/10 {
+		t.Errorf("size after compaction = %d, want well below %d", after.Size(), before.Size())
+	}
+	if db.garbage != 0 {
+		t.Errorf("garbage after compaction = %d, want 0", db.garbage)
+	}
+
+	// Writes after compaction go to the new file
+	if err := db.Put("new", []byte("new")); err != nil {
+		t.Fatalf("Put error = %v", err)
+	}
+	db = reopen(t, db, path)
+	want := map[string]string{"overwritten": string(value), "kept": "kept", "new": "new"}
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("contents after compaction differ: got %d keys, want %d", len(got), len(want))
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*compact-*"))
+	if len(matches) > 0 {
+		t.Errorf("compaction left temp files %v", matches)
+	}
+}
+
+func TestAutomaticCompaction(t *testing.T) {
+	db, path := openTemp(t)
+	value := bytes.Repeat([]byte("x"), 64<<10)
+	for i := 0; i < 2*compactMinGarbage/len(value); i++ {
+		if err := db.Put("key", value); err != nil {
+			t.Fatalf("Put error = %v", err)
+		}
+	}
+
+	info, _ := os.Stat(path)
+	if info.Size() > 2*compactMinGarbage {
+		t.Errorf("file size = %d, want compaction to keep it below %d", info.Size(), 2*compactMinGarbage)
+	}
+	if got, err := db.Get("key"); err != nil || !bytes.Equal(got, value) {
+		t.Errorf("Get after compaction = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	db, _ := openTemp(t)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("second Close error = %v", err)
+	}
+
+	scan := func(string, []byte) error { return nil }
+	for name, err := range map[string]error{
+		"Put":     db.Put("a", nil),
+		"Get":     func() error { _, err := db.Get("a"); return err }(),
+		"Scan":    db.Scan("", "", scan),
+		"Compact": db.Compact(),
+	} {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%s error = %v, want %v", name, err, ErrClosed)
+		}
+	}
+}
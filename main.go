@@ -5,7 +5,7 @@ import (
 	"log"
 	"net/http"
 	"online-bookstore-api/handlers"
-	"online-bookstore-api/stores"
+	"online-bookstore-api/reports"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,21 +13,37 @@ import (
 )
 
 func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Maintenance commands run instead of the server
+	if ran, err := runCommand(cfg, os.Args[1:]); ran {
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	// Initialize stores
-	bookStore := stores.NewInMemoryBookStore()
-	authorStore := stores.NewInMemoryAuthorStore()
-	customerStore := stores.NewInMemoryCustomerStore()
-	orderStore := stores.NewInMemoryOrderStore()
-
-	// Load data from persistence if it exists
-	if err := stores.LoadDatabase(bookStore, authorStore, customerStore, orderStore, "database.json"); err != nil {
-		log.Printf("Warning: Failed to load database: %v", err)
+	backend, err := openBackend(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize stores: %v", err)
 	}
+	defer backend.close()
 
 	log.Println("Stores initialized successfully")
 
 	// Initialize handlers
-	handler := handlers.NewHandler(bookStore, authorStore, customerStore, orderStore)
+	handler := handlers.NewHandler(backend.bookStore, backend.authorStore, backend.customerStore, backend.orderStore)
+	handler.ReportDir = cfg.ReportDir
+	handler.ReportRetention = cfg.ReportRetention
+	handler.Archive = backend.archive
+	handler.AdminToken = cfg.AdminToken
+	if backend.persistence != nil {
+		handler.Persistence = backend.persistence
+	}
 
 	// Setup routes
 	router := handler.SetupRoutes()
@@ -51,27 +67,46 @@ func main() {
 		}
 	}()
 
+	// Shutdown context, cancelled on interrupt signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start periodic sales report generation
+	// Stored reports keep every book sold so they can be merged without loss
+	scheduler := reports.NewScheduler(backend.orderStore, cfg.ReportDir, cfg.ReportInterval, reports.Options{
+		TopBooks:   -1,
+		Breakdowns: reports.AllBreakdowns,
+		Compare:    cfg.ReportCompare,
+		Basis:      cfg.ReportBasis,
+	})
+	scheduler.SetRetention(cfg.ReportRetention)
+	scheduler.Start(ctx)
+
+	if backend.persistence != nil {
+		backend.persistence.Start(ctx)
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	<-ctx.Done()
+	stop()
 
 	log.Println("Shutting down server...")
 
-	// Save data before shutdown
-	log.Println("Saving database...")
-	if err := stores.SaveDatabase(bookStore, authorStore, customerStore, orderStore, "database.json"); err != nil {
-		log.Printf("Error saving database: %v", err)
-	} else {
-		log.Println("Database saved successfully")
-	}
-
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+
+	// Let a report run that is in progress finish before exiting
+	log.Println("Waiting for report scheduler...")
+	scheduler.Wait()
+
+	// Wait for the final save
+	if backend.persistence != nil {
+		backend.persistence.Wait()
 	}
 
 	log.Println("Server exited")
@@ -124,7 +159,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		
 		// Log request details
 		log.Printf(
-			"[%s] %s %s %s %d %d %v",
+			"[%s] %s %s %s %d %d %v id=%s",
 			clientIP,
 			r.Method,
 			r.URL.Path,
@@ -132,6 +167,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 			rw.statusCode,
 			rw.bytesWritten,
 			duration,
+			rw.Header().Get(handlers.RequestIDHeader),
 		)
 		
 		// Log errors (4xx and 5xx status codes)
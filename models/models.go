@@ -8,7 +8,7 @@ type Book struct {
 	Author      Author    `json:"author"`
 	Genres      []string  `json:"genres"`
 	PublishedAt time.Time `json:"published_at"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price"`
 	Stock       int       `json:"stock"`
 }
 
@@ -42,16 +42,73 @@ type Customer struct {
 type OrderItem struct {
 	Book     Book `json:"book"`
 	Quantity int  `json:"quantity"`
+	// UnitPrice, Title and AuthorName are copied from the catalog when the
+	// line is priced by the server and are not changed by later edits to the
+	// book. Values sent by clients are ignored.
+	UnitPrice  Money  `json:"unit_price"`
+	Title      string `json:"title,omitempty"`
+	AuthorName string `json:"author_name,omitempty"`
 }
 
+// Price returns the unit price the line was bought at. Lines stored before
+// unit prices were kept fall back to the copy of the book taken with them.
+func (i OrderItem) Price() Money {
+	if i.UnitPrice.IsZero() {
+		return i.Book.Price
+	}
+	return i.UnitPrice
+}
+
+// Subtotal returns the price of the line, failing with ErrAmountOverflow
+// when it does not fit in an amount
+func (i OrderItem) Subtotal() (Money, error) {
+	return i.Price().Mul(i.Quantity)
+}
+
+// OrderItemUpdate is the body of PUT /orders/{id}/items/{index}
+type OrderItemUpdate struct {
+	Quantity int `json:"quantity"`
+}
+
+// Order statuses. Orders start pending and move between statuses only
+// through the transitions the stores allow.
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
 // Order represents an order
 type Order struct {
 	ID         int         `json:"id"`
 	Customer   Customer    `json:"customer"`
 	Items      []OrderItem `json:"items"`
-	TotalPrice float64     `json:"total_price"`
+	TotalPrice Money       `json:"total_price"`
 	CreatedAt  time.Time   `json:"created_at"`
 	Status     string      `json:"status"`
+	// History lists the status changes of the order, oldest first. It is
+	// maintained by the stores; orders from before it was kept are given one
+	// when their database file is migrated.
+	History []OrderStatusChange `json:"history,omitempty"`
+}
+
+// OrderStatusChange records one change of an order's status. The first
+// entry of an order has no previous status.
+type OrderStatusChange struct {
+	Timestamp      time.Time `json:"timestamp"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	NewStatus      string    `json:"new_status"`
+	Actor          string    `json:"actor,omitempty"`
+	Note           string    `json:"note,omitempty"`
+}
+
+// OrderTransitionRequest is the optional body of an order transition
+type OrderTransitionRequest struct {
+	Actor string `json:"actor"`
+	Note  string `json:"note"`
 }
 
 // BookSales represents book sales data for reports
@@ -60,24 +117,122 @@ type BookSales struct {
 	Quantity int  `json:"quantity_sold"`
 }
 
+// SalesBreakdown represents revenue and units sold for one group of sales
+type SalesBreakdown struct {
+	Key     string      `json:"key"`
+	Name    string      `json:"name,omitempty"`
+	Revenue MoneyTotals `json:"revenue"`
+	Units   int         `json:"units"`
+}
+
+// BookSalesChange compares the units sold of a book with the previous period
+type BookSalesChange struct {
+	BookID           int      `json:"book_id"`
+	Title            string   `json:"title"`
+	Quantity         int      `json:"quantity_sold"`
+	PreviousQuantity int      `json:"previous_quantity_sold"`
+	Delta            int      `json:"delta"`
+	ChangePercent    *float64 `json:"change_percent"`
+}
+
+// SalesComparison compares a sales report with an earlier equivalent window.
+// Revenue is compared per currency. Percentages are null when the previous
+// value is zero.
+type SalesComparison struct {
+	Basis                string            `json:"basis"`
+	PeriodStart          time.Time         `json:"period_start"`
+	PeriodEnd            time.Time         `json:"period_end"`
+	TotalRevenue         MoneyTotals       `json:"total_revenue"`
+	TotalOrders          int               `json:"total_orders"`
+	RevenueDelta         MoneyTotals       `json:"revenue_delta"`
+	RevenueChangePercent PercentChanges    `json:"revenue_change_percent"`
+	OrdersDelta          int               `json:"orders_delta"`
+	OrdersChangePercent  *float64          `json:"orders_change_percent"`
+	TopSellingBooks      []BookSalesChange `json:"top_selling_books"`
+}
+
 // SalesReport represents a sales report
 type SalesReport struct {
-	Timestamp       time.Time   `json:"timestamp"`
-	TotalRevenue    float64     `json:"total_revenue"`
-	TotalOrders     int         `json:"total_orders"`
-	TopSellingBooks []BookSales `json:"top_selling_books"`
+	Kind            string           `json:"kind,omitempty"`
+	Basis           string           `json:"basis,omitempty"`
+	Timestamp       time.Time        `json:"timestamp"`
+	PeriodStart     time.Time        `json:"period_start"`
+	PeriodEnd       time.Time        `json:"period_end"`
+	TotalRevenue    MoneyTotals      `json:"total_revenue"`
+	TotalOrders     int              `json:"total_orders"`
+	TotalBooksSold  int              `json:"total_books_sold"`
+	TopSellingBooks []BookSales      `json:"top_selling_books"`
+	ByGenre         []SalesBreakdown `json:"by_genre,omitzero"`
+	ByAuthor        []SalesBreakdown `json:"by_author,omitzero"`
+	ByCountry       []SalesBreakdown `json:"by_country,omitzero"`
+	Comparison      *SalesComparison `json:"comparison,omitempty"`
+	MergedFrom      []string         `json:"merged_from,omitempty"`
+}
+
+// SalesReportPreview describes the orders an ad-hoc report would aggregate
+type SalesReportPreview struct {
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	MatchedOrders int       `json:"matched_orders"`
+}
+
+// CompactionResult summarizes a run of the report retention policy
+type CompactionResult struct {
+	ReportsMerged    int      `json:"reports_merged"`
+	ReportsDeleted   int      `json:"reports_deleted"`
+	SummariesWritten []string `json:"summaries_written"`
+}
+
+// PersistenceStatus reports the state of background database saves
+type PersistenceStatus struct {
+	LastSaveAt       *time.Time `json:"last_save_at"`
+	LastError        string     `json:"last_error,omitempty"`
+	PendingMutations int        `json:"pending_mutations"`
+	Saves            int        `json:"saves"`
+}
+
+// RestoreResult summarizes a database restore, counting records per entity
+type RestoreResult struct {
+	Mode     string         `json:"mode"`
+	Imported map[string]int `json:"imported"`
+	Skipped  map[string]int `json:"skipped,omitempty"`
 }
 
-// SearchCriteria represents search parameters for books
+// SearchCriteria represents search parameters for books. Price bounds only
+// match books priced in the same currency.
 type SearchCriteria struct {
 	Title    string
 	AuthorID int
 	Genre    string
-	MinPrice float64
-	MaxPrice float64
+	MinPrice Money
+	MaxPrice Money
 }
 
-// ErrorResponse represents an error response
+// FieldError describes why a single input field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse represents an error response. Code is stable and meant for
+// programs; Error is a human-readable message that may change.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Code      string       `json:"code"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// ProblemDetails is an error response in the RFC 7807 problem+json format,
+// carrying the fields of ErrorResponse as extension members
+type ProblemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
 }
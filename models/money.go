@@ -0,0 +1,439 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultCurrency is the currency of amounts that do not name one
+const DefaultCurrency = "USD"
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are
+// added or subtracted
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrAmountOverflow is returned when a sum or product does not fit in an
+// amount
+var ErrAmountOverflow = errors.New("amount out of range")
+
+// minorUnits is the number of minor units in a major unit. Every currency is
+// kept to two decimal places.
+const minorUnits = 100
+
+// Money is an exact amount in the minor units (cents) of a currency. Sums and
+// products are computed on integers, so totals never drift. Rounding happens
+// only when a decimal with more than two places is parsed: it goes to the
+// nearest cent, with halves rounded away from zero.
+//
+// In JSON, amounts in DefaultCurrency are plain numbers with two decimals, as
+// prices have always been written, so existing database files and clients
+// keep working. Other currencies are written as {"amount": 12.30,
+// "currency": "EUR"}. Both forms are read.
+type Money struct {
+	// Amount is the number of minor units
+	Amount int64
+	// Currency is an ISO 4217 code; empty means DefaultCurrency
+	Currency string
+}
+
+// Cents returns an amount of minor units in DefaultCurrency
+func Cents(amount int64) Money {
+	return Money{Amount: amount}
+}
+
+// decimalPattern matches the amounts ParseMoney accepts: an optional sign,
+// digits and an optional fraction, without exponents
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)$`)
+
+// ParseMoney parses a decimal amount such as "12.30" in currency, rounding it
+// to the nearest minor unit. Fractions such as "1/3" and exponents such as
+// "1e2" are rejected.
+func ParseMoney(value, currency string) (Money, error) {
+	value = strings.TrimSpace(value)
+	var r big.Rat
+	if !decimalPattern.MatchString(value) {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if _, ok := r.SetString(value); !ok {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+
+	// Round half away from zero: |n| * 100 / d, plus one when the remainder
+	// is at least half of d
+	n := new(big.Int).Mul(new(big.Int).Abs(r.Num()), big.NewInt(minorUnits))
+	q, rem := new(big.Int).QuoRem(n, r.Denom(), new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("amount %q is out of range", value)
+	}
+
+	amount := q.Int64()
+	if r.Sign() < 0 {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: normalizeCurrency(currency)}, nil
+}
+
+// CurrencyCode returns the currency of m, DefaultCurrency when unset
+func (m Money) CurrencyCode() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// SameCurrency reports whether m and o are in the same currency
+func (m Money) SameCurrency(o Money) bool {
+	return m.CurrencyCode() == o.CurrencyCode()
+}
+
+// IsZero reports whether m is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m + o. Both must be in the same currency, except that a zero
+// Money{} takes the currency of the other side, so sums can start from it.
+// Other mixes fail with ErrCurrencyMismatch, and sums that do not fit with
+// ErrAmountOverflow.
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.sumCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrAmountOverflow, m, o)
+	}
+	return Money{Amount: sum, Currency: currency}, nil
+}
+
+// Sub returns m - o under the same rules as Add
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrAmountOverflow, m, o)
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Mul returns m times n, failing with ErrAmountOverflow when the product
+// does not fit
+func (m Money) Mul(n int) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(int64(n)))
+	if !product.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s × %d", ErrAmountOverflow, m, n)
+	}
+	return Money{Amount: product.Int64(), Currency: m.Currency}, nil
+}
+
+// Cmp compares the amounts of m and o, returning -1, 0 or +1
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Decimal formats the amount with two decimals, e.g. "12.30" or "-0.05"
+func (m Money) Decimal() string {
+	// The magnitude is unsigned, as negating math.MinInt64 overflows
+	sign, amount := "", uint64(m.Amount)
+	if m.Amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/minorUnits, amount%minorUnits)
+}
+
+// String formats the amount with its currency, e.g. "12.30 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.CurrencyCode()
+}
+
+// moneyJSON is the JSON object form of amounts outside DefaultCurrency
+type moneyJSON struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+// MarshalJSON writes m as a number, or as an object when its currency is
+// not DefaultCurrency
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.CurrencyCode() == DefaultCurrency {
+		return []byte(m.Decimal()), nil
+	}
+	return json.Marshal(moneyJSON{Amount: json.Number(m.Decimal()), Currency: m.Currency})
+}
+
+// UnmarshalJSON reads a number, a numeric string or an object with amount
+// and currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	var value, currency string
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var object moneyJSON
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		value, currency = object.Amount.String(), object.Currency
+	case len(data) > 0 && data[0] == '"':
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+	default:
+		value = string(data)
+	}
+
+	parsed, err := ParseMoney(value, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// sumCurrency returns the currency of a sum of m and o
+func (m Money) sumCurrency(o Money) (string, error) {
+	switch {
+	case m.SameCurrency(o):
+		return m.Currency, nil
+	case m == Money{}:
+		return o.Currency, nil
+	case o == Money{}:
+		return m.Currency, nil
+	default:
+		return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.CurrencyCode(), o.CurrencyCode())
+	}
+}
+
+// normalizeCurrency upper-cases a currency code and leaves DefaultCurrency
+// unset, so amounts compare equal however their currency was written
+func normalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == DefaultCurrency {
+		return ""
+	}
+	return currency
+}
+
+// MoneyTotals is a sum of amounts kept per currency, keyed by currency code,
+// so amounts in different currencies are never added together. Currencies
+// whose total comes to zero are dropped. In JSON, totals held only in
+// DefaultCurrency are a plain number, as single-currency totals have always
+// been written; otherwise they are an object such as {"EUR": 5.00,
+// "USD": 12.30}.
+type MoneyTotals map[string]Money
+
+// Add adds m to the total of its currency
+func (t *MoneyTotals) Add(m Money) error {
+	if m.IsZero() {
+		return nil
+	}
+	if *t == nil {
+		*t = make(MoneyTotals)
+	}
+	code := m.CurrencyCode()
+	sum, err := (*t)[code].Add(m)
+	if err != nil {
+		return err
+	}
+	if sum.IsZero() {
+		delete(*t, code)
+		return nil
+	}
+	(*t)[code] = sum
+	return nil
+}
+
+// AddTotals adds every total in o
+func (t *MoneyTotals) AddTotals(o MoneyTotals) error {
+	for _, amount := range o.Amounts() {
+		if err := t.Add(amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sub returns t - o, currency by currency
+func (t MoneyTotals) Sub(o MoneyTotals) (MoneyTotals, error) {
+	var result MoneyTotals
+	if err := result.AddTotals(t); err != nil {
+		return nil, err
+	}
+	for _, amount := range o.Amounts() {
+		negated, err := Money{Currency: amount.Currency}.Sub(amount)
+		if err != nil {
+			return nil, err
+		}
+		if err := result.Add(negated); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Get returns the total in currency, zero when there is none
+func (t MoneyTotals) Get(currency string) Money {
+	currency = normalizeCurrency(currency)
+	if total, exists := t[Money{Currency: currency}.CurrencyCode()]; exists {
+		return total
+	}
+	return Money{Currency: currency}
+}
+
+// Currencies returns the currency codes of t and o, sorted
+func (t MoneyTotals) Currencies(o MoneyTotals) []string {
+	codes := make([]string, 0, len(t)+len(o))
+	for code := range t {
+		codes = append(codes, code)
+	}
+	for code := range o {
+		if _, exists := t[code]; !exists {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Amounts returns the totals ordered by currency code
+func (t MoneyTotals) Amounts() []Money {
+	amounts := make([]Money, 0, len(t))
+	for _, code := range t.Currencies(nil) {
+		amounts = append(amounts, t[code])
+	}
+	return amounts
+}
+
+// Cmp compares t and o currency by currency in code order, returning -1, 0
+// or +1 at the first currency whose totals differ
+func (t MoneyTotals) Cmp(o MoneyTotals) int {
+	for _, code := range t.Currencies(o) {
+		if c := t.Get(code).Cmp(o.Get(code)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// defaultOnly reports whether t holds no currency but DefaultCurrency
+func (t MoneyTotals) defaultOnly() bool {
+	for code := range t {
+		if code != DefaultCurrency {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the totals with their currencies, e.g. "5.00 EUR, 12.30 USD"
+func (t MoneyTotals) String() string {
+	if len(t) == 0 {
+		return Money{}.String()
+	}
+	parts := make([]string, 0, len(t))
+	for _, amount := range t.Amounts() {
+		parts = append(parts, amount.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MarshalJSON writes t as a number when it holds only DefaultCurrency, and
+// as an object keyed by currency code otherwise
+func (t MoneyTotals) MarshalJSON() ([]byte, error) {
+	if t.defaultOnly() {
+		return t.Get(DefaultCurrency).MarshalJSON()
+	}
+	object := make(map[string]json.Number, len(t))
+	for code, amount := range t {
+		object[code] = json.Number(amount.Decimal())
+	}
+	return json.Marshal(object)
+}
+
+// UnmarshalJSON reads a single amount in any form Money accepts, or an
+// object keyed by currency code
+func (t *MoneyTotals) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	var object map[string]json.RawMessage
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+	}
+
+	*t = nil
+	if _, single := object["amount"]; object == nil || single {
+		var amount Money
+		if err := amount.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		return t.Add(amount)
+	}
+	for code, raw := range object {
+		var value json.Number
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("invalid %s total: %w", code, err)
+		}
+		amount, err := ParseMoney(value.String(), code)
+		if err != nil {
+			return err
+		}
+		if err := t.Add(amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PercentChanges holds a percentage per currency code; nil entries mark
+// currencies with nothing to compare against. In JSON, changes only in
+// DefaultCurrency are a plain number or null, as before; otherwise they are
+// an object keyed by currency code.
+type PercentChanges map[string]*float64
+
+// MarshalJSON writes p as a number or null when it holds only
+// DefaultCurrency, and as an object keyed by currency code otherwise
+func (p PercentChanges) MarshalJSON() ([]byte, error) {
+	for code := range p {
+		if code != DefaultCurrency {
+			return json.Marshal(map[string]*float64(p))
+		}
+	}
+	return json.Marshal(p[DefaultCurrency])
+}
+
+// UnmarshalJSON reads a number, null or an object keyed by currency code
+func (p *PercentChanges) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var object map[string]*float64
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		*p = object
+		return nil
+	}
+	var value *float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*p = PercentChanges{DefaultCurrency: value}
+	return nil
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{value: "12.30", want: Money{Amount: 1230}},
+		{value: "12.3", want: Money{Amount: 1230}},
+		{value: "12", want: Money{Amount: 1200}},
+		{value: " 0.01 ", want: Money{Amount: 1}},
+		{value: "-0.05", want: Money{Amount: -5}},
+		{value: "5.005", want: Money{Amount: 501}},
+		{value: "5.0049", want: Money{Amount: 500}},
+		{value: "-5.005", want: Money{Amount: -501}},
+		{value: ".5", want: Money{Amount: 50}},
+		{value: "+3.", want: Money{Amount: 300}},
+		{value: "7.50", currency: "eur", want: Money{Amount: 750, Currency: "EUR"}},
+		{value: "7.50", currency: "usd", want: Money{Amount: 750}},
+		{value: "abc", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "1e2", wantErr: true},
+		{value: "1/3", wantErr: true},
+		{value: "-1/3", wantErr: true},
+		{value: "0x10", wantErr: true},
+		{value: "1_000", wantErr: true},
+		{value: "1.2.3", wantErr: true},
+		{value: "-", wantErr: true},
+		{value: ".", wantErr: true},
+		{value: "92233720368547758.08", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMoney(%q, %q) error = %v, wantErr %v", tt.value, tt.currency, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %#v, want %#v", tt.value, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: Money{}, want: `0.00`},
+		{money: Cents(1230), want: `12.30`},
+		{money: Cents(-5), want: `-0.05`},
+		{money: Cents(math.MaxInt64), want: `92233720368547758.07`},
+		{money: Cents(math.MinInt64), want: `-92233720368547758.08`},
+		{money: Money{Amount: 750, Currency: "EUR"}, want: `{"amount":7.50,"currency":"EUR"}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.money)
+		if err != nil {
+			t.Errorf("Marshal(%#v) error = %v", tt.money, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Marshal(%#v) = %s, want %s", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    Money
+		wantErr bool
+	}{
+		{data: `12.3`, want: Cents(1230)},
+		{data: `"12.30"`, want: Cents(1230)},
+		{data: `{"amount": 7.5, "currency": "eur"}`, want: Money{Amount: 750, Currency: "EUR"}},
+		{data: `{"amount": "7.50", "currency": "USD"}`, want: Cents(750)},
+		{data: `null`, want: Money{}},
+		{data: `"abc"`, wantErr: true},
+		{data: `{"amount": true}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	eur := func(amount int64) Money { return Money{Amount: amount, Currency: "EUR"} }
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{name: "add", op: func() (Money, error) { return Cents(100).Add(Cents(25)) }, want: Cents(125)},
+		{name: "add to zero value", op: func() (Money, error) { return Money{}.Add(eur(5)) }, want: eur(5)},
+		{name: "add zero value", op: func() (Money, error) { return eur(5).Add(Money{}) }, want: eur(5)},
+		{name: "add mixed currencies", op: func() (Money, error) { return Cents(100).Add(eur(5)) }, wantErr: ErrCurrencyMismatch},
+		{name: "add overflow", op: func() (Money, error) { return Cents(math.MaxInt64).Add(Cents(1)) }, wantErr: ErrAmountOverflow},
+		{name: "sub", op: func() (Money, error) { return eur(100).Sub(eur(150)) }, want: eur(-50)},
+		{name: "sub mixed currencies", op: func() (Money, error) { return eur(100).Sub(Cents(1)) }, wantErr: ErrCurrencyMismatch},
+		{name: "sub overflow", op: func() (Money, error) { return Cents(0).Sub(Cents(math.MinInt64)) }, wantErr: ErrAmountOverflow},
+		{name: "mul", op: func() (Money, error) { return eur(250).Mul(3) }, want: eur(750)},
+		{name: "mul negative", op: func() (Money, error) { return Cents(250).Mul(-1) }, want: Cents(-250)},
+		{name: "mul overflow", op: func() (Money, error) { return Cents(math.MaxInt64 / 2).Mul(3) }, wantErr: ErrAmountOverflow},
+	}
+	for _, tt := range tests {
+		got, err := tt.op()
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyTotals(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []Money
+		want    string
+	}{
+		{name: "empty", want: `0.00`},
+		{name: "default currency", amounts: []Money{Cents(1000), Cents(230)}, want: `12.30`},
+		{name: "several currencies", amounts: []Money{Cents(1230), {Amount: 500, Currency: "EUR"}}, want: `{"EUR":5.00,"USD":12.30}`},
+		{name: "other currency only", amounts: []Money{{Amount: 500, Currency: "EUR"}}, want: `{"EUR":5.00}`},
+		{name: "zero totals dropped", amounts: []Money{Cents(100), {Amount: 500, Currency: "EUR"}, {Amount: -500, Currency: "EUR"}}, want: `1.00`},
+	}
+	for _, tt := range tests {
+		var totals MoneyTotals
+		for _, amount := range tt.amounts {
+			if err := totals.Add(amount); err != nil {
+				t.Fatalf("%s: Add(%v) error = %v", tt.name, amount, err)
+			}
+		}
+		got, err := json.Marshal(totals)
+		if err != nil {
+			t.Errorf("%s: Marshal error = %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: Marshal = %s, want %s", tt.name, got, tt.want)
+		}
+
+		var decoded MoneyTotals
+		if err := json.Unmarshal(got, &decoded); err != nil {
+			t.Errorf("%s: Unmarshal(%s) error = %v", tt.name, got, err)
+			continue
+		}
+		if decoded.Cmp(totals) != 0 || len(decoded) != len(totals) {
+			t.Errorf("%s: Unmarshal(%s) = %v, want %v", tt.name, got, decoded, totals)
+		}
+	}
+}
+
+func TestMoneyTotalsSub(t *testing.T) {
+	current := MoneyTotals{"USD": Cents(1500), "EUR": {Amount: 500, Currency: "EUR"}}
+	previous := MoneyTotals{"USD": Cents(1000), "GBP": {Amount: 200, Currency: "GBP"}}
+
+	delta, err := current.Sub(previous)
+	if err != nil {
+		t.Fatalf("Sub error = %v", err)
+	}
+	want := map[string]Money{
+		"USD": Cents(500),
+		"EUR": {Amount: 500, Currency: "EUR"},
+		"GBP": {Amount: -200, Currency: "GBP"},
+	}
+	if len(delta) != len(want) {
+		t.Fatalf("Sub = %v, want %v", delta, want)
+	}
+	for currency, amount := range want {
+		if got := delta.Get(currency); got != amount {
+			t.Errorf("Sub[%s] = %v, want %v", currency, got, amount)
+		}
+	}
+}
+
+func TestPercentChangesJSON(t *testing.T) {
+	half := 50.0
+	tests := []struct {
+		changes PercentChanges
+		want    string
+	}{
+		{changes: nil, want: `null`},
+		{changes: PercentChanges{"USD": &half}, want: `50`},
+		{changes: PercentChanges{"USD": nil}, want: `null`},
+		{changes: PercentChanges{"USD": &half, "EUR": nil}, want: `{"EUR":null,"USD":50}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.changes)
+		if err != nil {
+			t.Errorf("Marshal(%v) error = %v", tt.changes, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.changes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Basis selects the moment an order counts towards a report window
+type Basis string
+
+// Available revenue bases
+const (
+	// BasisCreated counts orders when they are placed
+	BasisCreated Basis = "created"
+	// BasisPaid counts orders when they are first paid
+	BasisPaid Basis = "paid"
+	// BasisDelivered counts orders when they are first delivered
+	BasisDelivered Basis = "delivered"
+)
+
+// ParseBasis parses a revenue basis; an empty value means BasisCreated
+func ParseBasis(value string) (Basis, error) {
+	switch basis := Basis(strings.ToLower(strings.TrimSpace(value))); basis {
+	case "":
+		return BasisCreated, nil
+	case BasisCreated, BasisPaid, BasisDelivered:
+		return basis, nil
+	default:
+		return "", fmt.Errorf("unknown basis %q", value)
+	}
+}
+
+// status returns the order status whose first entry in the history counts
+// the order, or "" when orders count at creation
+func (b Basis) status() string {
+	switch b {
+	case BasisPaid:
+		return models.OrderPaid
+	case BasisDelivered:
+		return models.OrderDelivered
+	default:
+		return ""
+	}
+}
+
+// reversalStatuses undo an order counted when paid or delivered
+var reversalStatuses = []string{models.OrderCancelled, models.OrderRefunded}
+
+// basisEntry is an order counting towards a report window. Sign is +1 when
+// the order counts and -1 when an order counted earlier is cancelled or
+// refunded.
+type basisEntry struct {
+	order models.Order
+	at    time.Time
+	sign  int
+}
+
+// ordersForBasis returns the entries counting towards [start, end) under
+// basis, oldest first. On the paid and delivered bases an order counts when
+// its history first reaches that status, and counts again negatively when it
+// is later cancelled or refunded, in the window where that happened, so
+// reports already written stay correct. Orders without a history never
+// count; the database migration to format version 4 gives orders saved
+// before histories were kept one dated at their creation, so this only
+// applies to histories removed by hand. Only orders with a matching status
+// change in the window are read.
+func ordersForBasis(ctx context.Context, orderStore interfaces.OrderStore, start, end time.Time, basis Basis) ([]basisEntry, error) {
+	status := basis.status()
+	var orders []models.Order
+	var err error
+	if status == "" {
+		orders, err = orderStore.GetOrdersInTimeRange(ctx, start, end)
+		orders = ordersInWindow(orders, end)
+	} else {
+		orders, err = ordersChangedInTimeRange(ctx, orderStore, append([]string{status}, reversalStatuses...), start, end)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch orders: %w", err)
+	}
+
+	entries := make([]basisEntry, 0, len(orders))
+	for _, order := range orders {
+		if status == "" {
+			entries = append(entries, basisEntry{order: order, at: order.CreatedAt, sign: 1})
+			continue
+		}
+		reachedAt, reversedAt, reached, reversed := statusSpan(order, status)
+		if reached && inWindow(reachedAt, start, end) {
+			entries = append(entries, basisEntry{order: order, at: reachedAt, sign: 1})
+		}
+		if reversed && inWindow(reversedAt, start, end) {
+			entries = append(entries, basisEntry{order: order, at: reversedAt, sign: -1})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].at.Equal(entries[j].at) {
+			return entries[i].at.Before(entries[j].at)
+		}
+		if entries[i].order.ID != entries[j].order.ID {
+			return entries[i].order.ID < entries[j].order.ID
+		}
+		return entries[i].sign > entries[j].sign
+	})
+	return entries, nil
+}
+
+// ordersChangedInTimeRange returns the orders whose status changed to one of
+// statuses within [start, end). Stores without a status index return every
+// order created before end, which the caller filters.
+func ordersChangedInTimeRange(ctx context.Context, orderStore interfaces.OrderStore, statuses []string, start, end time.Time) ([]models.Order, error) {
+	if index, ok := orderStore.(interfaces.OrderStatusIndex); ok {
+		return index.GetOrdersChangedInTimeRange(ctx, statuses, start, end)
+	}
+	// An order is always placed before its status changes
+	return orderStore.GetOrdersInTimeRange(ctx, time.Time{}, end)
+}
+
+// statusSpan returns when an order's history first reaches status and, if
+// it was then cancelled or refunded, when that happened
+func statusSpan(order models.Order, status string) (reachedAt, reversedAt time.Time, reached, reversed bool) {
+	for _, change := range order.History {
+		switch {
+		case !reached && change.NewStatus == status:
+			reachedAt, reached = change.Timestamp, true
+		case reached && slices.Contains(reversalStatuses, change.NewStatus):
+			return reachedAt, change.Timestamp, true, true
+		}
+	}
+	return reachedAt, time.Time{}, reached, false
+}
+
+// inWindow reports whether t falls within [start, end)
+func inWindow(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
@@ -0,0 +1,228 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// unindexedOrderStore hides the status index of the wrapped store
+type unindexedOrderStore struct {
+	interfaces.OrderStore
+}
+
+// step is a status change made at a time
+type step struct {
+	status string
+	at     time.Time
+}
+
+// moved appends status changes to an order's history
+func moved(order models.Order, steps ...step) models.Order {
+	for _, s := range steps {
+		change := models.OrderStatusChange{Timestamp: s.at, PreviousStatus: order.Status, NewStatus: s.status}
+		order.History = append(order.History, change)
+		order.Status = change.NewStatus
+	}
+	return order
+}
+
+func TestGenerateSalesReportBasis(t *testing.T) {
+	a, b, c, d := testBook(1, 1000), testBook(2, 100), testBook(3, 10), testBook(4, 1)
+	orders := []models.Order{
+		// Paid in the window, delivered in the next one
+		moved(testOrder(day.Add(-48*time.Hour), line(a, 1)),
+			step{models.OrderPaid, day.Add(time.Hour)},
+			step{models.OrderShipped, day.Add(2 * time.Hour)},
+			step{models.OrderDelivered, day.Add(30 * time.Hour)}),
+		// Paid and cancelled in the window
+		moved(testOrder(day, line(b, 2)),
+			step{models.OrderPaid, day.Add(2 * time.Hour)},
+			step{models.OrderCancelled, day.Add(3 * time.Hour)}),
+		// Paid and delivered earlier, refunded in the window
+		moved(testOrder(day.Add(-48*time.Hour), line(c, 3)),
+			step{models.OrderPaid, day.Add(-24 * time.Hour)},
+			step{models.OrderShipped, day.Add(-22 * time.Hour)},
+			step{models.OrderDelivered, day.Add(-20 * time.Hour)},
+			step{models.OrderRefunded, day.Add(5 * time.Hour)}),
+		// Cancelled before payment
+		moved(testOrder(day, line(d, 4)),
+			step{models.OrderCancelled, day.Add(time.Hour)}),
+		// Created in the window, paid in the next one
+		moved(testOrder(day.Add(time.Hour), line(d, 5)),
+			step{models.OrderPaid, day.Add(25 * time.Hour)}),
+	}
+	first, second := day, day.Add(24*time.Hour)
+
+	tests := []struct {
+		name        string
+		basis       Basis
+		start       time.Time
+		wantOrders  int
+		wantRevenue int64
+		wantBooks   int
+		wantTop     [][2]int
+	}{
+		{
+			name:        "created",
+			basis:       BasisCreated,
+			start:       first,
+			wantOrders:  3,
+			wantRevenue: 200 + 4 + 5,
+			wantBooks:   11,
+			wantTop:     [][2]int{{4, 9}, {2, 2}},
+		},
+		{
+			name:        "paid",
+			basis:       BasisPaid,
+			start:       first,
+			wantOrders:  1 + 1 - 1 - 1,
+			wantRevenue: 1000 + 200 - 200 - 30,
+			wantBooks:   1 + 2 - 2 - 3,
+			wantTop:     [][2]int{{1, 1}, {3, -3}},
+		},
+		{
+			name:        "paid, next window",
+			basis:       BasisPaid,
+			start:       second,
+			wantOrders:  1,
+			wantRevenue: 5,
+			wantBooks:   5,
+			wantTop:     [][2]int{{4, 5}},
+		},
+		{
+			name:        "delivered",
+			basis:       BasisDelivered,
+			start:       first,
+			wantOrders:  -1,
+			wantRevenue: -30,
+			wantBooks:   -3,
+			wantTop:     [][2]int{{3, -3}},
+		},
+		{
+			name:        "delivered, next window",
+			basis:       BasisDelivered,
+			start:       second,
+			wantOrders:  1,
+			wantRevenue: 1000,
+			wantBooks:   1,
+			wantTop:     [][2]int{{1, 1}},
+		},
+	}
+	stores := map[string]func(t *testing.T) interfaces.OrderStore{
+		"indexed": func(t *testing.T) interfaces.OrderStore { return newOrderStore(t, orders...) },
+		"unindexed": func(t *testing.T) interfaces.OrderStore {
+			return unindexedOrderStore{newOrderStore(t, orders...)}
+		},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				store := newStore(t)
+				end := tt.start.Add(24 * time.Hour)
+				report, err := GenerateSalesReport(ctx, store, tt.start, end, Options{Basis: tt.basis})
+				if err != nil {
+					t.Fatalf("GenerateSalesReport error = %v", err)
+				}
+
+				if report.TotalOrders != tt.wantOrders {
+					t.Errorf("orders = %d, want %d", report.TotalOrders, tt.wantOrders)
+				}
+				if got := report.TotalRevenue.Get(models.DefaultCurrency); got != models.Cents(tt.wantRevenue) {
+					t.Errorf("revenue = %v, want %v", got, models.Cents(tt.wantRevenue))
+				}
+				if report.TotalBooksSold != tt.wantBooks {
+					t.Errorf("books sold = %d, want %d", report.TotalBooksSold, tt.wantBooks)
+				}
+				if got := bookQuantities(report.TopSellingBooks); !reflect.DeepEqual(got, tt.wantTop) {
+					t.Errorf("top books = %v, want %v", got, tt.wantTop)
+				}
+
+				count, err := CountOrders(ctx, store, tt.start, end, tt.basis)
+				if err != nil {
+					t.Fatalf("CountOrders error = %v", err)
+				}
+				if count != tt.wantOrders {
+					t.Errorf("CountOrders = %d, want %d", count, tt.wantOrders)
+				}
+			})
+		}
+	}
+}
+
+func TestGenerateSalesReportBasisSplitsAcrossWindows(t *testing.T) {
+	// An order paid and then cancelled a window later nets to zero over both
+	a := testBook(1, 1000)
+	store := newOrderStore(t, moved(testOrder(day, line(a, 2)),
+		step{models.OrderPaid, day.Add(23 * time.Hour)},
+		step{models.OrderCancelled, day.Add(25 * time.Hour)}))
+
+	var orders, books int
+	var revenue models.MoneyTotals
+	for start := day; start.Before(day.Add(72 * time.Hour)); start = start.Add(24 * time.Hour) {
+		report, err := GenerateSalesReport(context.Background(), store, start, start.Add(24*time.Hour), Options{Basis: BasisPaid})
+		if err != nil {
+			t.Fatalf("GenerateSalesReport error = %v", err)
+		}
+		orders += report.TotalOrders
+		books += report.TotalBooksSold
+		if err := revenue.AddTotals(report.TotalRevenue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if orders != 0 || books != 0 || len(revenue) != 0 {
+		t.Errorf("totals over every window = %d orders, %d books, %v; want none", orders, books, revenue)
+	}
+}
+
+func TestGenerateSalesReportBasisMigratedOrder(t *testing.T) {
+	// A delivered order saved before orders kept a history is counted as
+	// paid and delivered when it was created
+	path := filepath.Join(t.TempDir(), "database.json")
+	content := `{"version": 3, "orders": {"1": {"id": 1, "created_at": "2024-03-01T09:00:00Z", "status": "delivered",
+		"items": [{"book": {"id": 1}, "quantity": 2, "unit_price": 10}], "total_price": 20}}}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := stores.NewInMemoryOrderStore()
+	if _, err := stores.LoadDatabase(path, store); err != nil {
+		t.Fatalf("LoadDatabase error = %v", err)
+	}
+
+	for _, basis := range []Basis{BasisCreated, BasisPaid, BasisDelivered} {
+		report, err := GenerateSalesReport(context.Background(), store, day, day.Add(24*time.Hour), Options{Basis: basis})
+		if err != nil {
+			t.Fatalf("GenerateSalesReport error = %v", err)
+		}
+		if report.TotalOrders != 1 || report.TotalBooksSold != 2 {
+			t.Errorf("%s basis: %d orders, %d books sold; want 1 and 2", basis, report.TotalOrders, report.TotalBooksSold)
+		}
+	}
+}
+
+func TestParseBasis(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Basis
+		wantErr bool
+	}{
+		{value: "", want: BasisCreated},
+		{value: "created", want: BasisCreated},
+		{value: " Paid ", want: BasisPaid},
+		{value: "DELIVERED", want: BasisDelivered},
+		{value: "shipped", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBasis(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBasis(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package reports
+
+import (
+	"fmt"
+	"online-bookstore-api/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Breakdown names an optional section of a sales report
+type Breakdown string
+
+// Available report breakdowns
+const (
+	BreakdownGenre   Breakdown = "genre"
+	BreakdownAuthor  Breakdown = "author"
+	BreakdownCountry Breakdown = "country"
+)
+
+// AllBreakdowns lists every breakdown section
+var AllBreakdowns = []Breakdown{BreakdownGenre, BreakdownAuthor, BreakdownCountry}
+
+// unknownKey groups sales whose genre, author or country is missing
+const unknownKey = "Unknown"
+
+// ParseBreakdowns parses a comma separated list such as "genre,country"
+func ParseBreakdowns(value string) ([]Breakdown, error) {
+	var breakdowns []Breakdown
+	for _, part := range strings.Split(value, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		breakdown := Breakdown(part)
+		if !breakdown.valid() {
+			return nil, fmt.Errorf("unknown report section %q", part)
+		}
+		if !hasBreakdown(breakdowns, breakdown) {
+			breakdowns = append(breakdowns, breakdown)
+		}
+	}
+	return breakdowns, nil
+}
+
+// SelectBreakdowns drops the breakdown sections of a report that were not requested
+func SelectBreakdowns(report models.SalesReport, breakdowns []Breakdown) models.SalesReport {
+	if !hasBreakdown(breakdowns, BreakdownGenre) {
+		report.ByGenre = nil
+	}
+	if !hasBreakdown(breakdowns, BreakdownAuthor) {
+		report.ByAuthor = nil
+	}
+	if !hasBreakdown(breakdowns, BreakdownCountry) {
+		report.ByCountry = nil
+	}
+	return report
+}
+
+// valid reports whether b is a known breakdown
+func (b Breakdown) valid() bool {
+	return hasBreakdown(AllBreakdowns, b)
+}
+
+// hasBreakdown reports whether breakdowns contains b
+func hasBreakdown(breakdowns []Breakdown, b Breakdown) bool {
+	for _, candidate := range breakdowns {
+		if candidate == b {
+			return true
+		}
+	}
+	return false
+}
+
+// breakdownTally accumulates sales for one breakdown section
+type breakdownTally struct {
+	entries map[string]*models.SalesBreakdown
+}
+
+// newBreakdownTally creates an empty tally
+func newBreakdownTally() *breakdownTally {
+	return &breakdownTally{entries: make(map[string]*models.SalesBreakdown)}
+}
+
+// add records revenue and units under a key. Keys are grouped
+// case-insensitively, keeping the first spelling seen.
+func (t *breakdownTally) add(key, name string, revenue models.MoneyTotals, units int) error {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = unknownKey
+	}
+
+	folded := strings.ToLower(key)
+	entry, exists := t.entries[folded]
+	if !exists {
+		entry = &models.SalesBreakdown{Key: key}
+		t.entries[folded] = entry
+	}
+	if name != "" {
+		entry.Name = name
+	}
+	if err := entry.Revenue.AddTotals(revenue); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	entry.Units += units
+	return nil
+}
+
+// sorted returns the entries ordered by revenue, then units, then key
+func (t *breakdownTally) sorted() []models.SalesBreakdown {
+	result := make([]models.SalesBreakdown, 0, len(t.entries))
+	for _, entry := range t.entries {
+		result = append(result, *entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if c := result[i].Revenue.Cmp(result[j].Revenue); c != 0 {
+			return c > 0
+		}
+		if result[i].Units != result[j].Units {
+			return result[i].Units > result[j].Units
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// breakdownSet holds the tallies for the requested breakdowns
+type breakdownSet struct {
+	genre   *breakdownTally
+	author  *breakdownTally
+	country *breakdownTally
+}
+
+// newBreakdownSet creates tallies for the requested breakdowns only
+func newBreakdownSet(breakdowns []Breakdown) breakdownSet {
+	var set breakdownSet
+	if hasBreakdown(breakdowns, BreakdownGenre) {
+		set.genre = newBreakdownTally()
+	}
+	if hasBreakdown(breakdowns, BreakdownAuthor) {
+		set.author = newBreakdownTally()
+	}
+	if hasBreakdown(breakdowns, BreakdownCountry) {
+		set.country = newBreakdownTally()
+	}
+	return set
+}
+
+// addItem records one order line, negated when sign is -1. A book with
+// several genres counts fully in each of them, so genre totals can exceed
+// the report totals.
+func (s breakdownSet) addItem(order models.Order, item models.OrderItem, sign int) error {
+	subtotal, err := item.Subtotal()
+	if err != nil {
+		return err
+	}
+	if subtotal, err = subtotal.Mul(sign); err != nil {
+		return err
+	}
+	var revenue models.MoneyTotals
+	if err := revenue.Add(subtotal); err != nil {
+		return err
+	}
+	units := item.Quantity * sign
+
+	if s.genre != nil {
+		if len(item.Book.Genres) == 0 {
+			if err := s.genre.add(unknownKey, "", revenue, units); err != nil {
+				return err
+			}
+		}
+		for _, genre := range uniqueGenres(item.Book.Genres) {
+			if err := s.genre.add(genre, "", revenue, units); err != nil {
+				return err
+			}
+		}
+	}
+	if s.author != nil {
+		author := item.Book.Author
+		key := ""
+		if author.ID != 0 {
+			key = strconv.Itoa(author.ID)
+		}
+		name := strings.TrimSpace(author.FirstName + " " + author.LastName)
+		if err := s.author.add(key, name, revenue, units); err != nil {
+			return err
+		}
+	}
+	if s.country != nil {
+		if err := s.country.add(order.Customer.Address.Country, "", revenue, units); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply stores the tallied sections on the report
+func (s breakdownSet) apply(report *models.SalesReport) {
+	if s.genre != nil {
+		report.ByGenre = s.genre.sorted()
+	}
+	if s.author != nil {
+		report.ByAuthor = s.author.sorted()
+	}
+	if s.country != nil {
+		report.ByCountry = s.country.sorted()
+	}
+}
+
+// uniqueGenres removes case-insensitive duplicates from a genre list
+func uniqueGenres(genres []string) []string {
+	seen := make(map[string]bool, len(genres))
+	unique := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		folded := strings.ToLower(strings.TrimSpace(genre))
+		if seen[folded] {
+			continue
+		}
+		seen[folded] = true
+		unique = append(unique, genre)
+	}
+	return unique
+}
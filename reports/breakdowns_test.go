@@ -0,0 +1,105 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// breakdownEntry is a SalesBreakdown with its revenue in DefaultCurrency
+type breakdownEntry struct {
+	key, name string
+	revenue   int64
+	units     int
+}
+
+func breakdownEntries(sales []models.SalesBreakdown) []breakdownEntry {
+	if sales == nil {
+		return nil
+	}
+	entries := make([]breakdownEntry, len(sales))
+	for i, entry := range sales {
+		entries[i] = breakdownEntry{entry.Key, entry.Name, entry.Revenue.Get(models.DefaultCurrency).Amount, entry.Units}
+	}
+	return entries
+}
+
+func TestGenerateSalesReportBreakdowns(t *testing.T) {
+	a := testBook(1, 1000)
+	a.Genres = []string{"Fiction", "Mystery", " fiction"}
+	a.Author = models.Author{ID: 7, FirstName: "Ada", LastName: "Lovelace"}
+	b := testBook(2, 100)
+	shipTo := func(order models.Order, country string) models.Order {
+		order.Customer.Address.Country = country
+		return order
+	}
+	orders := []models.Order{
+		shipTo(testOrder(day, line(a, 1), line(b, 2)), "FR"),
+		shipTo(testOrder(day.Add(time.Hour), line(b, 1)), "fr"),
+		shipTo(testOrder(day.Add(2*time.Hour), line(a, 2)), ""),
+	}
+
+	genres := []breakdownEntry{{"Fiction", "", 3000, 3}, {"Mystery", "", 3000, 3}, {"Unknown", "", 300, 3}}
+	authors := []breakdownEntry{{"7", "Ada Lovelace", 3000, 3}, {"Unknown", "", 300, 3}}
+	countries := []breakdownEntry{{"Unknown", "", 2000, 2}, {"FR", "", 1300, 4}}
+	tests := []struct {
+		name          string
+		breakdowns    []Breakdown
+		wantGenres    []breakdownEntry
+		wantAuthors   []breakdownEntry
+		wantCountries []breakdownEntry
+	}{
+		{name: "none"},
+		{name: "genre", breakdowns: []Breakdown{BreakdownGenre}, wantGenres: genres},
+		{name: "author and country", breakdowns: []Breakdown{BreakdownCountry, BreakdownAuthor}, wantAuthors: authors, wantCountries: countries},
+		{name: "all", breakdowns: AllBreakdowns, wantGenres: genres, wantAuthors: authors, wantCountries: countries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newOrderStore(t, orders...)
+			report, err := GenerateSalesReport(context.Background(), store, day, day.Add(24*time.Hour), Options{Breakdowns: tt.breakdowns})
+			if err != nil {
+				t.Fatalf("GenerateSalesReport error = %v", err)
+			}
+			if got := breakdownEntries(report.ByGenre); !reflect.DeepEqual(got, tt.wantGenres) {
+				t.Errorf("by genre = %v, want %v", got, tt.wantGenres)
+			}
+			if got := breakdownEntries(report.ByAuthor); !reflect.DeepEqual(got, tt.wantAuthors) {
+				t.Errorf("by author = %v, want %v", got, tt.wantAuthors)
+			}
+			if got := breakdownEntries(report.ByCountry); !reflect.DeepEqual(got, tt.wantCountries) {
+				t.Errorf("by country = %v, want %v", got, tt.wantCountries)
+			}
+		})
+	}
+}
+
+func TestParseBreakdowns(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []Breakdown
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "genre", want: []Breakdown{BreakdownGenre}},
+		{value: " Country , genre,,country", want: []Breakdown{BreakdownCountry, BreakdownGenre}},
+		{value: "genre,publisher", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBreakdowns(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseBreakdowns(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSelectBreakdowns(t *testing.T) {
+	section := []models.SalesBreakdown{{Key: "x"}}
+	report := models.SalesReport{ByGenre: section, ByAuthor: section, ByCountry: section}
+	got := SelectBreakdowns(report, []Breakdown{BreakdownAuthor})
+	if got.ByGenre != nil || got.ByAuthor == nil || got.ByCountry != nil {
+		t.Errorf("SelectBreakdowns kept genre %v, author %v, country %v; want only author", got.ByGenre, got.ByAuthor, got.ByCountry)
+	}
+}
@@ -0,0 +1,101 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strings"
+	"time"
+)
+
+// Comparison selects the earlier window a report is compared with
+type Comparison string
+
+// Available comparison bases
+const (
+	// ComparePrevious compares with the window of equal length just before
+	ComparePrevious Comparison = "previous"
+	// CompareWeek compares with the same window one week earlier
+	CompareWeek Comparison = "week"
+)
+
+// ParseComparison parses a comparison basis; an empty value means none
+func ParseComparison(value string) (Comparison, error) {
+	switch comparison := Comparison(strings.ToLower(strings.TrimSpace(value))); comparison {
+	case "", ComparePrevious, CompareWeek:
+		return comparison, nil
+	default:
+		return "", fmt.Errorf("unknown comparison %q", value)
+	}
+}
+
+// window returns the earlier window matching [start, end)
+func (c Comparison) window(start, end time.Time) (time.Time, time.Time) {
+	shift := end.Sub(start)
+	if c == CompareWeek {
+		shift = 7 * 24 * time.Hour
+	}
+	return start.Add(-shift), end.Add(-shift)
+}
+
+// compareWithPrevious builds the comparison section of a report
+func compareWithPrevious(ctx context.Context, orderStore interfaces.OrderStore, report models.SalesReport, basis Comparison, orderBasis Basis) (*models.SalesComparison, error) {
+	start, end := basis.window(report.PeriodStart, report.PeriodEnd)
+	previous, err := GenerateSalesReport(ctx, orderStore, start, end, Options{TopBooks: -1, Basis: orderBasis})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate comparison report: %w", err)
+	}
+
+	revenueDelta, err := report.TotalRevenue.Sub(previous.TotalRevenue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare revenue: %w", err)
+	}
+	revenueChange := make(models.PercentChanges)
+	for _, currency := range report.TotalRevenue.Currencies(previous.TotalRevenue) {
+		revenueChange[currency] = percentChange(float64(report.TotalRevenue.Get(currency).Amount),
+			float64(previous.TotalRevenue.Get(currency).Amount))
+	}
+
+	comparison := &models.SalesComparison{
+		Basis:                string(basis),
+		PeriodStart:          start,
+		PeriodEnd:            end,
+		TotalRevenue:         previous.TotalRevenue,
+		TotalOrders:          previous.TotalOrders,
+		RevenueDelta:         revenueDelta,
+		RevenueChangePercent: revenueChange,
+		OrdersDelta:          report.TotalOrders - previous.TotalOrders,
+		OrdersChangePercent:  percentChange(float64(report.TotalOrders), float64(previous.TotalOrders)),
+		TopSellingBooks:      make([]models.BookSalesChange, 0, len(report.TopSellingBooks)),
+	}
+
+	previousUnits := make(map[int]int, len(previous.TopSellingBooks))
+	for _, sales := range previous.TopSellingBooks {
+		previousUnits[sales.Book.ID] = sales.Quantity
+	}
+	for _, sales := range report.TopSellingBooks {
+		before := previousUnits[sales.Book.ID]
+		comparison.TopSellingBooks = append(comparison.TopSellingBooks, models.BookSalesChange{
+			BookID:           sales.Book.ID,
+			Title:            sales.Book.Title,
+			Quantity:         sales.Quantity,
+			PreviousQuantity: before,
+			Delta:            sales.Quantity - before,
+			ChangePercent:    percentChange(float64(sales.Quantity), float64(before)),
+		})
+	}
+
+	return comparison, nil
+}
+
+// percentChange returns the change from previous to current in percent,
+// rounded to two decimals, or nil when previous is zero
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round((current-previous)/previous*10000) / 100
+	return &change
+}
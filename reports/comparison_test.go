@@ -0,0 +1,100 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/models"
+	"testing"
+	"time"
+)
+
+func TestGenerateSalesReportComparison(t *testing.T) {
+	a := testBook(1, 1000)
+	store := newOrderStore(t,
+		testOrder(day.Add(-12*time.Hour), line(a, 2)),
+		testOrder(day.Add(time.Hour), line(a, 1)),
+		testOrder(day.Add(2*time.Hour), line(a, 2)),
+	)
+
+	report, err := GenerateSalesReport(context.Background(), store, day, day.Add(24*time.Hour), Options{Compare: ComparePrevious})
+	if err != nil {
+		t.Fatalf("GenerateSalesReport error = %v", err)
+	}
+	comparison := report.Comparison
+	if comparison == nil {
+		t.Fatal("report has no comparison")
+	}
+	if !comparison.PeriodStart.Equal(day.Add(-24*time.Hour)) || !comparison.PeriodEnd.Equal(day) {
+		t.Errorf("comparison window = %v - %v, want the previous day", comparison.PeriodStart, comparison.PeriodEnd)
+	}
+	if comparison.TotalOrders != 1 || comparison.OrdersDelta != 1 || *comparison.OrdersChangePercent != 100 {
+		t.Errorf("orders = %d, delta %d, change %v; want 1, 1, 100%%",
+			comparison.TotalOrders, comparison.OrdersDelta, *comparison.OrdersChangePercent)
+	}
+	if got := comparison.RevenueDelta.Get(models.DefaultCurrency); got != models.Cents(1000) {
+		t.Errorf("revenue delta = %v, want 10.00", got)
+	}
+	if got := comparison.RevenueChangePercent[models.DefaultCurrency]; got == nil || *got != 50 {
+		t.Errorf("revenue change = %v, want 50%%", got)
+	}
+	want := models.BookSalesChange{BookID: 1, Title: a.Title, Quantity: 3, PreviousQuantity: 2, Delta: 1}
+	if len(comparison.TopSellingBooks) != 1 {
+		t.Fatalf("comparison has %d books, want 1", len(comparison.TopSellingBooks))
+	}
+	got := comparison.TopSellingBooks[0]
+	if got.ChangePercent == nil || *got.ChangePercent != 50 {
+		t.Errorf("book change = %v, want 50%%", got.ChangePercent)
+	}
+	got.ChangePercent = nil
+	if got != want {
+		t.Errorf("book comparison = %+v, want %+v", got, want)
+	}
+}
+
+func TestComparisonWindow(t *testing.T) {
+	tests := []struct {
+		comparison Comparison
+		start, end time.Time
+		wantStart  time.Time
+		wantEnd    time.Time
+	}{
+		{ComparePrevious, day, day.Add(24 * time.Hour), day.Add(-24 * time.Hour), day},
+		{ComparePrevious, day, day.Add(time.Hour), day.Add(-time.Hour), day},
+		{CompareWeek, day, day.Add(24 * time.Hour), day.AddDate(0, 0, -7), day.AddDate(0, 0, -6)},
+		{CompareWeek, day, day.Add(time.Hour), day.AddDate(0, 0, -7), day.AddDate(0, 0, -7).Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		start, end := tt.comparison.window(tt.start, tt.end)
+		if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+			t.Errorf("%s window of %v - %v = %v - %v, want %v - %v",
+				tt.comparison, tt.start, tt.end, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	tests := []struct {
+		current, previous float64
+		want              *float64
+	}{
+		{current: 150, previous: 100, want: ptr(50)},
+		{current: 50, previous: 100, want: ptr(-50)},
+		{current: 1, previous: 3, want: ptr(-66.67)},
+		{current: -10, previous: 10, want: ptr(-200)},
+		{current: 10, previous: 0, want: nil},
+	}
+	for _, tt := range tests {
+		got := percentChange(tt.current, tt.previous)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("percentChange(%v, %v) = %v, want %v", tt.current, tt.previous, deref(got), deref(tt.want))
+		}
+	}
+}
+
+func ptr(f float64) *float64 { return &f }
+
+func deref(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
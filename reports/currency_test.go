@@ -0,0 +1,53 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/models"
+	"testing"
+	"time"
+)
+
+func TestGenerateSalesReportCurrencies(t *testing.T) {
+	usd := testBook(1, 1000)
+	eur := testBook(2, 0)
+	eur.Price = models.Money{Amount: 300, Currency: "EUR"}
+	store := newOrderStore(t,
+		testOrder(day.Add(-time.Hour), line(usd, 1)),
+		testOrder(day.Add(-2*time.Hour), line(eur, 1)),
+		testOrder(day.Add(time.Hour), line(usd, 1)),
+		testOrder(day.Add(2*time.Hour), line(eur, 2)),
+		testOrder(day.Add(3*time.Hour), line(eur, 1)),
+	)
+
+	report, err := GenerateSalesReport(context.Background(), store, day, day.Add(24*time.Hour), Options{Compare: ComparePrevious})
+	if err != nil {
+		t.Fatalf("GenerateSalesReport error = %v", err)
+	}
+
+	tests := []struct {
+		currency   string
+		wantTotal  int64
+		wantDelta  int64
+		wantChange float64
+	}{
+		{currency: "USD", wantTotal: 1000, wantDelta: 0, wantChange: 0},
+		{currency: "EUR", wantTotal: 900, wantDelta: 600, wantChange: 200},
+	}
+	if len(report.TotalRevenue) != len(tests) {
+		t.Errorf("revenue = %v, want totals in %d currencies", report.TotalRevenue, len(tests))
+	}
+	for _, tt := range tests {
+		if got := report.TotalRevenue.Get(tt.currency); got.Amount != tt.wantTotal || got.CurrencyCode() != tt.currency {
+			t.Errorf("%s revenue = %v, want %d minor units", tt.currency, got, tt.wantTotal)
+		}
+		if got := report.Comparison.RevenueDelta.Get(tt.currency).Amount; got != tt.wantDelta {
+			t.Errorf("%s revenue delta = %d, want %d", tt.currency, got, tt.wantDelta)
+		}
+		if got := report.Comparison.RevenueChangePercent[tt.currency]; got == nil || *got != tt.wantChange {
+			t.Errorf("%s revenue change = %v, want %v%%", tt.currency, deref(got), tt.wantChange)
+		}
+	}
+	if report.TotalOrders != 3 || report.TotalBooksSold != 4 {
+		t.Errorf("orders = %d, books sold = %d; want 3 and 4", report.TotalOrders, report.TotalBooksSold)
+	}
+}
@@ -0,0 +1,231 @@
+package reports
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strconv"
+	"time"
+)
+
+// utf8BOM makes Excel detect CSV files as UTF-8
+const utf8BOM = "\ufeff"
+
+// CSVOptions controls the CSV dialect of exports
+type CSVOptions struct {
+	// Excel writes a UTF-8 byte order mark and CRLF line endings
+	Excel bool
+}
+
+// reportCSVHeader lists the columns of a flattened sales report. The
+// section column tells top-selling book rows from breakdown rows, which
+// leave the book columns empty and count units in quantity_sold.
+var reportCSVHeader = []string{
+	"timestamp", "period_start", "period_end",
+	"total_revenue", "total_orders", "total_books_sold",
+	"rank", "book_id", "title", "quantity_sold",
+	"section", "key", "name", "revenue",
+}
+
+// sectionTopBooks is the section column of top-selling book rows
+const sectionTopBooks = "top_books"
+
+// orderLineCSVHeader lists the columns of the order line-item export
+var orderLineCSVHeader = []string{
+	"order_id", "created_at", "status",
+	"customer_id", "customer_name",
+	"book_id", "title", "quantity", "unit_price", "line_total",
+}
+
+// ReportCSVWriter writes sales reports as CSV, one row per top-selling book
+// followed by one row per entry of each breakdown section the report holds.
+// Reports without sales get a single row with empty book columns.
+type ReportCSVWriter struct {
+	w             *csv.Writer
+	out           io.Writer
+	opts          CSVOptions
+	headerWritten bool
+}
+
+// NewReportCSVWriter creates a CSV writer for sales reports
+func NewReportCSVWriter(out io.Writer, opts CSVOptions) *ReportCSVWriter {
+	return &ReportCSVWriter{w: newCSVWriter(out, opts), out: out, opts: opts}
+}
+
+// Write appends the rows for one report
+func (rw *ReportCSVWriter) Write(report models.SalesReport) error {
+	if !rw.headerWritten {
+		if err := writeCSVHeader(rw.out, rw.w, rw.opts, reportCSVHeader); err != nil {
+			return err
+		}
+		rw.headerWritten = true
+	}
+
+	totals := []string{
+		formatTime(report.Timestamp),
+		formatTime(report.PeriodStart),
+		formatTime(report.PeriodEnd),
+		formatTotals(report.TotalRevenue),
+		strconv.Itoa(report.TotalOrders),
+		strconv.Itoa(report.TotalBooksSold),
+	}
+
+	if len(report.TopSellingBooks) == 0 {
+		return rw.w.Write(append(totals, "", "", "", "", "", "", "", ""))
+	}
+	for i, sales := range report.TopSellingBooks {
+		row := append(append([]string{}, totals...),
+			strconv.Itoa(i+1),
+			strconv.Itoa(sales.Book.ID),
+			sales.Book.Title,
+			strconv.Itoa(sales.Quantity),
+			sectionTopBooks, "", "", "",
+		)
+		if err := rw.w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	for _, section := range []struct {
+		breakdown Breakdown
+		entries   []models.SalesBreakdown
+	}{
+		{BreakdownGenre, report.ByGenre},
+		{BreakdownAuthor, report.ByAuthor},
+		{BreakdownCountry, report.ByCountry},
+	} {
+		for i, entry := range section.entries {
+			row := append(append([]string{}, totals...),
+				strconv.Itoa(i+1), "", "",
+				strconv.Itoa(entry.Units),
+				string(section.breakdown), entry.Key, entry.Name,
+				formatTotals(entry.Revenue),
+			)
+			if err := rw.w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered rows to the underlying writer. The header is written
+// even when no report was, so empty exports are still valid CSV.
+func (rw *ReportCSVWriter) Flush() error {
+	if !rw.headerWritten {
+		if err := writeCSVHeader(rw.out, rw.w, rw.opts, reportCSVHeader); err != nil {
+			return err
+		}
+		rw.headerWritten = true
+	}
+	rw.w.Flush()
+	return rw.w.Error()
+}
+
+// WriteOrderLinesCSV streams every order line created in [start, end) as CSV.
+// Stores implementing interfaces.OrderIterator are read one order at a time.
+func WriteOrderLinesCSV(ctx context.Context, out io.Writer, orderStore interfaces.OrderStore, start, end time.Time, opts CSVOptions) error {
+	w := newCSVWriter(out, opts)
+	if err := writeCSVHeader(out, w, opts, orderLineCSVHeader); err != nil {
+		return err
+	}
+
+	writeOrder := func(order models.Order) error {
+		if !order.CreatedAt.Before(end) {
+			return nil
+		}
+		for _, item := range order.Items {
+			title := item.Title
+			if title == "" {
+				title = item.Book.Title
+			}
+			subtotal, err := item.Subtotal()
+			if err != nil {
+				return fmt.Errorf("order %d: %w", order.ID, err)
+			}
+			row := []string{
+				strconv.Itoa(order.ID),
+				formatTime(order.CreatedAt),
+				order.Status,
+				strconv.Itoa(order.Customer.ID),
+				order.Customer.Name,
+				strconv.Itoa(item.Book.ID),
+				title,
+				strconv.Itoa(item.Quantity),
+				formatAmount(item.Price()),
+				formatAmount(subtotal),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if iterator, ok := orderStore.(interfaces.OrderIterator); ok {
+		if err := iterator.ForEachOrderInTimeRange(ctx, start, end, writeOrder); err != nil {
+			return fmt.Errorf("failed to export orders: %w", err)
+		}
+	} else {
+		orders, err := orderStore.GetOrdersInTimeRange(ctx, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to fetch orders: %w", err)
+		}
+		for _, order := range orders {
+			if err := writeOrder(order); err != nil {
+				return fmt.Errorf("failed to export orders: %w", err)
+			}
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// newCSVWriter creates a csv.Writer for the requested dialect
+func newCSVWriter(out io.Writer, opts CSVOptions) *csv.Writer {
+	w := csv.NewWriter(out)
+	w.UseCRLF = opts.Excel
+	return w
+}
+
+// writeCSVHeader writes the optional byte order mark and the header row
+func writeCSVHeader(out io.Writer, w *csv.Writer, opts CSVOptions, header []string) error {
+	if opts.Excel {
+		if _, err := io.WriteString(out, utf8BOM); err != nil {
+			return err
+		}
+	}
+	return w.Write(header)
+}
+
+// formatTime formats timestamps for CSV cells
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatAmount formats money amounts with two decimals, followed by the
+// currency code when it is not the default currency
+func formatAmount(amount models.Money) string {
+	if amount.CurrencyCode() != models.DefaultCurrency {
+		return amount.String()
+	}
+	return amount.Decimal()
+}
+
+// formatTotals formats per-currency totals, as a plain amount when they are
+// all in the default currency
+func formatTotals(totals models.MoneyTotals) string {
+	for currency := range totals {
+		if currency != models.DefaultCurrency {
+			return totals.String()
+		}
+	}
+	return formatAmount(totals.Get(models.DefaultCurrency))
+}
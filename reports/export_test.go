@@ -0,0 +1,124 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares output with testdata/name, or rewrites the file when
+// the tests run with -update
+func checkGolden(t *testing.T, name string, output []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, output, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, want) {
+		t.Errorf("output differs from %s:\n%q\nwant:\n%q", path, output, want)
+	}
+}
+
+func TestReportCSVWriter(t *testing.T) {
+	quoted := models.Book{ID: 1, Title: `Notes, "annotated"`}
+	multiline := models.Book{ID: 2, Title: "Letters\nVolume 2"}
+	sold := models.SalesReport{
+		Timestamp:      day.Add(24 * time.Hour),
+		PeriodStart:    day,
+		PeriodEnd:      day.Add(24 * time.Hour),
+		TotalRevenue:   models.MoneyTotals{models.DefaultCurrency: models.Cents(2250), "EUR": {Amount: 900, Currency: "EUR"}},
+		TotalOrders:    2,
+		TotalBooksSold: 4,
+		TopSellingBooks: []models.BookSales{
+			{Book: quoted, Quantity: 3},
+			{Book: multiline, Quantity: 1},
+		},
+		ByGenre: []models.SalesBreakdown{
+			{Key: "Science", Revenue: models.MoneyTotals{models.DefaultCurrency: models.Cents(2000)}, Units: 2},
+		},
+		ByAuthor: []models.SalesBreakdown{
+			{Key: "1", Name: "Lovelace, Ada", Revenue: models.MoneyTotals{models.DefaultCurrency: models.Cents(2250)}, Units: 3},
+		},
+	}
+	empty := models.SalesReport{
+		Timestamp:   day.Add(48 * time.Hour),
+		PeriodStart: day.Add(24 * time.Hour),
+		PeriodEnd:   day.Add(48 * time.Hour),
+	}
+
+	tests := []struct {
+		golden string
+		opts   CSVOptions
+	}{
+		{golden: "report.csv"},
+		{golden: "report_excel.csv", opts: CSVOptions{Excel: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewReportCSVWriter(&buf, tt.opts)
+			for _, report := range []models.SalesReport{sold, SelectBreakdowns(sold, []Breakdown{BreakdownAuthor}), empty} {
+				if err := w.Write(report); err != nil {
+					t.Fatalf("Write error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush error = %v", err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestReportCSVWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewReportCSVWriter(&buf, CSVOptions{Excel: true}).Flush(); err != nil {
+		t.Fatalf("Flush error = %v", err)
+	}
+	want := "\ufefftimestamp,period_start,period_end,total_revenue,total_orders,total_books_sold," +
+		"rank,book_id,title,quantity_sold,section,key,name,revenue\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("empty export = %q, want %q", got, want)
+	}
+}
+
+func TestWriteOrderLinesCSV(t *testing.T) {
+	book := models.Book{ID: 1, Title: "Notes", Price: models.Cents(1000)}
+	order := testOrder(day.Add(time.Hour),
+		models.OrderItem{Book: book, Quantity: 2, UnitPrice: models.Cents(950), Title: `Notes, "first" edition`},
+		models.OrderItem{Book: models.Book{ID: 2, Title: "Carnets"}, Quantity: 1, UnitPrice: models.Money{Amount: 900, Currency: "EUR"}},
+	)
+	order.Customer = models.Customer{ID: 7, Name: "Reader, Jane"}
+	store := newOrderStore(t, order, testOrder(day.Add(25*time.Hour), line(book, 1)))
+
+	tests := []struct {
+		golden string
+		opts   CSVOptions
+	}{
+		{golden: "order_lines.csv"},
+		{golden: "order_lines_excel.csv", opts: CSVOptions{Excel: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteOrderLinesCSV(context.Background(), &buf, store, day, day.Add(24*time.Hour), tt.opts); err != nil {
+				t.Fatalf("WriteOrderLinesCSV error = %v", err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package reports
+
+import (
+	"online-bookstore-api/models"
+	"sort"
+)
+
+// MergeReports combines reports covering disjoint windows into one summary
+// report of the given kind. Totals, book sales and breakdowns are summed, so
+// the result matches a report generated over the combined window as long as
+// the sources kept every book sold (TopBooks < 0). Revenue is summed per
+// currency. Comparisons are dropped.
+func MergeReports(kind string, sources []models.SalesReport) (models.SalesReport, error) {
+	sorted := append([]models.SalesReport(nil), sources...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].PeriodEnd.Before(sorted[j].PeriodEnd)
+	})
+
+	merged := models.SalesReport{Kind: kind}
+	sales := make(map[int]*models.BookSales)
+	var genre, author, country *breakdownTally
+
+	for _, source := range sorted {
+		if merged.PeriodStart.IsZero() || (!source.PeriodStart.IsZero() && source.PeriodStart.Before(merged.PeriodStart)) {
+			merged.PeriodStart = source.PeriodStart
+		}
+		if source.PeriodEnd.After(merged.PeriodEnd) {
+			merged.PeriodEnd = source.PeriodEnd
+		}
+		if source.Timestamp.After(merged.Timestamp) {
+			merged.Timestamp = source.Timestamp
+		}
+
+		merged.Basis = source.Basis
+		if err := merged.TotalRevenue.AddTotals(source.TotalRevenue); err != nil {
+			return models.SalesReport{}, err
+		}
+		merged.TotalOrders += source.TotalOrders
+		merged.TotalBooksSold += source.TotalBooksSold
+		merged.MergedFrom = append(merged.MergedFrom, source.MergedFrom...)
+
+		for _, bookSales := range source.TopSellingBooks {
+			entry, exists := sales[bookSales.Book.ID]
+			if !exists {
+				entry = &models.BookSales{}
+				sales[bookSales.Book.ID] = entry
+			}
+			// Sources are processed oldest first so the latest book details win
+			entry.Book = bookSales.Book
+			entry.Quantity += bookSales.Quantity
+		}
+
+		var err error
+		if genre, err = mergeBreakdown(genre, source.ByGenre); err != nil {
+			return models.SalesReport{}, err
+		}
+		if author, err = mergeBreakdown(author, source.ByAuthor); err != nil {
+			return models.SalesReport{}, err
+		}
+		if country, err = mergeBreakdown(country, source.ByCountry); err != nil {
+			return models.SalesReport{}, err
+		}
+	}
+
+	merged.TopSellingBooks = rankBookSales(sales, -1)
+	breakdownSet{genre: genre, author: author, country: country}.apply(&merged)
+	return merged, nil
+}
+
+// mergeBreakdown adds a stored breakdown section to a tally, creating the
+// tally the first time a source carries the section
+func mergeBreakdown(tally *breakdownTally, entries []models.SalesBreakdown) (*breakdownTally, error) {
+	if entries == nil {
+		return tally, nil
+	}
+	if tally == nil {
+		tally = newBreakdownTally()
+	}
+	for _, entry := range entries {
+		if err := tally.add(entry.Key, entry.Name, entry.Revenue, entry.Units); err != nil {
+			return nil, err
+		}
+	}
+	return tally, nil
+}
+
+// LimitTopBooks trims the top-selling books of a report to at most limit entries
+func LimitTopBooks(report models.SalesReport, limit int) models.SalesReport {
+	if limit >= 0 && len(report.TopSellingBooks) > limit {
+		report.TopSellingBooks = report.TopSellingBooks[:limit]
+	}
+	if report.Comparison != nil && limit >= 0 && len(report.Comparison.TopSellingBooks) > limit {
+		comparison := *report.Comparison
+		comparison.TopSellingBooks = comparison.TopSellingBooks[:limit]
+		report.Comparison = &comparison
+	}
+	return report
+}
@@ -0,0 +1,136 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"sort"
+	"time"
+)
+
+// DefaultTopBooks is the number of top-selling books kept when no limit is given
+const DefaultTopBooks = 5
+
+// Options controls how a sales report is built
+type Options struct {
+	// TopBooks limits TopSellingBooks; a negative value keeps every book sold
+	TopBooks int
+	// Breakdowns selects the optional breakdown sections to compute
+	Breakdowns []Breakdown
+	// Compare adds a comparison with an earlier window when set
+	Compare Comparison
+	// Basis selects when orders count; empty means BasisCreated
+	Basis Basis
+}
+
+// GenerateSalesReport builds a sales report for orders counting in [start, end),
+// by default those created in the window; opts.Basis can count them when
+// paid or delivered instead, taking cancellations and refunds off the window
+// they happen in. The end bound is exclusive so that consecutive windows
+// never count an order twice.
+func GenerateSalesReport(ctx context.Context, orderStore interfaces.OrderStore, start, end time.Time, opts Options) (models.SalesReport, error) {
+	if !end.After(start) {
+		return models.SalesReport{}, fmt.Errorf("report window end %s must be after start %s", end, start)
+	}
+
+	// Orders come oldest first so the latest book details win
+	entries, err := ordersForBasis(ctx, orderStore, start, end, opts.Basis)
+	if err != nil {
+		return models.SalesReport{}, err
+	}
+
+	report := models.SalesReport{
+		Timestamp:   end,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+	if opts.Basis != "" && opts.Basis != BasisCreated {
+		report.Basis = string(opts.Basis)
+	}
+
+	bookSales := make(map[int]*models.BookSales)
+	breakdowns := newBreakdownSet(opts.Breakdowns)
+	for _, entry := range entries {
+		order := entry.order
+		report.TotalOrders += entry.sign
+		revenue, err := order.TotalPrice.Mul(entry.sign)
+		if err == nil {
+			err = report.TotalRevenue.Add(revenue)
+		}
+		if err != nil {
+			return models.SalesReport{}, fmt.Errorf("order %d: %w", order.ID, err)
+		}
+
+		for _, item := range order.Items {
+			quantity := item.Quantity * entry.sign
+			report.TotalBooksSold += quantity
+
+			sales, exists := bookSales[item.Book.ID]
+			if !exists {
+				sales = &models.BookSales{}
+				bookSales[item.Book.ID] = sales
+			}
+			sales.Book = item.Book
+			sales.Quantity += quantity
+
+			if err := breakdowns.addItem(order, item, entry.sign); err != nil {
+				return models.SalesReport{}, fmt.Errorf("order %d: %w", order.ID, err)
+			}
+		}
+	}
+
+	report.TopSellingBooks = rankBookSales(bookSales, opts.topBooks())
+	breakdowns.apply(&report)
+
+	if opts.Compare != "" {
+		comparison, err := compareWithPrevious(ctx, orderStore, report, opts.Compare, opts.Basis)
+		if err != nil {
+			return models.SalesReport{}, err
+		}
+		report.Comparison = comparison
+	}
+	return report, nil
+}
+
+// ordersInWindow drops orders created exactly at the exclusive end bound
+func ordersInWindow(orders []models.Order, end time.Time) []models.Order {
+	filtered := make([]models.Order, 0, len(orders))
+	for _, order := range orders {
+		if order.CreatedAt.Before(end) {
+			filtered = append(filtered, order)
+		}
+	}
+	return filtered
+}
+
+// rankBookSales orders book sales by quantity sold, breaking ties by book ID.
+// Books whose sales were all reversed are left out.
+func rankBookSales(sales map[int]*models.BookSales, limit int) []models.BookSales {
+	ranked := make([]models.BookSales, 0, len(sales))
+	for _, entry := range sales {
+		if entry.Quantity != 0 {
+			ranked = append(ranked, *entry)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Quantity != ranked[j].Quantity {
+			return ranked[i].Quantity > ranked[j].Quantity
+		}
+		return ranked[i].Book.ID < ranked[j].Book.ID
+	})
+
+	if limit >= 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// topBooks returns the effective TopSellingBooks limit
+func (o Options) topBooks() int {
+	if o.TopBooks == 0 {
+		return DefaultTopBooks
+	}
+	return o.TopBooks
+}
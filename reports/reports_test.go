@@ -0,0 +1,145 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"online-bookstore-api/stores"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// day is the start of the test window
+var day = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func testBook(id int, price int64) models.Book {
+	return models.Book{ID: id, Title: "Book " + string(rune('A'+id-1)), Price: models.Cents(price)}
+}
+
+// testOrder builds an order created at the given time, priced from its lines
+func testOrder(createdAt time.Time, items ...models.OrderItem) models.Order {
+	order := models.Order{CreatedAt: createdAt, Items: items, Status: models.OrderPending}
+	for _, item := range items {
+		subtotal, _ := item.Subtotal()
+		order.TotalPrice, _ = order.TotalPrice.Add(subtotal)
+	}
+	order.History = []models.OrderStatusChange{{Timestamp: createdAt, NewStatus: models.OrderPending}}
+	return order
+}
+
+func line(book models.Book, quantity int) models.OrderItem {
+	return models.OrderItem{Book: book, Quantity: quantity, UnitPrice: book.Price}
+}
+
+// newOrderStore returns an in-memory order store holding orders
+func newOrderStore(t *testing.T, orders ...models.Order) interfaces.OrderStore {
+	t.Helper()
+	store := stores.NewInMemoryOrderStore()
+	for _, order := range orders {
+		if _, err := store.CreateOrder(context.Background(), order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// bookQuantities returns the book IDs and quantities of a ranking
+func bookQuantities(sales []models.BookSales) [][2]int {
+	pairs := make([][2]int, len(sales))
+	for i, entry := range sales {
+		pairs[i] = [2]int{entry.Book.ID, entry.Quantity}
+	}
+	return pairs
+}
+
+func TestGenerateSalesReport(t *testing.T) {
+	a, b, c := testBook(1, 1000), testBook(2, 250), testBook(3, 100)
+	orders := []models.Order{
+		testOrder(day.Add(-time.Second), line(a, 9)), // before the window
+		testOrder(day, line(a, 1), line(b, 2)),       // at the start
+		testOrder(day.Add(12*time.Hour), line(b, 1), line(c, 3)),
+		testOrder(day.Add(23*time.Hour), line(c, 1)),
+		testOrder(day.Add(24*time.Hour), line(c, 9)), // at the exclusive end
+	}
+
+	tests := []struct {
+		name        string
+		start, end  time.Time
+		opts        Options
+		wantRevenue int64
+		wantOrders  int
+		wantBooks   int
+		wantTop     [][2]int
+	}{
+		{
+			name:        "day",
+			start:       day,
+			end:         day.Add(24 * time.Hour),
+			wantRevenue: 1000 + 500 + 250 + 300 + 100,
+			wantOrders:  3,
+			wantBooks:   8,
+			wantTop:     [][2]int{{3, 4}, {2, 3}, {1, 1}},
+		},
+		{
+			name:        "top books limited",
+			start:       day,
+			end:         day.Add(24 * time.Hour),
+			opts:        Options{TopBooks: 2},
+			wantRevenue: 2150,
+			wantOrders:  3,
+			wantBooks:   8,
+			wantTop:     [][2]int{{3, 4}, {2, 3}},
+		},
+		{
+			name:        "first half of the day, every book",
+			start:       day,
+			end:         day.Add(12 * time.Hour),
+			opts:        Options{TopBooks: -1},
+			wantRevenue: 1500,
+			wantOrders:  1,
+			wantBooks:   3,
+			wantTop:     [][2]int{{2, 2}, {1, 1}},
+		},
+		{
+			name:    "empty window",
+			start:   day.Add(time.Hour),
+			end:     day.Add(2 * time.Hour),
+			wantTop: [][2]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newOrderStore(t, orders...)
+			report, err := GenerateSalesReport(context.Background(), store, tt.start, tt.end, tt.opts)
+			if err != nil {
+				t.Fatalf("GenerateSalesReport error = %v", err)
+			}
+
+			if got := report.TotalRevenue.Get(models.DefaultCurrency); got != models.Cents(tt.wantRevenue) {
+				t.Errorf("revenue = %v, want %v", got, models.Cents(tt.wantRevenue))
+			}
+			if report.TotalOrders != tt.wantOrders {
+				t.Errorf("orders = %d, want %d", report.TotalOrders, tt.wantOrders)
+			}
+			if report.TotalBooksSold != tt.wantBooks {
+				t.Errorf("books sold = %d, want %d", report.TotalBooksSold, tt.wantBooks)
+			}
+			if got := bookQuantities(report.TopSellingBooks); !reflect.DeepEqual(got, tt.wantTop) {
+				t.Errorf("top books = %v, want %v", got, tt.wantTop)
+			}
+			if !report.PeriodStart.Equal(tt.start) || !report.PeriodEnd.Equal(tt.end) || report.Basis != "" {
+				t.Errorf("period = %v - %v basis %q, want %v - %v", report.PeriodStart, report.PeriodEnd, report.Basis, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestGenerateSalesReportInvalidWindow(t *testing.T) {
+	store := newOrderStore(t)
+	for _, end := range []time.Time{day, day.Add(-time.Hour)} {
+		if _, err := GenerateSalesReport(context.Background(), store, day, end, Options{}); err == nil {
+			t.Errorf("GenerateSalesReport(%v, %v) succeeded, want an error", day, end)
+		}
+	}
+}
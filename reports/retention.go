@@ -0,0 +1,207 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy decides when stored reports are rolled into summaries.
+// Periodic reports older than KeepDays are merged into weekly summaries and
+// weekly summaries older than KeepWeeks into monthly ones; the originals are
+// deleted once the summary is safely written. A zero value disables a step.
+type RetentionPolicy struct {
+	KeepDays  int
+	KeepWeeks int
+}
+
+// Enabled reports whether the policy compacts anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepDays > 0 || p.KeepWeeks > 0
+}
+
+// Validate checks that weekly summaries outlive the periodic reports feeding
+// them, otherwise a week could be rolled into a month while still growing
+func (p RetentionPolicy) Validate() error {
+	if p.KeepDays < 0 || p.KeepWeeks < 0 {
+		return fmt.Errorf("retention periods must not be negative")
+	}
+	if p.KeepDays > 0 && p.KeepWeeks > 0 && p.KeepWeeks*7 <= p.KeepDays {
+		return fmt.Errorf("weekly retention (%d weeks) must be longer than daily retention (%d days)", p.KeepWeeks, p.KeepDays)
+	}
+	return nil
+}
+
+// compactMu serializes compaction runs from the scheduler and the admin endpoint
+var compactMu sync.Mutex
+
+// Compact applies the retention policy to the reports stored in dir.
+// Every summary records the periodic reports it was built from, so a run
+// interrupted between writing a summary and deleting its sources never
+// counts the same sales twice.
+func Compact(dir string, policy RetentionPolicy, now time.Time) (models.CompactionResult, error) {
+	compactMu.Lock()
+	defer compactMu.Unlock()
+
+	result := models.CompactionResult{SummariesWritten: []string{}}
+	if err := policy.Validate(); err != nil {
+		return result, err
+	}
+
+	files, err := listReportFiles(dir)
+	if err != nil {
+		return result, err
+	}
+
+	if policy.KeepDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.KeepDays)
+		var expired []reportFile
+		for _, file := range files {
+			if file.Kind == "" && !file.Time.After(cutoff) {
+				expired = append(expired, file)
+			}
+		}
+		if err := rollUp(dir, KindWeekly, expired, weekBucketEnd, &result); err != nil {
+			return result, err
+		}
+
+		// Weekly summaries may have changed; list again for the monthly step
+		if files, err = listReportFiles(dir); err != nil {
+			return result, err
+		}
+	}
+
+	if policy.KeepWeeks > 0 {
+		cutoff := now.AddDate(0, 0, -7*policy.KeepWeeks)
+		var expired []reportFile
+		for _, file := range files {
+			if file.Kind == KindWeekly && !file.Time.After(cutoff) {
+				expired = append(expired, file)
+			}
+		}
+		if err := rollUp(dir, KindMonthly, expired, monthBucketEnd, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// bucketFunc returns the end of the summary bucket a stored report belongs to
+type bucketFunc func(report models.SalesReport, file reportFile) time.Time
+
+// rollUp merges the given files into summaries of kind and deletes them
+func rollUp(dir, kind string, files []reportFile, bucketEnd bucketFunc, result *models.CompactionResult) error {
+	type source struct {
+		file   reportFile
+		report models.SalesReport
+	}
+
+	buckets := make(map[time.Time][]source)
+	for _, file := range files {
+		report, err := LoadReport(file.Path)
+		if err != nil {
+			return err
+		}
+		// Periodic reports are the leaves every summary is traced back to
+		if file.Kind == "" {
+			report.MergedFrom = []string{file.Name()}
+		}
+		end := bucketEnd(report, file)
+		buckets[end] = append(buckets[end], source{file: file, report: report})
+	}
+
+	ends := make([]time.Time, 0, len(buckets))
+	for end := range buckets {
+		ends = append(ends, end)
+	}
+	sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+
+	for _, end := range ends {
+		targetName := reportFilename(kind, end)
+		target := filepath.Join(dir, targetName)
+
+		var toMerge []models.SalesReport
+		merged := make(map[string]bool)
+		existing, err := LoadReport(target)
+		switch {
+		case err == nil:
+			toMerge = append(toMerge, existing)
+			for _, name := range existing.MergedFrom {
+				merged[name] = true
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+
+		var obsolete []reportFile
+		newSources := 0
+		for _, src := range buckets[end] {
+			done := 0
+			for _, name := range src.report.MergedFrom {
+				if merged[name] {
+					done++
+				}
+			}
+			switch {
+			case done == 0:
+				toMerge = append(toMerge, src.report)
+				newSources++
+			case done < len(src.report.MergedFrom):
+				return fmt.Errorf("%s is partially merged into %s; resolve manually", src.file.Name(), targetName)
+			}
+			obsolete = append(obsolete, src.file)
+		}
+
+		if newSources > 0 {
+			summary, err := MergeReports(kind, toMerge)
+			if err != nil {
+				return fmt.Errorf("failed to merge reports into %s: %w", targetName, err)
+			}
+			summary.Timestamp = end
+			if _, err := SaveReport(dir, summary); err != nil {
+				return err
+			}
+			result.ReportsMerged += newSources
+			result.SummariesWritten = append(result.SummariesWritten, targetName)
+		}
+
+		for _, file := range obsolete {
+			if err := os.Remove(file.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete compacted report: %w", err)
+			}
+			result.ReportsDeleted++
+		}
+	}
+	return nil
+}
+
+// weekBucketEnd places a periodic report in the week (Monday 00:00 UTC)
+// in which its window starts
+func weekBucketEnd(report models.SalesReport, file reportFile) time.Time {
+	start := report.PeriodStart
+	if start.IsZero() {
+		start = file.Time
+	}
+	day := startOfDay(start)
+	monday := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, 7)
+}
+
+// monthBucketEnd places a weekly summary in the month its week starts in
+func monthBucketEnd(_ models.SalesReport, file reportFile) time.Time {
+	weekStart := file.Time.AddDate(0, 0, -7)
+	return time.Date(weekStart.Year(), weekStart.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+}
+
+// startOfDay truncates t to midnight UTC
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
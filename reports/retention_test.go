@@ -0,0 +1,256 @@
+package reports
+
+import (
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// saveDailyReport writes a periodic report for the day starting at start
+// with the given number of orders, each selling one copy of book 1
+func saveDailyReport(t *testing.T, dir string, start time.Time, orders int) string {
+	t.Helper()
+	end := start.Add(24 * time.Hour)
+	report := models.SalesReport{
+		Timestamp:       end,
+		PeriodStart:     start,
+		PeriodEnd:       end,
+		TotalRevenue:    models.MoneyTotals{models.DefaultCurrency: models.Cents(int64(orders) * 100)},
+		TotalOrders:     orders,
+		TotalBooksSold:  orders,
+		TopSellingBooks: []models.BookSales{{Book: testBook(1, 100), Quantity: orders}},
+	}
+	path, err := SaveReport(dir, report)
+	if err != nil {
+		t.Fatalf("SaveReport error = %v", err)
+	}
+	return path
+}
+
+// storedReports returns the report file names in dir and their total orders
+func storedReports(t *testing.T, dir string) ([]string, int) {
+	t.Helper()
+	files, err := listReportFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	orders := 0
+	for _, file := range files {
+		report, err := LoadReport(file.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, file.Name())
+		orders += report.TotalOrders
+	}
+	return names, orders
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy  RetentionPolicy
+		wantErr bool
+	}{
+		{policy: RetentionPolicy{}},
+		{policy: RetentionPolicy{KeepDays: 7}},
+		{policy: RetentionPolicy{KeepWeeks: 4}},
+		{policy: RetentionPolicy{KeepDays: 7, KeepWeeks: 2}},
+		{policy: RetentionPolicy{KeepDays: 14, KeepWeeks: 2}, wantErr: true},
+		{policy: RetentionPolicy{KeepDays: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, want error %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	// day is a Friday; the third report starts the following week
+	tests := []struct {
+		name          string
+		policy        RetentionPolicy
+		now           time.Time
+		wantResult    models.CompactionResult
+		wantRemaining []string
+	}{
+		{
+			name:   "nothing expired",
+			policy: RetentionPolicy{KeepDays: 30},
+			now:    day.AddDate(0, 0, 9),
+			wantResult: models.CompactionResult{
+				SummariesWritten: []string{},
+			},
+			wantRemaining: []string{"report_030220240000.json", "report_030320240000.json", "report_030520240000.json"},
+		},
+		{
+			name:   "weekly",
+			policy: RetentionPolicy{KeepDays: 3},
+			now:    day.AddDate(0, 0, 9),
+			wantResult: models.CompactionResult{
+				ReportsMerged:    3,
+				ReportsDeleted:   3,
+				SummariesWritten: []string{"weekly_030420240000.json", "weekly_031120240000.json"},
+			},
+			wantRemaining: []string{"weekly_030420240000.json", "weekly_031120240000.json"},
+		},
+		{
+			name:   "weekly and monthly",
+			policy: RetentionPolicy{KeepDays: 3, KeepWeeks: 1},
+			now:    day.AddDate(0, 1, 19),
+			wantResult: models.CompactionResult{
+				ReportsMerged:  5,
+				ReportsDeleted: 5,
+				SummariesWritten: []string{
+					"weekly_030420240000.json", "weekly_031120240000.json",
+					"monthly_030120240000.json", "monthly_040120240000.json",
+				},
+			},
+			wantRemaining: []string{"monthly_030120240000.json", "monthly_040120240000.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			saveDailyReport(t, dir, day, 1)
+			saveDailyReport(t, dir, day.AddDate(0, 0, 1), 2)
+			saveDailyReport(t, dir, day.AddDate(0, 0, 3), 3)
+
+			result, err := Compact(dir, tt.policy, tt.now)
+			if err != nil {
+				t.Fatalf("Compact error = %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.wantResult) {
+				t.Errorf("Compact = %+v, want %+v", result, tt.wantResult)
+			}
+			names, orders := storedReports(t, dir)
+			if !reflect.DeepEqual(names, tt.wantRemaining) {
+				t.Errorf("remaining reports = %v, want %v", names, tt.wantRemaining)
+			}
+			if orders != 6 {
+				t.Errorf("remaining reports hold %d orders, want 6", orders)
+			}
+
+			// A second run has nothing left to do
+			again, err := Compact(dir, tt.policy, tt.now)
+			if err != nil {
+				t.Fatalf("second Compact error = %v", err)
+			}
+			if again.ReportsMerged != 0 || again.ReportsDeleted != 0 {
+				t.Errorf("second Compact = %+v, want no changes", again)
+			}
+		})
+	}
+}
+
+func TestCompactResumesInterruptedRun(t *testing.T) {
+	dir := t.TempDir()
+	first := saveDailyReport(t, dir, day, 1)
+	saveDailyReport(t, dir, day.AddDate(0, 0, 1), 2)
+	kept, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := RetentionPolicy{KeepDays: 3}
+	now := day.AddDate(0, 0, 9)
+	if _, err := Compact(dir, policy, now); err != nil {
+		t.Fatalf("Compact error = %v", err)
+	}
+
+	// The summary was written but the run stopped before deleting a source
+	if err := os.WriteFile(first, kept, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Compact(dir, policy, now)
+	if err != nil {
+		t.Fatalf("Compact error = %v", err)
+	}
+	if result.ReportsMerged != 0 || result.ReportsDeleted != 1 {
+		t.Errorf("Compact = %+v, want the source deleted without merging", result)
+	}
+	names, orders := storedReports(t, dir)
+	if want := []string{"weekly_030420240000.json"}; !reflect.DeepEqual(names, want) || orders != 3 {
+		t.Errorf("remaining reports = %v with %d orders, want %v with 3", names, orders, want)
+	}
+}
+
+func TestCompactRefusesPartialMerge(t *testing.T) {
+	dir := t.TempDir()
+	saveDailyReport(t, dir, day, 1)
+	saveDailyReport(t, dir, day.AddDate(0, 0, 1), 2)
+	policy := RetentionPolicy{KeepDays: 3, KeepWeeks: 1}
+	if _, err := Compact(dir, RetentionPolicy{KeepDays: 3}, day.AddDate(0, 0, 9)); err != nil {
+		t.Fatalf("Compact error = %v", err)
+	}
+
+	// A weekly summary of which the monthly summary holds only one source
+	weekly := filepath.Join(dir, "weekly_030420240000.json")
+	report, err := LoadReport(weekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monthly := models.SalesReport{Kind: KindMonthly, Timestamp: day, MergedFrom: report.MergedFrom[:1]}
+	if _, err := SaveReport(dir, monthly); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Compact(dir, policy, day.AddDate(0, 1, 19)); err == nil {
+		t.Error("Compact succeeded, want a partial merge error")
+	}
+	if _, err := os.Stat(weekly); err != nil {
+		t.Errorf("weekly summary was removed: %v", err)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	a, b := testBook(1, 100), testBook(2, 100)
+	sources := []models.SalesReport{
+		{
+			Timestamp:       day.Add(48 * time.Hour),
+			PeriodStart:     day.Add(24 * time.Hour),
+			PeriodEnd:       day.Add(48 * time.Hour),
+			TotalRevenue:    models.MoneyTotals{"EUR": {Amount: 500, Currency: "EUR"}},
+			TotalOrders:     1,
+			TotalBooksSold:  5,
+			TopSellingBooks: []models.BookSales{{Book: b, Quantity: 5}},
+			MergedFrom:      []string{"second"},
+		},
+		{
+			Timestamp:       day.Add(24 * time.Hour),
+			PeriodStart:     day,
+			PeriodEnd:       day.Add(24 * time.Hour),
+			TotalRevenue:    models.MoneyTotals{models.DefaultCurrency: models.Cents(300)},
+			TotalOrders:     2,
+			TotalBooksSold:  3,
+			TopSellingBooks: []models.BookSales{{Book: a, Quantity: 1}, {Book: b, Quantity: 2}},
+			Comparison:      &models.SalesComparison{},
+			MergedFrom:      []string{"first"},
+		},
+	}
+
+	merged, err := MergeReports(KindWeekly, sources)
+	if err != nil {
+		t.Fatalf("MergeReports error = %v", err)
+	}
+	if merged.Kind != KindWeekly || !merged.PeriodStart.Equal(day) || !merged.PeriodEnd.Equal(day.Add(48*time.Hour)) ||
+		!merged.Timestamp.Equal(day.Add(48*time.Hour)) {
+		t.Errorf("merged = %s %v - %v at %v, want a weekly report over both days", merged.Kind, merged.PeriodStart, merged.PeriodEnd, merged.Timestamp)
+	}
+	if merged.TotalOrders != 3 || merged.TotalBooksSold != 8 || merged.Comparison != nil {
+		t.Errorf("merged orders = %d, books sold = %d, comparison %v; want 3, 8, none", merged.TotalOrders, merged.TotalBooksSold, merged.Comparison)
+	}
+	if merged.TotalRevenue.Get(models.DefaultCurrency).Amount != 300 || merged.TotalRevenue.Get("EUR").Amount != 500 {
+		t.Errorf("merged revenue = %v, want 3.00 USD and 5.00 EUR", merged.TotalRevenue)
+	}
+	if want := [][2]int{{2, 7}, {1, 1}}; !reflect.DeepEqual(bookQuantities(merged.TopSellingBooks), want) {
+		t.Errorf("merged top books = %v, want %v", bookQuantities(merged.TopSellingBooks), want)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(merged.MergedFrom, want) {
+		t.Errorf("merged from = %v, want %v", merged.MergedFrom, want)
+	}
+}
@@ -0,0 +1,142 @@
+package reports
+
+import (
+	"context"
+	"log"
+	"online-bookstore-api/interfaces"
+	"time"
+)
+
+// DefaultInterval is how often the scheduler produces a report
+const DefaultInterval = 24 * time.Hour
+
+// retryDelay is how long the scheduler waits after a failed run
+const retryDelay = time.Minute
+
+// Scheduler periodically generates sales reports and writes them to disk.
+// Report windows are contiguous: each one starts where the last stored
+// report ended, so windows missed while the process was down are caught up.
+type Scheduler struct {
+	orderStore interfaces.OrderStore
+	outputDir  string
+	interval   time.Duration
+	options    Options
+	retention  RetentionPolicy
+	now        func() time.Time
+	done       chan struct{}
+}
+
+// NewScheduler creates a scheduler writing reports to outputDir every interval
+func NewScheduler(orderStore interfaces.OrderStore, outputDir string, interval time.Duration, opts Options) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{
+		orderStore: orderStore,
+		outputDir:  outputDir,
+		interval:   interval,
+		options:    opts,
+		now:        time.Now,
+		done:       make(chan struct{}),
+	}
+}
+
+// SetRetention makes the scheduler apply policy after generating reports.
+// It must be called before Start.
+func (s *Scheduler) SetRetention(policy RetentionPolicy) {
+	s.retention = policy
+}
+
+// Start runs the scheduler in a background goroutine until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+// Wait blocks until the scheduler has stopped, including any run in progress
+func (s *Scheduler) Wait() {
+	<-s.done
+}
+
+// run is the scheduler loop
+func (s *Scheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	next, err := s.firstWindowStart()
+	if err != nil {
+		log.Printf("Report scheduler: failed to find previous reports: %v", err)
+		next = s.now().UTC().Truncate(time.Minute).Add(-s.interval)
+	}
+	log.Printf("Report scheduler started (interval %v, output %s)", s.interval, s.outputDir)
+
+	for {
+		wait := s.interval
+		for ctx.Err() == nil {
+			end := next.Add(s.interval)
+			if end.After(s.now()) {
+				wait = end.Sub(s.now())
+				break
+			}
+			// A started window is always completed, even if shutdown begins meanwhile
+			if err := s.runWindow(context.WithoutCancel(ctx), next, end); err != nil {
+				log.Printf("Report scheduler: failed to generate report for %s - %s: %v",
+					next.Format(time.RFC3339), end.Format(time.RFC3339), err)
+				wait = retryDelay
+				break
+			}
+			next = end
+		}
+
+		if s.retention.Enabled() && ctx.Err() == nil {
+			s.compact()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Report scheduler stopped")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// firstWindowStart returns where the next report window begins
+func (s *Scheduler) firstWindowStart() (time.Time, error) {
+	last, found, err := latestPeriodEnd(s.outputDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if found {
+		return last.UTC(), nil
+	}
+	// Without history, cover the interval leading up to startup
+	return s.now().UTC().Truncate(time.Minute).Add(-s.interval), nil
+}
+
+// runWindow generates and stores the report for [start, end)
+func (s *Scheduler) runWindow(ctx context.Context, start, end time.Time) error {
+	report, err := GenerateSalesReport(ctx, s.orderStore, start, end, s.options)
+	if err != nil {
+		return err
+	}
+	path, err := SaveReport(s.outputDir, report)
+	if err != nil {
+		return err
+	}
+	log.Printf("Sales report written to %s (%d orders, revenue %s)", path, report.TotalOrders, report.TotalRevenue)
+	return nil
+}
+
+// compact applies the retention policy to the output directory
+func (s *Scheduler) compact() {
+	result, err := Compact(s.outputDir, s.retention, s.now())
+	if err != nil {
+		log.Printf("Report scheduler: retention failed: %v", err)
+		return
+	}
+	if result.ReportsDeleted > 0 {
+		log.Printf("Report retention merged %d reports into %v, deleted %d",
+			result.ReportsMerged, result.SummariesWritten, result.ReportsDeleted)
+	}
+}
@@ -0,0 +1,126 @@
+package reports
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitForReports polls dir until it holds n reports
+func waitForReports(t *testing.T, dir string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if names, _ := storedReports(t, dir); len(names) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduler did not write %d reports in time", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerResumesAfterLastReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      bool
+		wantReports []string
+		wantOrders  int
+	}{
+		{
+			// The windows after the stored report are caught up
+			name:        "stored report",
+			stored:      true,
+			wantReports: []string{"report_030220240000.json", "report_030320240000.json", "report_030420240000.json"},
+			wantOrders:  1 + 2,
+		},
+		{
+			// Without history only the interval before startup is covered
+			name:        "no reports",
+			wantReports: []string{"report_030420240000.json"},
+			wantOrders:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.stored {
+				saveDailyReport(t, dir, day, 1)
+			}
+			a := testBook(1, 100)
+			store := newOrderStore(t,
+				testOrder(day.Add(30*time.Hour), line(a, 1)),
+				testOrder(day.Add(50*time.Hour), line(a, 1)))
+
+			scheduler := NewScheduler(store, dir, 24*time.Hour, Options{})
+			scheduler.now = func() time.Time { return day.Add(72*time.Hour + 30*time.Second) }
+			ctx, cancel := context.WithCancel(context.Background())
+			scheduler.Start(ctx)
+			waitForReports(t, dir, len(tt.wantReports))
+			cancel()
+			scheduler.Wait()
+
+			names, orders := storedReports(t, dir)
+			if !reflect.DeepEqual(names, tt.wantReports) {
+				t.Errorf("reports = %v, want %v", names, tt.wantReports)
+			}
+			if orders != tt.wantOrders {
+				t.Errorf("reports hold %d orders, want %d", orders, tt.wantOrders)
+			}
+		})
+	}
+}
+
+// blockingOrderStore holds range reads until release is closed
+type blockingOrderStore struct {
+	interfaces.OrderStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.OrderStore.GetOrdersInTimeRange(ctx, start, end)
+}
+
+func TestSchedulerWaitCompletesStartedWindow(t *testing.T) {
+	dir := t.TempDir()
+	store := blockingOrderStore{
+		OrderStore: newOrderStore(t, testOrder(day.Add(time.Hour), line(testBook(1, 100), 1))),
+		entered:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	scheduler := NewScheduler(store, dir, 24*time.Hour, Options{})
+	scheduler.now = func() time.Time { return day.Add(24 * time.Hour) }
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.Start(ctx)
+
+	// Shutdown begins while the window's orders are being read
+	<-store.entered
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Wait returned before the started window was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(store.release)
+	<-stopped
+	names, orders := storedReports(t, dir)
+	if want := []string{"report_030220240000.json"}; !reflect.DeepEqual(names, want) || orders != 1 {
+		t.Errorf("reports = %v with %d orders, want %v with 1", names, orders, want)
+	}
+}
@@ -0,0 +1,234 @@
+package reports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultOutputDir is the directory periodic reports are written to
+const DefaultOutputDir = "output-reports"
+
+// Report kinds; periodic reports written by the scheduler have no kind
+const (
+	KindWeekly  = "weekly"
+	KindMonthly = "monthly"
+)
+
+const (
+	reportFilePrefix = "report_"
+	reportFileExt    = ".json"
+	reportTimeLayout = "010220061504" // MMDDYYYYHHMM
+)
+
+// kindFilePrefixes maps report kinds to their file name prefix
+var kindFilePrefixes = map[string]string{
+	"":          reportFilePrefix,
+	KindWeekly:  KindWeekly + "_",
+	KindMonthly: KindMonthly + "_",
+}
+
+// ReportFilename returns the file name for a report generated at t
+func ReportFilename(t time.Time) string {
+	return reportFilename("", t)
+}
+
+// reportFilename returns the file name for a report of the given kind
+func reportFilename(kind string, t time.Time) string {
+	return kindFilePrefixes[kind] + t.UTC().Format(reportTimeLayout) + reportFileExt
+}
+
+// parseReportFilename extracts the report kind and time encoded in a file name
+func parseReportFilename(name string) (string, time.Time, bool) {
+	if !strings.HasSuffix(name, reportFileExt) {
+		return "", time.Time{}, false
+	}
+	for kind, prefix := range kindFilePrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), reportFileExt)
+		t, err := time.Parse(reportTimeLayout, stamp)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		return kind, t, true
+	}
+	return "", time.Time{}, false
+}
+
+// SaveReport writes a report to dir, replacing the file atomically
+func SaveReport(dir string, report models.SalesReport) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	path := filepath.Join(dir, reportFilename(report.Kind, report.Timestamp))
+	tmp, err := os.CreateTemp(dir, ".report-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to set report file mode: %w", err)
+	}
+
+	encoder := json.NewEncoder(tmp)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to sync report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to close report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move report into place: %w", err)
+	}
+	return path, nil
+}
+
+// LoadReport reads a single report file
+func LoadReport(path string) (models.SalesReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.SalesReport{}, fmt.Errorf("failed to open report: %w", err)
+	}
+	defer file.Close()
+
+	var report models.SalesReport
+	if err := json.NewDecoder(file).Decode(&report); err != nil {
+		return models.SalesReport{}, fmt.Errorf("failed to decode report %s: %w", filepath.Base(path), err)
+	}
+	return report, nil
+}
+
+// reportFile is a report file found on disk
+type reportFile struct {
+	Path string
+	Kind string
+	Time time.Time
+}
+
+// Name returns the base name of the report file
+func (f reportFile) Name() string {
+	return filepath.Base(f.Path)
+}
+
+// listReportFiles returns the report files in dir sorted by their timestamp
+func listReportFiles(dir string) ([]reportFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read report directory: %w", err)
+	}
+
+	var files []reportFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		kind, t, ok := parseReportFilename(entry.Name())
+		if !ok {
+			continue
+		}
+		files = append(files, reportFile{Path: filepath.Join(dir, entry.Name()), Kind: kind, Time: t})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Time.Equal(files[j].Time) {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].Time.Before(files[j].Time)
+	})
+	return files, nil
+}
+
+// latestPeriodEnd returns the end of the most recent report window in dir,
+// looking at the newest report of each kind so compacted history counts too
+func latestPeriodEnd(dir string) (time.Time, bool, error) {
+	files, err := listReportFiles(dir)
+	if err != nil || len(files) == 0 {
+		return time.Time{}, false, err
+	}
+
+	newest := make(map[string]reportFile)
+	for _, file := range files {
+		newest[file.Kind] = file
+	}
+
+	var latest time.Time
+	for _, file := range newest {
+		report, err := LoadReport(file.Path)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		end := report.PeriodEnd
+		if end.IsZero() {
+			end = file.Time
+		}
+		if end.After(latest) {
+			latest = end
+		}
+	}
+	return latest, true, nil
+}
+
+// LoadReports returns the stored reports whose timestamp falls in [start, end),
+// oldest first. A zero start or end leaves that side of the range open.
+func LoadReports(dir string, start, end time.Time) ([]models.SalesReport, error) {
+	reports := []models.SalesReport{}
+	err := WalkReports(dir, start, end, func(report models.SalesReport) error {
+		reports = append(reports, report)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// WalkReports calls fn for each stored report in [start, end), oldest first,
+// reading one file at a time. It stops at the first error fn returns.
+func WalkReports(dir string, start, end time.Time, fn func(models.SalesReport) error) error {
+	files, err := listReportFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !start.IsZero() && file.Time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && !file.Time.Before(end) {
+			continue
+		}
+		report, err := LoadReport(file.Path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed by compaction since the directory was listed
+				continue
+			}
+			return err
+		}
+		if err := fn(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
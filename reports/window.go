@@ -0,0 +1,73 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeBoundLayouts are the accepted formats for absolute window bounds
+var timeBoundLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+}
+
+// ParseLookback parses a relative window such as "24h", "90m", "7d" or "2w".
+// Day and week suffixes are added on top of time.ParseDuration.
+func ParseLookback(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty window")
+	}
+
+	var lookback time.Duration
+	switch unit := value[len(value)-1]; unit {
+	case 'd', 'w':
+		count, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		lookback = time.Duration(count) * 24 * time.Hour
+		if unit == 'w' {
+			lookback *= 7
+		}
+	default:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		lookback = parsed
+	}
+
+	if lookback <= 0 {
+		return 0, fmt.Errorf("window %q must be positive", value)
+	}
+	return lookback, nil
+}
+
+// ParseTimeBound parses an absolute RFC3339 window bound; seconds may be omitted
+func ParseTimeBound(value string) (time.Time, error) {
+	for _, layout := range timeBoundLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339", value)
+}
+
+// CountOrders returns how many orders count towards the report window
+// [start, end) under basis, net of cancellations and refunds
+func CountOrders(ctx context.Context, orderStore interfaces.OrderStore, start, end time.Time, basis Basis) (int, error) {
+	entries, err := ordersForBasis(ctx, orderStore, start, end, basis)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		count += entry.sign
+	}
+	return count, nil
+}
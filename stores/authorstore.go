@@ -1,6 +1,8 @@
 package stores
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"online-bookstore-api/interfaces"
 	"online-bookstore-api/models"
@@ -9,9 +11,10 @@ import (
 
 // InMemoryAuthorStore implements AuthorStore interface
 type InMemoryAuthorStore struct {
-	mu      sync.RWMutex
-	authors map[int]models.Author
-	nextID  int
+	mu         sync.RWMutex
+	authors    map[int]models.Author
+	nextID     int
+	onMutation MutationHook
 }
 
 // NewInMemoryAuthorStore creates a new in-memory author store
@@ -23,51 +26,75 @@ func NewInMemoryAuthorStore() *InMemoryAuthorStore {
 }
 
 // CreateAuthor creates a new author
-func (s *InMemoryAuthorStore) CreateAuthor(author models.Author) (models.Author, error) {
+func (s *InMemoryAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Author{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	author.ID = s.nextID
+	if err := s.notify(Mutation{Entity: EntityAuthor, Op: OpCreate, ID: author.ID, Value: author}); err != nil {
+		return models.Author{}, err
+	}
+
 	s.nextID++
 	s.authors[author.ID] = author
 	return author, nil
 }
 
 // GetAuthor retrieves an author by ID
-func (s *InMemoryAuthorStore) GetAuthor(id int) (models.Author, error) {
+func (s *InMemoryAuthorStore) GetAuthor(ctx context.Context, id int) (models.Author, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	author, exists := s.authors[id]
 	if !exists {
-		return models.Author{}, fmt.Errorf("author with ID %d not found", id)
+		return models.Author{}, notFoundError(EntityAuthor, id)
 	}
 	return author, nil
 }
 
 // UpdateAuthor updates an existing author
-func (s *InMemoryAuthorStore) UpdateAuthor(id int, author models.Author) (models.Author, error) {
+func (s *InMemoryAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Author{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	 _, exists := s.authors[id]
+	_, exists := s.authors[id]
 	if !exists {
-		return models.Author{}, fmt.Errorf("author with ID %d not found", id)
+		return models.Author{}, notFoundError(EntityAuthor, id)
 	}
 
 	author.ID = id
+	if err := s.notify(Mutation{Entity: EntityAuthor, Op: OpUpdate, ID: id, Value: author}); err != nil {
+		return models.Author{}, err
+	}
+
 	s.authors[id] = author
 	return author, nil
 }
 
 // DeleteAuthor deletes an author by ID
-func (s *InMemoryAuthorStore) DeleteAuthor(id int) error {
+func (s *InMemoryAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	_, exists := s.authors[id]
 	if !exists {
-		return fmt.Errorf("author with ID %d not found", id)
+		return notFoundError(EntityAuthor, id)
+	}
+
+	if err := s.notify(Mutation{Entity: EntityAuthor, Op: OpDelete, ID: id}); err != nil {
+		return err
 	}
 
 	delete(s.authors, id)
@@ -75,12 +102,15 @@ func (s *InMemoryAuthorStore) DeleteAuthor(id int) error {
 }
 
 // GetAllAuthors returns all authors
-func (s *InMemoryAuthorStore) GetAllAuthors() ([]models.Author, error) {
+func (s *InMemoryAuthorStore) GetAllAuthors(ctx context.Context) ([]models.Author, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	authors := make([]models.Author, 0, len(s.authors))
 	for _, author := range s.authors {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		authors = append(authors, author)
 	}
 	return authors, nil
@@ -114,5 +144,72 @@ func (s *InMemoryAuthorStore) GetNextID() int {
 	return s.nextID
 }
 
+// Entity returns the name of the records held by the store
+func (s *InMemoryAuthorStore) Entity() string {
+	return EntityAuthor
+}
+
+// Snapshot captures the authors and the next ID for persistence
+func (s *InMemoryAuthorStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return snapshotRecords(s.authors, s.nextID)
+}
+
+// Restore replaces the authors with the contents of a snapshot
+func (s *InMemoryAuthorStore) Restore(snapshot interfaces.Snapshot) error {
+	authors, nextID, err := restoreRecords[models.Author](EntityAuthor, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authors = authors
+	s.nextID = nextID
+	return nil
+}
+
+// ReplayMutation applies a logged mutation without running the mutation hook
+func (s *InMemoryAuthorStore) ReplayMutation(op string, id int, value json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if op == OpDelete {
+		delete(s.authors, id)
+		return nil
+	}
+
+	var author models.Author
+	if err := json.Unmarshal(value, &author); err != nil {
+		return fmt.Errorf("invalid author record: %w", err)
+	}
+	author.ID = id
+	s.authors[id] = author
+	if id >= s.nextID {
+		s.nextID = id + 1
+	}
+	return nil
+}
+
+// SetMutationHook registers a hook called before every create, update and delete
+func (s *InMemoryAuthorStore) SetMutationHook(hook MutationHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMutation = hook
+}
+
+// notify runs the mutation hook; the caller must hold the write lock
+func (s *InMemoryAuthorStore) notify(m Mutation) error {
+	if s.onMutation == nil {
+		return nil
+	}
+	return s.onMutation(m)
+}
+
 // Verify interface implementation
-var _ interfaces.AuthorStore = (*InMemoryAuthorStore)(nil)
+var (
+	_ interfaces.AuthorStore      = (*InMemoryAuthorStore)(nil)
+	_ interfaces.Snapshotter      = (*InMemoryAuthorStore)(nil)
+	_ interfaces.MutationReplayer = (*InMemoryAuthorStore)(nil)
+)
@@ -0,0 +1,163 @@
+package stores
+
+import (
+	"context"
+	"log"
+	"online-bookstore-api/models"
+	"sync"
+	"time"
+)
+
+// Default autosave settings
+const (
+	DefaultAutosaveInterval  = time.Minute
+	DefaultAutosaveMutations = 100
+)
+
+// PersistenceManager saves the database in the background: on a fixed
+// interval when anything changed, and as soon as a number of mutations has
+// accumulated. Saves run on a single goroutine and a pending request is only
+// queued once, so a burst of writes is coalesced into a single flush.
+type PersistenceManager struct {
+	save      func() error
+	interval  time.Duration
+	threshold int
+
+	mu       sync.Mutex
+	pending  int
+	saves    int
+	lastSave time.Time
+	lastErr  error
+
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// NewPersistenceManager creates a manager calling save every interval and
+// after threshold mutations
+func NewPersistenceManager(save func() error, interval time.Duration, threshold int) *PersistenceManager {
+	if interval <= 0 {
+		interval = DefaultAutosaveInterval
+	}
+	if threshold <= 0 {
+		threshold = DefaultAutosaveMutations
+	}
+	return &PersistenceManager{
+		save:      save,
+		interval:  interval,
+		threshold: threshold,
+		flush:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// Notify records a mutation; it can be registered as a store's MutationHook
+func (m *PersistenceManager) Notify(Mutation) error {
+	m.mu.Lock()
+	m.pending++
+	full := m.pending >= m.threshold
+	m.mu.Unlock()
+
+	if full {
+		select {
+		case m.flush <- struct{}{}:
+		default:
+			// A flush is already queued and will include this mutation
+		}
+	}
+	return nil
+}
+
+// Start runs the manager in a background goroutine until ctx is done
+func (m *PersistenceManager) Start(ctx context.Context) {
+	go m.run(ctx)
+}
+
+// Wait blocks until the manager has stopped and the final save has finished
+func (m *PersistenceManager) Wait() {
+	<-m.done
+}
+
+// run is the autosave loop
+func (m *PersistenceManager) run(ctx context.Context) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	log.Printf("Autosave started (interval %v, every %d mutations)", m.interval, m.threshold)
+	for {
+		select {
+		case <-ctx.Done():
+			// Always save on shutdown, even if nothing is pending
+			log.Println("Saving database...")
+			if err := m.SaveNow(); err != nil {
+				log.Printf("Error saving database: %v", err)
+			} else {
+				log.Println("Database saved successfully")
+			}
+			return
+		case <-ticker.C:
+			m.saveIfPending()
+		case <-m.flush:
+			m.saveIfPending()
+		}
+	}
+}
+
+// saveIfPending saves when there are unsaved mutations
+func (m *PersistenceManager) saveIfPending() {
+	m.mu.Lock()
+	pending := m.pending
+	m.mu.Unlock()
+
+	if pending == 0 {
+		return
+	}
+	if err := m.SaveNow(); err != nil {
+		log.Printf("Autosave failed: %v", err)
+	}
+}
+
+// SaveNow runs the save function and records the outcome. Mutations made
+// while saving stay pending, and a failed save keeps everything pending.
+func (m *PersistenceManager) SaveNow() error {
+	m.mu.Lock()
+	saving := m.pending
+	m.pending = 0
+	m.mu.Unlock()
+
+	err := m.save()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.pending += saving
+		m.lastErr = err
+		return err
+	}
+	m.saves++
+	m.lastSave = time.Now()
+	m.lastErr = nil
+	return nil
+}
+
+// PersistenceStatus reports the last save time, the last save error and the
+// number of mutations not yet saved
+func (m *PersistenceManager) PersistenceStatus() models.PersistenceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := models.PersistenceStatus{
+		PendingMutations: m.pending,
+		Saves:            m.saves,
+	}
+	if !m.lastSave.IsZero() {
+		lastSave := m.lastSave
+		status.LastSaveAt = &lastSave
+	}
+	if m.lastErr != nil {
+		status.LastError = m.lastErr.Error()
+	}
+	return status
+}
@@ -0,0 +1,112 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForSaves polls the manager until it has saved n times
+func waitForSaves(t *testing.T, m *PersistenceManager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.PersistenceStatus().Saves < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("manager saved %d times, want %d", m.PersistenceStatus().Saves, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPersistenceManagerCoalescesBurst(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m := NewPersistenceManager(func() error {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	}, time.Hour, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Start(ctx)
+
+	for range 10 {
+		m.Notify(Mutation{})
+	}
+	<-started
+
+	// A burst while the first save runs is flushed once after it
+	for range 490 {
+		m.Notify(Mutation{})
+	}
+	if status := m.PersistenceStatus(); status.PendingMutations != 490 {
+		t.Errorf("pending mutations during the save = %d, want 490", status.PendingMutations)
+	}
+	close(release)
+	waitForSaves(t, m, 2)
+	if status := m.PersistenceStatus(); status.PendingMutations != 0 || status.LastSaveAt == nil {
+		t.Errorf("status after the burst = %+v, want nothing pending and a save time", status)
+	}
+
+	// Shutdown always saves once more
+	cancel()
+	m.Wait()
+	if got := calls.Load(); got != 3 {
+		t.Errorf("save called %d times, want 3", got)
+	}
+}
+
+func TestPersistenceManagerInterval(t *testing.T) {
+	var calls atomic.Int32
+	m := NewPersistenceManager(func() error {
+		calls.Add(1)
+		return nil
+	}, 5*time.Millisecond, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer m.Wait()
+	defer cancel()
+	m.Start(ctx)
+
+	// Ticks without mutations do not save
+	time.Sleep(30 * time.Millisecond)
+	if got := calls.Load(); got != 0 {
+		t.Errorf("save called %d times with nothing to save, want 0", got)
+	}
+
+	m.Notify(Mutation{})
+	waitForSaves(t, m, 1)
+}
+
+func TestPersistenceManagerSaveError(t *testing.T) {
+	errDisk := errors.New("disk full")
+	fail := true
+	m := NewPersistenceManager(func() error {
+		if fail {
+			return errDisk
+		}
+		return nil
+	}, time.Hour, 100)
+	m.Notify(Mutation{})
+	m.Notify(Mutation{})
+
+	if err := m.SaveNow(); !errors.Is(err, errDisk) {
+		t.Fatalf("SaveNow error = %v, want %v", err, errDisk)
+	}
+	status := m.PersistenceStatus()
+	if status.LastError != errDisk.Error() || status.PendingMutations != 2 || status.Saves != 0 || status.LastSaveAt != nil {
+		t.Errorf("status after a failed save = %+v, want the error and both mutations pending", status)
+	}
+
+	fail = false
+	if err := m.SaveNow(); err != nil {
+		t.Fatalf("SaveNow error = %v", err)
+	}
+	status = m.PersistenceStatus()
+	if status.LastError != "" || status.PendingMutations != 0 || status.Saves != 1 || status.LastSaveAt == nil {
+		t.Errorf("status after a save = %+v, want it cleared", status)
+	}
+}
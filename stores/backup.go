@@ -0,0 +1,353 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strings"
+)
+
+// Restore modes
+const (
+	// RestoreReplace replaces the contents of every store with the backup
+	RestoreReplace = "replace"
+	// RestoreMerge imports only the records whose IDs are not in use
+	RestoreMerge = "merge"
+)
+
+// ErrInvalidBackup is returned when an uploaded backup cannot be restored
+// because of its contents, as opposed to a failure of the stores
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// recordDecoders check that a record decodes into its model and return its
+// ID, keyed by entity
+var recordDecoders = map[string]func(json.RawMessage) (int, error){
+	EntityBook:     decodeRecordID[models.Book],
+	EntityAuthor:   decodeRecordID[models.Author],
+	EntityCustomer: decodeRecordID[models.Customer],
+	EntityOrder:    decodeRecordID[models.Order],
+}
+
+// decodeRecordID decodes a record as T and returns its ID
+func decodeRecordID[T any](raw json.RawMessage) (int, error) {
+	var record T
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return 0, err
+	}
+	var ref struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &ref); err != nil {
+		return 0, err
+	}
+	return ref.ID, nil
+}
+
+// Archive backs up and restores a set of stores as a whole. Stores are
+// captured and replaced while holding a WriteGate exclusively, so a backup
+// is a single point in time across stores and no write interleaves with a
+// restore. The stores handed to handlers must be gated by the same gate.
+type Archive struct {
+	gate         *WriteGate
+	snapshotters []interfaces.Snapshotter
+
+	// AfterRestore, when set, runs after a successful restore once writes
+	// are let through again, e.g. to persist stores that are not written
+	// through to disk
+	AfterRestore func() error
+}
+
+// NewArchive creates an archive of the given stores
+func NewArchive(gate *WriteGate, snapshotters ...interfaces.Snapshotter) *Archive {
+	return &Archive{gate: gate, snapshotters: snapshotters}
+}
+
+// Backup captures the stores in the database file format. When entities is
+// not empty only those collections are included.
+func (a *Archive) Backup(entities []string) (io.WriterTo, error) {
+	selected := a.snapshotters
+	if len(entities) > 0 {
+		var err error
+		if selected, err = a.selectStores(entities); err != nil {
+			return nil, err
+		}
+	}
+
+	var data DatabaseData
+	err := a.gate.Exclusive(func() error {
+		var err error
+		data, err = CaptureDatabase(selected...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// selectStores returns the stores holding the named entities
+func (a *Archive) selectStores(entities []string) ([]interfaces.Snapshotter, error) {
+	var selected []interfaces.Snapshotter
+	for _, entity := range entities {
+		store := a.store(entity)
+		if store == nil {
+			return nil, fmt.Errorf("%w: unknown entity %q", ErrInvalidBackup, entity)
+		}
+		selected = append(selected, store)
+	}
+	return selected, nil
+}
+
+// store returns the store holding entity, or nil
+func (a *Archive) store(entity string) interfaces.Snapshotter {
+	for _, store := range a.snapshotters {
+		if store.Entity() == entity {
+			return store
+		}
+	}
+	return nil
+}
+
+// Restore reads a backup in the database file format and loads it into the
+// stores. In replace mode the backup must hold a collection for every store
+// and replaces their contents, and it is rolled back if a restored record
+// refers to one that is missing. In merge mode only records whose IDs are
+// free are imported and existing records are left untouched, and the merge
+// is rolled back if it adds a reference to a missing record. The whole
+// backup is validated before any store is changed, and stores already
+// restored are rolled back if a later one fails.
+func (a *Archive) Restore(r io.Reader, mode string) (models.RestoreResult, error) {
+	if mode == "" {
+		mode = RestoreReplace
+	}
+	if mode != RestoreReplace && mode != RestoreMerge {
+		return models.RestoreResult{}, fmt.Errorf("%w: unknown restore mode %q", ErrInvalidBackup, mode)
+	}
+
+	var data DatabaseData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return models.RestoreResult{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	incoming, err := a.validate(data, mode)
+	if err != nil {
+		return models.RestoreResult{}, err
+	}
+
+	result := models.RestoreResult{Mode: mode, Imported: make(map[string]int)}
+	if mode == RestoreMerge {
+		result.Skipped = make(map[string]int)
+	}
+	err = a.gate.Exclusive(func() error {
+		return a.restore(data, incoming, &result)
+	})
+	if err != nil {
+		return models.RestoreResult{}, err
+	}
+
+	if a.AfterRestore != nil {
+		if err := a.AfterRestore(); err != nil {
+			return models.RestoreResult{}, fmt.Errorf("restored, but failed to save: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// validate checks every collection of a backup and returns its records
+// keyed by entity and ID
+func (a *Archive) validate(data DatabaseData, mode string) (map[string]map[int]json.RawMessage, error) {
+	incoming := make(map[string]map[int]json.RawMessage, len(data.Entities))
+	for _, entity := range data.Entities {
+		if a.store(entity) == nil {
+			return nil, fmt.Errorf("%w: unknown collection %q", ErrInvalidBackup, collectionKey(entity))
+		}
+		records, err := decodeCollection(entity, data.Records[entity])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+		incoming[entity] = records
+	}
+
+	if mode == RestoreReplace {
+		var missing []string
+		for _, store := range a.snapshotters {
+			if _, exists := incoming[store.Entity()]; !exists {
+				missing = append(missing, collectionKey(store.Entity()))
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%w: a replace needs every collection, missing %s; use merge mode for partial backups",
+				ErrInvalidBackup, strings.Join(missing, ", "))
+		}
+	}
+	return incoming, nil
+}
+
+// decodeCollection decodes the records of a collection, checking that every
+// record is a valid model stored under its own positive ID
+func decodeCollection(entity string, raw json.RawMessage) (map[int]json.RawMessage, error) {
+	records := make(map[int]json.RawMessage)
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return nil, fmt.Errorf("%s: %w", collectionKey(entity), err)
+		}
+	}
+
+	decode := recordDecoders[entity]
+	for key, record := range records {
+		if key < 1 {
+			return nil, fmt.Errorf("%s: invalid ID %d", collectionKey(entity), key)
+		}
+		if decode == nil {
+			continue
+		}
+		id, err := decode(record)
+		if err != nil {
+			return nil, fmt.Errorf("%s %d: %w", entity, key, err)
+		}
+		if id != key {
+			return nil, fmt.Errorf("%s %d: record has ID %d", entity, key, id)
+		}
+	}
+	return records, nil
+}
+
+// restore loads validated collections into the stores; the caller holds the
+// gate exclusively
+func (a *Archive) restore(data DatabaseData, incoming map[string]map[int]json.RawMessage, result *models.RestoreResult) error {
+	// Keep the current contents to roll back to
+	var restored []interfaces.Snapshotter
+	previous := make(map[string]interfaces.Snapshot, len(incoming))
+	for _, store := range a.snapshotters {
+		if _, exists := incoming[store.Entity()]; !exists {
+			continue
+		}
+		snapshot, err := store.Snapshot()
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s store: %w", store.Entity(), err)
+		}
+		previous[store.Entity()] = snapshot
+	}
+
+	// A merge must not add references to records neither side has; those
+	// already in the stores are left for the integrity check to report
+	var before map[DanglingReference]bool
+	if result.Mode == RestoreMerge {
+		dangling, err := a.checkIntegrity()
+		if err != nil {
+			return fmt.Errorf("failed to check integrity: %w", err)
+		}
+		before = make(map[DanglingReference]bool, len(dangling))
+		for _, ref := range dangling {
+			before[ref] = true
+		}
+	}
+
+	rollback := func(cause error) error {
+		for _, store := range restored {
+			if err := store.Restore(previous[store.Entity()]); err != nil {
+				return fmt.Errorf("%w; rolling back %s store also failed: %w", cause, store.Entity(), err)
+			}
+		}
+		return cause
+	}
+
+	for _, store := range a.snapshotters {
+		entity := store.Entity()
+		records, exists := incoming[entity]
+		if !exists {
+			continue
+		}
+
+		snapshot := interfaces.Snapshot{NextID: data.NextIDs[entity]}
+		if result.Mode == RestoreMerge {
+			var skipped int
+			var err error
+			snapshot, skipped, err = mergeSnapshot(previous[entity], records, snapshot.NextID)
+			if err != nil {
+				return rollback(fmt.Errorf("failed to merge %s records: %w", entity, err))
+			}
+			result.Skipped[entity] = skipped
+			result.Imported[entity] = len(records) - skipped
+		} else {
+			encoded, err := json.Marshal(records)
+			if err != nil {
+				return rollback(fmt.Errorf("failed to encode %s records: %w", entity, err))
+			}
+			snapshot.Records = encoded
+			result.Imported[entity] = len(records)
+		}
+
+		if err := store.Restore(snapshot); err != nil {
+			return rollback(fmt.Errorf("failed to restore %s store: %w", entity, err))
+		}
+		restored = append(restored, store)
+	}
+
+	// In replace mode before is empty, so every dangling reference counts
+	dangling, err := a.checkIntegrity()
+	if err != nil {
+		return rollback(fmt.Errorf("failed to check integrity: %w", err))
+	}
+	var added []string
+	for _, ref := range dangling {
+		if !before[ref] {
+			added = append(added, ref.String())
+		}
+	}
+	if len(added) > 0 {
+		records := "restored records"
+		if result.Mode == RestoreMerge {
+			records = "merged records"
+		}
+		return rollback(fmt.Errorf("%w: %s refer to missing records: %s",
+			ErrInvalidBackup, records, strings.Join(added, "; ")))
+	}
+	return nil
+}
+
+// checkIntegrity reports the dangling references between the stores, or
+// none when the archive does not hold every store CheckIntegrity reads
+func (a *Archive) checkIntegrity() ([]DanglingReference, error) {
+	books, _ := a.store(EntityBook).(interfaces.BookStore)
+	authors, _ := a.store(EntityAuthor).(interfaces.AuthorStore)
+	customers, _ := a.store(EntityCustomer).(interfaces.CustomerStore)
+	orders, _ := a.store(EntityOrder).(interfaces.OrderStore)
+	if books == nil || authors == nil || customers == nil || orders == nil {
+		return nil, nil
+	}
+	return CheckIntegrity(context.Background(), books, authors, customers, orders)
+}
+
+// mergeSnapshot adds the records whose IDs are not in current to it and
+// returns the merged snapshot and the number of records skipped. The next
+// ID is the larger of the two, so neither side's IDs are handed out again.
+func mergeSnapshot(current interfaces.Snapshot, records map[int]json.RawMessage, nextID int) (interfaces.Snapshot, int, error) {
+	merged := make(map[int]json.RawMessage)
+	if len(current.Records) > 0 {
+		if err := json.Unmarshal(current.Records, &merged); err != nil {
+			return interfaces.Snapshot{}, 0, err
+		}
+	}
+
+	skipped := 0
+	for id, record := range records {
+		if _, exists := merged[id]; exists {
+			skipped++
+			continue
+		}
+		merged[id] = record
+	}
+
+	encoded, err := json.Marshal(merged)
+	if err != nil {
+		return interfaces.Snapshot{}, 0, err
+	}
+	return interfaces.Snapshot{Records: encoded, NextID: max(current.NextID, nextID)}, skipped, nil
+}
+
+// Verify interface implementation
+var _ interfaces.DatabaseArchive = (*Archive)(nil)
@@ -0,0 +1,450 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive holds in-memory stores seeded with one record of each entity:
+// author 1, book 1 by author 1, customer 1 and order 1 by customer 1 for
+// book 1
+type testArchive struct {
+	*Archive
+	books     *InMemoryBookStore
+	authors   *InMemoryAuthorStore
+	customers *InMemoryCustomerStore
+	orders    *InMemoryOrderStore
+}
+
+func newTestArchive(t *testing.T) testArchive {
+	t.Helper()
+	a := testArchive{
+		books:     NewInMemoryBookStore(),
+		authors:   NewInMemoryAuthorStore(),
+		customers: NewInMemoryCustomerStore(),
+		orders:    NewInMemoryOrderStore(),
+	}
+	a.Archive = NewArchive(NewWriteGate(), a.books, a.authors, a.customers, a.orders)
+
+	ctx := context.Background()
+	author, err := a.authors.CreateAuthor(ctx, models.Author{FirstName: "Ada", LastName: "Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := a.books.CreateBook(ctx, models.Book{Title: "Notes", Author: author, Price: models.Cents(1000), Stock: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer, err := a.customers.CreateCustomer(ctx, models.Customer{Name: "Reader", Email: "reader@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.orders.CreateOrder(ctx, models.Order{
+		Customer:   customer,
+		Items:      []models.OrderItem{{Book: book, Quantity: 1}},
+		TotalPrice: models.Cents(1000),
+		CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Status:     models.OrderPaid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// ids returns the IDs held by every store, keyed by entity
+func (a testArchive) ids(t *testing.T) map[string][]int {
+	t.Helper()
+	ctx := context.Background()
+	books, _ := a.books.GetAllBooks(ctx)
+	authors, _ := a.authors.GetAllAuthors(ctx)
+	customers, _ := a.customers.GetAllCustomers(ctx)
+	orders, _ := a.orders.GetAllOrders(ctx)
+
+	ids := map[string][]int{}
+	for _, book := range books {
+		ids[EntityBook] = append(ids[EntityBook], book.ID)
+	}
+	for _, author := range authors {
+		ids[EntityAuthor] = append(ids[EntityAuthor], author.ID)
+	}
+	for _, customer := range customers {
+		ids[EntityCustomer] = append(ids[EntityCustomer], customer.ID)
+	}
+	for _, order := range orders {
+		ids[EntityOrder] = append(ids[EntityOrder], order.ID)
+	}
+	for _, list := range ids {
+		sort.Ints(list)
+	}
+	return ids
+}
+
+// failingStore is a snapshotter whose Restore fails after failAfter calls
+type failingStore struct {
+	interfaces.Snapshotter
+	calls     *int
+	failAfter int
+}
+
+func (s failingStore) Restore(snapshot interfaces.Snapshot) error {
+	*s.calls++
+	if *s.calls > s.failAfter {
+		return errors.New("disk full")
+	}
+	return s.Snapshotter.Restore(snapshot)
+}
+
+const fullBackup = `{
+	"version": 2,
+	"authors": {"1": {"id": 1, "first_name": "Grace", "last_name": "Hopper"}, "2": {"id": 2, "first_name": "Alan", "last_name": "Turing"}},
+	"books": {"2": {"id": 2, "title": "Compilers", "author": {"id": 1}, "price": 5}, "3": {"id": 3, "title": "Machines", "author": {"id": 2}, "price": 7}},
+	"customers": {"1": {"id": 1, "name": "Other"}},
+	"orders": {},
+	"next_ids": {"author": 3, "book": 9, "customer": 2, "order": 1}
+}`
+
+func TestArchiveRestore(t *testing.T) {
+	tests := []struct {
+		name         string
+		backup       string
+		mode         string
+		wantErr      error
+		wantIDs      map[string][]int
+		wantImported map[string]int
+		wantSkipped  map[string]int
+	}{
+		{
+			name:   "replace",
+			backup: fullBackup,
+			wantIDs: map[string][]int{
+				EntityAuthor: {1, 2}, EntityBook: {2, 3}, EntityCustomer: {1},
+			},
+			wantImported: map[string]int{EntityAuthor: 2, EntityBook: 2, EntityCustomer: 1, EntityOrder: 0},
+		},
+		{
+			name:   "merge",
+			backup: fullBackup,
+			mode:   RestoreMerge,
+			wantIDs: map[string][]int{
+				EntityAuthor: {1, 2}, EntityBook: {1, 2, 3}, EntityCustomer: {1}, EntityOrder: {1},
+			},
+			wantImported: map[string]int{EntityAuthor: 1, EntityBook: 2, EntityCustomer: 0, EntityOrder: 0},
+			wantSkipped:  map[string]int{EntityAuthor: 1, EntityBook: 0, EntityCustomer: 1, EntityOrder: 0},
+		},
+		{
+			name:   "merge a partial backup",
+			backup: `{"version": 2, "authors": {"5": {"id": 5, "first_name": "New"}}, "next_ids": {"author": 6}}`,
+			mode:   RestoreMerge,
+			wantIDs: map[string][]int{
+				EntityAuthor: {1, 5}, EntityBook: {1}, EntityCustomer: {1}, EntityOrder: {1},
+			},
+			wantImported: map[string]int{EntityAuthor: 1},
+			wantSkipped:  map[string]int{EntityAuthor: 0},
+		},
+		{
+			name: "replace with dangling references",
+			backup: `{"version": 2, "authors": {}, "customers": {},
+				"books": {"2": {"id": 2, "title": "Compilers", "author": {"id": 7}, "price": 5}},
+				"orders": {"1": {"id": 1, "customer": {"id": 3}, "items": [{"book": {"id": 2}, "quantity": 1}]}}}`,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "replace with a partial backup",
+			backup:  `{"version": 2, "authors": {}}`,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "unknown mode",
+			backup:  fullBackup,
+			mode:    "append",
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "not JSON",
+			backup:  `authors`,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "newer format",
+			backup:  `{"version": 99, "authors": {}}`,
+			mode:    RestoreMerge,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "unknown collection",
+			backup:  `{"version": 2, "publishers": {}}`,
+			mode:    RestoreMerge,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "record under another ID",
+			backup:  `{"version": 2, "authors": {"3": {"id": 4}}}`,
+			mode:    RestoreMerge,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "invalid ID",
+			backup:  `{"version": 2, "authors": {"0": {"id": 0}}}`,
+			mode:    RestoreMerge,
+			wantErr: ErrInvalidBackup,
+		},
+		{
+			name:    "record of the wrong shape",
+			backup:  `{"version": 2, "books": {"3": {"id": 3, "title": 7}}}`,
+			mode:    RestoreMerge,
+			wantErr: ErrInvalidBackup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := newTestArchive(t)
+			before := archive.ids(t)
+
+			result, err := archive.Restore(strings.NewReader(tt.backup), tt.mode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Restore error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if after := archive.ids(t); !reflect.DeepEqual(after, before) {
+					t.Errorf("stores changed by a rejected restore: %v, want %v", after, before)
+				}
+				return
+			}
+
+			if got := archive.ids(t); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("IDs after restore = %v, want %v", got, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(result.Imported, tt.wantImported) {
+				t.Errorf("imported = %v, want %v", result.Imported, tt.wantImported)
+			}
+			if !reflect.DeepEqual(result.Skipped, tt.wantSkipped) {
+				t.Errorf("skipped = %v, want %v", result.Skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestArchiveRestoreNextIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		wantNextID int
+	}{
+		// The backup's counter is kept even when it is ahead of its records
+		{name: "replace", mode: RestoreReplace, wantNextID: 9},
+		{name: "merge", mode: RestoreMerge, wantNextID: 9},
+	}
+	for _, tt := range tests {
+		archive := newTestArchive(t)
+		if _, err := archive.Restore(strings.NewReader(fullBackup), tt.mode); err != nil {
+			t.Fatalf("%s: Restore error = %v", tt.name, err)
+		}
+		book, err := archive.books.CreateBook(context.Background(), models.Book{Title: "Next"})
+		if err != nil {
+			t.Fatalf("%s: CreateBook error = %v", tt.name, err)
+		}
+		if book.ID != tt.wantNextID {
+			t.Errorf("%s: next book ID = %d, want %d", tt.name, book.ID, tt.wantNextID)
+		}
+	}
+}
+
+func TestArchiveRestoreRollsBack(t *testing.T) {
+	tests := []struct {
+		name          string
+		rollbackFails bool
+		wantErr       string
+	}{
+		{name: "restore fails", wantErr: "failed to restore order store: disk full"},
+		{name: "rollback fails", rollbackFails: true, wantErr: "rolling back author store also failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seeded := newTestArchive(t)
+			before := seeded.ids(t)
+
+			// The order store is restored last, after the others were replaced
+			var orderCalls, authorCalls int
+			var authors interfaces.Snapshotter = seeded.authors
+			if tt.rollbackFails {
+				authors = failingStore{Snapshotter: seeded.authors, calls: &authorCalls, failAfter: 1}
+			}
+			orders := failingStore{Snapshotter: seeded.orders, calls: &orderCalls, failAfter: 0}
+			archive := NewArchive(NewWriteGate(), seeded.books, authors, seeded.customers, orders)
+
+			_, err := archive.Restore(strings.NewReader(fullBackup), RestoreReplace)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Restore error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.rollbackFails {
+				return
+			}
+			if after := seeded.ids(t); !reflect.DeepEqual(after, before) {
+				t.Errorf("stores after a failed restore = %v, want %v", after, before)
+			}
+			author, err := seeded.authors.GetAuthor(context.Background(), 1)
+			if err != nil || author.FirstName != "Ada" {
+				t.Errorf("author 1 after a failed restore = %+v, %v; want the original", author, err)
+			}
+		})
+	}
+}
+
+func TestArchiveAfterRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		hookErr error
+		wantErr bool
+	}{
+		{name: "saved", hookErr: nil},
+		{name: "save fails", hookErr: errors.New("disk full"), wantErr: true},
+	}
+	for _, tt := range tests {
+		archive := newTestArchive(t)
+		called := 0
+		archive.AfterRestore = func() error {
+			called++
+			return tt.hookErr
+		}
+
+		_, err := archive.Restore(strings.NewReader(fullBackup), RestoreReplace)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Restore error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if called != 1 {
+			t.Errorf("%s: AfterRestore called %d times, want 1", tt.name, called)
+		}
+	}
+}
+
+func TestArchiveBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities []string
+		wantIDs  map[string][]int
+		wantErr  error
+	}{
+		{
+			name:    "everything",
+			wantIDs: map[string][]int{EntityAuthor: {1}, EntityBook: {1}, EntityCustomer: {1}, EntityOrder: {1}},
+		},
+		{
+			name:     "selected collections",
+			entities: []string{EntityAuthor, EntityBook},
+			wantIDs:  map[string][]int{EntityAuthor: {1}, EntityBook: {1}},
+		},
+		{
+			name:     "unknown collection",
+			entities: []string{"publisher"},
+			wantErr:  ErrInvalidBackup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newTestArchive(t)
+			backup, err := source.Backup(tt.entities)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Backup error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			var buf bytes.Buffer
+			if _, err := backup.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo error = %v", err)
+			}
+
+			// Restore into empty stores
+			target := testArchive{
+				books:     NewInMemoryBookStore(),
+				authors:   NewInMemoryAuthorStore(),
+				customers: NewInMemoryCustomerStore(),
+				orders:    NewInMemoryOrderStore(),
+			}
+			target.Archive = NewArchive(NewWriteGate(), target.books, target.authors, target.customers, target.orders)
+			if _, err := target.Restore(&buf, RestoreMerge); err != nil {
+				t.Fatalf("Restore error = %v", err)
+			}
+			if got := target.ids(t); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("IDs restored from backup = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestArchiveMergeIntegrity(t *testing.T) {
+	tests := []struct {
+		name       string
+		backup     string
+		corrupt    func(a testArchive)
+		wantErr    string
+		wantAuthor []int
+	}{
+		{
+			name:       "references resolved by the backup",
+			backup:     `{"version": 2, "authors": {"2": {"id": 2}}, "books": {"2": {"id": 2, "author": {"id": 2}}}}`,
+			wantAuthor: []int{1, 2},
+		},
+		{
+			name:       "references resolved by the stores",
+			backup:     `{"version": 2, "books": {"2": {"id": 2, "author": {"id": 1}}}}`,
+			wantAuthor: []int{1},
+		},
+		{
+			name:       "missing author",
+			backup:     `{"version": 2, "authors": {"3": {"id": 3}}, "books": {"2": {"id": 2, "author": {"id": 7}}}}`,
+			wantErr:    "book 2: author.id refers to missing author 7",
+			wantAuthor: []int{1},
+		},
+		{
+			name: "missing customer and book",
+			backup: `{"version": 2, "orders": {"2": {"id": 2, "customer": {"id": 5},
+				"items": [{"book": {"id": 6}, "quantity": 1}], "created_at": "2024-01-01T00:00:00Z"}}}`,
+			wantErr:    "order 2: customer.id refers to missing customer 5",
+			wantAuthor: []int{1},
+		},
+		{
+			name:   "dangling references already in the stores",
+			backup: `{"version": 2, "authors": {"2": {"id": 2}}}`,
+			corrupt: func(a testArchive) {
+				a.books.CreateBook(context.Background(), models.Book{Author: models.Author{ID: 9}})
+			},
+			wantAuthor: []int{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := newTestArchive(t)
+			if tt.corrupt != nil {
+				tt.corrupt(archive)
+			}
+			before := archive.ids(t)
+
+			_, err := archive.Restore(strings.NewReader(tt.backup), RestoreMerge)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidBackup) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Restore error = %v, want %v with %q", err, ErrInvalidBackup, tt.wantErr)
+				}
+				if after := archive.ids(t); !reflect.DeepEqual(after, before) {
+					t.Errorf("stores after a rejected merge = %v, want %v", after, before)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Restore error = %v", err)
+			}
+			if got := archive.ids(t)[EntityAuthor]; !reflect.DeepEqual(got, tt.wantAuthor) {
+				t.Errorf("authors after merge = %v, want %v", got, tt.wantAuthor)
+			}
+		})
+	}
+}
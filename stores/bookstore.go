@@ -1,6 +1,8 @@
 package stores
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"online-bookstore-api/interfaces"
 	"online-bookstore-api/models"
@@ -10,9 +12,10 @@ import (
 
 // InMemoryBookStore implements BookStore interface
 type InMemoryBookStore struct {
-	mu     sync.RWMutex
-	books  map[int]models.Book
-	nextID int
+	mu         sync.RWMutex
+	books      map[int]models.Book
+	nextID     int
+	onMutation MutationHook
 }
 
 // NewInMemoryBookStore creates a new in-memory book store
@@ -24,94 +27,126 @@ func NewInMemoryBookStore() *InMemoryBookStore {
 }
 
 // CreateBook creates a new book
-func (s *InMemoryBookStore) CreateBook(book models.Book) (models.Book, error) {
+func (s *InMemoryBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Book{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	book.ID = s.nextID
+	if err := s.notify(Mutation{Entity: EntityBook, Op: OpCreate, ID: book.ID, Value: book}); err != nil {
+		return models.Book{}, err
+	}
+
 	s.nextID++
 	s.books[book.ID] = book
 	return book, nil
 }
 
 // GetBook retrieves a book by ID
-func (s *InMemoryBookStore) GetBook(id int) (models.Book, error) {
+func (s *InMemoryBookStore) GetBook(ctx context.Context, id int) (models.Book, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	book, exists := s.books[id]
 	if !exists {
-		return models.Book{}, fmt.Errorf("book with ID %d not found", id)
+		return models.Book{}, notFoundError(EntityBook, id)
 	}
 	return book, nil
 }
 
 // UpdateBook updates an existing book
-func (s *InMemoryBookStore) UpdateBook(id int, book models.Book) (models.Book, error) {
+func (s *InMemoryBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Book{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.books[id]; !exists {
-		return models.Book{}, fmt.Errorf("book with ID %d not found", id)
+		return models.Book{}, notFoundError(EntityBook, id)
 	}
 
 	book.ID = id
+	if err := s.notify(Mutation{Entity: EntityBook, Op: OpUpdate, ID: id, Value: book}); err != nil {
+		return models.Book{}, err
+	}
+
 	s.books[id] = book
 	return book, nil
 }
 
 // DeleteBook deletes a book by ID
-func (s *InMemoryBookStore) DeleteBook(id int) error {
+func (s *InMemoryBookStore) DeleteBook(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.books[id]; !exists {
-		return fmt.Errorf("book with ID %d not found", id)
+		return notFoundError(EntityBook, id)
+	}
+
+	if err := s.notify(Mutation{Entity: EntityBook, Op: OpDelete, ID: id}); err != nil {
+		return err
 	}
 
 	delete(s.books, id)
 	return nil
 }
 
+// AdjustStock adds each change to the stock of the book with that ID
+func (s *InMemoryBookStore) AdjustStock(ctx context.Context, changes map[int]int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stock, err := adjustedStock(changes, func(id int) (int, bool, error) {
+		book, exists := s.books[id]
+		return book.Stock, exists, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Log the changes as one batch, so a failure leaves every book as it was
+	ids := stockIDs(changes)
+	books := make([]models.Book, len(ids))
+	batch := Mutation{Entity: EntityBook, Op: OpBatch, Batch: make([]Mutation, len(ids))}
+	for i, id := range ids {
+		books[i] = s.books[id]
+		books[i].Stock = stock[id]
+		batch.Batch[i] = Mutation{Entity: EntityBook, Op: OpUpdate, ID: id, Value: books[i]}
+	}
+	if err := s.notify(batch); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		s.books[book.ID] = book
+	}
+	return nil
+}
+
 // SearchBooks searches for books based on criteria
-func (s *InMemoryBookStore) SearchBooks(criteria models.SearchCriteria) ([]models.Book, error) {
+func (s *InMemoryBookStore) SearchBooks(ctx context.Context, criteria models.SearchCriteria) ([]models.Book, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var results []models.Book
 	for _, book := range s.books {
-		matches := true
-
-		// Case-insensitive partial match for title
-		if criteria.Title != "" {
-			titleMatch := strings.Contains(strings.ToLower(book.Title), strings.ToLower(criteria.Title))
-			if !titleMatch {
-				matches = false
-			}
-		}
-		if criteria.AuthorID != 0 && book.Author.ID != criteria.AuthorID {
-			matches = false
-		}
-		if criteria.Genre != "" {
-			genreFound := false
-			for _, genre := range book.Genres {
-				if strings.EqualFold(genre, criteria.Genre) {
-					genreFound = true
-					break
-				}
-			}
-			if !genreFound {
-				matches = false
-			}
-		}
-		if criteria.MinPrice > 0 && book.Price < criteria.MinPrice {
-			matches = false
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		if criteria.MaxPrice > 0 && book.Price > criteria.MaxPrice {
-			matches = false
-		}
-
-		if matches {
+		if matchesBookCriteria(book, criteria) {
 			results = append(results, book)
 		}
 	}
@@ -119,13 +154,47 @@ func (s *InMemoryBookStore) SearchBooks(criteria models.SearchCriteria) ([]model
 	return results, nil
 }
 
+// matchesBookCriteria reports whether a book satisfies every search criterion
+func matchesBookCriteria(book models.Book, criteria models.SearchCriteria) bool {
+	// Case-insensitive partial match for title
+	if criteria.Title != "" && !strings.Contains(strings.ToLower(book.Title), strings.ToLower(criteria.Title)) {
+		return false
+	}
+	if criteria.AuthorID != 0 && book.Author.ID != criteria.AuthorID {
+		return false
+	}
+	if criteria.Genre != "" {
+		genreFound := false
+		for _, genre := range book.Genres {
+			if strings.EqualFold(genre, criteria.Genre) {
+				genreFound = true
+				break
+			}
+		}
+		if !genreFound {
+			return false
+		}
+	}
+	// Prices in another currency than a bound cannot be compared with it
+	if criteria.MinPrice.Amount > 0 && (!book.Price.SameCurrency(criteria.MinPrice) || book.Price.Cmp(criteria.MinPrice) < 0) {
+		return false
+	}
+	if criteria.MaxPrice.Amount > 0 && (!book.Price.SameCurrency(criteria.MaxPrice) || book.Price.Cmp(criteria.MaxPrice) > 0) {
+		return false
+	}
+	return true
+}
+
 // GetAllBooks returns all books
-func (s *InMemoryBookStore) GetAllBooks() ([]models.Book, error) {
+func (s *InMemoryBookStore) GetAllBooks(ctx context.Context) ([]models.Book, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	books := make([]models.Book, 0, len(s.books))
 	for _, book := range s.books {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		books = append(books, book)
 	}
 	return books, nil
@@ -159,5 +228,72 @@ func (s *InMemoryBookStore) GetNextID() int {
 	return s.nextID
 }
 
+// Entity returns the name of the records held by the store
+func (s *InMemoryBookStore) Entity() string {
+	return EntityBook
+}
+
+// Snapshot captures the books and the next ID for persistence
+func (s *InMemoryBookStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return snapshotRecords(s.books, s.nextID)
+}
+
+// Restore replaces the books with the contents of a snapshot
+func (s *InMemoryBookStore) Restore(snapshot interfaces.Snapshot) error {
+	books, nextID, err := restoreRecords[models.Book](EntityBook, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.books = books
+	s.nextID = nextID
+	return nil
+}
+
+// ReplayMutation applies a logged mutation without running the mutation hook
+func (s *InMemoryBookStore) ReplayMutation(op string, id int, value json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if op == OpDelete {
+		delete(s.books, id)
+		return nil
+	}
+
+	var book models.Book
+	if err := json.Unmarshal(value, &book); err != nil {
+		return fmt.Errorf("invalid book record: %w", err)
+	}
+	book.ID = id
+	s.books[id] = book
+	if id >= s.nextID {
+		s.nextID = id + 1
+	}
+	return nil
+}
+
+// SetMutationHook registers a hook called before every create, update and delete
+func (s *InMemoryBookStore) SetMutationHook(hook MutationHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMutation = hook
+}
+
+// notify runs the mutation hook; the caller must hold the write lock
+func (s *InMemoryBookStore) notify(m Mutation) error {
+	if s.onMutation == nil {
+		return nil
+	}
+	return s.onMutation(m)
+}
+
 // Verify interface implementation
-var _ interfaces.BookStore = (*InMemoryBookStore)(nil)
+var (
+	_ interfaces.BookStore        = (*InMemoryBookStore)(nil)
+	_ interfaces.Snapshotter      = (*InMemoryBookStore)(nil)
+	_ interfaces.MutationReplayer = (*InMemoryBookStore)(nil)
+)
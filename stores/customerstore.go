@@ -1,6 +1,8 @@
 package stores
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"online-bookstore-api/interfaces"
 	"online-bookstore-api/models"
@@ -9,9 +11,10 @@ import (
 
 // InMemoryCustomerStore implements CustomerStore interface
 type InMemoryCustomerStore struct {
-	mu        sync.RWMutex
-	customers map[int]models.Customer
-	nextID    int
+	mu         sync.RWMutex
+	customers  map[int]models.Customer
+	nextID     int
+	onMutation MutationHook
 }
 
 // NewInMemoryCustomerStore creates a new in-memory customer store
@@ -23,49 +26,73 @@ func NewInMemoryCustomerStore() *InMemoryCustomerStore {
 }
 
 // CreateCustomer creates a new customer
-func (s *InMemoryCustomerStore) CreateCustomer(customer models.Customer) (models.Customer, error) {
+func (s *InMemoryCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Customer{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	customer.ID = s.nextID
+	if err := s.notify(Mutation{Entity: EntityCustomer, Op: OpCreate, ID: customer.ID, Value: customer}); err != nil {
+		return models.Customer{}, err
+	}
+
 	s.nextID++
 	s.customers[customer.ID] = customer
 	return customer, nil
 }
 
 // GetCustomer retrieves a customer by ID
-func (s *InMemoryCustomerStore) GetCustomer(id int) (models.Customer, error) {
+func (s *InMemoryCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	customer, exists := s.customers[id]
 	if !exists {
-		return models.Customer{}, fmt.Errorf("customer with ID %d not found", id)
+		return models.Customer{}, notFoundError(EntityCustomer, id)
 	}
 	return customer, nil
 }
 
 // UpdateCustomer updates an existing customer
-func (s *InMemoryCustomerStore) UpdateCustomer(id int, customer models.Customer) (models.Customer, error) {
+func (s *InMemoryCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Customer{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.customers[id]; !exists {
-		return models.Customer{}, fmt.Errorf("customer with ID %d not found", id)
+		return models.Customer{}, notFoundError(EntityCustomer, id)
 	}
 
 	customer.ID = id
+	if err := s.notify(Mutation{Entity: EntityCustomer, Op: OpUpdate, ID: id, Value: customer}); err != nil {
+		return models.Customer{}, err
+	}
+
 	s.customers[id] = customer
 	return customer, nil
 }
 
 // DeleteCustomer deletes a customer by ID
-func (s *InMemoryCustomerStore) DeleteCustomer(id int) error {
+func (s *InMemoryCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.customers[id]; !exists {
-		return fmt.Errorf("customer with ID %d not found", id)
+		return notFoundError(EntityCustomer, id)
+	}
+
+	if err := s.notify(Mutation{Entity: EntityCustomer, Op: OpDelete, ID: id}); err != nil {
+		return err
 	}
 
 	delete(s.customers, id)
@@ -73,12 +100,15 @@ func (s *InMemoryCustomerStore) DeleteCustomer(id int) error {
 }
 
 // GetAllCustomers returns all customers
-func (s *InMemoryCustomerStore) GetAllCustomers() ([]models.Customer, error) {
+func (s *InMemoryCustomerStore) GetAllCustomers(ctx context.Context) ([]models.Customer, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	customers := make([]models.Customer, 0, len(s.customers))
 	for _, customer := range s.customers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		customers = append(customers, customer)
 	}
 	return customers, nil
@@ -112,5 +142,72 @@ func (s *InMemoryCustomerStore) GetNextID() int {
 	return s.nextID
 }
 
+// Entity returns the name of the records held by the store
+func (s *InMemoryCustomerStore) Entity() string {
+	return EntityCustomer
+}
+
+// Snapshot captures the customers and the next ID for persistence
+func (s *InMemoryCustomerStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return snapshotRecords(s.customers, s.nextID)
+}
+
+// Restore replaces the customers with the contents of a snapshot
+func (s *InMemoryCustomerStore) Restore(snapshot interfaces.Snapshot) error {
+	customers, nextID, err := restoreRecords[models.Customer](EntityCustomer, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.customers = customers
+	s.nextID = nextID
+	return nil
+}
+
+// ReplayMutation applies a logged mutation without running the mutation hook
+func (s *InMemoryCustomerStore) ReplayMutation(op string, id int, value json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if op == OpDelete {
+		delete(s.customers, id)
+		return nil
+	}
+
+	var customer models.Customer
+	if err := json.Unmarshal(value, &customer); err != nil {
+		return fmt.Errorf("invalid customer record: %w", err)
+	}
+	customer.ID = id
+	s.customers[id] = customer
+	if id >= s.nextID {
+		s.nextID = id + 1
+	}
+	return nil
+}
+
+// SetMutationHook registers a hook called before every create, update and delete
+func (s *InMemoryCustomerStore) SetMutationHook(hook MutationHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMutation = hook
+}
+
+// notify runs the mutation hook; the caller must hold the write lock
+func (s *InMemoryCustomerStore) notify(m Mutation) error {
+	if s.onMutation == nil {
+		return nil
+	}
+	return s.onMutation(m)
+}
+
 // Verify interface implementation
-var _ interfaces.CustomerStore = (*InMemoryCustomerStore)(nil)
+var (
+	_ interfaces.CustomerStore    = (*InMemoryCustomerStore)(nil)
+	_ interfaces.Snapshotter      = (*InMemoryCustomerStore)(nil)
+	_ interfaces.MutationReplayer = (*InMemoryCustomerStore)(nil)
+)
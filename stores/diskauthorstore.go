@@ -0,0 +1,137 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/models"
+	"sync"
+)
+
+// DiskAuthorStore implements AuthorStore on top of the embedded key-value store
+type DiskAuthorStore struct {
+	mu      sync.Mutex // serializes writes
+	db      *kvstore.DB
+	authors diskTable[models.Author]
+}
+
+// NewDiskAuthorStore creates an author store persisted in db
+func NewDiskAuthorStore(db *kvstore.DB) *DiskAuthorStore {
+	return &DiskAuthorStore{db: db, authors: newDiskTable[models.Author](db, EntityAuthor)}
+}
+
+// CreateAuthor creates a new author
+func (s *DiskAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Author{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.authors.nextID()
+	if err != nil {
+		return models.Author{}, err
+	}
+	author.ID = id
+
+	var batch kvstore.Batch
+	if err := s.authors.put(&batch, id, author); err != nil {
+		return models.Author{}, err
+	}
+	s.authors.setNextID(&batch, id+1)
+	if err := s.db.Write(&batch); err != nil {
+		return models.Author{}, err
+	}
+	return author, nil
+}
+
+// GetAuthor retrieves an author by ID
+func (s *DiskAuthorStore) GetAuthor(ctx context.Context, id int) (models.Author, error) {
+	author, exists, err := s.authors.get(id)
+	if err != nil {
+		return models.Author{}, err
+	}
+	if !exists {
+		return models.Author{}, notFoundError(EntityAuthor, id)
+	}
+	return author, nil
+}
+
+// UpdateAuthor updates an existing author
+func (s *DiskAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Author{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.authors.get(id); err != nil {
+		return models.Author{}, err
+	} else if !exists {
+		return models.Author{}, notFoundError(EntityAuthor, id)
+	}
+
+	author.ID = id
+	var batch kvstore.Batch
+	if err := s.authors.put(&batch, id, author); err != nil {
+		return models.Author{}, err
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return models.Author{}, err
+	}
+	return author, nil
+}
+
+// DeleteAuthor deletes an author by ID
+func (s *DiskAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.authors.get(id); err != nil {
+		return err
+	} else if !exists {
+		return notFoundError(EntityAuthor, id)
+	}
+	return s.db.Delete(s.authors.key(id))
+}
+
+// GetAllAuthors returns all authors
+func (s *DiskAuthorStore) GetAllAuthors(ctx context.Context) ([]models.Author, error) {
+	return s.authors.all(ctx)
+}
+
+// Entity returns the name of the records held by the store
+func (s *DiskAuthorStore) Entity() string {
+	return EntityAuthor
+}
+
+// Snapshot captures the authors and the next ID for persistence
+func (s *DiskAuthorStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authors.snapshot()
+}
+
+// Restore replaces the authors with the contents of a snapshot
+func (s *DiskAuthorStore) Restore(snapshot interfaces.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch kvstore.Batch
+	if _, err := s.authors.restore(&batch, snapshot); err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.AuthorStore = (*DiskAuthorStore)(nil)
+	_ interfaces.Snapshotter = (*DiskAuthorStore)(nil)
+)
@@ -0,0 +1,182 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/models"
+	"sync"
+)
+
+// DiskBookStore implements BookStore on top of the embedded key-value store
+type DiskBookStore struct {
+	mu    sync.Mutex // serializes writes
+	db    *kvstore.DB
+	books diskTable[models.Book]
+}
+
+// NewDiskBookStore creates a book store persisted in db
+func NewDiskBookStore(db *kvstore.DB) *DiskBookStore {
+	return &DiskBookStore{db: db, books: newDiskTable[models.Book](db, EntityBook)}
+}
+
+// CreateBook creates a new book
+func (s *DiskBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Book{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.books.nextID()
+	if err != nil {
+		return models.Book{}, err
+	}
+	book.ID = id
+
+	var batch kvstore.Batch
+	if err := s.books.put(&batch, id, book); err != nil {
+		return models.Book{}, err
+	}
+	s.books.setNextID(&batch, id+1)
+	if err := s.db.Write(&batch); err != nil {
+		return models.Book{}, err
+	}
+	return book, nil
+}
+
+// GetBook retrieves a book by ID
+func (s *DiskBookStore) GetBook(ctx context.Context, id int) (models.Book, error) {
+	book, exists, err := s.books.get(id)
+	if err != nil {
+		return models.Book{}, err
+	}
+	if !exists {
+		return models.Book{}, notFoundError(EntityBook, id)
+	}
+	return book, nil
+}
+
+// UpdateBook updates an existing book
+func (s *DiskBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Book{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.books.get(id); err != nil {
+		return models.Book{}, err
+	} else if !exists {
+		return models.Book{}, notFoundError(EntityBook, id)
+	}
+
+	book.ID = id
+	var batch kvstore.Batch
+	if err := s.books.put(&batch, id, book); err != nil {
+		return models.Book{}, err
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return models.Book{}, err
+	}
+	return book, nil
+}
+
+// DeleteBook deletes a book by ID
+func (s *DiskBookStore) DeleteBook(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.books.get(id); err != nil {
+		return err
+	} else if !exists {
+		return notFoundError(EntityBook, id)
+	}
+	return s.db.Delete(s.books.key(id))
+}
+
+// AdjustStock adds each change to the stock of the book with that ID
+func (s *DiskBookStore) AdjustStock(ctx context.Context, changes map[int]int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	books := make(map[int]models.Book, len(changes))
+	stock, err := adjustedStock(changes, func(id int) (int, bool, error) {
+		book, exists, err := s.books.get(id)
+		books[id] = book
+		return book.Stock, exists, err
+	})
+	if err != nil {
+		return err
+	}
+
+	// One batch, so every change is written or none is
+	var batch kvstore.Batch
+	for id, book := range books {
+		book.Stock = stock[id]
+		if err := s.books.put(&batch, id, book); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(&batch)
+}
+
+// SearchBooks searches for books based on criteria
+func (s *DiskBookStore) SearchBooks(ctx context.Context, criteria models.SearchCriteria) ([]models.Book, error) {
+	var results []models.Book
+	err := s.books.forEach(ctx, func(_ int, book models.Book) error {
+		if matchesBookCriteria(book, criteria) {
+			results = append(results, book)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetAllBooks returns all books
+func (s *DiskBookStore) GetAllBooks(ctx context.Context) ([]models.Book, error) {
+	return s.books.all(ctx)
+}
+
+// Entity returns the name of the records held by the store
+func (s *DiskBookStore) Entity() string {
+	return EntityBook
+}
+
+// Snapshot captures the books and the next ID for persistence
+func (s *DiskBookStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.books.snapshot()
+}
+
+// Restore replaces the books with the contents of a snapshot
+func (s *DiskBookStore) Restore(snapshot interfaces.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch kvstore.Batch
+	if _, err := s.books.restore(&batch, snapshot); err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.BookStore   = (*DiskBookStore)(nil)
+	_ interfaces.Snapshotter = (*DiskBookStore)(nil)
+)
@@ -0,0 +1,137 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/models"
+	"sync"
+)
+
+// DiskCustomerStore implements CustomerStore on top of the embedded key-value store
+type DiskCustomerStore struct {
+	mu        sync.Mutex // serializes writes
+	db        *kvstore.DB
+	customers diskTable[models.Customer]
+}
+
+// NewDiskCustomerStore creates a customer store persisted in db
+func NewDiskCustomerStore(db *kvstore.DB) *DiskCustomerStore {
+	return &DiskCustomerStore{db: db, customers: newDiskTable[models.Customer](db, EntityCustomer)}
+}
+
+// CreateCustomer creates a new customer
+func (s *DiskCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Customer{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.customers.nextID()
+	if err != nil {
+		return models.Customer{}, err
+	}
+	customer.ID = id
+
+	var batch kvstore.Batch
+	if err := s.customers.put(&batch, id, customer); err != nil {
+		return models.Customer{}, err
+	}
+	s.customers.setNextID(&batch, id+1)
+	if err := s.db.Write(&batch); err != nil {
+		return models.Customer{}, err
+	}
+	return customer, nil
+}
+
+// GetCustomer retrieves a customer by ID
+func (s *DiskCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	customer, exists, err := s.customers.get(id)
+	if err != nil {
+		return models.Customer{}, err
+	}
+	if !exists {
+		return models.Customer{}, notFoundError(EntityCustomer, id)
+	}
+	return customer, nil
+}
+
+// UpdateCustomer updates an existing customer
+func (s *DiskCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Customer{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.customers.get(id); err != nil {
+		return models.Customer{}, err
+	} else if !exists {
+		return models.Customer{}, notFoundError(EntityCustomer, id)
+	}
+
+	customer.ID = id
+	var batch kvstore.Batch
+	if err := s.customers.put(&batch, id, customer); err != nil {
+		return models.Customer{}, err
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return models.Customer{}, err
+	}
+	return customer, nil
+}
+
+// DeleteCustomer deletes a customer by ID
+func (s *DiskCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists, err := s.customers.get(id); err != nil {
+		return err
+	} else if !exists {
+		return notFoundError(EntityCustomer, id)
+	}
+	return s.db.Delete(s.customers.key(id))
+}
+
+// GetAllCustomers returns all customers
+func (s *DiskCustomerStore) GetAllCustomers(ctx context.Context) ([]models.Customer, error) {
+	return s.customers.all(ctx)
+}
+
+// Entity returns the name of the records held by the store
+func (s *DiskCustomerStore) Entity() string {
+	return EntityCustomer
+}
+
+// Snapshot captures the customers and the next ID for persistence
+func (s *DiskCustomerStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.customers.snapshot()
+}
+
+// Restore replaces the customers with the contents of a snapshot
+func (s *DiskCustomerStore) Restore(snapshot interfaces.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch kvstore.Batch
+	if _, err := s.customers.restore(&batch, snapshot); err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.CustomerStore = (*DiskCustomerStore)(nil)
+	_ interfaces.Snapshotter   = (*DiskCustomerStore)(nil)
+)
@@ -0,0 +1,375 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderTimePrefix holds the creation time index of the disk order store.
+// Keys are "order_time/<created_at>/<id>" with the timestamp encoded so that
+// keys sort chronologically; values are empty.
+const orderTimePrefix = "order_time/"
+
+// orderStatusPrefix holds the status change index of the disk order store,
+// with a key "order_status/<status>/<changed_at>/<id>" for every entry in
+// the history of an order. Stores written before the index existed build it
+// on first use and then set orderStatusIndexedKey.
+const (
+	orderStatusPrefix     = "order_status/"
+	orderStatusIndexedKey = "order_status_indexed"
+)
+
+// DiskOrderStore implements OrderStore on top of the embedded key-value
+// store. Orders are indexed by creation time and by the time of their status
+// changes, so range queries only read the orders they return.
+type DiskOrderStore struct {
+	mu     sync.Mutex // serializes writes
+	db     *kvstore.DB
+	orders diskTable[models.Order]
+
+	// statusIndexed caches that the status index is complete; guarded by mu
+	statusIndexed bool
+}
+
+// NewDiskOrderStore creates an order store persisted in db
+func NewDiskOrderStore(db *kvstore.DB) *DiskOrderStore {
+	return &DiskOrderStore{db: db, orders: newDiskTable[models.Order](db, EntityOrder)}
+}
+
+// CreateOrder creates a new order
+func (s *DiskOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Order{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.orders.nextID()
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.ID = id
+
+	var batch kvstore.Batch
+	if err := s.orders.put(&batch, id, order); err != nil {
+		return models.Order{}, err
+	}
+	batch.Put(orderTimeKey(order.CreatedAt, id), nil)
+	for _, key := range orderStatusKeys(order) {
+		batch.Put(key, nil)
+	}
+	s.orders.setNextID(&batch, id+1)
+	if err := s.db.Write(&batch); err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// GetOrder retrieves an order by ID
+func (s *DiskOrderStore) GetOrder(ctx context.Context, id int) (models.Order, error) {
+	order, exists, err := s.orders.get(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if !exists {
+		return models.Order{}, notFoundError(EntityOrder, id)
+	}
+	return order, nil
+}
+
+// UpdateOrder updates an existing order
+func (s *DiskOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Order{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists, err := s.orders.get(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if !exists {
+		return models.Order{}, notFoundError(EntityOrder, id)
+	}
+
+	order.ID = id
+	var batch kvstore.Batch
+	if err := s.orders.put(&batch, id, order); err != nil {
+		return models.Order{}, err
+	}
+	if !order.CreatedAt.Equal(existing.CreatedAt) {
+		batch.Delete(orderTimeKey(existing.CreatedAt, id))
+		batch.Put(orderTimeKey(order.CreatedAt, id), nil)
+	}
+	for _, key := range orderStatusKeys(existing) {
+		batch.Delete(key)
+	}
+	for _, key := range orderStatusKeys(order) {
+		batch.Put(key, nil)
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// DeleteOrder deletes an order by ID
+func (s *DiskOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists, err := s.orders.get(id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFoundError(EntityOrder, id)
+	}
+
+	var batch kvstore.Batch
+	batch.Delete(s.orders.key(id))
+	batch.Delete(orderTimeKey(existing.CreatedAt, id))
+	for _, key := range orderStatusKeys(existing) {
+		batch.Delete(key)
+	}
+	return s.db.Write(&batch)
+}
+
+// GetAllOrders returns all orders
+func (s *DiskOrderStore) GetAllOrders(ctx context.Context) ([]models.Order, error) {
+	return s.orders.all(ctx)
+}
+
+// GetOrdersInTimeRange retrieves orders within a time range
+func (s *DiskOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
+	var results []models.Order
+	err := s.ForEachOrderInTimeRange(ctx, start, end, func(order models.Order) error {
+		results = append(results, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ForEachOrderInTimeRange streams orders within a time range, oldest first.
+// The matching IDs are read from the time index; each order is then loaded
+// on its own so slow consumers do not block writers.
+func (s *DiskOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	if end.Before(start) {
+		return nil
+	}
+
+	var ids []int
+	// '0' sorts after the '/' separating the timestamp from the ID, so the
+	// bound includes every order created exactly at end
+	err := s.db.Scan(orderTimePrefix+encodeOrderTime(start), orderTimePrefix+encodeOrderTime(end)+"0",
+		func(key string, _ []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			id, err := strconv.Atoi(key[strings.LastIndexByte(key, '/')+1:])
+			if err != nil {
+				return fmt.Errorf("invalid order index key %q", key)
+			}
+			ids = append(ids, id)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		order, exists, err := s.orders.get(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// Deleted since the scan started
+			continue
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrdersChangedInTimeRange returns the orders whose status changed to one
+// of statuses within [start, end), ordered by ID. The IDs are read from the
+// status index, one range per status.
+func (s *DiskOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	if err := s.buildStatusIndex(ctx); err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, status := range statuses {
+		prefix := orderStatusPrefix + status + "/"
+		err := s.db.Scan(prefix+encodeOrderTime(start), prefix+encodeOrderTime(end), func(key string, _ []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			id, err := strconv.Atoi(key[strings.LastIndexByte(key, '/')+1:])
+			if err != nil {
+				return fmt.Errorf("invalid order status index key %q", key)
+			}
+			seen[id] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var orders []models.Order
+	for _, id := range ids {
+		order, exists, err := s.orders.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// buildStatusIndex indexes the history of every order, once, in stores
+// written before the status index existed
+func (s *DiskOrderStore) buildStatusIndex(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statusIndexed {
+		return nil
+	}
+	if _, err := s.db.Get(orderStatusIndexedKey); err == nil {
+		s.statusIndexed = true
+		return nil
+	} else if !errors.Is(err, kvstore.ErrNotFound) {
+		return err
+	}
+
+	var batch kvstore.Batch
+	if err := deletePrefix(s.db, &batch, orderStatusPrefix); err != nil {
+		return err
+	}
+	err := s.orders.forEach(ctx, func(_ int, order models.Order) error {
+		for _, key := range orderStatusKeys(order) {
+			batch.Put(key, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch.Put(orderStatusIndexedKey, nil)
+	if err := s.db.Write(&batch); err != nil {
+		return err
+	}
+	s.statusIndexed = true
+	return nil
+}
+
+// Entity returns the name of the records held by the store
+func (s *DiskOrderStore) Entity() string {
+	return EntityOrder
+}
+
+// Snapshot captures the orders and the next ID for persistence
+func (s *DiskOrderStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orders.snapshot()
+}
+
+// Restore replaces the orders with the contents of a snapshot and rebuilds
+// the time and status indexes
+func (s *DiskOrderStore) Restore(snapshot interfaces.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch kvstore.Batch
+	if err := deletePrefix(s.db, &batch, orderTimePrefix); err != nil {
+		return err
+	}
+	if err := deletePrefix(s.db, &batch, orderStatusPrefix); err != nil {
+		return err
+	}
+	orders, err := s.orders.restore(&batch, snapshot)
+	if err != nil {
+		return err
+	}
+	for id, order := range orders {
+		order.ID = id
+		batch.Put(orderTimeKey(order.CreatedAt, id), nil)
+		for _, key := range orderStatusKeys(order) {
+			batch.Put(key, nil)
+		}
+	}
+	batch.Put(orderStatusIndexedKey, nil)
+	if err := s.db.Write(&batch); err != nil {
+		return err
+	}
+	s.statusIndexed = true
+	return nil
+}
+
+// orderTimeKey returns the time index key of an order
+func orderTimeKey(createdAt time.Time, id int) string {
+	return orderTimePrefix + encodeOrderTime(createdAt) + "/" + formatID(id)
+}
+
+// orderStatusKeys returns the status index keys of an order, one per entry
+// in its history
+func orderStatusKeys(order models.Order) []string {
+	keys := make([]string, len(order.History))
+	for i, change := range order.History {
+		keys[i] = orderStatusPrefix + change.NewStatus + "/" + encodeOrderTime(change.Timestamp) + "/" + formatID(order.ID)
+	}
+	return keys
+}
+
+// encodeOrderTime encodes a time as fixed-width hex that sorts
+// chronologically: seconds with the sign bit flipped, then nanoseconds
+func encodeOrderTime(t time.Time) string {
+	return fmt.Sprintf("%016x%08x", uint64(t.Unix())^(1<<63), t.Nanosecond())
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderStore       = (*DiskOrderStore)(nil)
+	_ interfaces.OrderIterator    = (*DiskOrderStore)(nil)
+	_ interfaces.OrderStatusIndex = (*DiskOrderStore)(nil)
+	_ interfaces.Snapshotter      = (*DiskOrderStore)(nil)
+)
@@ -0,0 +1,172 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/kvstore"
+	"online-bookstore-api/models"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openDiskOrderStore opens a disk order store in a temporary directory
+func openDiskOrderStore(t *testing.T) (*DiskOrderStore, *kvstore.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bookstore.kv")
+	db, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatalf("kvstore.Open error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewDiskOrderStore(db), db, path
+}
+
+// orderIDs returns the IDs of orders in the order given
+func orderIDs(orders []models.Order) []int {
+	ids := make([]int, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
+// orderWithHistory builds an order created at the first step's time that
+// moved through the given statuses
+func orderWithHistory(steps ...models.OrderStatusChange) models.Order {
+	order := models.Order{CreatedAt: steps[0].Timestamp}
+	for _, step := range steps {
+		step.PreviousStatus = order.Status
+		order.History = append(order.History, step)
+		order.Status = step.NewStatus
+	}
+	return order
+}
+
+func TestDiskOrderStoreTimeIndex(t *testing.T) {
+	ctx := context.Background()
+	store, _, _ := openDiskOrderStore(t)
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, createdAt := range []time.Time{
+		base.Add(2 * time.Hour),
+		base.Add(-time.Nanosecond),
+		time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), // before the Unix epoch
+		base,
+		base.Add(24 * time.Hour),
+		base.Add(time.Hour + 500*time.Millisecond),
+	} {
+		if _, err := store.CreateOrder(ctx, models.Order{CreatedAt: createdAt}); err != nil {
+			t.Fatalf("CreateOrder error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []int
+	}{
+		{name: "day, both bounds included", start: base, end: base.Add(24 * time.Hour), want: []int{4, 6, 1, 5}},
+		{name: "everything", start: time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), end: base.AddDate(1, 0, 0), want: []int{3, 2, 4, 6, 1, 5}},
+		{name: "before the epoch", start: time.Date(1959, 1, 1, 0, 0, 0, 0, time.UTC), end: time.Unix(0, 0), want: []int{3}},
+		{name: "sub-second bounds", start: base.Add(time.Hour + 500*time.Millisecond), end: base.Add(time.Hour + 999*time.Millisecond), want: []int{6}},
+		{name: "reversed", start: base.Add(time.Hour), end: base, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := store.GetOrdersInTimeRange(ctx, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("GetOrdersInTimeRange error = %v", err)
+			}
+			if got := orderIDs(orders); !slices.Equal(got, tt.want) {
+				t.Errorf("orders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Updates move an order in the index and deletes remove it
+	if _, err := store.UpdateOrder(ctx, 1, models.Order{CreatedAt: base.AddDate(0, 0, 2)}); err != nil {
+		t.Fatalf("UpdateOrder error = %v", err)
+	}
+	if err := store.DeleteOrder(ctx, 6); err != nil {
+		t.Fatalf("DeleteOrder error = %v", err)
+	}
+	orders, err := store.GetOrdersInTimeRange(ctx, base, base.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("GetOrdersInTimeRange error = %v", err)
+	}
+	if got, want := orderIDs(orders), []int{4, 5, 1}; !slices.Equal(got, want) {
+		t.Errorf("orders after update and delete = %v, want %v", got, want)
+	}
+}
+
+func TestDiskOrderStoreStatusIndex(t *testing.T) {
+	ctx := context.Background()
+	store, db, path := openDiskOrderStore(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	change := func(status string, at time.Duration) models.OrderStatusChange {
+		return models.OrderStatusChange{Timestamp: day.Add(at), NewStatus: status}
+	}
+	for _, order := range []models.Order{
+		orderWithHistory(change(models.OrderPending, -time.Hour), change(models.OrderPaid, time.Hour)),
+		orderWithHistory(change(models.OrderPending, time.Hour), change(models.OrderPaid, 25*time.Hour)),
+		orderWithHistory(change(models.OrderPending, time.Hour), change(models.OrderCancelled, 2*time.Hour)),
+		orderWithHistory(change(models.OrderPending, -48*time.Hour), change(models.OrderPaid, -47*time.Hour),
+			change(models.OrderRefunded, 3*time.Hour)),
+	} {
+		if _, err := store.CreateOrder(ctx, order); err != nil {
+			t.Fatalf("CreateOrder error = %v", err)
+		}
+	}
+
+	changed := func(t *testing.T, store *DiskOrderStore, statuses ...string) []int {
+		t.Helper()
+		orders, err := store.GetOrdersChangedInTimeRange(ctx, statuses, day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("GetOrdersChangedInTimeRange error = %v", err)
+		}
+		return orderIDs(orders)
+	}
+	if got, want := changed(t, store, models.OrderPaid), []int{1}; !slices.Equal(got, want) {
+		t.Errorf("paid in the window = %v, want %v", got, want)
+	}
+	if got, want := changed(t, store, models.OrderCancelled, models.OrderRefunded), []int{3, 4}; !slices.Equal(got, want) {
+		t.Errorf("cancelled or refunded in the window = %v, want %v", got, want)
+	}
+
+	// An update replaces the index entries of the old history
+	order, err := store.GetOrder(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.History[1].Timestamp = day.Add(30 * time.Hour)
+	if _, err := store.UpdateOrder(ctx, 1, order); err != nil {
+		t.Fatalf("UpdateOrder error = %v", err)
+	}
+	if got := changed(t, store, models.OrderPaid); len(got) != 0 {
+		t.Errorf("paid in the window after the update = %v, want none", got)
+	}
+
+	// A store written before the status index existed builds it on first use
+	var batch kvstore.Batch
+	if err := deletePrefix(db, &batch, orderStatusPrefix); err != nil {
+		t.Fatal(err)
+	}
+	batch.Delete(orderStatusIndexedKey)
+	if err := db.Write(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatalf("kvstore.Open error = %v", err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	if got, want := changed(t, NewDiskOrderStore(reopened), models.OrderCancelled, models.OrderRefunded), []int{3, 4}; !slices.Equal(got, want) {
+		t.Errorf("cancelled or refunded after rebuilding the index = %v, want %v", got, want)
+	}
+	if _, err := reopened.Get(orderStatusIndexedKey); err != nil {
+		t.Errorf("index marker after rebuilding = %v, want it set", err)
+	}
+}
@@ -0,0 +1,165 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/kvstore"
+	"strconv"
+)
+
+// Key layout of the disk backend. Records live under "<entity>/<id>" with the
+// ID zero-padded so keys sort numerically, and the next ID of every entity
+// under "seq/<entity>".
+const (
+	sequencePrefix = "seq/"
+	idDigits       = 20
+)
+
+// diskTable stores the records of one entity in a key-value database.
+// It does no locking; stores serialize their read-modify-write sequences.
+type diskTable[T any] struct {
+	db     *kvstore.DB
+	entity string
+}
+
+// newDiskTable creates a table for entity in db
+func newDiskTable[T any](db *kvstore.DB, entity string) diskTable[T] {
+	return diskTable[T]{db: db, entity: entity}
+}
+
+// prefix returns the key prefix of the table's records
+func (t diskTable[T]) prefix() string {
+	return t.entity + "/"
+}
+
+// key returns the key of a record
+func (t diskTable[T]) key(id int) string {
+	return t.prefix() + formatID(id)
+}
+
+// get reads a record, reporting whether it exists
+func (t diskTable[T]) get(id int) (T, bool, error) {
+	var record T
+	data, err := t.db.Get(t.key(id))
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, false, fmt.Errorf("invalid %s record %d: %w", t.entity, id, err)
+	}
+	return record, true, nil
+}
+
+// put adds a record write to batch
+func (t diskTable[T]) put(batch *kvstore.Batch, id int, record T) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %d: %w", t.entity, id, err)
+	}
+	batch.Put(t.key(id), data)
+	return nil
+}
+
+// nextID returns the ID the next created record receives
+func (t diskTable[T]) nextID() (int, error) {
+	data, err := t.db.Get(sequencePrefix + t.entity)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s sequence: %w", t.entity, err)
+	}
+	return id, nil
+}
+
+// setNextID adds a sequence update to batch
+func (t diskTable[T]) setNextID(batch *kvstore.Batch, id int) {
+	batch.Put(sequencePrefix+t.entity, []byte(strconv.Itoa(id)))
+}
+
+// forEach calls fn for every record in ID order. Records are decoded while
+// the database is being scanned, so fn must not write to it.
+func (t diskTable[T]) forEach(ctx context.Context, fn func(id int, record T) error) error {
+	return t.db.ScanPrefix(t.prefix(), func(key string, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(key[len(t.prefix()):])
+		if err != nil {
+			return fmt.Errorf("invalid %s key %q", t.entity, key)
+		}
+		var record T
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("invalid %s record %d: %w", t.entity, id, err)
+		}
+		return fn(id, record)
+	})
+}
+
+// all returns every record in ID order
+func (t diskTable[T]) all(ctx context.Context) ([]T, error) {
+	records := []T{}
+	err := t.forEach(ctx, func(_ int, record T) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// snapshot captures the records and sequence of the table
+func (t diskTable[T]) snapshot() (interfaces.Snapshot, error) {
+	records := make(map[int]T)
+	if err := t.forEach(context.Background(), func(id int, record T) error {
+		records[id] = record
+		return nil
+	}); err != nil {
+		return interfaces.Snapshot{}, err
+	}
+	nextID, err := t.nextID()
+	if err != nil {
+		return interfaces.Snapshot{}, err
+	}
+	return snapshotRecords(records, nextID)
+}
+
+// restore adds writes replacing the table contents with a snapshot to
+// batch and returns the restored records
+func (t diskTable[T]) restore(batch *kvstore.Batch, snapshot interfaces.Snapshot) (map[int]T, error) {
+	records, nextID, err := restoreRecords[T](t.entity, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := deletePrefix(t.db, batch, t.prefix()); err != nil {
+		return nil, err
+	}
+	for id, record := range records {
+		if err := t.put(batch, id, record); err != nil {
+			return nil, err
+		}
+	}
+	t.setNextID(batch, nextID)
+	return records, nil
+}
+
+// deletePrefix adds deletes for every key starting with prefix to batch
+func deletePrefix(db *kvstore.DB, batch *kvstore.Batch, prefix string) error {
+	return db.ScanPrefix(prefix, func(key string, _ []byte) error {
+		batch.Delete(key)
+		return nil
+	})
+}
+
+// formatID pads an ID so that keys sort in numeric order
+func formatID(id int) string {
+	return fmt.Sprintf("%0*d", idDigits, id)
+}
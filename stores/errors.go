@@ -0,0 +1,98 @@
+package stores
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Store errors. Stores wrap them in an EntityError naming the record, so
+// callers can test the kind of failure with errors.Is and find the record
+// with errors.As.
+var (
+	// ErrNotFound reports that a record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict reports that a change clashes with the current state,
+	// e.g. deleting a record that others still reference
+	ErrConflict = errors.New("conflict")
+	// ErrValidation reports a record that cannot be stored as given
+	ErrValidation = errors.New("validation failed")
+	// ErrInsufficientStock reports a book without enough copies in stock
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// ErrInvalidTransition reports an order status change the lifecycle does
+// not allow. It matches ErrConflict.
+var ErrInvalidTransition = fmt.Errorf("%w: invalid status transition", ErrConflict)
+
+// EntityError is a store error about a single record
+type EntityError struct {
+	// Entity names the kind of record, e.g. "book"
+	Entity string
+	// ID is the ID of the record, or 0 for a record not yet created
+	ID int
+	// Err is one of the store errors above
+	Err error
+	// Reason optionally explains the failure
+	Reason string
+}
+
+// Error describes the failure, e.g. "book with ID 3 not found"
+func (e *EntityError) Error() string {
+	subject := e.Entity
+	if e.ID != 0 {
+		subject = fmt.Sprintf("%s with ID %d", e.Entity, e.ID)
+	}
+	if e.Err == ErrNotFound && e.Reason == "" {
+		return subject + " not found"
+	}
+	message := fmt.Sprintf("%s: %v", subject, e.Err)
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+	return message
+}
+
+// Unwrap returns the store error
+func (e *EntityError) Unwrap() error {
+	return e.Err
+}
+
+// notFoundError reports that a record does not exist
+func notFoundError(entity string, id int) error {
+	return &EntityError{Entity: entity, ID: id, Err: ErrNotFound}
+}
+
+// entityError reports a failure about a record with a formatted reason
+func entityError(entity string, id int, err error, format string, args ...interface{}) error {
+	return &EntityError{Entity: entity, ID: id, Err: err, Reason: fmt.Sprintf(format, args...)}
+}
+
+// StockShortage is a book without enough copies for a stock change
+type StockShortage struct {
+	BookID    int
+	Requested int
+	Available int
+}
+
+// StockError lists every book a stock change would take below zero. It
+// matches ErrInsufficientStock with errors.Is.
+type StockError struct {
+	Shortages []StockShortage
+}
+
+// Error describes the shortages, e.g. "insufficient stock: book with ID 3
+// has 1 of 2 copies requested"
+func (e *StockError) Error() string {
+	details := make([]string, len(e.Shortages))
+	for i, shortage := range e.Shortages {
+		details[i] = fmt.Sprintf("book with ID %d has %d of %d copies requested",
+			shortage.BookID, shortage.Available, shortage.Requested)
+	}
+	return fmt.Sprintf("%v: %s", ErrInsufficientStock, strings.Join(details, "; "))
+}
+
+// Unwrap returns ErrInsufficientStock
+func (e *StockError) Unwrap() error {
+	return ErrInsufficientStock
+}
@@ -0,0 +1,233 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WriteGate lets a caller pause writes to a set of stores so that it can
+// capture or replace all of them at a single point in time. Stores are
+// wrapped with the Gate* decorators: every create, update and delete holds
+// the gate shared, so writes to different stores still run concurrently,
+// while Exclusive waits for writes in flight and holds off new ones.
+type WriteGate struct {
+	mu sync.RWMutex
+}
+
+// NewWriteGate creates a gate
+func NewWriteGate() *WriteGate {
+	return &WriteGate{}
+}
+
+// Exclusive runs fn while no gated write is in progress
+func (g *WriteGate) Exclusive(fn func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return fn()
+}
+
+// heldGateKey marks a context whose writes already hold a gate
+type heldGateKey struct{}
+
+// Hold runs fn while holding the gate shared. Gated writes made with the
+// context passed to fn join the hold instead of entering the gate again, so
+// Exclusive cannot run between them. A nil gate just runs fn.
+func (g *WriteGate) Hold(ctx context.Context, fn func(ctx context.Context) error) error {
+	if g == nil || ctx.Value(heldGateKey{}) == g {
+		return fn(ctx)
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return fn(context.WithValue(ctx, heldGateKey{}, g))
+}
+
+// enter brackets a gated write, returning the function that leaves the gate.
+// Writes within a Hold of the gate do not enter it again, as a second shared
+// lock would deadlock against a waiting Exclusive.
+func (g *WriteGate) enter(ctx context.Context) (leave func()) {
+	if ctx.Value(heldGateKey{}) == g {
+		return func() {}
+	}
+	g.mu.RLock()
+	return g.mu.RUnlock
+}
+
+// gatedBookStore passes writes through a WriteGate
+type gatedBookStore struct {
+	interfaces.BookStore
+	gate *WriteGate
+}
+
+// GateBookStore wraps a book store so its writes pass through gate
+func GateBookStore(store interfaces.BookStore, gate *WriteGate) interfaces.BookStore {
+	return gatedBookStore{BookStore: store, gate: gate}
+}
+
+func (s gatedBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	defer s.gate.enter(ctx)()
+	return s.BookStore.CreateBook(ctx, book)
+}
+
+func (s gatedBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	defer s.gate.enter(ctx)()
+	return s.BookStore.UpdateBook(ctx, id, book)
+}
+
+func (s gatedBookStore) DeleteBook(ctx context.Context, id int) error {
+	defer s.gate.enter(ctx)()
+	return s.BookStore.DeleteBook(ctx, id)
+}
+
+func (s gatedBookStore) AdjustStock(ctx context.Context, changes map[int]int) error {
+	defer s.gate.enter(ctx)()
+	return s.BookStore.AdjustStock(ctx, changes)
+}
+
+// gatedAuthorStore passes writes through a WriteGate
+type gatedAuthorStore struct {
+	interfaces.AuthorStore
+	gate *WriteGate
+}
+
+// GateAuthorStore wraps an author store so its writes pass through gate
+func GateAuthorStore(store interfaces.AuthorStore, gate *WriteGate) interfaces.AuthorStore {
+	return gatedAuthorStore{AuthorStore: store, gate: gate}
+}
+
+func (s gatedAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
+	defer s.gate.enter(ctx)()
+	return s.AuthorStore.CreateAuthor(ctx, author)
+}
+
+func (s gatedAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
+	defer s.gate.enter(ctx)()
+	return s.AuthorStore.UpdateAuthor(ctx, id, author)
+}
+
+func (s gatedAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	defer s.gate.enter(ctx)()
+	return s.AuthorStore.DeleteAuthor(ctx, id)
+}
+
+// gatedCustomerStore passes writes through a WriteGate
+type gatedCustomerStore struct {
+	interfaces.CustomerStore
+	gate *WriteGate
+}
+
+// GateCustomerStore wraps a customer store so its writes pass through gate
+func GateCustomerStore(store interfaces.CustomerStore, gate *WriteGate) interfaces.CustomerStore {
+	return gatedCustomerStore{CustomerStore: store, gate: gate}
+}
+
+func (s gatedCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	defer s.gate.enter(ctx)()
+	return s.CustomerStore.CreateCustomer(ctx, customer)
+}
+
+func (s gatedCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
+	defer s.gate.enter(ctx)()
+	return s.CustomerStore.UpdateCustomer(ctx, id, customer)
+}
+
+func (s gatedCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	defer s.gate.enter(ctx)()
+	return s.CustomerStore.DeleteCustomer(ctx, id)
+}
+
+// gatedOrderStore passes writes through a WriteGate
+type gatedOrderStore struct {
+	interfaces.OrderStore
+	gate *WriteGate
+}
+
+// GateOrderStore wraps an order store so its writes pass through gate. The
+// wrapper streams orders whether or not the wrapped store does.
+func GateOrderStore(store interfaces.OrderStore, gate *WriteGate) interfaces.OrderStore {
+	return gatedOrderStore{OrderStore: store, gate: gate}
+}
+
+func (s gatedOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	defer s.gate.enter(ctx)()
+	return s.OrderStore.CreateOrder(ctx, order)
+}
+
+func (s gatedOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	defer s.gate.enter(ctx)()
+	return s.OrderStore.UpdateOrder(ctx, id, order)
+}
+
+func (s gatedOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	defer s.gate.enter(ctx)()
+	return s.OrderStore.DeleteOrder(ctx, id)
+}
+
+func (s gatedOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return forEachOrder(ctx, s.OrderStore, start, end, fn)
+}
+
+// forEachOrder streams the orders in a time range from store, loading them
+// all at once when the store cannot stream. Decorators use it to keep
+// wrapped stores streaming.
+func forEachOrder(ctx context.Context, store interfaces.OrderStore, start, end time.Time, fn func(models.Order) error) error {
+	if iterator, ok := store.(interfaces.OrderIterator); ok {
+		return iterator.ForEachOrderInTimeRange(ctx, start, end, fn)
+	}
+	orders, err := store.GetOrdersInTimeRange(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s gatedOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	return ordersChangedInTimeRange(ctx, s.OrderStore, statuses, start, end)
+}
+
+// ordersChangedInTimeRange returns the orders of store whose status changed
+// to one of statuses within [start, end), reading every order when the store
+// has no status index. Decorators use it to keep wrapped stores indexed.
+func ordersChangedInTimeRange(ctx context.Context, store interfaces.OrderStore, statuses []string, start, end time.Time) ([]models.Order, error) {
+	if index, ok := store.(interfaces.OrderStatusIndex); ok {
+		return index.GetOrdersChangedInTimeRange(ctx, statuses, start, end)
+	}
+	orders, err := store.GetAllOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []models.Order
+	for _, order := range orders {
+		if statusChangedInTimeRange(order, statuses, start, end) {
+			results = append(results, order)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+// statusChangedInTimeRange reports whether the history of order records a
+// change to one of statuses within [start, end)
+func statusChangedInTimeRange(order models.Order, statuses []string, start, end time.Time) bool {
+	for _, change := range order.History {
+		if slices.Contains(statuses, change.NewStatus) && !change.Timestamp.Before(start) && change.Timestamp.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderIterator    = gatedOrderStore{}
+	_ interfaces.OrderStatusIndex = gatedOrderStore{}
+)
@@ -0,0 +1,357 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeleteAction decides what happens to the records that reference a record
+// being deleted
+type DeleteAction string
+
+// Delete actions
+const (
+	// DeleteRestrict rejects the delete with ErrConflict while references exist
+	DeleteRestrict DeleteAction = "restrict"
+	// DeleteCascade deletes the referencing records first
+	DeleteCascade DeleteAction = "cascade"
+)
+
+// ParseDeleteAction parses restrict or cascade
+func ParseDeleteAction(value string) (DeleteAction, error) {
+	switch action := DeleteAction(value); action {
+	case DeleteRestrict, DeleteCascade:
+		return action, nil
+	default:
+		return "", fmt.Errorf("expected %q or %q, got %q", DeleteRestrict, DeleteCascade, value)
+	}
+}
+
+// IntegrityPolicy holds the delete action of each relation between stores
+type IntegrityPolicy struct {
+	// AuthorBooks applies to the books of a deleted author
+	AuthorBooks DeleteAction
+	// CustomerOrders applies to the orders of a deleted customer
+	CustomerOrders DeleteAction
+	// BookOrders applies to the orders containing a deleted book, including
+	// books deleted by a cascade from their author
+	BookOrders DeleteAction
+}
+
+// DefaultIntegrityPolicy restricts every delete
+var DefaultIntegrityPolicy = IntegrityPolicy{
+	AuthorBooks:    DeleteRestrict,
+	CustomerOrders: DeleteRestrict,
+	BookOrders:     DeleteRestrict,
+}
+
+// Integrity enforces the references between stores: books must name an
+// existing author, orders an existing customer and existing books, and
+// deleting a referenced record is rejected or cascaded as the policy says.
+// The stores handed to handlers must be the ones returned by its methods.
+//
+// Writes that add references hold the integrity lock shared and deletes of
+// referenced records hold it exclusively, so a reference is never checked
+// against a record that is being deleted. Cascades delete referencing
+// records before the records they reference; one that fails part way
+// leaves fewer records, but no dangling references.
+type Integrity struct {
+	mu     sync.RWMutex
+	policy IntegrityPolicy
+
+	books     interfaces.BookStore
+	authors   interfaces.AuthorStore
+	customers interfaces.CustomerStore
+	orders    interfaces.OrderStore
+}
+
+// NewIntegrity enforces policy across the given stores
+func NewIntegrity(
+	policy IntegrityPolicy,
+	bookStore interfaces.BookStore,
+	authorStore interfaces.AuthorStore,
+	customerStore interfaces.CustomerStore,
+	orderStore interfaces.OrderStore,
+) *Integrity {
+	return &Integrity{
+		policy:    policy,
+		books:     bookStore,
+		authors:   authorStore,
+		customers: customerStore,
+		orders:    orderStore,
+	}
+}
+
+// BookStore returns the book store with references enforced
+func (i *Integrity) BookStore() interfaces.BookStore {
+	return integrityBookStore{BookStore: i.books, integrity: i}
+}
+
+// AuthorStore returns the author store with references enforced
+func (i *Integrity) AuthorStore() interfaces.AuthorStore {
+	return integrityAuthorStore{AuthorStore: i.authors, integrity: i}
+}
+
+// CustomerStore returns the customer store with references enforced
+func (i *Integrity) CustomerStore() interfaces.CustomerStore {
+	return integrityCustomerStore{CustomerStore: i.customers, integrity: i}
+}
+
+// OrderStore returns the order store with references enforced. It streams
+// orders whether or not the wrapped store does.
+func (i *Integrity) OrderStore() interfaces.OrderStore {
+	return integrityOrderStore{OrderStore: i.orders, integrity: i}
+}
+
+// checkBook checks that the author of a book exists
+func (i *Integrity) checkBook(ctx context.Context, id int, book models.Book) error {
+	if book.Author.ID == 0 {
+		return entityError(EntityBook, id, ErrValidation, "author is required")
+	}
+	return i.checkReference(EntityBook, id, EntityAuthor, book.Author.ID, func() error {
+		_, err := i.authors.GetAuthor(ctx, book.Author.ID)
+		return err
+	})
+}
+
+// checkOrder checks that the customer and every book of an order exist
+func (i *Integrity) checkOrder(ctx context.Context, id int, order models.Order) error {
+	err := i.checkReference(EntityOrder, id, EntityCustomer, order.Customer.ID, func() error {
+		_, err := i.customers.GetCustomer(ctx, order.Customer.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range order.Items {
+		err := i.checkReference(EntityOrder, id, EntityBook, item.Book.ID, func() error {
+			_, err := i.books.GetBook(ctx, item.Book.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReference runs lookup for the record an entity refers to, reporting
+// a missing record as a validation error of the referring entity
+func (i *Integrity) checkReference(entity string, id int, refEntity string, refID int, lookup func() error) error {
+	err := lookup()
+	if errors.Is(err, ErrNotFound) {
+		return entityError(entity, id, ErrValidation, "%s with ID %d does not exist", refEntity, refID)
+	}
+	return err
+}
+
+// ordersMatching returns the IDs of the orders for which match is true
+func (i *Integrity) ordersMatching(ctx context.Context, match func(models.Order) bool) ([]int, error) {
+	orders, err := i.orders.GetAllOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, order := range orders {
+		if match(order) {
+			ids = append(ids, order.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// deleteOrders deletes orders removed by a cascade
+func (i *Integrity) deleteOrders(ctx context.Context, ids []int) error {
+	for _, id := range ids {
+		if err := i.orders.DeleteOrder(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBooks deletes books, applying the book policy to the orders that
+// contain them. The delete of entity id caused it; conflicts are reported
+// against that record, with what leading the reason.
+func (i *Integrity) deleteBooks(ctx context.Context, entity string, id int, what string, bookIDs []int) error {
+	deleted := make(map[int]bool, len(bookIDs))
+	for _, bookID := range bookIDs {
+		deleted[bookID] = true
+	}
+	orderIDs, err := i.ordersMatching(ctx, func(order models.Order) bool {
+		for _, item := range order.Items {
+			if deleted[item.Book.ID] {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(orderIDs) > 0 {
+		if i.policy.BookOrders != DeleteCascade {
+			return entityError(entity, id, ErrConflict, "%s %d orders", what, len(orderIDs))
+		}
+		if err := i.deleteOrders(ctx, orderIDs); err != nil {
+			return err
+		}
+	}
+	for _, bookID := range bookIDs {
+		if err := i.books.DeleteBook(ctx, bookID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// integrityBookStore checks the author of books and applies the policy to
+// orders of deleted books
+type integrityBookStore struct {
+	interfaces.BookStore
+	integrity *Integrity
+}
+
+func (s integrityBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	s.integrity.mu.RLock()
+	defer s.integrity.mu.RUnlock()
+	if err := s.integrity.checkBook(ctx, 0, book); err != nil {
+		return models.Book{}, err
+	}
+	return s.BookStore.CreateBook(ctx, book)
+}
+
+func (s integrityBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	s.integrity.mu.RLock()
+	defer s.integrity.mu.RUnlock()
+	if _, err := s.BookStore.GetBook(ctx, id); err != nil {
+		return models.Book{}, err
+	}
+	if err := s.integrity.checkBook(ctx, id, book); err != nil {
+		return models.Book{}, err
+	}
+	return s.BookStore.UpdateBook(ctx, id, book)
+}
+
+func (s integrityBookStore) DeleteBook(ctx context.Context, id int) error {
+	s.integrity.mu.Lock()
+	defer s.integrity.mu.Unlock()
+	if _, err := s.BookStore.GetBook(ctx, id); err != nil {
+		return err
+	}
+	return s.integrity.deleteBooks(ctx, EntityBook, id, "referenced by", []int{id})
+}
+
+// integrityAuthorStore applies the policy to books of deleted authors
+type integrityAuthorStore struct {
+	interfaces.AuthorStore
+	integrity *Integrity
+}
+
+func (s integrityAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	i := s.integrity
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, err := s.AuthorStore.GetAuthor(ctx, id); err != nil {
+		return err
+	}
+
+	books, err := i.books.SearchBooks(ctx, models.SearchCriteria{AuthorID: id})
+	if err != nil {
+		return err
+	}
+	if len(books) > 0 {
+		if i.policy.AuthorBooks != DeleteCascade {
+			return entityError(EntityAuthor, id, ErrConflict, "referenced by %d books", len(books))
+		}
+		bookIDs := make([]int, 0, len(books))
+		for _, book := range books {
+			bookIDs = append(bookIDs, book.ID)
+		}
+		sort.Ints(bookIDs)
+		if err := i.deleteBooks(ctx, EntityAuthor, id, "its books are referenced by", bookIDs); err != nil {
+			return err
+		}
+	}
+	return s.AuthorStore.DeleteAuthor(ctx, id)
+}
+
+// integrityCustomerStore applies the policy to orders of deleted customers
+type integrityCustomerStore struct {
+	interfaces.CustomerStore
+	integrity *Integrity
+}
+
+func (s integrityCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	i := s.integrity
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, err := s.CustomerStore.GetCustomer(ctx, id); err != nil {
+		return err
+	}
+
+	orderIDs, err := i.ordersMatching(ctx, func(order models.Order) bool {
+		return order.Customer.ID == id
+	})
+	if err != nil {
+		return err
+	}
+	if len(orderIDs) > 0 {
+		if i.policy.CustomerOrders != DeleteCascade {
+			return entityError(EntityCustomer, id, ErrConflict, "referenced by %d orders", len(orderIDs))
+		}
+		if err := i.deleteOrders(ctx, orderIDs); err != nil {
+			return err
+		}
+	}
+	return s.CustomerStore.DeleteCustomer(ctx, id)
+}
+
+// integrityOrderStore checks the customer and books of orders
+type integrityOrderStore struct {
+	interfaces.OrderStore
+	integrity *Integrity
+}
+
+func (s integrityOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	s.integrity.mu.RLock()
+	defer s.integrity.mu.RUnlock()
+	if err := s.integrity.checkOrder(ctx, 0, order); err != nil {
+		return models.Order{}, err
+	}
+	return s.OrderStore.CreateOrder(ctx, order)
+}
+
+func (s integrityOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	s.integrity.mu.RLock()
+	defer s.integrity.mu.RUnlock()
+	if _, err := s.OrderStore.GetOrder(ctx, id); err != nil {
+		return models.Order{}, err
+	}
+	if err := s.integrity.checkOrder(ctx, id, order); err != nil {
+		return models.Order{}, err
+	}
+	return s.OrderStore.UpdateOrder(ctx, id, order)
+}
+
+func (s integrityOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return forEachOrder(ctx, s.OrderStore, start, end, fn)
+}
+
+func (s integrityOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	return ordersChangedInTimeRange(ctx, s.OrderStore, statuses, start, end)
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderIterator    = integrityOrderStore{}
+	_ interfaces.OrderStatusIndex = integrityOrderStore{}
+)
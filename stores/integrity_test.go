@@ -0,0 +1,300 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"online-bookstore-api/models"
+	"reflect"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// integrityFixture holds stores with authors 1 and 2, books 1 and 2 by
+// author 1 and book 3 by author 2, customers 1 and 2, and two orders: order 1
+// by customer 1 for book 1 and order 2 by customer 2 for book 3. Book 2 is
+// not ordered.
+type integrityFixture struct {
+	integrity *Integrity
+	books     *InMemoryBookStore
+	authors   *InMemoryAuthorStore
+	customers *InMemoryCustomerStore
+	orders    *InMemoryOrderStore
+}
+
+func newIntegrityFixture(t *testing.T, policy IntegrityPolicy) integrityFixture {
+	t.Helper()
+	ctx := context.Background()
+	f := integrityFixture{
+		books:     NewInMemoryBookStore(),
+		authors:   NewInMemoryAuthorStore(),
+		customers: NewInMemoryCustomerStore(),
+		orders:    NewInMemoryOrderStore(),
+	}
+	f.integrity = NewIntegrity(policy, f.books, f.authors, f.customers, f.orders)
+	for _, name := range []string{"Lovelace", "Babbage"} {
+		if _, err := f.authors.CreateAuthor(ctx, models.Author{LastName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, authorID := range []int{1, 1, 2} {
+		if _, err := f.books.CreateBook(ctx, models.Book{Title: "Book", Author: models.Author{ID: authorID}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"Reader", "Other"} {
+		if _, err := f.customers.CreateCustomer(ctx, models.Customer{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ref := range [][2]int{{1, 1}, {2, 3}} {
+		order := models.Order{Customer: models.Customer{ID: ref[0]}, Items: []models.OrderItem{{Book: models.Book{ID: ref[1]}, Quantity: 1}}}
+		if _, err := f.orders.CreateOrder(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// remaining returns the IDs of the records left in each store
+func (f integrityFixture) remaining(t *testing.T) map[string][]int {
+	t.Helper()
+	ctx := context.Background()
+	ids := make(map[string][]int)
+	authors, _ := f.authors.GetAllAuthors(ctx)
+	for _, author := range authors {
+		ids[EntityAuthor] = append(ids[EntityAuthor], author.ID)
+	}
+	books, _ := f.books.GetAllBooks(ctx)
+	for _, book := range books {
+		ids[EntityBook] = append(ids[EntityBook], book.ID)
+	}
+	customers, _ := f.customers.GetAllCustomers(ctx)
+	for _, customer := range customers {
+		ids[EntityCustomer] = append(ids[EntityCustomer], customer.ID)
+	}
+	orders, _ := f.orders.GetAllOrders(ctx)
+	for _, order := range orders {
+		ids[EntityOrder] = append(ids[EntityOrder], order.ID)
+	}
+	for _, list := range ids {
+		sort.Ints(list)
+	}
+	return ids
+}
+
+func TestIntegrityDeletePolicies(t *testing.T) {
+	all := map[string][]int{EntityAuthor: {1, 2}, EntityBook: {1, 2, 3}, EntityCustomer: {1, 2}, EntityOrder: {1, 2}}
+	cascadeAll := IntegrityPolicy{AuthorBooks: DeleteCascade, CustomerOrders: DeleteCascade, BookOrders: DeleteCascade}
+	tests := []struct {
+		name    string
+		policy  IntegrityPolicy
+		delete  func(ctx context.Context, i *Integrity) error
+		wantErr error
+		want    map[string][]int
+	}{
+		{
+			name:    "referenced book, restrict",
+			policy:  DefaultIntegrityPolicy,
+			delete:  func(ctx context.Context, i *Integrity) error { return i.BookStore().DeleteBook(ctx, 1) },
+			wantErr: ErrConflict,
+			want:    all,
+		},
+		{
+			name:   "referenced book, cascade",
+			policy: IntegrityPolicy{BookOrders: DeleteCascade},
+			delete: func(ctx context.Context, i *Integrity) error { return i.BookStore().DeleteBook(ctx, 1) },
+			want:   map[string][]int{EntityAuthor: {1, 2}, EntityBook: {2, 3}, EntityCustomer: {1, 2}, EntityOrder: {2}},
+		},
+		{
+			name:   "unreferenced book",
+			policy: DefaultIntegrityPolicy,
+			delete: func(ctx context.Context, i *Integrity) error { return i.BookStore().DeleteBook(ctx, 2) },
+			want:   map[string][]int{EntityAuthor: {1, 2}, EntityBook: {1, 3}, EntityCustomer: {1, 2}, EntityOrder: {1, 2}},
+		},
+		{
+			name:    "missing book",
+			policy:  DefaultIntegrityPolicy,
+			delete:  func(ctx context.Context, i *Integrity) error { return i.BookStore().DeleteBook(ctx, 9) },
+			wantErr: ErrNotFound,
+			want:    all,
+		},
+		{
+			name:    "author with books, restrict",
+			policy:  DefaultIntegrityPolicy,
+			delete:  func(ctx context.Context, i *Integrity) error { return i.AuthorStore().DeleteAuthor(ctx, 1) },
+			wantErr: ErrConflict,
+			want:    all,
+		},
+		{
+			// The author's books may go, but one of them is ordered
+			name:    "author with ordered books, cascade to books only",
+			policy:  IntegrityPolicy{AuthorBooks: DeleteCascade},
+			delete:  func(ctx context.Context, i *Integrity) error { return i.AuthorStore().DeleteAuthor(ctx, 1) },
+			wantErr: ErrConflict,
+			want:    all,
+		},
+		{
+			name:   "author with ordered books, cascade",
+			policy: cascadeAll,
+			delete: func(ctx context.Context, i *Integrity) error { return i.AuthorStore().DeleteAuthor(ctx, 1) },
+			want:   map[string][]int{EntityAuthor: {2}, EntityBook: {3}, EntityCustomer: {1, 2}, EntityOrder: {2}},
+		},
+		{
+			name:    "customer with orders, restrict",
+			policy:  DefaultIntegrityPolicy,
+			delete:  func(ctx context.Context, i *Integrity) error { return i.CustomerStore().DeleteCustomer(ctx, 2) },
+			wantErr: ErrConflict,
+			want:    all,
+		},
+		{
+			name:   "customer with orders, cascade",
+			policy: IntegrityPolicy{CustomerOrders: DeleteCascade},
+			delete: func(ctx context.Context, i *Integrity) error { return i.CustomerStore().DeleteCustomer(ctx, 2) },
+			want:   map[string][]int{EntityAuthor: {1, 2}, EntityBook: {1, 2, 3}, EntityCustomer: {1}, EntityOrder: {1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIntegrityFixture(t, tt.policy)
+			err := tt.delete(context.Background(), f.integrity)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("delete error = %v, want %v", err, tt.wantErr)
+			}
+			if got := f.remaining(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remaining records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrityReferences(t *testing.T) {
+	orderFor := func(customerID, bookID int) models.Order {
+		return models.Order{Customer: models.Customer{ID: customerID}, Items: []models.OrderItem{{Book: models.Book{ID: bookID}, Quantity: 1}}}
+	}
+	tests := []struct {
+		name    string
+		write   func(ctx context.Context, i *Integrity) error
+		wantErr error
+	}{
+		{
+			name: "book",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.BookStore().CreateBook(ctx, models.Book{Title: "New", Author: models.Author{ID: 2}})
+				return err
+			},
+		},
+		{
+			name: "book without an author",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.BookStore().CreateBook(ctx, models.Book{Title: "New"})
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "book by a missing author",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.BookStore().CreateBook(ctx, models.Book{Title: "New", Author: models.Author{ID: 9}})
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "book moved to a missing author",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.BookStore().UpdateBook(ctx, 1, models.Book{Title: "Moved", Author: models.Author{ID: 9}})
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "missing book updated",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.BookStore().UpdateBook(ctx, 9, models.Book{Title: "Missing", Author: models.Author{ID: 1}})
+				return err
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "order",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.OrderStore().CreateOrder(ctx, orderFor(1, 2))
+				return err
+			},
+		},
+		{
+			name: "order by a missing customer",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.OrderStore().CreateOrder(ctx, orderFor(9, 2))
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "order for a missing book",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.OrderStore().CreateOrder(ctx, orderFor(1, 9))
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "order updated with a missing book",
+			write: func(ctx context.Context, i *Integrity) error {
+				_, err := i.OrderStore().UpdateOrder(ctx, 1, orderFor(1, 9))
+				return err
+			},
+			wantErr: ErrValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIntegrityFixture(t, DefaultIntegrityPolicy)
+			if err := tt.write(context.Background(), f.integrity); !errors.Is(err, tt.wantErr) {
+				t.Errorf("write error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDeleteAction(t *testing.T) {
+	for _, value := range []string{"restrict", "cascade"} {
+		if action, err := ParseDeleteAction(value); err != nil || string(action) != value {
+			t.Errorf("ParseDeleteAction(%q) = %q, %v; want %q", value, action, err, value)
+		}
+	}
+	for _, value := range []string{"", "Cascade", "set null"} {
+		if _, err := ParseDeleteAction(value); err == nil {
+			t.Errorf("ParseDeleteAction(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	ctx := context.Background()
+	f := newIntegrityFixture(t, DefaultIntegrityPolicy)
+	// Deletes that bypass the integrity stores leave dangling references
+	if err := f.authors.DeleteAuthor(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.customers.DeleteCustomer(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.books.DeleteBook(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dangling, err := CheckIntegrity(ctx, f.books, f.authors, f.customers, f.orders)
+	if err != nil {
+		t.Fatalf("CheckIntegrity error = %v", err)
+	}
+	want := []DanglingReference{
+		{Entity: EntityBook, ID: 3, Field: "author.id", Target: EntityAuthor, TargetID: 2},
+		{Entity: EntityOrder, ID: 1, Field: "customer.id", Target: EntityCustomer, TargetID: 1},
+		{Entity: EntityOrder, ID: 1, Field: "items[0].book.id", Target: EntityBook, TargetID: 1},
+	}
+	if !slices.Equal(dangling, want) {
+		t.Errorf("CheckIntegrity = %v, want %v", dangling, want)
+	}
+}
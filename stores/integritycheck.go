@@ -0,0 +1,124 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"sort"
+)
+
+// DanglingReference is a reference from one record to a record that does
+// not exist
+type DanglingReference struct {
+	// Entity and ID name the referring record
+	Entity string
+	ID     int
+	// Field is the path of the reference within the record, e.g. "author.id"
+	Field string
+	// Target and TargetID name the missing record
+	Target   string
+	TargetID int
+}
+
+// String describes the reference, e.g. "book 3: author.id refers to missing author 7"
+func (r DanglingReference) String() string {
+	return fmt.Sprintf("%s %d: %s refers to missing %s %d", r.Entity, r.ID, r.Field, r.Target, r.TargetID)
+}
+
+// UnknownOrderStatus is an order whose status is not a lifecycle status,
+// e.g. a legacy value the database migration could not map
+type UnknownOrderStatus struct {
+	ID     int
+	Status string
+}
+
+// String describes the order, e.g. `order 3: unknown status "on hold"`
+func (s UnknownOrderStatus) String() string {
+	return fmt.Sprintf("%s %d: unknown status %q", EntityOrder, s.ID, s.Status)
+}
+
+// CheckOrderStatuses reports every order whose status is not one of the
+// lifecycle statuses, by ID. Such orders cannot change status.
+func CheckOrderStatuses(ctx context.Context, orderStore interfaces.OrderStore) ([]UnknownOrderStatus, error) {
+	orders, err := orderStore.GetAllOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(orders, func(a, b int) bool { return orders[a].ID < orders[b].ID })
+	var unknown []UnknownOrderStatus
+	for _, order := range orders {
+		if !IsOrderStatus(order.Status) {
+			unknown = append(unknown, UnknownOrderStatus{ID: order.ID, Status: order.Status})
+		}
+	}
+	return unknown, nil
+}
+
+// CheckIntegrity reports every reference between the stores that points at
+// a missing record: books without their author and orders without their
+// customer or one of their books. References are listed by entity and ID.
+func CheckIntegrity(
+	ctx context.Context,
+	bookStore interfaces.BookStore,
+	authorStore interfaces.AuthorStore,
+	customerStore interfaces.CustomerStore,
+	orderStore interfaces.OrderStore,
+) ([]DanglingReference, error) {
+	authors, err := authorStore.GetAllAuthors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authorIDs := make(map[int]bool, len(authors))
+	for _, author := range authors {
+		authorIDs[author.ID] = true
+	}
+
+	customers, err := customerStore.GetAllCustomers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	customerIDs := make(map[int]bool, len(customers))
+	for _, customer := range customers {
+		customerIDs[customer.ID] = true
+	}
+
+	books, err := bookStore.GetAllBooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(books, func(a, b int) bool { return books[a].ID < books[b].ID })
+	bookIDs := make(map[int]bool, len(books))
+	var dangling []DanglingReference
+	for _, book := range books {
+		bookIDs[book.ID] = true
+		if !authorIDs[book.Author.ID] {
+			dangling = append(dangling, DanglingReference{
+				Entity: EntityBook, ID: book.ID, Field: "author.id",
+				Target: EntityAuthor, TargetID: book.Author.ID,
+			})
+		}
+	}
+
+	orders, err := orderStore.GetAllOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(orders, func(a, b int) bool { return orders[a].ID < orders[b].ID })
+	for _, order := range orders {
+		if !customerIDs[order.Customer.ID] {
+			dangling = append(dangling, DanglingReference{
+				Entity: EntityOrder, ID: order.ID, Field: "customer.id",
+				Target: EntityCustomer, TargetID: order.Customer.ID,
+			})
+		}
+		for i, item := range order.Items {
+			if !bookIDs[item.Book.ID] {
+				dangling = append(dangling, DanglingReference{
+					Entity: EntityOrder, ID: order.ID, Field: fmt.Sprintf("items[%d].book.id", i),
+					Target: EntityBook, TargetID: item.Book.ID,
+				})
+			}
+		}
+	}
+	return dangling, nil
+}
@@ -0,0 +1,187 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stockIDs returns the IDs of the books a stock change touches in
+// ascending order, so stores write them in a stable order
+func stockIDs(changes map[int]int) []int {
+	ids := make([]int, 0, len(changes))
+	for id, change := range changes {
+		if change != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// adjustedStock returns the stock of each book after changes, reading the
+// current stock with lookup. It fails with ErrNotFound for a missing book
+// and with a StockError listing every book that would go below zero.
+func adjustedStock(changes map[int]int, lookup func(id int) (int, bool, error)) (map[int]int, error) {
+	stock := make(map[int]int, len(changes))
+	var shortages []StockShortage
+	for _, id := range stockIDs(changes) {
+		available, exists, err := lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, notFoundError(EntityBook, id)
+		}
+		if available+changes[id] < 0 {
+			shortages = append(shortages, StockShortage{BookID: id, Requested: -changes[id], Available: available})
+			continue
+		}
+		stock[id] = available + changes[id]
+	}
+	if len(shortages) > 0 {
+		return nil, &StockError{Shortages: shortages}
+	}
+	return stock, nil
+}
+
+// checkQuantities rejects orders with an item of zero or fewer copies, which
+// would put books back into stock instead of taking them
+func checkQuantities(id int, order models.Order) error {
+	for i, item := range order.Items {
+		if item.Quantity <= 0 {
+			return entityError(EntityOrder, id, ErrValidation, "items[%d].quantity must be positive, got %d", i, item.Quantity)
+		}
+	}
+	return nil
+}
+
+// orderDemand returns the copies of each book an order takes
+func orderDemand(order models.Order) map[int]int {
+	demand := make(map[int]int, len(order.Items))
+	for _, item := range order.Items {
+		demand[item.Book.ID] += item.Quantity
+	}
+	return demand
+}
+
+// stockOrderStore takes the books of orders out of stock
+type stockOrderStore struct {
+	interfaces.OrderStore
+	books interfaces.BookStore
+
+	// gate is held across the stock change and the order write, so a backup
+	// or snapshot never sees one without the other
+	gate *WriteGate
+
+	// mu serializes updates, so two updates of the same order cannot both
+	// adjust stock against the same stored items
+	mu *sync.Mutex
+}
+
+// ReserveStockOrderStore wraps an order store so that creating an order
+// takes its books out of stock in books, and updating one takes or returns
+// the difference. Items must order at least one copy. The order is rejected
+// with a StockError when a book is short, so orders racing for the last copy
+// cannot both succeed. gate is the WriteGate of both stores; it is held
+// across the stock change and the order write. Deleting an order leaves
+// stock alone. The wrapper streams orders whether or not the
+// wrapped store does.
+func ReserveStockOrderStore(store interfaces.OrderStore, books interfaces.BookStore, gate *WriteGate) interfaces.OrderStore {
+	return stockOrderStore{OrderStore: store, books: books, gate: gate, mu: &sync.Mutex{}}
+}
+
+func (s stockOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	if err := checkQuantities(0, order); err != nil {
+		return models.Order{}, err
+	}
+
+	var created models.Order
+	err := s.gate.Hold(ctx, func(ctx context.Context) error {
+		demand := orderDemand(order)
+		if err := s.books.AdjustStock(ctx, negateStock(demand)); err != nil {
+			return err
+		}
+		var err error
+		if created, err = s.OrderStore.CreateOrder(ctx, order); err != nil {
+			return s.returnStock(ctx, err, demand)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return created, nil
+}
+
+func (s stockOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	if err := checkQuantities(id, order); err != nil {
+		return models.Order{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	// Take what the new items need beyond the old ones
+	changes := orderDemand(existing)
+	for bookID, quantity := range orderDemand(order) {
+		changes[bookID] -= quantity
+	}
+
+	var updated models.Order
+	err = s.gate.Hold(ctx, func(ctx context.Context) error {
+		if err := s.books.AdjustStock(ctx, changes); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = s.OrderStore.UpdateOrder(ctx, id, order); err != nil {
+			return s.returnStock(ctx, err, negateStock(changes))
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return updated, nil
+}
+
+// returnStock undoes a stock change after the order write failed with cause.
+// The stock is returned even if the request was cancelled.
+func (s stockOrderStore) returnStock(ctx context.Context, cause error, changes map[int]int) error {
+	if err := s.books.AdjustStock(context.WithoutCancel(ctx), changes); err != nil {
+		return fmt.Errorf("%w; returning the reserved stock also failed: %w", cause, err)
+	}
+	return cause
+}
+
+func (s stockOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return forEachOrder(ctx, s.OrderStore, start, end, fn)
+}
+
+func (s stockOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	return ordersChangedInTimeRange(ctx, s.OrderStore, statuses, start, end)
+}
+
+// negateStock returns the stock change undoing changes
+func negateStock(changes map[int]int) map[int]int {
+	negated := make(map[int]int, len(changes))
+	for id, change := range changes {
+		negated[id] = -change
+	}
+	return negated
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderIterator    = stockOrderStore{}
+	_ interfaces.OrderStatusIndex = stockOrderStore{}
+)
@@ -0,0 +1,223 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStockStore returns a gated stock store over empty in-memory stores and
+// a book with the given stock
+func newStockStore(t *testing.T, orders interfaces.OrderStore, stock int) (interfaces.OrderStore, *InMemoryBookStore, *WriteGate, models.Book) {
+	t.Helper()
+	books := NewInMemoryBookStore()
+	book, err := books.CreateBook(context.Background(), models.Book{Title: "Notes", Stock: stock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := NewWriteGate()
+	store := ReserveStockOrderStore(GateOrderStore(orders, gate), GateBookStore(books, gate), gate)
+	return store, books, gate, book
+}
+
+func orderFor(book models.Book, quantity int) models.Order {
+	return models.Order{Items: []models.OrderItem{{Book: book, Quantity: quantity}}}
+}
+
+func stockOf(t *testing.T, books *InMemoryBookStore, id int) int {
+	t.Helper()
+	book, err := books.GetBook(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book.Stock
+}
+
+func TestReserveStockLastCopy(t *testing.T) {
+	store, books, _, book := newStockStore(t, NewInMemoryOrderStore(), 1)
+
+	const racers = 8
+	errs := make([]error, racers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, errs[i] = store.CreateOrder(context.Background(), orderFor(book, 1))
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		var stockErr *StockError
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.As(err, &stockErr):
+			t.Errorf("CreateOrder error = %v, want a StockError", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d orders for the last copy succeeded, want 1", succeeded)
+	}
+	if stock := stockOf(t, books, book.ID); stock != 0 {
+		t.Errorf("stock = %d, want 0", stock)
+	}
+	orders, _ := store.GetAllOrders(context.Background())
+	if len(orders) != 1 {
+		t.Errorf("%d orders stored, want 1", len(orders))
+	}
+}
+
+// failingOrderStore fails every order write
+type failingOrderStore struct {
+	interfaces.OrderStore
+}
+
+func (failingOrderStore) CreateOrder(context.Context, models.Order) (models.Order, error) {
+	return models.Order{}, ErrConflict
+}
+
+func (failingOrderStore) UpdateOrder(context.Context, int, models.Order) (models.Order, error) {
+	return models.Order{}, ErrConflict
+}
+
+// brokenBookStore fails stock changes after the first
+type brokenBookStore struct {
+	interfaces.BookStore
+	calls *int
+}
+
+func (s brokenBookStore) AdjustStock(ctx context.Context, changes map[int]int) error {
+	*s.calls++
+	if *s.calls > 1 {
+		return ErrValidation
+	}
+	return s.BookStore.AdjustStock(ctx, changes)
+}
+
+func TestReserveStockReturnsStockOnFailure(t *testing.T) {
+	ctx := context.Background()
+	orders := NewInMemoryOrderStore()
+	store, books, _, book := newStockStore(t, failingOrderStore{orders}, 5)
+
+	if _, err := store.CreateOrder(ctx, orderFor(book, 2)); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateOrder error = %v, want %v", err, ErrConflict)
+	}
+	if stock := stockOf(t, books, book.ID); stock != 5 {
+		t.Errorf("stock after failed create = %d, want 5", stock)
+	}
+
+	created, err := orders.CreateOrder(ctx, orderFor(book, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateOrder(ctx, created.ID, orderFor(book, 3)); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateOrder error = %v, want %v", err, ErrConflict)
+	}
+	if stock := stockOf(t, books, book.ID); stock != 5 {
+		t.Errorf("stock after failed update = %d, want 5", stock)
+	}
+}
+
+func TestReserveStockReportsFailedReturn(t *testing.T) {
+	books := NewInMemoryBookStore()
+	book, err := books.CreateBook(context.Background(), models.Book{Title: "Notes", Stock: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	store := ReserveStockOrderStore(failingOrderStore{NewInMemoryOrderStore()}, brokenBookStore{books, &calls}, nil)
+
+	_, err = store.CreateOrder(context.Background(), orderFor(book, 2))
+	if !errors.Is(err, ErrConflict) || !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "returning the reserved stock also failed") {
+		t.Errorf("CreateOrder error = %v, want the order and the stock return failures", err)
+	}
+}
+
+func TestReserveStockUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		quantity  int
+		wantErr   error
+		wantStock int
+	}{
+		{name: "more copies", quantity: 5, wantStock: 0},
+		{name: "fewer copies", quantity: 1, wantStock: 4},
+		{name: "too many copies", quantity: 6, wantErr: ErrInsufficientStock, wantStock: 3},
+		{name: "no copies", quantity: 0, wantErr: ErrValidation, wantStock: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, books, _, book := newStockStore(t, NewInMemoryOrderStore(), 5)
+			order, err := store.CreateOrder(ctx, orderFor(book, 2))
+			if err != nil {
+				t.Fatalf("CreateOrder error = %v", err)
+			}
+
+			if _, err := store.UpdateOrder(ctx, order.ID, orderFor(book, tt.quantity)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateOrder error = %v, want %v", err, tt.wantErr)
+			}
+			if stock := stockOf(t, books, book.ID); stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", stock, tt.wantStock)
+			}
+		})
+	}
+}
+
+// blockingOrderStore holds order creates until release is closed
+type blockingOrderStore struct {
+	interfaces.OrderStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	close(s.entered)
+	<-s.release
+	return s.OrderStore.CreateOrder(ctx, order)
+}
+
+func TestReserveStockHoldsGate(t *testing.T) {
+	orders := blockingOrderStore{OrderStore: NewInMemoryOrderStore(), entered: make(chan struct{}), release: make(chan struct{})}
+	store, books, gate, book := newStockStore(t, orders, 1)
+
+	created := make(chan error)
+	go func() {
+		_, err := store.CreateOrder(context.Background(), orderFor(book, 1))
+		created <- err
+	}()
+	<-orders.entered
+
+	// The stock is taken but the order is not written yet; a snapshot must
+	// wait for both
+	captured := make(chan int)
+	go gate.Exclusive(func() error {
+		stored, err := books.GetBook(context.Background(), book.ID)
+		captured <- stored.Stock
+		return err
+	})
+	select {
+	case stock := <-captured:
+		t.Fatalf("snapshot ran between the stock change and the order write, stock %d", stock)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(orders.release)
+	if err := <-created; err != nil {
+		t.Fatalf("CreateOrder error = %v", err)
+	}
+	if stock := <-captured; stock != 0 {
+		t.Errorf("snapshot stock = %d, want 0", stock)
+	}
+}
@@ -0,0 +1,159 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// orderTransitions lists the statuses an order may move to from each
+// status. Cancelled and refunded orders are final.
+var orderTransitions = map[string][]string{
+	models.OrderPending:   {models.OrderPaid, models.OrderCancelled},
+	models.OrderPaid:      {models.OrderShipped, models.OrderCancelled},
+	models.OrderShipped:   {models.OrderDelivered, models.OrderRefunded},
+	models.OrderDelivered: {models.OrderRefunded},
+}
+
+// orderStatuses lists every lifecycle status
+var orderStatuses = []string{
+	models.OrderPending, models.OrderPaid, models.OrderShipped,
+	models.OrderDelivered, models.OrderCancelled, models.OrderRefunded,
+}
+
+// IsOrderStatus reports whether status is one of the lifecycle statuses
+func IsOrderStatus(status string) bool {
+	return slices.Contains(orderStatuses, status)
+}
+
+// CanTransitionOrder reports whether an order may move from one status to
+// another
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleOrderStore keeps orders to their lifecycle
+type lifecycleOrderStore struct {
+	interfaces.OrderStore
+	books interfaces.BookStore
+
+	// gate is held across a restock and the status change, so a backup or
+	// snapshot never sees one without the other
+	gate *WriteGate
+
+	// mu serializes status changes and updates, so an order cannot be
+	// edited or moved twice from the same status at once
+	mu *sync.Mutex
+}
+
+// LifecycleOrderStore wraps an order store so that orders start pending,
+// only pending orders can be updated, and statuses change only through
+// TransitionOrder, which records every change in the order's history.
+// Cancelling an order returns its books to stock in books; refunds do not,
+// as the books have left the store. gate is the WriteGate of both stores.
+// The wrapper streams orders whether or not the wrapped store does.
+func LifecycleOrderStore(store interfaces.OrderStore, books interfaces.BookStore, gate *WriteGate) interfaces.OrderStore {
+	return lifecycleOrderStore{OrderStore: store, books: books, gate: gate, mu: &sync.Mutex{}}
+}
+
+func (s lifecycleOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	if order.Status == "" {
+		order.Status = models.OrderPending
+	}
+	if order.Status != models.OrderPending {
+		return models.Order{}, entityError(EntityOrder, 0, ErrValidation, "new orders must be %s, got %q", models.OrderPending, order.Status)
+	}
+	order.History = []models.OrderStatusChange{{
+		Timestamp: order.CreatedAt,
+		NewStatus: order.Status,
+	}}
+	return s.OrderStore.CreateOrder(ctx, order)
+}
+
+func (s lifecycleOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if existing.Status != models.OrderPending {
+		return models.Order{}, entityError(EntityOrder, id, ErrConflict, "only %s orders can be changed, order is %s", models.OrderPending, existing.Status)
+	}
+	if order.Status == "" {
+		order.Status = existing.Status
+	}
+	if order.Status != existing.Status {
+		return models.Order{}, entityError(EntityOrder, id, ErrValidation, "status is changed with the order transition endpoints")
+	}
+	order.History = existing.History
+	return s.OrderStore.UpdateOrder(ctx, id, order)
+}
+
+// TransitionOrder moves an order to change.NewStatus and records the change
+func (s lifecycleOrderStore) TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := change.NewStatus
+
+	order, err := s.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if !CanTransitionOrder(order.Status, status) {
+		return models.Order{}, entityError(EntityOrder, id, ErrInvalidTransition, "cannot move a %s order to %s", order.Status, status)
+	}
+
+	change.Timestamp = time.Now().UTC()
+	change.PreviousStatus = order.Status
+	demand := orderDemand(order)
+	order.Status = status
+	order.History = append(order.History, change)
+
+	var updated models.Order
+	err = s.gate.Hold(ctx, func(ctx context.Context) error {
+		restock := status == models.OrderCancelled
+		if restock {
+			if err := s.books.AdjustStock(ctx, demand); err != nil {
+				return err
+			}
+		}
+		var err error
+		if updated, err = s.OrderStore.UpdateOrder(ctx, id, order); err != nil && restock {
+			// Take the books back out, even if the request was cancelled
+			if undoErr := s.books.AdjustStock(context.WithoutCancel(ctx), negateStock(demand)); undoErr != nil {
+				return fmt.Errorf("%w; taking the restocked books back out also failed: %w", err, undoErr)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return updated, nil
+}
+
+func (s lifecycleOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return forEachOrder(ctx, s.OrderStore, start, end, fn)
+}
+
+func (s lifecycleOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	return ordersChangedInTimeRange(ctx, s.OrderStore, statuses, start, end)
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderIterator     = lifecycleOrderStore{}
+	_ interfaces.OrderStatusIndex  = lifecycleOrderStore{}
+	_ interfaces.OrderTransitioner = lifecycleOrderStore{}
+)
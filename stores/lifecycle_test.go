@@ -0,0 +1,198 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"online-bookstore-api/models"
+	"testing"
+	"time"
+)
+
+func TestCanTransitionOrder(t *testing.T) {
+	statuses := []string{
+		models.OrderPending, models.OrderPaid, models.OrderShipped,
+		models.OrderDelivered, models.OrderCancelled, models.OrderRefunded,
+	}
+	allowed := map[[2]string]bool{
+		{models.OrderPending, models.OrderPaid}:       true,
+		{models.OrderPending, models.OrderCancelled}:  true,
+		{models.OrderPaid, models.OrderShipped}:       true,
+		{models.OrderPaid, models.OrderCancelled}:     true,
+		{models.OrderShipped, models.OrderDelivered}:  true,
+		{models.OrderShipped, models.OrderRefunded}:   true,
+		{models.OrderDelivered, models.OrderRefunded}: true,
+	}
+	for _, from := range append(statuses, "", "unknown") {
+		for _, to := range append(statuses, "", "unknown") {
+			if got := CanTransitionOrder(from, to); got != allowed[[2]string{from, to}] {
+				t.Errorf("CanTransitionOrder(%q, %q) = %v, want %v", from, to, got, !got)
+			}
+		}
+	}
+}
+
+// newLifecycleStore returns a lifecycle store holding a pending order for
+// two copies of book 1, of which five are in stock
+func newLifecycleStore(t *testing.T) (lifecycleOrderStore, *InMemoryBookStore, models.Order) {
+	t.Helper()
+	ctx := context.Background()
+	books := NewInMemoryBookStore()
+	book, err := books.CreateBook(ctx, models.Book{Title: "Notes", Stock: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := LifecycleOrderStore(NewInMemoryOrderStore(), books, nil).(lifecycleOrderStore)
+	order, err := store.CreateOrder(ctx, models.Order{
+		Items:     []models.OrderItem{{Book: book, Quantity: 2}},
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder error = %v", err)
+	}
+	return store, books, order
+}
+
+func TestTransitionOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      []string
+		wantErr   error
+		wantStock int
+	}{
+		{name: "fulfilled", path: []string{models.OrderPaid, models.OrderShipped, models.OrderDelivered}, wantStock: 5},
+		{name: "cancelled while pending", path: []string{models.OrderCancelled}, wantStock: 7},
+		{name: "cancelled after payment", path: []string{models.OrderPaid, models.OrderCancelled}, wantStock: 7},
+		{name: "refunded after delivery", path: []string{models.OrderPaid, models.OrderShipped, models.OrderDelivered, models.OrderRefunded}, wantStock: 5},
+		{name: "refunded in transit", path: []string{models.OrderPaid, models.OrderShipped, models.OrderRefunded}, wantStock: 5},
+		{name: "skipping payment", path: []string{models.OrderShipped}, wantErr: ErrInvalidTransition, wantStock: 5},
+		{name: "cancelled once shipped", path: []string{models.OrderPaid, models.OrderShipped, models.OrderCancelled}, wantErr: ErrInvalidTransition, wantStock: 5},
+		{name: "reopening a cancelled order", path: []string{models.OrderCancelled, models.OrderPending}, wantErr: ErrInvalidTransition, wantStock: 7},
+		{name: "cancelling twice", path: []string{models.OrderCancelled, models.OrderCancelled}, wantErr: ErrInvalidTransition, wantStock: 7},
+		{name: "unknown status", path: []string{"lost"}, wantErr: ErrInvalidTransition, wantStock: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, books, order := newLifecycleStore(t)
+
+			var err error
+			for i, status := range tt.path {
+				_, err = store.TransitionOrder(ctx, order.ID, models.OrderStatusChange{NewStatus: status, Actor: "test"})
+				if err != nil && i < len(tt.path)-1 {
+					t.Fatalf("TransitionOrder(%s) error = %v", status, err)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransitionOrder error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, _ := store.GetOrder(ctx, order.ID)
+			applied := tt.path
+			if tt.wantErr != nil {
+				applied = tt.path[:len(tt.path)-1]
+			}
+			wantStatus := models.OrderPending
+			if len(applied) > 0 {
+				wantStatus = applied[len(applied)-1]
+			}
+			if stored.Status != wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, wantStatus)
+			}
+			if len(stored.History) != len(applied)+1 {
+				t.Fatalf("history has %d entries, want %d", len(stored.History), len(applied)+1)
+			}
+			previous := models.OrderPending
+			for i, status := range applied {
+				change := stored.History[i+1]
+				if change.PreviousStatus != previous || change.NewStatus != status || change.Actor != "test" || change.Timestamp.IsZero() {
+					t.Errorf("history[%d] = %+v, want %s -> %s by test", i+1, change, previous, status)
+				}
+				previous = status
+			}
+
+			book, _ := books.GetBook(ctx, 1)
+			if book.Stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", book.Stock, tt.wantStock)
+			}
+		})
+	}
+}
+
+func TestTransitionOrderNotFound(t *testing.T) {
+	store, _, _ := newLifecycleStore(t)
+	_, err := store.TransitionOrder(context.Background(), 99, models.OrderStatusChange{NewStatus: models.OrderPaid})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("TransitionOrder error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestLifecycleCreateOrder(t *testing.T) {
+	tests := []struct {
+		status  string
+		wantErr error
+	}{
+		{status: ""},
+		{status: models.OrderPending},
+		{status: models.OrderPaid, wantErr: ErrValidation},
+		{status: models.OrderCancelled, wantErr: ErrValidation},
+	}
+	for _, tt := range tests {
+		store := LifecycleOrderStore(NewInMemoryOrderStore(), NewInMemoryBookStore(), nil)
+		createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		order, err := store.CreateOrder(context.Background(), models.Order{Status: tt.status, CreatedAt: createdAt})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("CreateOrder(%q) error = %v, want %v", tt.status, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if order.Status != models.OrderPending || len(order.History) != 1 ||
+			order.History[0].NewStatus != models.OrderPending || !order.History[0].Timestamp.Equal(createdAt) {
+			t.Errorf("CreateOrder(%q) = status %s, history %+v; want a pending order created at %v",
+				tt.status, order.Status, order.History, createdAt)
+		}
+	}
+}
+
+func TestLifecycleUpdateOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []string
+		status  string
+		wantErr error
+	}{
+		{name: "pending order", status: ""},
+		{name: "same status", status: models.OrderPending},
+		{name: "status change", status: models.OrderPaid, wantErr: ErrValidation},
+		{name: "paid order", path: []string{models.OrderPaid}, wantErr: ErrConflict},
+		{name: "cancelled order", path: []string{models.OrderCancelled}, wantErr: ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, _, order := newLifecycleStore(t)
+			for _, status := range tt.path {
+				if _, err := store.TransitionOrder(ctx, order.ID, models.OrderStatusChange{NewStatus: status}); err != nil {
+					t.Fatalf("TransitionOrder(%s) error = %v", status, err)
+				}
+			}
+			before, _ := store.GetOrder(ctx, order.ID)
+
+			update := before
+			update.Status = tt.status
+			update.History = nil
+			updated, err := store.UpdateOrder(ctx, order.ID, update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrder error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// The history is kept from the stored order
+			if len(updated.History) != len(before.History) {
+				t.Errorf("history after update has %d entries, want %d", len(updated.History), len(before.History))
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package stores
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"online-bookstore-api/models"
+	"strings"
+)
+
+// DatabaseVersion is the format version SaveDatabase writes. It must equal
+// the version of the last entry in databaseMigrations.
+const DatabaseVersion = 4
+
+// ErrUnsupportedVersion is returned for database files written by a newer
+// build. Such files are never loaded, so a downgrade cannot overwrite them
+// with data it only partially understands.
+var ErrUnsupportedVersion = errors.New("unsupported database format version")
+
+// databaseMigration upgrades a database document to version from the
+// version before it. Migrations work on the raw document so they can rename
+// or restructure fields before the records are decoded into models.
+type databaseMigration struct {
+	version     int
+	description string
+	apply       func(doc map[string]json.RawMessage) error
+}
+
+// databaseMigrations lists every format change in order. To change the
+// persisted format, append a migration and bump DatabaseVersion; never edit
+// a released migration.
+var databaseMigrations = []databaseMigration{
+	{
+		// Files written before versioning have no "version" key and are
+		// treated as version 0. Their layout is unchanged in version 1.
+		version:     1,
+		description: "add format version",
+		apply:       func(map[string]json.RawMessage) error { return nil },
+	},
+	{
+		// Order lines keep the unit price, title and author name they were
+		// bought at. Older lines take them from the copy of the book stored
+		// with the line, falling back to the catalog for missing names.
+		version:     2,
+		description: "add order line snapshots",
+		apply:       fillOrderLineSnapshots,
+	},
+	{
+		// Order statuses were free-form before the lifecycle. Known legacy
+		// spellings become lifecycle statuses; other values are kept as
+		// they are, logged, and listed by the check command.
+		version:     3,
+		description: "normalize order statuses",
+		apply:       normalizeOrderStatuses,
+	},
+	{
+		// Orders saved before status changes were recorded get a history
+		// leading to their status, dated at their creation, so reports on
+		// the paid and delivered bases count them
+		version:     4,
+		description: "add order status history",
+		apply:       fillOrderHistories,
+	},
+}
+
+// legacyOrderStatuses maps the lower-case free-form statuses of orders
+// written before the lifecycle to lifecycle statuses
+var legacyOrderStatuses = map[string]string{
+	"":           models.OrderPending,
+	"new":        models.OrderPending,
+	"created":    models.OrderPending,
+	"processing": models.OrderPaid,
+	"shipping":   models.OrderShipped,
+	"dispatched": models.OrderShipped,
+	"complete":   models.OrderDelivered,
+	"completed":  models.OrderDelivered,
+	"canceled":   models.OrderCancelled,
+}
+
+// impliedStatusPaths lists the statuses an order passed through to reach
+// each status, along the shortest lifecycle path
+var impliedStatusPaths = map[string][]string{
+	models.OrderPending:   {models.OrderPending},
+	models.OrderPaid:      {models.OrderPending, models.OrderPaid},
+	models.OrderShipped:   {models.OrderPending, models.OrderPaid, models.OrderShipped},
+	models.OrderDelivered: {models.OrderPending, models.OrderPaid, models.OrderShipped, models.OrderDelivered},
+	models.OrderCancelled: {models.OrderPending, models.OrderCancelled},
+	models.OrderRefunded:  {models.OrderPending, models.OrderPaid, models.OrderShipped, models.OrderRefunded},
+}
+
+// migratedHistoryNote marks the status changes made up by fillOrderHistories
+const migratedHistoryNote = "recorded by database migration; the order's creation time stands in for the change time"
+
+// normalizeOrderStatus returns the lifecycle status a stored status stands
+// for, ignoring case and surrounding space, and false when it stands for none
+func normalizeOrderStatus(status string) (string, bool) {
+	key := strings.ToLower(strings.TrimSpace(status))
+	if IsOrderStatus(key) {
+		return key, true
+	}
+	normalized, known := legacyOrderStatuses[key]
+	return normalized, known
+}
+
+// normalizeOrderStatuses rewrites the status of every order to the lifecycle
+// status it stands for, leaving unknown statuses in place
+func normalizeOrderStatuses(doc map[string]json.RawMessage) error {
+	raw, exists := doc[collectionKey(EntityOrder)]
+	if !exists {
+		return nil
+	}
+	var orders map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &orders); err != nil {
+		return fmt.Errorf("%s: %w", collectionKey(EntityOrder), err)
+	}
+
+	for id, order := range orders {
+		var status string
+		if raw, exists := order["status"]; exists {
+			if err := json.Unmarshal(raw, &status); err != nil {
+				return fmt.Errorf("%s %s: status: %w", EntityOrder, id, err)
+			}
+		}
+		normalized, known := normalizeOrderStatus(status)
+		if !known {
+			log.Printf("Warning: %s %s has unknown status %q; it is kept and listed by the check command", EntityOrder, id, status)
+			continue
+		}
+		order["status"], _ = json.Marshal(normalized)
+	}
+
+	encoded, err := json.Marshal(orders)
+	if err != nil {
+		return err
+	}
+	doc[collectionKey(EntityOrder)] = encoded
+	return nil
+}
+
+// fillOrderLineSnapshots sets unit_price, title and author_name on the order
+// lines that lack them
+func fillOrderLineSnapshots(doc map[string]json.RawMessage) error {
+	raw, exists := doc[collectionKey(EntityOrder)]
+	if !exists {
+		return nil
+	}
+	var orders map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &orders); err != nil {
+		return fmt.Errorf("%s: %w", collectionKey(EntityOrder), err)
+	}
+
+	var books map[int]models.Book
+	var authors map[int]models.Author
+	if raw, exists := doc[collectionKey(EntityBook)]; exists {
+		if err := json.Unmarshal(raw, &books); err != nil {
+			return fmt.Errorf("%s: %w", collectionKey(EntityBook), err)
+		}
+	}
+	if raw, exists := doc[collectionKey(EntityAuthor)]; exists {
+		if err := json.Unmarshal(raw, &authors); err != nil {
+			return fmt.Errorf("%s: %w", collectionKey(EntityAuthor), err)
+		}
+	}
+
+	for id, order := range orders {
+		var items []map[string]json.RawMessage
+		if raw, exists := order["items"]; exists {
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return fmt.Errorf("%s %s: %w", EntityOrder, id, err)
+			}
+		}
+		for i, fields := range items {
+			encoded, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			var item models.OrderItem
+			if err := json.Unmarshal(encoded, &item); err != nil {
+				return fmt.Errorf("%s %s: items[%d]: %w", EntityOrder, id, i, err)
+			}
+
+			catalog := books[item.Book.ID]
+			author := item.Book.Author
+			if author.FirstName == "" && author.LastName == "" {
+				authorID := author.ID
+				if authorID == 0 {
+					authorID = catalog.Author.ID
+				}
+				author = authors[authorID]
+			}
+			if item.UnitPrice.IsZero() {
+				if fields["unit_price"], err = json.Marshal(item.Book.Price); err != nil {
+					return err
+				}
+			}
+			if item.Title == "" {
+				title := item.Book.Title
+				if title == "" {
+					title = catalog.Title
+				}
+				fields["title"], _ = json.Marshal(title)
+			}
+			if item.AuthorName == "" {
+				fields["author_name"], _ = json.Marshal(strings.TrimSpace(author.FirstName + " " + author.LastName))
+			}
+		}
+		if items != nil {
+			encoded, err := json.Marshal(items)
+			if err != nil {
+				return err
+			}
+			order["items"] = encoded
+		}
+	}
+
+	encoded, err := json.Marshal(orders)
+	if err != nil {
+		return err
+	}
+	doc[collectionKey(EntityOrder)] = encoded
+	return nil
+}
+
+// fillOrderHistories gives every order without a history the status changes
+// implied by its status, all at its creation time. Orders with an unknown
+// status get a single entry for it.
+func fillOrderHistories(doc map[string]json.RawMessage) error {
+	raw, exists := doc[collectionKey(EntityOrder)]
+	if !exists {
+		return nil
+	}
+	var orders map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &orders); err != nil {
+		return fmt.Errorf("%s: %w", collectionKey(EntityOrder), err)
+	}
+
+	for id, fields := range orders {
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		var order models.Order
+		if err := json.Unmarshal(encoded, &order); err != nil {
+			return fmt.Errorf("%s %s: %w", EntityOrder, id, err)
+		}
+		if len(order.History) > 0 {
+			continue
+		}
+
+		path, known := impliedStatusPaths[order.Status]
+		if !known {
+			path = []string{order.Status}
+		}
+		history := make([]models.OrderStatusChange, len(path))
+		previous := ""
+		for i, status := range path {
+			history[i] = models.OrderStatusChange{
+				Timestamp:      order.CreatedAt,
+				PreviousStatus: previous,
+				NewStatus:      status,
+				Note:           migratedHistoryNote,
+			}
+			previous = status
+		}
+		if fields["history"], err = json.Marshal(history); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(orders)
+	if err != nil {
+		return err
+	}
+	doc[collectionKey(EntityOrder)] = encoded
+	return nil
+}
+
+// upgradeDatabaseDocument migrates a decoded document to DatabaseVersion in
+// place and returns the version it was stored in
+func upgradeDatabaseDocument(doc map[string]json.RawMessage) (int, error) {
+	storedVersion := 0
+	if raw, exists := doc[versionKey]; exists {
+		if err := json.Unmarshal(raw, &storedVersion); err != nil || storedVersion < 0 {
+			return 0, fmt.Errorf("invalid %s %s", versionKey, raw)
+		}
+	}
+	if storedVersion > DatabaseVersion {
+		return 0, fmt.Errorf("%w %d: this build supports up to version %d", ErrUnsupportedVersion, storedVersion, DatabaseVersion)
+	}
+
+	for _, migration := range databaseMigrations {
+		if migration.version <= storedVersion {
+			continue
+		}
+		if err := migration.apply(doc); err != nil {
+			return 0, fmt.Errorf("migration to version %d (%s) failed: %w", migration.version, migration.description, err)
+		}
+		doc[versionKey] = json.RawMessage(fmt.Sprint(migration.version))
+	}
+	return storedVersion, nil
+}
+
+// MigrateDatabaseFile rewrites a database file in the latest format and
+// returns the version it was stored in. The previous file is kept as the
+// backup generation. Files with pending write-ahead log records are refused:
+// start and stop the server once so the log is folded into the snapshot.
+func MigrateDatabaseFile(filename string) (int, error) {
+	data, err := readDatabaseFile(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	walPath := WALFilename(filename)
+	for _, segment := range []string{sealedWALFilename(walPath), walPath} {
+		records, _, err := readWAL(segment)
+		if err != nil {
+			return 0, err
+		}
+		if count := countWALMutations(records, data.Sequence); count > 0 {
+			return 0, fmt.Errorf("%s has %d unapplied records; start and stop the server to apply them first", segment, count)
+		}
+	}
+
+	if data.StoredVersion == DatabaseVersion {
+		return data.StoredVersion, nil
+	}
+	if err := WriteDatabase(filename, data); err != nil {
+		return 0, err
+	}
+	return data.StoredVersion, nil
+}
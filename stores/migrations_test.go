@@ -0,0 +1,366 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatabaseMigrationsOrdered(t *testing.T) {
+	for i, migration := range databaseMigrations {
+		if migration.version != i+1 {
+			t.Errorf("databaseMigrations[%d] has version %d, want %d", i, migration.version, i+1)
+		}
+	}
+	if last := databaseMigrations[len(databaseMigrations)-1].version; last != DatabaseVersion {
+		t.Errorf("last migration has version %d, want DatabaseVersion %d", last, DatabaseVersion)
+	}
+}
+
+func TestUpgradeDatabaseDocument(t *testing.T) {
+	tests := []struct {
+		name        string
+		doc         string
+		wantVersion int
+		wantErr     error
+		wantErrText string
+	}{
+		{name: "unversioned", doc: `{"books": {}}`, wantVersion: 0},
+		{name: "empty", doc: `{}`, wantVersion: 0},
+		{name: "version 1", doc: `{"version": 1, "books": {}}`, wantVersion: 1},
+		{name: "version 2", doc: `{"version": 2, "books": {}}`, wantVersion: 2},
+		{name: "version 3", doc: `{"version": 3, "books": {}}`, wantVersion: 3},
+		{name: "version 4", doc: `{"version": 4, "books": {}}`, wantVersion: 4},
+		{name: "newer version", doc: `{"version": 99}`, wantErr: ErrUnsupportedVersion},
+		{name: "negative version", doc: `{"version": -1}`, wantErrText: "invalid version"},
+		{name: "non-numeric version", doc: `{"version": "2"}`, wantErrText: "invalid version"},
+	}
+	for _, tt := range tests {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		version, err := upgradeDatabaseDocument(doc)
+		if tt.wantErr != nil || tt.wantErrText != "" {
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantErrText) {
+				t.Errorf("%s: error = %v, want %v %q", tt.name, err, tt.wantErr, tt.wantErrText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if version != tt.wantVersion {
+			t.Errorf("%s: stored version = %d, want %d", tt.name, version, tt.wantVersion)
+		}
+		if got := string(doc[versionKey]); got != fmt.Sprint(DatabaseVersion) {
+			t.Errorf("%s: upgraded version = %s, want %d", tt.name, got, DatabaseVersion)
+		}
+	}
+}
+
+func TestDatabaseDataRoundTrip(t *testing.T) {
+	var data DatabaseData
+	input := `{"books": {"1": {"id": 1}}, "next_ids": {"book": 2}, "wal_sequence": 7}`
+	if err := json.Unmarshal([]byte(input), &data); err != nil {
+		t.Fatalf("Unmarshal error = %v", err)
+	}
+	if data.StoredVersion != 0 || len(data.Entities) != 1 || data.Entities[0] != EntityBook || data.NextIDs[EntityBook] != 2 || data.Sequence != 7 {
+		t.Fatalf("Unmarshal = %+v", data)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Marshal error = %v", err)
+	}
+	var decoded DatabaseData
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal(%s) error = %v", encoded, err)
+	}
+	if decoded.StoredVersion != DatabaseVersion {
+		t.Errorf("version after save = %d, want %d", decoded.StoredVersion, DatabaseVersion)
+	}
+	if decoded.Sequence != 7 {
+		t.Errorf("sequence after save = %d, want 7", decoded.Sequence)
+	}
+}
+
+func TestDatabaseDataWriteTo(t *testing.T) {
+	data := DatabaseData{
+		Sequence: 12,
+		Entities: []string{EntityAuthor, EntityBook},
+		Records: map[string]json.RawMessage{
+			EntityBook: json.RawMessage(`{"1":{"id":1,"title":"Notes","genres":["Science"]}}`),
+		},
+		NextIDs: map[string]int{EntityAuthor: 1, EntityBook: 2},
+	}
+	want, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := data.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo error = %v", err)
+	}
+	if got := buf.String(); got != string(want)+"\n" || n != int64(len(got)) {
+		t.Errorf("WriteTo wrote %d bytes:\n%s\nwant:\n%s", n, got, want)
+	}
+}
+
+func TestMigrateDatabaseFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		walRecords  int
+		wantVersion int
+		wantErr     bool
+		wantBackup  bool
+	}{
+		{name: "unversioned", content: `{"books": {}, "next_ids": {"book": 1}}`, wantVersion: 0, wantBackup: true},
+		{name: "current", content: `{"version": 4, "books": {}, "next_ids": {"book": 1}}`, wantVersion: 4},
+		{name: "newer", content: `{"version": 99, "books": {}}`, wantErr: true},
+		{name: "pending log records", content: `{"books": {}}`, walRecords: 1, wantErr: true},
+		{name: "log records in the snapshot", content: `{"version": 4, "wal_sequence": 1, "books": {}}`, walRecords: 1, wantVersion: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "database.json")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.walRecords; i++ {
+				appendMutations(t, WALFilename(filename), Mutation{Entity: EntityBook, Op: OpDelete, ID: i + 1})
+			}
+
+			version, err := MigrateDatabaseFile(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MigrateDatabaseFile error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if content, _ := os.ReadFile(filename); string(content) != tt.content {
+					t.Errorf("file changed after a failed migration: %s", content)
+				}
+				return
+			}
+			if version != tt.wantVersion {
+				t.Errorf("stored version = %d, want %d", version, tt.wantVersion)
+			}
+
+			data, err := readDatabaseFile(filename)
+			if err != nil {
+				t.Fatalf("readDatabaseFile error = %v", err)
+			}
+			if data.StoredVersion != DatabaseVersion {
+				t.Errorf("version after migration = %d, want %d", data.StoredVersion, DatabaseVersion)
+			}
+			backup, err := os.ReadFile(BackupFilename(filename))
+			if tt.wantBackup && string(backup) != tt.content {
+				t.Errorf("backup = %q, %v; want the original file", backup, err)
+			}
+			if !tt.wantBackup && err == nil {
+				t.Errorf("backup written for a file already in the latest format")
+			}
+		})
+	}
+}
+
+func TestFillOrderLineSnapshots(t *testing.T) {
+	const catalog = `"books": {"7": {"id": 7, "title": "Catalog Title", "author": {"id": 3}, "price": 9.99}},
+		"authors": {"3": {"id": 3, "first_name": "Ada", "last_name": "Lovelace"}}`
+
+	tests := []struct {
+		name    string
+		doc     string
+		want    models.OrderItem
+		wantErr bool
+	}{
+		{
+			name: "from the embedded book",
+			doc: `{"version": 1, ` + catalog + `, "orders": {"1": {"id": 1, "items": [{"book": {"id": 7, "title": "Old Title",
+				"author": {"id": 3, "first_name": "Grace", "last_name": "Hopper"}, "price": 4.50}, "quantity": 2}]}}}`,
+			want: models.OrderItem{UnitPrice: models.Cents(450), Title: "Old Title", AuthorName: "Grace Hopper"},
+		},
+		{
+			name: "names from the catalog",
+			doc: `{"version": 1, ` + catalog + `, "orders": {"1": {"id": 1, "items": [{"book": {"id": 7,
+				"price": {"amount": 4.50, "currency": "EUR"}}, "quantity": 2}]}}}`,
+			want: models.OrderItem{UnitPrice: models.Money{Amount: 450, Currency: "EUR"}, Title: "Catalog Title", AuthorName: "Ada Lovelace"},
+		},
+		{
+			name: "book no longer in the catalog",
+			doc:  `{"version": 1, "orders": {"1": {"id": 1, "items": [{"book": {"id": 8, "price": 1}, "quantity": 1}]}}}`,
+			want: models.OrderItem{UnitPrice: models.Cents(100)},
+		},
+		{
+			name: "snapshot already set",
+			doc: `{"version": 1, ` + catalog + `, "orders": {"1": {"id": 1, "items": [{"book": {"id": 7, "price": 4.50},
+				"quantity": 1, "unit_price": 3.00, "title": "Kept", "author_name": "Kept Author"}]}}}`,
+			want: models.OrderItem{UnitPrice: models.Cents(300), Title: "Kept", AuthorName: "Kept Author"},
+		},
+		{
+			name:    "invalid orders",
+			doc:     `{"version": 1, "orders": []}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		_, err := upgradeDatabaseDocument(doc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		var orders map[int]models.Order
+		if err := json.Unmarshal(doc[collectionKey(EntityOrder)], &orders); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		item := orders[1].Items[0]
+		if item.UnitPrice != tt.want.UnitPrice || item.Title != tt.want.Title || item.AuthorName != tt.want.AuthorName {
+			t.Errorf("%s: line = %v %q %q, want %v %q %q", tt.name,
+				item.UnitPrice, item.Title, item.AuthorName, tt.want.UnitPrice, tt.want.Title, tt.want.AuthorName)
+		}
+	}
+}
+
+func TestFillOrderLineSnapshotsWithoutOrders(t *testing.T) {
+	doc := map[string]json.RawMessage{"books": json.RawMessage(`{}`)}
+	if err := fillOrderLineSnapshots(doc); err != nil {
+		t.Fatalf("fillOrderLineSnapshots error = %v", err)
+	}
+	if _, exists := doc[collectionKey(EntityOrder)]; exists {
+		t.Errorf("orders collection added to a document without one")
+	}
+}
+
+func TestNormalizeOrderStatuses(t *testing.T) {
+	tests := []struct {
+		stored string
+		want   string
+	}{
+		{stored: `"pending"`, want: models.OrderPending},
+		{stored: `" Shipped "`, want: models.OrderShipped},
+		{stored: `""`, want: models.OrderPending},
+		{stored: `null`, want: models.OrderPending},
+		{stored: `"new"`, want: models.OrderPending},
+		{stored: `"processing"`, want: models.OrderPaid},
+		{stored: `"Dispatched"`, want: models.OrderShipped},
+		{stored: `"completed"`, want: models.OrderDelivered},
+		{stored: `"canceled"`, want: models.OrderCancelled},
+		{stored: `"on hold"`, want: "on hold"},
+	}
+	for _, tt := range tests {
+		doc := map[string]json.RawMessage{
+			collectionKey(EntityOrder): json.RawMessage(`{"1": {"id": 1, "status": ` + tt.stored + `}}`),
+		}
+		if err := normalizeOrderStatuses(doc); err != nil {
+			t.Fatalf("%s: normalizeOrderStatuses error = %v", tt.stored, err)
+		}
+		var orders map[int]models.Order
+		if err := json.Unmarshal(doc[collectionKey(EntityOrder)], &orders); err != nil {
+			t.Fatalf("%s: %v", tt.stored, err)
+		}
+		if got := orders[1].Status; got != tt.want {
+			t.Errorf("status %s normalized to %q, want %q", tt.stored, got, tt.want)
+		}
+	}
+
+	doc := map[string]json.RawMessage{collectionKey(EntityOrder): json.RawMessage(`{"1": {"id": 1, "status": 7}}`)}
+	if err := normalizeOrderStatuses(doc); err == nil {
+		t.Error("normalizeOrderStatuses accepted a numeric status")
+	}
+}
+
+func TestCheckOrderStatuses(t *testing.T) {
+	ctx := context.Background()
+	orders := NewInMemoryOrderStore()
+	for _, status := range []string{models.OrderPaid, "on hold", models.OrderRefunded, ""} {
+		if _, err := orders.CreateOrder(ctx, models.Order{Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unknown, err := CheckOrderStatuses(ctx, orders)
+	if err != nil {
+		t.Fatalf("CheckOrderStatuses error = %v", err)
+	}
+	want := []UnknownOrderStatus{{ID: 2, Status: "on hold"}, {ID: 4, Status: ""}}
+	if !reflect.DeepEqual(unknown, want) {
+		t.Errorf("CheckOrderStatuses = %v, want %v", unknown, want)
+	}
+	if got := unknown[0].String(); got != `order 2: unknown status "on hold"` {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestFillOrderHistories(t *testing.T) {
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	recorded := `[{"timestamp": "2024-02-01T00:00:00Z", "new_status": "pending"}]`
+	tests := []struct {
+		name     string
+		status   string
+		history  string
+		want     []string
+		wantNote bool
+	}{
+		{name: "pending", status: models.OrderPending, want: []string{"pending"}, wantNote: true},
+		{name: "delivered", status: models.OrderDelivered, want: []string{"pending", "paid", "shipped", "delivered"}, wantNote: true},
+		{name: "cancelled", status: models.OrderCancelled, want: []string{"pending", "cancelled"}, wantNote: true},
+		{name: "refunded", status: models.OrderRefunded, want: []string{"pending", "paid", "shipped", "refunded"}, wantNote: true},
+		{name: "unknown status", status: "on hold", want: []string{"on hold"}, wantNote: true},
+		{name: "empty history", status: models.OrderPaid, history: `[]`, want: []string{"pending", "paid"}, wantNote: true},
+		{name: "recorded history", status: models.OrderPaid, history: recorded, want: []string{"pending"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := fmt.Sprintf(`{"id": 1, "created_at": "2024-01-01T09:00:00Z", "status": %q`, tt.status)
+			if tt.history != "" {
+				order += `, "history": ` + tt.history
+			}
+			doc := map[string]json.RawMessage{collectionKey(EntityOrder): json.RawMessage(`{"1": ` + order + `}}`)}
+			if err := fillOrderHistories(doc); err != nil {
+				t.Fatalf("fillOrderHistories error = %v", err)
+			}
+
+			var orders map[int]models.Order
+			if err := json.Unmarshal(doc[collectionKey(EntityOrder)], &orders); err != nil {
+				t.Fatal(err)
+			}
+			var statuses []string
+			previous := ""
+			for _, change := range orders[1].History {
+				statuses = append(statuses, change.NewStatus)
+				if !tt.wantNote {
+					continue
+				}
+				if !change.Timestamp.Equal(created) || change.PreviousStatus != previous || change.Note != migratedHistoryNote {
+					t.Errorf("change = %+v, want one from %q at the creation time with the migration note", change, previous)
+				}
+				previous = change.NewStatus
+			}
+			if !reflect.DeepEqual(statuses, tt.want) {
+				t.Errorf("history statuses = %v, want %v", statuses, tt.want)
+			}
+		})
+	}
+}
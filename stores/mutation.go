@@ -0,0 +1,46 @@
+package stores
+
+// Mutation operations
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	// OpBatch groups mutations that must be applied together or not at all
+	OpBatch = "batch"
+)
+
+// Entity names used in mutations
+const (
+	EntityBook     = "book"
+	EntityAuthor   = "author"
+	EntityCustomer = "customer"
+	EntityOrder    = "order"
+)
+
+// Mutation describes a change about to be applied to a store. Value holds
+// the entity as it will be stored; it is nil for deletes. A batch carries
+// its mutations in Batch instead, and has no ID or Value of its own.
+type Mutation struct {
+	Entity string
+	Op     string
+	ID     int
+	Value  interface{}
+	Batch  []Mutation
+}
+
+// MutationHook is called for every create, update and delete while the
+// store's write lock is held, before the change is applied. Returning an
+// error rejects the mutation.
+type MutationHook func(Mutation) error
+
+// ChainMutationHooks runs hooks in order, stopping at the first error
+func ChainMutationHooks(hooks ...MutationHook) MutationHook {
+	return func(m Mutation) error {
+		for _, hook := range hooks {
+			if err := hook(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -1,18 +1,22 @@
 package stores
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"online-bookstore-api/interfaces"
 	"online-bookstore-api/models"
+	"sort"
 	"sync"
 	"time"
 )
 
 // InMemoryOrderStore implements OrderStore interface
 type InMemoryOrderStore struct {
-	mu     sync.RWMutex
-	orders map[int]models.Order
-	nextID int
+	mu         sync.RWMutex
+	orders     map[int]models.Order
+	nextID     int
+	onMutation MutationHook
 }
 
 // NewInMemoryOrderStore creates a new in-memory order store
@@ -24,49 +28,73 @@ func NewInMemoryOrderStore() *InMemoryOrderStore {
 }
 
 // CreateOrder creates a new order
-func (s *InMemoryOrderStore) CreateOrder(order models.Order) (models.Order, error) {
+func (s *InMemoryOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Order{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	order.ID = s.nextID
+	if err := s.notify(Mutation{Entity: EntityOrder, Op: OpCreate, ID: order.ID, Value: order}); err != nil {
+		return models.Order{}, err
+	}
+
 	s.nextID++
 	s.orders[order.ID] = order
 	return order, nil
 }
 
 // GetOrder retrieves an order by ID
-func (s *InMemoryOrderStore) GetOrder(id int) (models.Order, error) {
+func (s *InMemoryOrderStore) GetOrder(ctx context.Context, id int) (models.Order, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	order, exists := s.orders[id]
 	if !exists {
-		return models.Order{}, fmt.Errorf("order with ID %d not found", id)
+		return models.Order{}, notFoundError(EntityOrder, id)
 	}
 	return order, nil
 }
 
 // UpdateOrder updates an existing order
-func (s *InMemoryOrderStore) UpdateOrder(id int, order models.Order) (models.Order, error) {
+func (s *InMemoryOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Order{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.orders[id]; !exists {
-		return models.Order{}, fmt.Errorf("order with ID %d not found", id)
+		return models.Order{}, notFoundError(EntityOrder, id)
 	}
 
 	order.ID = id
+	if err := s.notify(Mutation{Entity: EntityOrder, Op: OpUpdate, ID: id, Value: order}); err != nil {
+		return models.Order{}, err
+	}
+
 	s.orders[id] = order
 	return order, nil
 }
 
 // DeleteOrder deletes an order by ID
-func (s *InMemoryOrderStore) DeleteOrder(id int) error {
+func (s *InMemoryOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.orders[id]; !exists {
-		return fmt.Errorf("order with ID %d not found", id)
+		return notFoundError(EntityOrder, id)
+	}
+
+	if err := s.notify(Mutation{Entity: EntityOrder, Op: OpDelete, ID: id}); err != nil {
+		return err
 	}
 
 	delete(s.orders, id)
@@ -74,24 +102,30 @@ func (s *InMemoryOrderStore) DeleteOrder(id int) error {
 }
 
 // GetAllOrders returns all orders
-func (s *InMemoryOrderStore) GetAllOrders() ([]models.Order, error) {
+func (s *InMemoryOrderStore) GetAllOrders(ctx context.Context) ([]models.Order, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	orders := make([]models.Order, 0, len(s.orders))
 	for _, order := range s.orders {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		orders = append(orders, order)
 	}
 	return orders, nil
 }
 
 // GetOrdersInTimeRange retrieves orders within a time range
-func (s *InMemoryOrderStore) GetOrdersInTimeRange(start, end time.Time) ([]models.Order, error) {
+func (s *InMemoryOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var results []models.Order
 	for _, order := range s.orders {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if (order.CreatedAt.After(start) || order.CreatedAt.Equal(start)) &&
 			(order.CreatedAt.Before(end) || order.CreatedAt.Equal(end)) {
 			results = append(results, order)
@@ -100,6 +134,73 @@ func (s *InMemoryOrderStore) GetOrdersInTimeRange(start, end time.Time) ([]model
 	return results, nil
 }
 
+// ForEachOrderInTimeRange streams orders within a time range, oldest first.
+// Only the matching IDs are collected under the lock; each order is then
+// read on its own so slow consumers do not block writers.
+func (s *InMemoryOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	type match struct {
+		id        int
+		createdAt time.Time
+	}
+
+	s.mu.RLock()
+	var matches []match
+	for id, order := range s.orders {
+		if err := ctx.Err(); err != nil {
+			s.mu.RUnlock()
+			return err
+		}
+		if (order.CreatedAt.After(start) || order.CreatedAt.Equal(start)) &&
+			(order.CreatedAt.Before(end) || order.CreatedAt.Equal(end)) {
+			matches = append(matches, match{id: id, createdAt: order.CreatedAt})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].createdAt.Equal(matches[j].createdAt) {
+			return matches[i].id < matches[j].id
+		}
+		return matches[i].createdAt.Before(matches[j].createdAt)
+	})
+
+	for _, m := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.mu.RLock()
+		order, exists := s.orders[m.id]
+		s.mu.RUnlock()
+		if !exists {
+			// Deleted since the scan started
+			continue
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrdersChangedInTimeRange returns the orders whose status changed to one
+// of statuses within [start, end), ordered by ID
+func (s *InMemoryOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []models.Order
+	for _, order := range s.orders {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if statusChangedInTimeRange(order, statuses, start, end) {
+			results = append(results, order)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
 // GetData returns the internal data for persistence
 func (s *InMemoryOrderStore) GetData() map[int]models.Order {
 	s.mu.RLock()
@@ -128,5 +229,74 @@ func (s *InMemoryOrderStore) GetNextID() int {
 	return s.nextID
 }
 
+// Entity returns the name of the records held by the store
+func (s *InMemoryOrderStore) Entity() string {
+	return EntityOrder
+}
+
+// Snapshot captures the orders and the next ID for persistence
+func (s *InMemoryOrderStore) Snapshot() (interfaces.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return snapshotRecords(s.orders, s.nextID)
+}
+
+// Restore replaces the orders with the contents of a snapshot
+func (s *InMemoryOrderStore) Restore(snapshot interfaces.Snapshot) error {
+	orders, nextID, err := restoreRecords[models.Order](EntityOrder, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders = orders
+	s.nextID = nextID
+	return nil
+}
+
+// ReplayMutation applies a logged mutation without running the mutation hook
+func (s *InMemoryOrderStore) ReplayMutation(op string, id int, value json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if op == OpDelete {
+		delete(s.orders, id)
+		return nil
+	}
+
+	var order models.Order
+	if err := json.Unmarshal(value, &order); err != nil {
+		return fmt.Errorf("invalid order record: %w", err)
+	}
+	order.ID = id
+	s.orders[id] = order
+	if id >= s.nextID {
+		s.nextID = id + 1
+	}
+	return nil
+}
+
+// SetMutationHook registers a hook called before every create, update and delete
+func (s *InMemoryOrderStore) SetMutationHook(hook MutationHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMutation = hook
+}
+
+// notify runs the mutation hook; the caller must hold the write lock
+func (s *InMemoryOrderStore) notify(m Mutation) error {
+	if s.onMutation == nil {
+		return nil
+	}
+	return s.onMutation(m)
+}
+
 // Verify interface implementation
-var _ interfaces.OrderStore = (*InMemoryOrderStore)(nil)
+var (
+	_ interfaces.OrderStore       = (*InMemoryOrderStore)(nil)
+	_ interfaces.Snapshotter      = (*InMemoryOrderStore)(nil)
+	_ interfaces.MutationReplayer = (*InMemoryOrderStore)(nil)
+	_ interfaces.OrderIterator    = (*InMemoryOrderStore)(nil)
+	_ interfaces.OrderStatusIndex = (*InMemoryOrderStore)(nil)
+)
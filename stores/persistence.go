@@ -1,99 +1,345 @@
 package stores
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"online-bookstore-api/models"
+	"io"
+	"io/fs"
+	"log"
+	"online-bookstore-api/interfaces"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// DatabaseData represents the complete database structure for persistence
+// Database file keys that are not entity collections
+const (
+	versionKey     = "version"
+	nextIDsKey     = "next_ids"
+	walSequenceKey = "wal_sequence"
+)
+
+// DatabaseData represents the complete database structure for persistence.
+// In the file every entity has a collection keyed by its plural name, e.g.
+// "books", next to a "next_ids" object keyed by entity name, the format
+// "version" and, for snapshots, the "wal_sequence" they hold.
 type DatabaseData struct {
-	Books     map[int]models.Book     `json:"books"`
-	Authors   map[int]models.Author   `json:"authors"`
-	Customers map[int]models.Customer `json:"customers"`
-	Orders    map[int]models.Order    `json:"orders"`
-	NextIDs   struct {
-		Book     int `json:"book"`
-		Author   int `json:"author"`
-		Customer int `json:"customer"`
-		Order    int `json:"order"`
-	} `json:"next_ids"`
+	// StoredVersion is the format version the data was read in. Older
+	// documents are upgraded to DatabaseVersion while they are decoded.
+	StoredVersion int
+	// Sequence is the sequence number of the last write-ahead log record
+	// the snapshot holds, zero if it was taken without a log
+	Sequence int64
+	// Entities lists the collections in the order they are written
+	Entities []string
+	// Records holds the JSON records of each entity
+	Records map[string]json.RawMessage
+	// NextIDs holds the next ID of each entity
+	NextIDs map[string]int
+}
+
+// collectionKey returns the file key of an entity's collection
+func collectionKey(entity string) string {
+	return entity + "s"
+}
+
+// MarshalJSON writes the current format version and the collections in
+// order, followed by the next IDs
+func (d DatabaseData) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"%s":%d,`, versionKey, DatabaseVersion)
+	if d.Sequence != 0 {
+		fmt.Fprintf(&buf, `"%s":%d,`, walSequenceKey, d.Sequence)
+	}
+	for _, entity := range d.Entities {
+		key, _ := json.Marshal(collectionKey(entity))
+		records := d.Records[entity]
+		if len(records) == 0 {
+			records = json.RawMessage("{}")
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(records)
+		buf.WriteByte(',')
+	}
+	nextIDs, err := json.Marshal(d.NextIDs)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(`"` + nextIDsKey + `":`)
+	buf.Write(nextIDs)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
-// SaveDatabase saves all stores to a JSON file
-func SaveDatabase(
-	bookStore *InMemoryBookStore,
-	authorStore *InMemoryAuthorStore,
-	customerStore *InMemoryCustomerStore,
-	orderStore *InMemoryOrderStore,
-	filename string,
-) error {
+// WriteTo writes the data as an indented JSON document, the same document
+// as json.MarshalIndent. Collections are indented and written one at a time,
+// so only one collection is copied in memory.
+func (d DatabaseData) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	write := func(parts ...[]byte) error {
+		for _, part := range parts {
+			n, err := w.Write(part)
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  %q: %d,\n", versionKey, DatabaseVersion)
+	if d.Sequence != 0 {
+		fmt.Fprintf(&buf, "  %q: %d,\n", walSequenceKey, d.Sequence)
+	}
+	if err := write(buf.Bytes()); err != nil {
+		return written, err
+	}
+	for _, entity := range d.Entities {
+		records := d.Records[entity]
+		if len(records) == 0 {
+			records = json.RawMessage("{}")
+		}
+		buf.Reset()
+		if err := json.Indent(&buf, records, "  ", "  "); err != nil {
+			return written, fmt.Errorf("failed to encode %s: %w", collectionKey(entity), err)
+		}
+		if err := write(fmt.Appendf(nil, "  %q: ", collectionKey(entity)), buf.Bytes(), []byte(",\n")); err != nil {
+			return written, err
+		}
+	}
+
+	nextIDs, err := json.MarshalIndent(d.NextIDs, "  ", "  ")
+	if err != nil {
+		return written, fmt.Errorf("failed to encode data: %w", err)
+	}
+	err = write(fmt.Appendf(nil, "  %q: ", nextIDsKey), nextIDs, []byte("\n}\n"))
+	return written, err
+}
+
+// UnmarshalJSON reads every collection in the file, upgrading documents
+// written in an older format version
+func (d *DatabaseData) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	storedVersion, err := upgradeDatabaseDocument(fields)
+	if err != nil {
+		return err
+	}
+
+	*d = DatabaseData{
+		StoredVersion: storedVersion,
+		Records:       make(map[string]json.RawMessage),
+		NextIDs:       make(map[string]int),
+	}
+	for key, value := range fields {
+		switch key {
+		case versionKey:
+			continue
+		case nextIDsKey:
+			if err := json.Unmarshal(value, &d.NextIDs); err != nil {
+				return fmt.Errorf("invalid %s: %w", nextIDsKey, err)
+			}
+			continue
+		case walSequenceKey:
+			if err := json.Unmarshal(value, &d.Sequence); err != nil || d.Sequence < 0 {
+				return fmt.Errorf("invalid %s %s", walSequenceKey, value)
+			}
+			continue
+		}
+		entity := strings.TrimSuffix(key, "s")
+		d.Entities = append(d.Entities, entity)
+		d.Records[entity] = value
+	}
+	sort.Strings(d.Entities)
+	return nil
+}
+
+// SaveDatabase saves a snapshot of every store to a JSON file
+func SaveDatabase(filename string, snapshotters ...interfaces.Snapshotter) error {
+	data, err := CaptureDatabase(snapshotters...)
+	if err != nil {
+		return err
+	}
+	return WriteDatabase(filename, data)
+}
+
+// CaptureDatabase takes a snapshot of every store. Stores are captured one
+// after another; hold a WriteGate exclusively to get a single point in time.
+func CaptureDatabase(snapshotters ...interfaces.Snapshotter) (DatabaseData, error) {
 	data := DatabaseData{
-		Books:     bookStore.GetData(),
-		Authors:   authorStore.GetData(),
-		Customers: customerStore.GetData(),
-		Orders:    orderStore.GetData(),
+		StoredVersion: DatabaseVersion,
+		Records:       make(map[string]json.RawMessage),
+		NextIDs:       make(map[string]int),
+	}
+	for _, store := range snapshotters {
+		entity := store.Entity()
+		snapshot, err := store.Snapshot()
+		if err != nil {
+			return DatabaseData{}, fmt.Errorf("failed to snapshot %s store: %w", entity, err)
+		}
+		data.Entities = append(data.Entities, entity)
+		data.Records[entity] = snapshot.Records
+		data.NextIDs[entity] = snapshot.NextID
 	}
+	return data, nil
+}
 
-	// Get next IDs from stores
-	data.NextIDs.Book = bookStore.GetNextID()
-	data.NextIDs.Author = authorStore.GetNextID()
-	data.NextIDs.Customer = customerStore.GetNextID()
-	data.NextIDs.Order = orderStore.GetNextID()
+// BackupFilename returns the name of the previous generation of a database file
+func BackupFilename(filename string) string {
+	return filename + ".bak"
+}
 
-	file, err := os.Create(filename)
+// WriteDatabase replaces filename atomically: the data is written and
+// synced to a temp file in the same directory, the current file is kept as
+// the backup generation and the temp file is renamed into place.
+func WriteDatabase(filename string, data DatabaseData) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp uses 0600; match the permissions os.Create would give
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file mode: %w", err)
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("failed to encode data: %w", err)
+	if _, err := data.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	// Keep the previous generation; LoadDatabase falls back to it if the
+	// primary is missing or corrupt
+	if err := os.Rename(filename, BackupFilename(filename)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to keep backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to move database into place: %w", err)
 	}
 
+	syncDir(dir)
 	return nil
 }
 
-// LoadDatabase loads all stores from a JSON file
-func LoadDatabase(
-	bookStore *InMemoryBookStore,
-	authorStore *InMemoryAuthorStore,
-	customerStore *InMemoryCustomerStore,
-	orderStore *InMemoryOrderStore,
-	filename string,
-) error {
-	file, err := os.Open(filename)
+// syncDir flushes directory entries so renames survive a crash. Not every
+// platform supports syncing a directory, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	_ = d.Sync()
+}
+
+// LoadDatabase restores every store from a JSON file and replays the
+// write-ahead log written since that snapshot. Stores without a collection
+// in the file are left as they are. It returns the sequence number of the
+// last log record the stores hold, for OpenWAL to number on from.
+//
+// Only records newer than the snapshot are replayed. When the backup
+// generation is loaded but the log no longer holds every record written
+// since, LoadDatabase fails with ErrWALMismatch rather than restore a state
+// that never existed.
+func LoadDatabase(filename string, snapshotters ...interfaces.Snapshotter) (int64, error) {
+	data, err := readDatabaseFile(filename)
+	if errors.Is(err, ErrUnsupportedVersion) {
+		// Falling back to the backup would later overwrite the newer file
+		return 0, err
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			// File doesn't exist, start with empty stores
-			return nil
+		backup, backupErr := readDatabaseFile(BackupFilename(filename))
+		switch {
+		case backupErr == nil:
+			log.Printf("Warning: %s is unusable (%v), loading backup %s", filename, err, BackupFilename(filename))
+			data = backup
+		case errors.Is(err, fs.ErrNotExist) && errors.Is(backupErr, fs.ErrNotExist):
+			// Neither file exists, start with empty stores
+			data = DatabaseData{}
+		case errors.Is(err, fs.ErrNotExist):
+			return 0, backupErr
+		default:
+			return 0, err
 		}
-		return fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
 
-	var data DatabaseData
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode data: %w", err)
+	if data.StoredVersion < DatabaseVersion && len(data.Entities) > 0 {
+		log.Printf("Upgraded %s from format version %d to %d; it is rewritten on the next save",
+			filename, data.StoredVersion, DatabaseVersion)
 	}
 
 	// Load data into stores
-	if data.Books != nil {
-		bookStore.LoadData(data.Books, data.NextIDs.Book)
+	byEntity := make(map[string]interfaces.Snapshotter, len(snapshotters))
+	for _, store := range snapshotters {
+		entity := store.Entity()
+		byEntity[entity] = store
+
+		records, exists := data.Records[entity]
+		if !exists {
+			continue
+		}
+		snapshot := interfaces.Snapshot{Records: records, NextID: data.NextIDs[entity]}
+		if err := store.Restore(snapshot); err != nil {
+			return 0, fmt.Errorf("failed to restore %s store: %w", entity, err)
+		}
 	}
-	if data.Authors != nil {
-		authorStore.LoadData(data.Authors, data.NextIDs.Author)
+	for _, entity := range data.Entities {
+		if byEntity[entity] == nil {
+			log.Printf("Warning: %s has no store for %q, its records will not be saved again", filename, collectionKey(entity))
+		}
 	}
-	if data.Customers != nil {
-		customerStore.LoadData(data.Customers, data.NextIDs.Customer)
+
+	// Replay mutations logged after the snapshot was taken
+	sequence, replayed, err := replayWAL(WALFilename(filename), data.Sequence, func(record walRecord) error {
+		store, exists := byEntity[record.Entity]
+		if !exists {
+			return fmt.Errorf("unknown entity %q in write-ahead log", record.Entity)
+		}
+		replayer, ok := store.(interfaces.MutationReplayer)
+		if !ok {
+			return fmt.Errorf("%s store cannot replay write-ahead log records", record.Entity)
+		}
+		return replayer.ReplayMutation(record.Op, record.ID, record.Value)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to replay write-ahead log: %w", err)
 	}
-	if data.Orders != nil {
-		orderStore.LoadData(data.Orders, data.NextIDs.Order)
+	if replayed > 0 {
+		log.Printf("Replayed %d write-ahead log records", replayed)
 	}
 
-	return nil
+	return sequence, nil
+}
+
+// readDatabaseFile reads and decodes a database file
+func readDatabaseFile(filename string) (DatabaseData, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return DatabaseData{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	var data DatabaseData
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&data); err != nil {
+		return DatabaseData{}, fmt.Errorf("failed to decode %s: %w", filename, err)
+	}
+	return data, nil
 }
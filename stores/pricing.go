@@ -0,0 +1,239 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entityOrderItem names order lines in errors. Lines are stored with their
+// order, so it is not a mutation entity.
+const entityOrderItem = "order item"
+
+// pricingOrderStore prices order lines against the catalog
+type pricingOrderStore struct {
+	interfaces.OrderStore
+	books   interfaces.BookStore
+	authors interfaces.AuthorStore
+
+	// mu serializes edits, so two edits of the same order cannot both start
+	// from the same lines
+	mu *sync.Mutex
+}
+
+// PricingOrderStore wraps an order store so that every order line carries
+// the unit price, title and author name of its book at the time the line
+// was priced, and TotalPrice is always the sum of the lines, all in one
+// currency. New orders are priced against books. UpdateOrder rejects changes
+// to the customer, lines, total and creation time with ErrValidation; fields
+// left empty keep their stored values. Lines are changed through the
+// OrderItemEditor methods instead. Status transitions
+// are passed to the wrapped store. The wrapper streams orders whether or not
+// the wrapped store does.
+func PricingOrderStore(store interfaces.OrderStore, books interfaces.BookStore, authors interfaces.AuthorStore) interfaces.OrderStore {
+	return pricingOrderStore{OrderStore: store, books: books, authors: authors, mu: &sync.Mutex{}}
+}
+
+func (s pricingOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	for i := range order.Items {
+		if order.Items[i].Quantity <= 0 {
+			return models.Order{}, entityError(EntityOrder, 0, ErrValidation, "quantity must be positive, got %d", order.Items[i].Quantity)
+		}
+		if err := s.priceItem(ctx, 0, &order.Items[i]); err != nil {
+			return models.Order{}, err
+		}
+	}
+	total, err := orderTotal(0, order.Items)
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.TotalPrice = total
+	return s.OrderStore.CreateOrder(ctx, order)
+}
+
+func (s pricingOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if order.Customer.ID != 0 && order.Customer.ID != existing.Customer.ID {
+		return models.Order{}, entityError(EntityOrder, id, ErrValidation, "the customer of an order cannot be changed")
+	}
+	if order.Items != nil && !sameOrderLines(existing.Items, order.Items) {
+		return models.Order{}, entityError(EntityOrder, id, ErrValidation, "items are changed with the order item endpoints")
+	}
+	total, err := orderTotal(id, existing.Items)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if !order.TotalPrice.IsZero() && (!order.TotalPrice.SameCurrency(total) || order.TotalPrice.Amount != total.Amount) {
+		return models.Order{}, entityError(EntityOrder, id, ErrValidation, "total_price is the sum of the items, %s", total)
+	}
+	if !order.CreatedAt.IsZero() && !order.CreatedAt.Equal(existing.CreatedAt) {
+		return models.Order{}, entityError(EntityOrder, id, ErrValidation, "created_at cannot be changed")
+	}
+	order.Customer = existing.Customer
+	order.Items = existing.Items
+	order.TotalPrice = total
+	order.CreatedAt = existing.CreatedAt
+	return s.OrderStore.UpdateOrder(ctx, id, order)
+}
+
+// AddOrderItem appends a line priced at the book's current price
+func (s pricingOrderStore) AddOrderItem(ctx context.Context, id int, item models.OrderItem) (models.Order, error) {
+	return s.editItems(ctx, id, func(items []models.OrderItem) ([]models.OrderItem, error) {
+		if item.Quantity <= 0 {
+			return nil, entityError(EntityOrder, id, ErrValidation, "quantity must be positive, got %d", item.Quantity)
+		}
+		if err := s.priceItem(ctx, id, &item); err != nil {
+			return nil, err
+		}
+		return append(items, item), nil
+	})
+}
+
+// UpdateOrderItem changes the quantity of a line and prices it again at the
+// book's current price
+func (s pricingOrderStore) UpdateOrderItem(ctx context.Context, id, index, quantity int) (models.Order, error) {
+	return s.editItems(ctx, id, func(items []models.OrderItem) ([]models.OrderItem, error) {
+		if index < 0 || index >= len(items) {
+			return nil, entityError(entityOrderItem, 0, ErrNotFound, "order %d has no item %d", id, index)
+		}
+		if quantity <= 0 {
+			return nil, entityError(EntityOrder, id, ErrValidation, "quantity must be positive, got %d", quantity)
+		}
+		item := models.OrderItem{Book: models.Book{ID: items[index].Book.ID}, Quantity: quantity}
+		if err := s.priceItem(ctx, id, &item); err != nil {
+			return nil, err
+		}
+		items[index] = item
+		return items, nil
+	})
+}
+
+// RemoveOrderItem removes a line, keeping at least one
+func (s pricingOrderStore) RemoveOrderItem(ctx context.Context, id, index int) (models.Order, error) {
+	return s.editItems(ctx, id, func(items []models.OrderItem) ([]models.OrderItem, error) {
+		if index < 0 || index >= len(items) {
+			return nil, entityError(entityOrderItem, 0, ErrNotFound, "order %d has no item %d", id, index)
+		}
+		if len(items) == 1 {
+			return nil, entityError(EntityOrder, id, ErrValidation, "an order must keep at least one item")
+		}
+		return append(items[:index], items[index+1:]...), nil
+	})
+}
+
+// editItems replaces the lines of an order with the result of edit, which
+// receives a copy of the stored lines, and updates the total to match
+func (s pricingOrderStore) editItems(ctx context.Context, id int, edit func([]models.OrderItem) ([]models.OrderItem, error)) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.OrderStore.GetOrder(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	items, err := edit(append([]models.OrderItem(nil), order.Items...))
+	if err != nil {
+		return models.Order{}, err
+	}
+	total, err := orderTotal(id, items)
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.Items = items
+	order.TotalPrice = total
+	return s.OrderStore.UpdateOrder(ctx, id, order)
+}
+
+// priceItem fills in a line from the current catalog entry of its book
+func (s pricingOrderStore) priceItem(ctx context.Context, orderID int, item *models.OrderItem) error {
+	book, err := s.books.GetBook(ctx, item.Book.ID)
+	if errors.Is(err, ErrNotFound) {
+		return entityError(EntityOrder, orderID, ErrValidation, "%s with ID %d does not exist", EntityBook, item.Book.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	author, err := s.authors.GetAuthor(ctx, book.Author.ID)
+	if err == nil {
+		book.Author = author
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	item.Book = book
+	item.UnitPrice = book.Price
+	item.Title = book.Title
+	item.AuthorName = strings.TrimSpace(book.Author.FirstName + " " + book.Author.LastName)
+	return nil
+}
+
+// TransitionOrder passes status changes to the wrapped store
+func (s pricingOrderStore) TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error) {
+	transitioner, ok := s.OrderStore.(interfaces.OrderTransitioner)
+	if !ok {
+		return models.Order{}, fmt.Errorf("order transitions: %w", errors.ErrUnsupported)
+	}
+	return transitioner.TransitionOrder(ctx, id, change)
+}
+
+func (s pricingOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return forEachOrder(ctx, s.OrderStore, start, end, fn)
+}
+
+func (s pricingOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	return ordersChangedInTimeRange(ctx, s.OrderStore, statuses, start, end)
+}
+
+// orderTotal returns the sum of the lines of an order, rejecting lines priced
+// in more than one currency and totals too large to represent
+func orderTotal(id int, items []models.OrderItem) (models.Money, error) {
+	var total models.Money
+	for i, item := range items {
+		subtotal, err := item.Subtotal()
+		if err == nil {
+			total, err = total.Add(subtotal)
+		}
+		switch {
+		case errors.Is(err, models.ErrCurrencyMismatch):
+			return models.Money{}, entityError(EntityOrder, id, ErrValidation, "all items must be priced in %s, got %s",
+				items[0].Price().CurrencyCode(), item.Price().CurrencyCode())
+		case err != nil:
+			return models.Money{}, entityError(EntityOrder, id, ErrValidation, "items[%d]: %v", i, err)
+		}
+	}
+	return total, nil
+}
+
+// sameOrderLines reports whether two sets of lines order the same books in
+// the same quantities, ignoring prices and book details
+func sameOrderLines(a, b []models.OrderItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Book.ID != b[i].Book.ID || a[i].Quantity != b[i].Quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderIterator     = pricingOrderStore{}
+	_ interfaces.OrderStatusIndex  = pricingOrderStore{}
+	_ interfaces.OrderTransitioner = pricingOrderStore{}
+	_ interfaces.OrderItemEditor   = pricingOrderStore{}
+)
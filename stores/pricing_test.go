@@ -0,0 +1,317 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+// pricingFixture is a pricing store over in-memory stores holding an author
+// and three books, two priced in dollars and one in euros
+type pricingFixture struct {
+	store   interfaces.OrderStore
+	editor  interfaces.OrderItemEditor
+	books   *InMemoryBookStore
+	authors *InMemoryAuthorStore
+}
+
+func newPricingFixture(t *testing.T) pricingFixture {
+	t.Helper()
+	ctx := context.Background()
+	books := NewInMemoryBookStore()
+	authors := NewInMemoryAuthorStore()
+	author, err := authors.CreateAuthor(ctx, models.Author{FirstName: "Ada", LastName: "Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range []models.Book{
+		{Title: "Notes", Author: models.Author{ID: author.ID}, Price: models.Cents(1000), Stock: 10},
+		{Title: "Letters", Price: models.Cents(250), Stock: 10},
+		{Title: "Carnets", Price: models.Money{Amount: 900, Currency: "EUR"}, Stock: 10},
+	} {
+		if _, err := books.CreateBook(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orders := ReserveStockOrderStore(NewInMemoryOrderStore(), books, nil)
+	store := PricingOrderStore(LifecycleOrderStore(orders, books, nil), books, authors)
+	return pricingFixture{store: store, editor: store.(interfaces.OrderItemEditor), books: books, authors: authors}
+}
+
+// createOrder places an order for quantities of books by ID
+func (f pricingFixture) createOrder(t *testing.T, quantities ...[2]int) models.Order {
+	t.Helper()
+	order := models.Order{Customer: models.Customer{ID: 1}, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, q := range quantities {
+		order.Items = append(order.Items, models.OrderItem{Book: models.Book{ID: q[0]}, Quantity: q[1]})
+	}
+	created, err := f.store.CreateOrder(context.Background(), order)
+	if err != nil {
+		t.Fatalf("CreateOrder error = %v", err)
+	}
+	return created
+}
+
+// orderLine is the priced part of an order line
+type orderLine struct {
+	bookID, quantity  int
+	unitPrice         int64
+	title, authorName string
+}
+
+func orderLines(order models.Order) []orderLine {
+	lines := make([]orderLine, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = orderLine{item.Book.ID, item.Quantity, item.UnitPrice.Amount, item.Title, item.AuthorName}
+	}
+	return lines
+}
+
+func TestPricingCreateOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []models.OrderItem
+		wantErr   error
+		wantLines []orderLine
+		wantTotal models.Money
+	}{
+		{
+			name: "priced from the catalog",
+			items: []models.OrderItem{
+				// Prices and names sent by clients are ignored
+				{Book: models.Book{ID: 1, Price: models.Cents(1)}, Quantity: 2, UnitPrice: models.Cents(1), Title: "Free"},
+				{Book: models.Book{ID: 2}, Quantity: 1},
+			},
+			wantLines: []orderLine{{1, 2, 1000, "Notes", "Ada Lovelace"}, {2, 1, 250, "Letters", ""}},
+			wantTotal: models.Cents(2250),
+		},
+		{
+			name:      "other currency",
+			items:     []models.OrderItem{{Book: models.Book{ID: 3}, Quantity: 2}},
+			wantLines: []orderLine{{3, 2, 900, "Carnets", ""}},
+			wantTotal: models.Money{Amount: 1800, Currency: "EUR"},
+		},
+		{
+			name:    "mixed currencies",
+			items:   []models.OrderItem{{Book: models.Book{ID: 1}, Quantity: 1}, {Book: models.Book{ID: 3}, Quantity: 1}},
+			wantErr: ErrValidation,
+		},
+		{name: "missing book", items: []models.OrderItem{{Book: models.Book{ID: 9}, Quantity: 1}}, wantErr: ErrValidation},
+		{name: "no copies", items: []models.OrderItem{{Book: models.Book{ID: 1}, Quantity: 0}}, wantErr: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPricingFixture(t)
+			order, err := f.store.CreateOrder(context.Background(), models.Order{Items: tt.items, TotalPrice: models.Cents(1)})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOrder error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := orderLines(order); !slices.Equal(got, tt.wantLines) {
+				t.Errorf("lines = %+v, want %+v", got, tt.wantLines)
+			}
+			if order.TotalPrice.Amount != tt.wantTotal.Amount || !order.TotalPrice.SameCurrency(tt.wantTotal) {
+				t.Errorf("total = %v, want %v", order.TotalPrice, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestPricingSnapshotsSurviveBookEdits(t *testing.T) {
+	ctx := context.Background()
+	f := newPricingFixture(t)
+	order := f.createOrder(t, [2]int{1, 2})
+
+	book, _ := f.books.GetBook(ctx, 1)
+	book.Title, book.Price = "Notes, Revised", models.Cents(5000)
+	if _, err := f.books.UpdateBook(ctx, 1, book); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.authors.UpdateAuthor(ctx, 1, models.Author{FirstName: "Augusta", LastName: "King"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := f.store.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []orderLine{{1, 2, 1000, "Notes", "Ada Lovelace"}}
+	if got := orderLines(stored); !slices.Equal(got, want) || stored.TotalPrice != models.Cents(2000) {
+		t.Errorf("order after book edit = %+v total %v, want %+v total 20.00", got, stored.TotalPrice, want)
+	}
+
+	// Lines priced after the edit use the new price
+	edited, err := f.editor.AddOrderItem(ctx, order.ID, models.OrderItem{Book: models.Book{ID: 1}, Quantity: 1})
+	if err != nil {
+		t.Fatalf("AddOrderItem error = %v", err)
+	}
+	want = append(want, orderLine{1, 1, 5000, "Notes, Revised", "Augusta King"})
+	if got := orderLines(edited); !slices.Equal(got, want) || edited.TotalPrice != models.Cents(7000) {
+		t.Errorf("order after adding a line = %+v total %v, want %+v total 70.00", got, edited.TotalPrice, want)
+	}
+}
+
+func TestPricingUpdateOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(order *models.Order)
+		wantErr error
+	}{
+		{name: "unchanged", change: func(*models.Order) {}},
+		{name: "fields left out", change: func(order *models.Order) { *order = models.Order{} }},
+		{name: "customer", change: func(order *models.Order) { order.Customer.ID = 2 }, wantErr: ErrValidation},
+		{name: "items", change: func(order *models.Order) { order.Items[0].Quantity = 5 }, wantErr: ErrValidation},
+		{name: "no items", change: func(order *models.Order) { order.Items = []models.OrderItem{} }, wantErr: ErrValidation},
+		{name: "total", change: func(order *models.Order) { order.TotalPrice = models.Cents(1) }, wantErr: ErrValidation},
+		{name: "total currency", change: func(order *models.Order) { order.TotalPrice.Currency = "EUR" }, wantErr: ErrValidation},
+		{name: "creation time", change: func(order *models.Order) { order.CreatedAt = order.CreatedAt.Add(time.Hour) }, wantErr: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newPricingFixture(t)
+			order := f.createOrder(t, [2]int{1, 2})
+
+			update := order
+			update.Items = append([]models.OrderItem(nil), order.Items...)
+			tt.change(&update)
+			updated, err := f.store.UpdateOrder(ctx, order.ID, update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrder error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if updated.Customer.ID != 1 || !slices.Equal(orderLines(updated), orderLines(order)) ||
+				updated.TotalPrice != order.TotalPrice || !updated.CreatedAt.Equal(order.CreatedAt) {
+				t.Errorf("UpdateOrder = %+v, want the stored order %+v", updated, order)
+			}
+		})
+	}
+}
+
+func TestPricingOrderItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error)
+		wantErr   error
+		wantLines []orderLine
+	}{
+		{
+			name: "add",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.AddOrderItem(ctx, id, models.OrderItem{Book: models.Book{ID: 2}, Quantity: 3, UnitPrice: models.Cents(1)})
+			},
+			wantLines: []orderLine{{1, 2, 1000, "Notes", "Ada Lovelace"}, {2, 1, 250, "Letters", ""}, {2, 3, 250, "Letters", ""}},
+		},
+		{
+			name: "add in another currency",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.AddOrderItem(ctx, id, models.OrderItem{Book: models.Book{ID: 3}, Quantity: 1})
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "add beyond stock",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.AddOrderItem(ctx, id, models.OrderItem{Book: models.Book{ID: 1}, Quantity: 9})
+			},
+			wantErr: ErrInsufficientStock,
+		},
+		{
+			name: "update",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.UpdateOrderItem(ctx, id, 1, 4)
+			},
+			wantLines: []orderLine{{1, 2, 1000, "Notes", "Ada Lovelace"}, {2, 4, 250, "Letters", ""}},
+		},
+		{
+			name: "update to no copies",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.UpdateOrderItem(ctx, id, 0, 0)
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "update a missing line",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.UpdateOrderItem(ctx, id, 2, 1)
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "remove",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.RemoveOrderItem(ctx, id, 0)
+			},
+			wantLines: []orderLine{{2, 1, 250, "Letters", ""}},
+		},
+		{
+			name: "remove a missing line",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.RemoveOrderItem(ctx, id, -1)
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "remove the last line",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				if _, err := editor.RemoveOrderItem(ctx, id, 0); err != nil {
+					return models.Order{}, err
+				}
+				return editor.RemoveOrderItem(ctx, id, 0)
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name: "missing order",
+			edit: func(ctx context.Context, editor interfaces.OrderItemEditor, id int) (models.Order, error) {
+				return editor.UpdateOrderItem(ctx, id+1, 0, 1)
+			},
+			wantErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newPricingFixture(t)
+			order := f.createOrder(t, [2]int{1, 2}, [2]int{2, 1})
+
+			edited, err := tt.edit(ctx, f.editor, order.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("edit error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := orderLines(edited); !slices.Equal(got, tt.wantLines) {
+				t.Errorf("lines = %+v, want %+v", got, tt.wantLines)
+			}
+			total, _ := orderTotal(order.ID, edited.Items)
+			if edited.TotalPrice != total {
+				t.Errorf("total = %v, want the sum of the lines %v", edited.TotalPrice, total)
+			}
+		})
+	}
+}
+
+func TestPricingOrderItemsOnlyPending(t *testing.T) {
+	ctx := context.Background()
+	f := newPricingFixture(t)
+	order := f.createOrder(t, [2]int{1, 2})
+	transitioner := f.store.(interfaces.OrderTransitioner)
+	if _, err := transitioner.TransitionOrder(ctx, order.ID, models.OrderStatusChange{NewStatus: models.OrderPaid}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.editor.UpdateOrderItem(ctx, order.ID, 0, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateOrderItem on a paid order error = %v, want %v", err, ErrConflict)
+	}
+}
@@ -0,0 +1,43 @@
+package stores
+
+import (
+	"encoding/json"
+	"fmt"
+	"online-bookstore-api/interfaces"
+)
+
+// snapshotRecords encodes the records of an in-memory store; the caller
+// must hold the store's read lock
+func snapshotRecords[T any](records map[int]T, nextID int) (interfaces.Snapshot, error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return interfaces.Snapshot{}, fmt.Errorf("failed to encode records: %w", err)
+	}
+	return interfaces.Snapshot{Records: data, NextID: nextID}, nil
+}
+
+// restoreRecords decodes the records of a snapshot. The next ID is raised
+// above the highest record ID so a stale or missing counter never hands out
+// an ID that is already taken.
+func restoreRecords[T any](entity string, snapshot interfaces.Snapshot) (map[int]T, int, error) {
+	var records map[int]T
+	if len(snapshot.Records) > 0 {
+		if err := json.Unmarshal(snapshot.Records, &records); err != nil {
+			return nil, 0, fmt.Errorf("invalid %s records: %w", entity, err)
+		}
+	}
+	if records == nil {
+		records = make(map[int]T)
+	}
+
+	nextID := snapshot.NextID
+	if nextID < 1 {
+		nextID = 1
+	}
+	for id := range records {
+		if id >= nextID {
+			nextID = id + 1
+		}
+	}
+	return records, nextID, nil
+}
@@ -0,0 +1,159 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+)
+
+// SQLAuthorStore implements AuthorStore on a SQL database
+type SQLAuthorStore struct {
+	db *sql.DB
+}
+
+// NewSQLAuthorStore creates an author store backed by db. The schema must
+// have been brought up to date with MigrateSQL.
+func NewSQLAuthorStore(db *sql.DB) *SQLAuthorStore {
+	return &SQLAuthorStore{db: db}
+}
+
+// CreateAuthor creates a new author
+func (s *SQLAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		id, err := nextSQLID(ctx, tx, EntityAuthor)
+		if err != nil {
+			return err
+		}
+		author.ID = id
+		return insertSQLAuthor(ctx, tx, author)
+	})
+	if err != nil {
+		return models.Author{}, err
+	}
+	return author, nil
+}
+
+// GetAuthor retrieves an author by ID
+func (s *SQLAuthorStore) GetAuthor(ctx context.Context, id int) (models.Author, error) {
+	var author models.Author
+	err := s.db.QueryRowContext(ctx, `SELECT id, first_name, last_name, bio FROM authors WHERE id = ?`, id).
+		Scan(&author.ID, &author.FirstName, &author.LastName, &author.Bio)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Author{}, notFoundError(EntityAuthor, id)
+	}
+	if err != nil {
+		return models.Author{}, err
+	}
+	return author, nil
+}
+
+// UpdateAuthor updates an existing author
+func (s *SQLAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
+	author.ID = id
+	result, err := s.db.ExecContext(ctx, `UPDATE authors SET first_name = ?, last_name = ?, bio = ? WHERE id = ?`,
+		author.FirstName, author.LastName, author.Bio, id)
+	if err != nil {
+		return models.Author{}, err
+	}
+	if err := checkRowsAffected(result, EntityAuthor, id); err != nil {
+		return models.Author{}, err
+	}
+	return author, nil
+}
+
+// DeleteAuthor deletes an author by ID
+func (s *SQLAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM authors WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result, EntityAuthor, id)
+}
+
+// GetAllAuthors returns all authors
+func (s *SQLAuthorStore) GetAllAuthors(ctx context.Context) ([]models.Author, error) {
+	return selectSQLAuthors(ctx, s.db)
+}
+
+// Entity returns the name of the records held by the store
+func (s *SQLAuthorStore) Entity() string {
+	return EntityAuthor
+}
+
+// Snapshot captures the authors and the next ID for persistence
+func (s *SQLAuthorStore) Snapshot() (interfaces.Snapshot, error) {
+	ctx := context.Background()
+	var snapshot interfaces.Snapshot
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		authors, err := selectSQLAuthors(ctx, tx)
+		if err != nil {
+			return err
+		}
+		nextID, err := readSQLSequence(ctx, tx, EntityAuthor)
+		if err != nil {
+			return err
+		}
+		records := make(map[int]models.Author, len(authors))
+		for _, author := range authors {
+			records[author.ID] = author
+		}
+		snapshot, err = snapshotRecords(records, nextID)
+		return err
+	})
+	return snapshot, err
+}
+
+// Restore replaces the authors with the contents of a snapshot
+func (s *SQLAuthorStore) Restore(snapshot interfaces.Snapshot) error {
+	ctx := context.Background()
+	authors, nextID, err := restoreRecords[models.Author](EntityAuthor, snapshot)
+	if err != nil {
+		return err
+	}
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM authors`); err != nil {
+			return err
+		}
+		for id, author := range authors {
+			author.ID = id
+			if err := insertSQLAuthor(ctx, tx, author); err != nil {
+				return err
+			}
+		}
+		return setSQLSequence(ctx, tx, EntityAuthor, nextID)
+	})
+}
+
+// selectSQLAuthors reads every author in ID order
+func selectSQLAuthors(ctx context.Context, q sqlQuerier) ([]models.Author, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, first_name, last_name, bio FROM authors ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []models.Author{}
+	for rows.Next() {
+		var author models.Author
+		if err := rows.Scan(&author.ID, &author.FirstName, &author.LastName, &author.Bio); err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+// insertSQLAuthor inserts an author row
+func insertSQLAuthor(ctx context.Context, tx *sql.Tx, author models.Author) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO authors (id, first_name, last_name, bio) VALUES (?, ?, ?, ?)`,
+		author.ID, author.FirstName, author.LastName, author.Bio)
+	return err
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.AuthorStore = (*SQLAuthorStore)(nil)
+	_ interfaces.Snapshotter = (*SQLAuthorStore)(nil)
+)
@@ -0,0 +1,328 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"strings"
+)
+
+// sqlBookColumns selects a book joined with its author; books whose author
+// row is missing keep only the author ID
+const sqlBookColumns = `SELECT b.id, b.title, b.author_id, COALESCE(a.first_name, ''), COALESCE(a.last_name, ''),
+	COALESCE(a.bio, ''), b.published_at, b.price_minor, b.currency, b.stock
+	FROM books b LEFT JOIN authors a ON a.id = b.author_id`
+
+// SQLBookStore implements BookStore on a SQL database. The author of a book
+// is stored as a reference, so books are read back with the author's
+// current details.
+type SQLBookStore struct {
+	db *sql.DB
+}
+
+// NewSQLBookStore creates a book store backed by db. The schema must have
+// been brought up to date with MigrateSQL.
+func NewSQLBookStore(db *sql.DB) *SQLBookStore {
+	return &SQLBookStore{db: db}
+}
+
+// CreateBook creates a new book
+func (s *SQLBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		id, err := nextSQLID(ctx, tx, EntityBook)
+		if err != nil {
+			return err
+		}
+		book.ID = id
+		return insertSQLBook(ctx, tx, book)
+	})
+	if err != nil {
+		return models.Book{}, err
+	}
+	return s.GetBook(ctx, book.ID)
+}
+
+// GetBook retrieves a book by ID
+func (s *SQLBookStore) GetBook(ctx context.Context, id int) (models.Book, error) {
+	books, err := selectSQLBooks(ctx, s.db, ` WHERE b.id = ?`, id)
+	if err != nil {
+		return models.Book{}, err
+	}
+	if len(books) == 0 {
+		return models.Book{}, notFoundError(EntityBook, id)
+	}
+	return books[0], nil
+}
+
+// UpdateBook updates an existing book
+func (s *SQLBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	book.ID = id
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE books SET title = ?, author_id = ?, published_at = ?, price_minor = ?, currency = ?,
+			stock = ? WHERE id = ?`, book.Title, book.Author.ID, formatSQLTime(book.PublishedAt),
+			book.Price.Amount, book.Price.Currency, book.Stock, id)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(result, EntityBook, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM book_genres WHERE book_id = ?`, id); err != nil {
+			return err
+		}
+		return insertSQLGenres(ctx, tx, book)
+	})
+	if err != nil {
+		return models.Book{}, err
+	}
+	return s.GetBook(ctx, id)
+}
+
+// AdjustStock adds each change to the stock of the book with that ID. Each
+// book is updated only if its stock stays non-negative, and the transaction
+// is rolled back if any is not, so concurrent changes cannot oversell.
+func (s *SQLBookStore) AdjustStock(ctx context.Context, changes map[int]int) error {
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		var shortages []StockShortage
+		for _, id := range stockIDs(changes) {
+			change := changes[id]
+			result, err := tx.ExecContext(ctx, `UPDATE books SET stock = stock + ? WHERE id = ? AND stock + ? >= 0`,
+				change, id, change)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected > 0 {
+				continue
+			}
+
+			var available int
+			err = tx.QueryRowContext(ctx, `SELECT stock FROM books WHERE id = ?`, id).Scan(&available)
+			if errors.Is(err, sql.ErrNoRows) {
+				return notFoundError(EntityBook, id)
+			}
+			if err != nil {
+				return err
+			}
+			shortages = append(shortages, StockShortage{BookID: id, Requested: -change, Available: available})
+		}
+		if len(shortages) > 0 {
+			return &StockError{Shortages: shortages}
+		}
+		return nil
+	})
+}
+
+// DeleteBook deletes a book by ID
+func (s *SQLBookStore) DeleteBook(ctx context.Context, id int) error {
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM book_genres WHERE book_id = ?`, id); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `DELETE FROM books WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		return checkRowsAffected(result, EntityBook, id)
+	})
+}
+
+// SearchBooks searches for books based on criteria. Every criterion is
+// evaluated by the database.
+func (s *SQLBookStore) SearchBooks(ctx context.Context, criteria models.SearchCriteria) ([]models.Book, error) {
+	var conditions []string
+	var args []interface{}
+	if criteria.Title != "" {
+		conditions = append(conditions, `LOWER(b.title) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(criteria.Title))+"%")
+	}
+	if criteria.AuthorID != 0 {
+		conditions = append(conditions, `b.author_id = ?`)
+		args = append(args, criteria.AuthorID)
+	}
+	if criteria.Genre != "" {
+		conditions = append(conditions,
+			`EXISTS (SELECT 1 FROM book_genres g WHERE g.book_id = b.id AND g.genre = ? COLLATE NOCASE)`)
+		args = append(args, criteria.Genre)
+	}
+	// Prices in another currency than a bound cannot be compared with it
+	if criteria.MinPrice.Amount > 0 {
+		conditions = append(conditions, `b.currency = ? AND b.price_minor >= ?`)
+		args = append(args, criteria.MinPrice.Currency, criteria.MinPrice.Amount)
+	}
+	if criteria.MaxPrice.Amount > 0 {
+		conditions = append(conditions, `b.currency = ? AND b.price_minor <= ?`)
+		args = append(args, criteria.MaxPrice.Currency, criteria.MaxPrice.Amount)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	books, err := selectSQLBooks(ctx, s.db, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, nil
+	}
+	return books, nil
+}
+
+// GetAllBooks returns all books
+func (s *SQLBookStore) GetAllBooks(ctx context.Context) ([]models.Book, error) {
+	return selectSQLBooks(ctx, s.db, "")
+}
+
+// Entity returns the name of the records held by the store
+func (s *SQLBookStore) Entity() string {
+	return EntityBook
+}
+
+// Snapshot captures the books and the next ID for persistence
+func (s *SQLBookStore) Snapshot() (interfaces.Snapshot, error) {
+	ctx := context.Background()
+	var snapshot interfaces.Snapshot
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		books, err := selectSQLBooks(ctx, tx, "")
+		if err != nil {
+			return err
+		}
+		nextID, err := readSQLSequence(ctx, tx, EntityBook)
+		if err != nil {
+			return err
+		}
+		records := make(map[int]models.Book, len(books))
+		for _, book := range books {
+			records[book.ID] = book
+		}
+		snapshot, err = snapshotRecords(records, nextID)
+		return err
+	})
+	return snapshot, err
+}
+
+// Restore replaces the books with the contents of a snapshot
+func (s *SQLBookStore) Restore(snapshot interfaces.Snapshot) error {
+	ctx := context.Background()
+	books, nextID, err := restoreRecords[models.Book](EntityBook, snapshot)
+	if err != nil {
+		return err
+	}
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM book_genres`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM books`); err != nil {
+			return err
+		}
+		for id, book := range books {
+			book.ID = id
+			if err := insertSQLBook(ctx, tx, book); err != nil {
+				return err
+			}
+		}
+		return setSQLSequence(ctx, tx, EntityBook, nextID)
+	})
+}
+
+// insertSQLBook inserts a book row and its genres
+func insertSQLBook(ctx context.Context, tx *sql.Tx, book models.Book) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO books (id, title, author_id, published_at, price_minor, currency, stock)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, book.ID, book.Title, book.Author.ID, formatSQLTime(book.PublishedAt),
+		book.Price.Amount, book.Price.Currency, book.Stock)
+	if err != nil {
+		return err
+	}
+	return insertSQLGenres(ctx, tx, book)
+}
+
+// insertSQLGenres inserts the genres of a book, keeping their order
+func insertSQLGenres(ctx context.Context, tx *sql.Tx, book models.Book) error {
+	for position, genre := range book.Genres {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO book_genres (book_id, position, genre) VALUES (?, ?, ?)`,
+			book.ID, position, genre); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectSQLBooks reads the books matching where, in ID order, with their
+// authors and genres
+func selectSQLBooks(ctx context.Context, q sqlQuerier, where string, args ...interface{}) ([]models.Book, error) {
+	rows, err := q.QueryContext(ctx, sqlBookColumns+where+` ORDER BY b.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []models.Book{}
+	for rows.Next() {
+		var book models.Book
+		var publishedAt string
+		if err := rows.Scan(&book.ID, &book.Title, &book.Author.ID, &book.Author.FirstName, &book.Author.LastName,
+			&book.Author.Bio, &publishedAt, &book.Price.Amount, &book.Price.Currency, &book.Stock); err != nil {
+			return nil, err
+		}
+		if book.PublishedAt, err = parseSQLTime(publishedAt); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := loadSQLGenres(ctx, q, books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// loadSQLGenres fills in the genres of books
+func loadSQLGenres(ctx context.Context, q sqlQuerier, books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	positions := make(map[int]int, len(books))
+	ids := make([]int, len(books))
+	for i, book := range books {
+		positions[book.ID] = i
+		ids[i] = book.ID
+	}
+
+	for _, chunk := range chunkIDs(ids) {
+		rows, err := q.QueryContext(ctx, `SELECT book_id, genre FROM book_genres WHERE book_id IN (`+
+			sqlPlaceholders(len(chunk))+`) ORDER BY book_id, position`, sqlIntArgs(chunk)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var bookID int
+			var genre string
+			if err := rows.Scan(&bookID, &genre); err != nil {
+				rows.Close()
+				return err
+			}
+			i := positions[bookID]
+			books[i].Genres = append(books[i].Genres, genre)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.BookStore   = (*SQLBookStore)(nil)
+	_ interfaces.Snapshotter = (*SQLBookStore)(nil)
+)
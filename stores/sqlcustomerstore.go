@@ -0,0 +1,196 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+)
+
+// sqlCustomerColumns selects a customer joined with their address
+const sqlCustomerColumns = `SELECT c.id, c.name, c.email, c.created_at, COALESCE(a.street, ''), COALESCE(a.city, ''),
+	COALESCE(a.state, ''), COALESCE(a.postal_code, ''), COALESCE(a.country, '')
+	FROM customers c LEFT JOIN addresses a ON a.customer_id = c.id`
+
+// SQLCustomerStore implements CustomerStore on a SQL database
+type SQLCustomerStore struct {
+	db *sql.DB
+}
+
+// NewSQLCustomerStore creates a customer store backed by db. The schema must
+// have been brought up to date with MigrateSQL.
+func NewSQLCustomerStore(db *sql.DB) *SQLCustomerStore {
+	return &SQLCustomerStore{db: db}
+}
+
+// CreateCustomer creates a new customer
+func (s *SQLCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		id, err := nextSQLID(ctx, tx, EntityCustomer)
+		if err != nil {
+			return err
+		}
+		customer.ID = id
+		return insertSQLCustomer(ctx, tx, customer)
+	})
+	if err != nil {
+		return models.Customer{}, err
+	}
+	return customer, nil
+}
+
+// GetCustomer retrieves a customer by ID
+func (s *SQLCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	customers, err := selectSQLCustomers(ctx, s.db, ` WHERE c.id = ?`, id)
+	if err != nil {
+		return models.Customer{}, err
+	}
+	if len(customers) == 0 {
+		return models.Customer{}, notFoundError(EntityCustomer, id)
+	}
+	return customers[0], nil
+}
+
+// UpdateCustomer updates an existing customer
+func (s *SQLCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
+	customer.ID = id
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE customers SET name = ?, email = ?, created_at = ? WHERE id = ?`,
+			customer.Name, customer.Email, formatSQLTime(customer.CreatedAt), id)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(result, EntityCustomer, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM addresses WHERE customer_id = ?`, id); err != nil {
+			return err
+		}
+		return insertSQLAddress(ctx, tx, customer)
+	})
+	if err != nil {
+		return models.Customer{}, err
+	}
+	return customer, nil
+}
+
+// DeleteCustomer deletes a customer by ID
+func (s *SQLCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM addresses WHERE customer_id = ?`, id); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `DELETE FROM customers WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		return checkRowsAffected(result, EntityCustomer, id)
+	})
+}
+
+// GetAllCustomers returns all customers
+func (s *SQLCustomerStore) GetAllCustomers(ctx context.Context) ([]models.Customer, error) {
+	return selectSQLCustomers(ctx, s.db, "")
+}
+
+// Entity returns the name of the records held by the store
+func (s *SQLCustomerStore) Entity() string {
+	return EntityCustomer
+}
+
+// Snapshot captures the customers and the next ID for persistence
+func (s *SQLCustomerStore) Snapshot() (interfaces.Snapshot, error) {
+	ctx := context.Background()
+	var snapshot interfaces.Snapshot
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		customers, err := selectSQLCustomers(ctx, tx, "")
+		if err != nil {
+			return err
+		}
+		nextID, err := readSQLSequence(ctx, tx, EntityCustomer)
+		if err != nil {
+			return err
+		}
+		records := make(map[int]models.Customer, len(customers))
+		for _, customer := range customers {
+			records[customer.ID] = customer
+		}
+		snapshot, err = snapshotRecords(records, nextID)
+		return err
+	})
+	return snapshot, err
+}
+
+// Restore replaces the customers with the contents of a snapshot
+func (s *SQLCustomerStore) Restore(snapshot interfaces.Snapshot) error {
+	ctx := context.Background()
+	customers, nextID, err := restoreRecords[models.Customer](EntityCustomer, snapshot)
+	if err != nil {
+		return err
+	}
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM addresses`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM customers`); err != nil {
+			return err
+		}
+		for id, customer := range customers {
+			customer.ID = id
+			if err := insertSQLCustomer(ctx, tx, customer); err != nil {
+				return err
+			}
+		}
+		return setSQLSequence(ctx, tx, EntityCustomer, nextID)
+	})
+}
+
+// insertSQLCustomer inserts a customer row and their address
+func insertSQLCustomer(ctx context.Context, tx *sql.Tx, customer models.Customer) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO customers (id, name, email, created_at) VALUES (?, ?, ?, ?)`,
+		customer.ID, customer.Name, customer.Email, formatSQLTime(customer.CreatedAt))
+	if err != nil {
+		return err
+	}
+	return insertSQLAddress(ctx, tx, customer)
+}
+
+// insertSQLAddress inserts the address of a customer
+func insertSQLAddress(ctx context.Context, tx *sql.Tx, customer models.Customer) error {
+	address := customer.Address
+	_, err := tx.ExecContext(ctx, `INSERT INTO addresses (customer_id, street, city, state, postal_code, country)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		customer.ID, address.Street, address.City, address.State, address.PostalCode, address.Country)
+	return err
+}
+
+// selectSQLCustomers reads the customers matching where, in ID order
+func selectSQLCustomers(ctx context.Context, q sqlQuerier, where string, args ...interface{}) ([]models.Customer, error) {
+	rows, err := q.QueryContext(ctx, sqlCustomerColumns+where+` ORDER BY c.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []models.Customer{}
+	for rows.Next() {
+		var customer models.Customer
+		var createdAt string
+		address := &customer.Address
+		if err := rows.Scan(&customer.ID, &customer.Name, &customer.Email, &createdAt, &address.Street,
+			&address.City, &address.State, &address.PostalCode, &address.Country); err != nil {
+			return nil, err
+		}
+		if customer.CreatedAt, err = parseSQLTime(createdAt); err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.CustomerStore = (*SQLCustomerStore)(nil)
+	_ interfaces.Snapshotter   = (*SQLCustomerStore)(nil)
+)
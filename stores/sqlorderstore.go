@@ -0,0 +1,483 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"online-bookstore-api/interfaces"
+	"online-bookstore-api/models"
+	"time"
+)
+
+// SQLOrderStore implements OrderStore on a SQL database. Orders keep their
+// customer and every line its book as they were when ordered, in columns of
+// orders and order_items and in order_item_genres, so later changes to the
+// customer or the catalog do not alter them. Book stock and author
+// biographies are not kept. Status changes are kept in order_status_history. Orders are indexed by
+// creation time, so range queries read only the orders in the range.
+type SQLOrderStore struct {
+	db *sql.DB
+}
+
+// NewSQLOrderStore creates an order store backed by db. The schema must have
+// been brought up to date with MigrateSQL.
+func NewSQLOrderStore(db *sql.DB) *SQLOrderStore {
+	return &SQLOrderStore{db: db}
+}
+
+// CreateOrder creates a new order
+func (s *SQLOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		id, err := nextSQLID(ctx, tx, EntityOrder)
+		if err != nil {
+			return err
+		}
+		order.ID = id
+		return insertSQLOrder(ctx, tx, order)
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// GetOrder retrieves an order by ID
+func (s *SQLOrderStore) GetOrder(ctx context.Context, id int) (models.Order, error) {
+	orders, err := selectSQLOrders(ctx, s.db, ` WHERE id = ?`, []interface{}{id}, 1)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if len(orders) == 0 {
+		return models.Order{}, notFoundError(EntityOrder, id)
+	}
+	return orders[0], nil
+}
+
+// UpdateOrder updates an existing order
+func (s *SQLOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	order.ID = id
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE orders SET customer_id = ?, total_minor = ?, currency = ?, created_at = ?, status = ?,
+			customer_name = ?, customer_email = ?, customer_created_at = ?, customer_street = ?, customer_city = ?,
+			customer_state = ?, customer_postal_code = ?, customer_country = ?
+			WHERE id = ?`, append(append([]interface{}{order.Customer.ID, order.TotalPrice.Amount, order.TotalPrice.Currency,
+			formatSQLTime(order.CreatedAt), order.Status}, sqlCustomerSnapshot(order.Customer)...), id)...)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(result, EntityOrder, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_item_genres WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_items WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_status_history WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if err := insertSQLOrderItems(ctx, tx, order); err != nil {
+			return err
+		}
+		return insertSQLOrderHistory(ctx, tx, order)
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// DeleteOrder deletes an order by ID
+func (s *SQLOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_item_genres WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_items WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_status_history WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `DELETE FROM orders WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		return checkRowsAffected(result, EntityOrder, id)
+	})
+}
+
+// GetAllOrders returns all orders, oldest first
+func (s *SQLOrderStore) GetAllOrders(ctx context.Context) ([]models.Order, error) {
+	orders := []models.Order{}
+	err := scanSQLOrders(ctx, s.db, "1 = 1", nil, func(order models.Order) error {
+		orders = append(orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetOrdersInTimeRange retrieves orders within a time range
+func (s *SQLOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
+	var results []models.Order
+	err := s.ForEachOrderInTimeRange(ctx, start, end, func(order models.Order) error {
+		results = append(results, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ForEachOrderInTimeRange streams orders within a time range, oldest first.
+// Orders are read a page at a time with an index range scan, so no query
+// stays open while fn runs.
+func (s *SQLOrderStore) ForEachOrderInTimeRange(ctx context.Context, start, end time.Time, fn func(models.Order) error) error {
+	return scanSQLOrders(ctx, s.db, "created_at >= ? AND created_at <= ?",
+		[]interface{}{formatSQLTime(start), formatSQLTime(end)}, fn)
+}
+
+// GetOrdersChangedInTimeRange returns the orders whose status changed to one
+// of statuses within [start, end), ordered by ID. The changes are found with
+// the index on new_status and changed_at.
+func (s *SQLOrderStore) GetOrdersChangedInTimeRange(ctx context.Context, statuses []string, start, end time.Time) ([]models.Order, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, 0, len(statuses)+2)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, formatSQLTime(start), formatSQLTime(end))
+
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT order_id FROM order_status_history
+		WHERE new_status IN (`+sqlPlaceholders(len(statuses))+`) AND changed_at >= ? AND changed_at < ?
+		ORDER BY order_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	var orders []models.Order
+	for _, chunk := range chunkIDs(ids) {
+		page, err := selectSQLOrders(ctx, s.db, ` WHERE id IN (`+sqlPlaceholders(len(chunk))+`) ORDER BY id`,
+			sqlIntArgs(chunk), len(chunk))
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+	}
+	return orders, nil
+}
+
+// Entity returns the name of the records held by the store
+func (s *SQLOrderStore) Entity() string {
+	return EntityOrder
+}
+
+// Snapshot captures the orders and the next ID for persistence
+func (s *SQLOrderStore) Snapshot() (interfaces.Snapshot, error) {
+	ctx := context.Background()
+	var snapshot interfaces.Snapshot
+	err := withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		records := make(map[int]models.Order)
+		err := scanSQLOrders(ctx, tx, "1 = 1", nil, func(order models.Order) error {
+			records[order.ID] = order
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		nextID, err := readSQLSequence(ctx, tx, EntityOrder)
+		if err != nil {
+			return err
+		}
+		snapshot, err = snapshotRecords(records, nextID)
+		return err
+	})
+	return snapshot, err
+}
+
+// Restore replaces the orders with the contents of a snapshot
+func (s *SQLOrderStore) Restore(snapshot interfaces.Snapshot) error {
+	ctx := context.Background()
+	orders, nextID, err := restoreRecords[models.Order](EntityOrder, snapshot)
+	if err != nil {
+		return err
+	}
+	return withSQLTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_item_genres`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_items`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM order_status_history`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM orders`); err != nil {
+			return err
+		}
+		for id, order := range orders {
+			order.ID = id
+			if err := insertSQLOrder(ctx, tx, order); err != nil {
+				return err
+			}
+		}
+		return setSQLSequence(ctx, tx, EntityOrder, nextID)
+	})
+}
+
+// insertSQLOrder inserts an order row with its items and history
+func insertSQLOrder(ctx context.Context, tx *sql.Tx, order models.Order) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO orders (id, customer_id, total_minor, currency, created_at, status,
+		customer_name, customer_email, customer_created_at, customer_street, customer_city, customer_state,
+		customer_postal_code, customer_country) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append([]interface{}{order.ID, order.Customer.ID, order.TotalPrice.Amount, order.TotalPrice.Currency,
+			formatSQLTime(order.CreatedAt), order.Status}, sqlCustomerSnapshot(order.Customer)...)...)
+	if err != nil {
+		return err
+	}
+	if err := insertSQLOrderItems(ctx, tx, order); err != nil {
+		return err
+	}
+	return insertSQLOrderHistory(ctx, tx, order)
+}
+
+// insertSQLOrderHistory inserts the status changes of an order, keeping their order
+func insertSQLOrderHistory(ctx context.Context, tx *sql.Tx, order models.Order) error {
+	for position, change := range order.History {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO order_status_history
+			(order_id, position, changed_at, previous_status, new_status, actor, note) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, position, formatSQLTime(change.Timestamp), change.PreviousStatus, change.NewStatus,
+			change.Actor, change.Note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlCustomerSnapshot returns the values of the customer columns of orders,
+// in the order they are listed in statements
+func sqlCustomerSnapshot(customer models.Customer) []interface{} {
+	return []interface{}{customer.Name, customer.Email, formatSQLTime(customer.CreatedAt), customer.Address.Street,
+		customer.Address.City, customer.Address.State, customer.Address.PostalCode, customer.Address.Country}
+}
+
+// insertSQLOrderItems inserts the lines of an order with their books,
+// keeping their order
+func insertSQLOrderItems(ctx context.Context, tx *sql.Tx, order models.Order) error {
+	for position, item := range order.Items {
+		book := item.Book
+		// The title of the book is read back from the line's title
+		title := item.Title
+		if title == "" {
+			title = book.Title
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO order_items
+			(order_id, position, book_id, quantity, unit_price_minor, title, author_name,
+			author_id, author_first_name, author_last_name, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, position, book.ID, item.Quantity, item.Price().Amount, title, item.AuthorName,
+			book.Author.ID, book.Author.FirstName, book.Author.LastName, formatSQLTime(book.PublishedAt)); err != nil {
+			return err
+		}
+		for genrePosition, genre := range book.Genres {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO order_item_genres (order_id, item_position, position, genre)
+				VALUES (?, ?, ?, ?)`, order.ID, position, genrePosition, genre); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanSQLOrders calls fn for every order matching cond, ordered by creation
+// time and ID. Orders are fetched in pages using the last row seen as the
+// lower bound, which keeps every page an index range scan.
+func scanSQLOrders(ctx context.Context, q sqlQuerier, cond string, args []interface{}, fn func(models.Order) error) error {
+	afterTime, afterID := "", 0
+	for {
+		pageArgs := append(append([]interface{}(nil), args...), afterTime, afterTime, afterID)
+		orders, err := selectSQLOrders(ctx, q,
+			` WHERE `+cond+` AND (created_at > ? OR (created_at = ? AND id > ?)) ORDER BY created_at, id`,
+			pageArgs, sqlBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if err := fn(order); err != nil {
+				return err
+			}
+		}
+		if len(orders) < sqlBatchSize {
+			return nil
+		}
+		last := orders[len(orders)-1]
+		afterTime, afterID = formatSQLTime(last.CreatedAt), last.ID
+	}
+}
+
+// selectSQLOrders reads up to limit orders matching the given clause with
+// their customers and items
+func selectSQLOrders(ctx context.Context, q sqlQuerier, clause string, args []interface{}, limit int) ([]models.Order, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, customer_id, total_minor, currency, created_at, status,
+		customer_name, customer_email, customer_created_at, customer_street, customer_city, customer_state,
+		customer_postal_code, customer_country FROM orders`+clause+` LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		var createdAt, customerCreatedAt string
+		customer := &order.Customer
+		if err := rows.Scan(&order.ID, &customer.ID, &order.TotalPrice.Amount, &order.TotalPrice.Currency,
+			&createdAt, &order.Status, &customer.Name, &customer.Email, &customerCreatedAt, &customer.Address.Street,
+			&customer.Address.City, &customer.Address.State, &customer.Address.PostalCode, &customer.Address.Country); err != nil {
+			return nil, err
+		}
+		if order.CreatedAt, err = parseSQLTime(createdAt); err != nil {
+			return nil, err
+		}
+		if customer.CreatedAt, err = parseSQLTime(customerCreatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := loadSQLOrderDetails(ctx, q, orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// loadSQLOrderDetails fills in the items and history of orders
+func loadSQLOrderDetails(ctx context.Context, q sqlQuerier, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	positions := make(map[int]int, len(orders))
+	orderIDs := make([]int, len(orders))
+	for i, order := range orders {
+		positions[order.ID] = i
+		orderIDs[i] = order.ID
+	}
+
+	for _, chunk := range chunkIDs(orderIDs) {
+		rows, err := q.QueryContext(ctx, `SELECT order_id, book_id, quantity, unit_price_minor, title, author_name,
+			author_id, author_first_name, author_last_name, published_at FROM order_items WHERE order_id IN (`+
+			sqlPlaceholders(len(chunk))+`) ORDER BY order_id, position`, sqlIntArgs(chunk)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var orderID int
+			var publishedAt string
+			var item models.OrderItem
+			book := &item.Book
+			if err := rows.Scan(&orderID, &book.ID, &item.Quantity, &item.UnitPrice.Amount, &item.Title, &item.AuthorName,
+				&book.Author.ID, &book.Author.FirstName, &book.Author.LastName, &publishedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			if book.PublishedAt, err = parseSQLTime(publishedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			// Lines are in the currency of their order, and their book carries
+			// the price it was sold at
+			i := positions[orderID]
+			item.UnitPrice.Currency = orders[i].TotalPrice.Currency
+			book.Title, book.Price = item.Title, item.UnitPrice
+			orders[i].Items = append(orders[i].Items, item)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, chunk := range chunkIDs(orderIDs) {
+		rows, err := q.QueryContext(ctx, `SELECT order_id, item_position, genre FROM order_item_genres WHERE order_id IN (`+
+			sqlPlaceholders(len(chunk))+`) ORDER BY order_id, item_position, position`, sqlIntArgs(chunk)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var orderID, itemPosition int
+			var genre string
+			if err := rows.Scan(&orderID, &itemPosition, &genre); err != nil {
+				rows.Close()
+				return err
+			}
+			book := &orders[positions[orderID]].Items[itemPosition].Book
+			book.Genres = append(book.Genres, genre)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, chunk := range chunkIDs(orderIDs) {
+		rows, err := q.QueryContext(ctx, `SELECT order_id, changed_at, previous_status, new_status, actor, note
+			FROM order_status_history WHERE order_id IN (`+sqlPlaceholders(len(chunk))+`) ORDER BY order_id, position`,
+			sqlIntArgs(chunk)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var orderID int
+			var changedAt string
+			var change models.OrderStatusChange
+			if err := rows.Scan(&orderID, &changedAt, &change.PreviousStatus, &change.NewStatus, &change.Actor, &change.Note); err != nil {
+				rows.Close()
+				return err
+			}
+			if change.Timestamp, err = parseSQLTime(changedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			i := positions[orderID]
+			orders[i].History = append(orders[i].History, change)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify interface implementation
+var (
+	_ interfaces.OrderStore       = (*SQLOrderStore)(nil)
+	_ interfaces.OrderIterator    = (*SQLOrderStore)(nil)
+	_ interfaces.OrderStatusIndex = (*SQLOrderStore)(nil)
+	_ interfaces.Snapshotter      = (*SQLOrderStore)(nil)
+)
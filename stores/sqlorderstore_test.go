@@ -0,0 +1,72 @@
+package stores
+
+import (
+	"context"
+	"online-bookstore-api/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLOrderSnapshotsSurviveEdits(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQL(t)
+	if _, err := MigrateSQL(db); err != nil {
+		t.Fatalf("MigrateSQL error = %v", err)
+	}
+	authors, books, customers := NewSQLAuthorStore(db), NewSQLBookStore(db), NewSQLCustomerStore(db)
+	author, err := authors.CreateAuthor(ctx, models.Author{FirstName: "Ada", LastName: "Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(1843, 10, 1, 0, 0, 0, 0, time.UTC)
+	book, err := books.CreateBook(ctx, models.Book{Title: "Notes", Author: author, Genres: []string{"Science", "History"},
+		PublishedAt: published, Price: models.Cents(1000), Stock: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer, err := customers.CreateCustomer(ctx, models.Customer{Name: "Reader", Email: "reader@example.com",
+		Address: models.Address{City: "Lyon", Country: "FR"}, CreatedAt: published})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The customer is filled in by the handler, the lines by pricing
+	store := PricingOrderStore(NewSQLOrderStore(db), books, authors)
+	order, err := store.CreateOrder(ctx, models.Order{Customer: customer,
+		Items: []models.OrderItem{{Book: models.Book{ID: book.ID}, Quantity: 2}}})
+	if err != nil {
+		t.Fatalf("CreateOrder error = %v", err)
+	}
+
+	book.Title, book.Genres, book.Price = "Notes, Revised", []string{"Poetry"}, models.Cents(5000)
+	if _, err := books.UpdateBook(ctx, book.ID, book); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authors.UpdateAuthor(ctx, author.ID, models.Author{FirstName: "Augusta", LastName: "King"}); err != nil {
+		t.Fatal(err)
+	}
+	customer.Name, customer.Address.Country = "Renamed", "DE"
+	if _, err := customers.UpdateCustomer(ctx, customer.ID, customer); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("GetOrder error = %v", err)
+	}
+	if got := stored.Customer; got.Name != "Reader" || got.Email != "reader@example.com" ||
+		got.Address != (models.Address{City: "Lyon", Country: "FR"}) || !got.CreatedAt.Equal(published) {
+		t.Errorf("customer = %+v, want the customer as ordered", got)
+	}
+	item := stored.Items[0]
+	if item.Title != "Notes" || item.AuthorName != "Ada Lovelace" || item.UnitPrice != models.Cents(1000) {
+		t.Errorf("line = %q %q %v, want \"Notes\" \"Ada Lovelace\" 10.00", item.Title, item.AuthorName, item.UnitPrice)
+	}
+	got := item.Book
+	if got.ID != book.ID || got.Title != "Notes" || got.Author.ID != author.ID || got.Author.FirstName != "Ada" ||
+		!reflect.DeepEqual(got.Genres, []string{"Science", "History"}) || !got.PublishedAt.Equal(published) ||
+		got.Price != models.Cents(1000) {
+		t.Errorf("line book = %+v, want the book as ordered", got)
+	}
+}
@@ -0,0 +1,249 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlMigration is one versioned step of the SQL schema. Migrations are
+// applied in order, each in its own transaction, and recorded in
+// schema_migrations so every step runs exactly once.
+type sqlMigration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// sqlMigrations is the schema history. Append new steps; never edit one that
+// has been released.
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		name:    "create bookstore tables",
+		statements: []string{
+			`CREATE TABLE authors (
+				id         INTEGER PRIMARY KEY,
+				first_name TEXT NOT NULL,
+				last_name  TEXT NOT NULL,
+				bio        TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE books (
+				id           INTEGER PRIMARY KEY,
+				title        TEXT NOT NULL,
+				author_id    INTEGER NOT NULL,
+				published_at TEXT NOT NULL,
+				price        REAL NOT NULL,
+				stock        INTEGER NOT NULL
+			)`,
+			`CREATE INDEX books_author_id ON books (author_id)`,
+			`CREATE TABLE book_genres (
+				book_id  INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+				position INTEGER NOT NULL,
+				genre    TEXT NOT NULL,
+				PRIMARY KEY (book_id, position)
+			)`,
+			`CREATE INDEX book_genres_genre ON book_genres (genre COLLATE NOCASE)`,
+			`CREATE TABLE customers (
+				id         INTEGER PRIMARY KEY,
+				name       TEXT NOT NULL,
+				email      TEXT NOT NULL,
+				created_at TEXT NOT NULL
+			)`,
+			`CREATE TABLE addresses (
+				customer_id INTEGER PRIMARY KEY REFERENCES customers (id) ON DELETE CASCADE,
+				street      TEXT NOT NULL DEFAULT '',
+				city        TEXT NOT NULL DEFAULT '',
+				state       TEXT NOT NULL DEFAULT '',
+				postal_code TEXT NOT NULL DEFAULT '',
+				country     TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE orders (
+				id          INTEGER PRIMARY KEY,
+				customer_id INTEGER NOT NULL,
+				total_price REAL NOT NULL,
+				created_at  TEXT NOT NULL,
+				status      TEXT NOT NULL
+			)`,
+			`CREATE INDEX orders_created_at ON orders (created_at, id)`,
+			`CREATE INDEX orders_customer_id ON orders (customer_id)`,
+			`CREATE TABLE order_items (
+				order_id   INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				book_id    INTEGER NOT NULL,
+				quantity   INTEGER NOT NULL,
+				unit_price REAL NOT NULL,
+				PRIMARY KEY (order_id, position)
+			)`,
+			`CREATE INDEX order_items_book_id ON order_items (book_id)`,
+			`CREATE TABLE id_sequences (
+				entity  TEXT PRIMARY KEY,
+				next_id INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "add order status history",
+		statements: []string{
+			`CREATE TABLE order_status_history (
+				order_id        INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+				position        INTEGER NOT NULL,
+				changed_at      TEXT NOT NULL,
+				previous_status TEXT NOT NULL DEFAULT '',
+				new_status      TEXT NOT NULL,
+				actor           TEXT NOT NULL DEFAULT '',
+				note            TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (order_id, position)
+			)`,
+			`CREATE INDEX order_status_history_new_status ON order_status_history (new_status, changed_at)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "add order line snapshots",
+		statements: []string{
+			`ALTER TABLE order_items ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE order_items ADD COLUMN author_name TEXT NOT NULL DEFAULT ''`,
+			// Existing lines take the current catalog details, the closest
+			// record of what was bought
+			`UPDATE order_items SET
+				title = COALESCE((SELECT title FROM books WHERE books.id = order_items.book_id), ''),
+				author_name = COALESCE((SELECT TRIM(authors.first_name || ' ' || authors.last_name)
+					FROM books JOIN authors ON authors.id = books.author_id
+					WHERE books.id = order_items.book_id), '')`,
+		},
+	},
+	{
+		version: 4,
+		name:    "store money as minor units",
+		statements: []string{
+			// Amounts become whole cents with a currency code; an empty code
+			// is models.DefaultCurrency. Order lines share their order's currency.
+			`ALTER TABLE books ADD COLUMN price_minor INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE books ADD COLUMN currency TEXT NOT NULL DEFAULT ''`,
+			`UPDATE books SET price_minor = CAST(ROUND(price * 100) AS INTEGER)`,
+			`ALTER TABLE books DROP COLUMN price`,
+			`ALTER TABLE orders ADD COLUMN total_minor INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT ''`,
+			`UPDATE orders SET total_minor = CAST(ROUND(total_price * 100) AS INTEGER)`,
+			`ALTER TABLE orders DROP COLUMN total_price`,
+			`ALTER TABLE order_items ADD COLUMN unit_price_minor INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE order_items SET unit_price_minor = CAST(ROUND(unit_price * 100) AS INTEGER)`,
+			`ALTER TABLE order_items DROP COLUMN unit_price`,
+		},
+	},
+	{
+		version: 5,
+		name:    "add order status history to older orders",
+		statements: []string{
+			// Orders written before version 2 have no history rows; they are
+			// given the statuses leading to their current one, dated at their
+			// creation, as the JSON database migration does. Unknown statuses
+			// get a single row.
+			`WITH implied_paths (status, position, previous_status, new_status) AS (VALUES
+				('pending', 0, '', 'pending'),
+				('paid', 0, '', 'pending'), ('paid', 1, 'pending', 'paid'),
+				('shipped', 0, '', 'pending'), ('shipped', 1, 'pending', 'paid'), ('shipped', 2, 'paid', 'shipped'),
+				('delivered', 0, '', 'pending'), ('delivered', 1, 'pending', 'paid'),
+				('delivered', 2, 'paid', 'shipped'), ('delivered', 3, 'shipped', 'delivered'),
+				('cancelled', 0, '', 'pending'), ('cancelled', 1, 'pending', 'cancelled'),
+				('refunded', 0, '', 'pending'), ('refunded', 1, 'pending', 'paid'),
+				('refunded', 2, 'paid', 'shipped'), ('refunded', 3, 'shipped', 'refunded'))
+			INSERT INTO order_status_history (order_id, position, changed_at, previous_status, new_status, note)
+			SELECT orders.id, COALESCE(implied_paths.position, 0), orders.created_at,
+				COALESCE(implied_paths.previous_status, ''), COALESCE(implied_paths.new_status, orders.status),
+				'recorded by database migration; the order''s creation time stands in for the change time'
+			FROM orders LEFT JOIN implied_paths ON implied_paths.status = orders.status
+			WHERE NOT EXISTS (SELECT 1 FROM order_status_history WHERE order_status_history.order_id = orders.id)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "add order customer and book snapshots",
+		statements: []string{
+			// Orders keep the customer and books as they were when ordered,
+			// as the other backends do, instead of joining the current rows
+			`ALTER TABLE orders ADD COLUMN customer_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_email TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_created_at TEXT NOT NULL DEFAULT '0001-01-01T00:00:00.000000000Z'`,
+			`ALTER TABLE orders ADD COLUMN customer_street TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_city TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_state TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_postal_code TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN customer_country TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE order_items ADD COLUMN author_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE order_items ADD COLUMN author_first_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE order_items ADD COLUMN author_last_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE order_items ADD COLUMN published_at TEXT NOT NULL DEFAULT '0001-01-01T00:00:00.000000000Z'`,
+			`CREATE TABLE order_item_genres (
+				order_id      INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+				item_position INTEGER NOT NULL,
+				position      INTEGER NOT NULL,
+				genre         TEXT NOT NULL,
+				PRIMARY KEY (order_id, item_position, position)
+			)`,
+			// Existing orders take the current details, the closest record of
+			// what was ordered; those of deleted customers and books stay empty
+			`UPDATE orders SET customer_name = customers.name, customer_email = customers.email,
+				customer_created_at = customers.created_at
+				FROM customers WHERE customers.id = orders.customer_id`,
+			`UPDATE orders SET customer_street = addresses.street, customer_city = addresses.city,
+				customer_state = addresses.state, customer_postal_code = addresses.postal_code,
+				customer_country = addresses.country
+				FROM addresses WHERE addresses.customer_id = orders.customer_id`,
+			`UPDATE order_items SET author_id = books.author_id, published_at = books.published_at
+				FROM books WHERE books.id = order_items.book_id`,
+			`UPDATE order_items SET author_first_name = authors.first_name, author_last_name = authors.last_name
+				FROM authors WHERE authors.id = order_items.author_id`,
+			`INSERT INTO order_item_genres (order_id, item_position, position, genre)
+				SELECT order_items.order_id, order_items.position, book_genres.position, book_genres.genre
+				FROM order_items JOIN book_genres ON book_genres.book_id = order_items.book_id`,
+		},
+	},
+}
+
+// MigrateSQL brings the schema of db up to date and returns the number of
+// migrations applied. It refuses to run against a schema newer than the
+// migrations this build knows about.
+func MigrateSQL(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	latest := sqlMigrations[len(sqlMigrations)-1].version
+	if current > latest {
+		return 0, fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latest)
+	}
+
+	applied := 0
+	for _, migration := range sqlMigrations {
+		if migration.version <= current {
+			continue
+		}
+		err := withSQLTx(context.Background(), db, func(tx *sql.Tx) error {
+			for _, statement := range migration.statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				migration.version, migration.name, formatSQLTime(time.Now()))
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", migration.version, migration.name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
@@ -0,0 +1,197 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"online-bookstore-api/models"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	// Pure Go SQLite driver for the SQL store tests
+	_ "modernc.org/sqlite"
+)
+
+// openTestSQL opens an empty SQLite database in a temporary directory
+func openTestSQL(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "bookstore.db") + "?_pragma=foreign_keys(1)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// migrateSQLTo applies the schema migrations up to version
+func migrateSQLTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+	all := sqlMigrations
+	defer func() { sqlMigrations = all }()
+	sqlMigrations = all[:version]
+	if _, err := MigrateSQL(db); err != nil {
+		t.Fatalf("MigrateSQL to version %d error = %v", version, err)
+	}
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	return version
+}
+
+func TestSQLMigrationsOrdered(t *testing.T) {
+	for i, migration := range sqlMigrations {
+		if migration.version != i+1 {
+			t.Errorf("sqlMigrations[%d] has version %d, want %d", i, migration.version, i+1)
+		}
+	}
+}
+
+func TestMigrateSQL(t *testing.T) {
+	latest := len(sqlMigrations)
+	tests := []struct {
+		name        string
+		from        int
+		wantApplied int
+	}{
+		{name: "empty database", from: 0, wantApplied: latest},
+		{name: "partly migrated", from: 2, wantApplied: latest - 2},
+		{name: "up to date", from: latest, wantApplied: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestSQL(t)
+			if tt.from > 0 {
+				migrateSQLTo(t, db, tt.from)
+			}
+
+			applied, err := MigrateSQL(db)
+			if err != nil {
+				t.Fatalf("MigrateSQL error = %v", err)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("applied = %d, want %d", applied, tt.wantApplied)
+			}
+			if version := schemaVersion(t, db); version != latest {
+				t.Errorf("schema version = %d, want %d", version, latest)
+			}
+		})
+	}
+}
+
+func TestMigrateSQLRefusesNewerSchema(t *testing.T) {
+	db := openTestSQL(t)
+	if _, err := MigrateSQL(db); err != nil {
+		t.Fatalf("MigrateSQL error = %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (99, 'future', '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateSQL(db); err == nil || !strings.Contains(err.Error(), "newer than this build supports") {
+		t.Errorf("MigrateSQL error = %v, want a newer schema error", err)
+	}
+}
+
+func TestMigrateSQLRollsBackFailedMigration(t *testing.T) {
+	db := openTestSQL(t)
+	all := sqlMigrations
+	defer func() { sqlMigrations = all }()
+	sqlMigrations = append(all[:len(all):len(all)], sqlMigration{
+		version:    len(all) + 1,
+		name:       "broken",
+		statements: []string{`CREATE TABLE broken (id INTEGER)`, `NOT SQL`},
+	})
+
+	applied, err := MigrateSQL(db)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("MigrateSQL error = %v, want the broken migration to fail", err)
+	}
+	if applied != len(all) {
+		t.Errorf("applied = %d, want %d", applied, len(all))
+	}
+	if version := schemaVersion(t, db); version != len(all) {
+		t.Errorf("schema version = %d, want %d", version, len(all))
+	}
+	var tables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'`).Scan(&tables)
+	if tables != 0 {
+		t.Errorf("statements of the failed migration were kept")
+	}
+}
+
+func TestMigrateSQLKeepsData(t *testing.T) {
+	db := openTestSQL(t)
+	migrateSQLTo(t, db, 2)
+
+	// Rows as written by a build at schema version 2
+	for _, statement := range []string{
+		`INSERT INTO authors (id, first_name, last_name) VALUES (1, 'Ada', 'Lovelace')`,
+		`INSERT INTO books (id, title, author_id, published_at, price, stock) VALUES (1, 'Notes', 1, '2020-01-01T00:00:00.000000000Z', 19.99, 3)`,
+		`INSERT INTO book_genres (book_id, position, genre) VALUES (1, 0, 'Science')`,
+		`INSERT INTO customers (id, name, email, created_at) VALUES (1, 'Reader', 'r@example.com', '2024-01-01T00:00:00.000000000Z')`,
+		`INSERT INTO addresses (customer_id, country) VALUES (1, 'FR')`,
+		`INSERT INTO orders (id, customer_id, total_price, created_at, status) VALUES (1, 1, 39.98, '2024-02-01T00:00:00.000000000Z', 'paid')`,
+		`INSERT INTO order_items (order_id, position, book_id, quantity, unit_price) VALUES (1, 0, 1, 2, 19.99)`,
+		`INSERT INTO id_sequences (entity, next_id) VALUES ('author', 2), ('book', 2), ('customer', 2), ('order', 2)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	if _, err := MigrateSQL(db); err != nil {
+		t.Fatalf("MigrateSQL error = %v", err)
+	}
+
+	ctx := context.Background()
+	book, err := NewSQLBookStore(db).GetBook(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetBook error = %v", err)
+	}
+	if book.Price != models.Cents(1999) {
+		t.Errorf("book price = %v, want 19.99", book.Price)
+	}
+
+	order, err := NewSQLOrderStore(db).GetOrder(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetOrder error = %v", err)
+	}
+	if order.TotalPrice != models.Cents(3998) {
+		t.Errorf("order total = %v, want 39.98", order.TotalPrice)
+	}
+	want := models.OrderItem{Quantity: 2, UnitPrice: models.Cents(1999), Title: "Notes", AuthorName: "Ada Lovelace"}
+	if len(order.Items) != 1 {
+		t.Fatalf("order has %d items, want 1", len(order.Items))
+	}
+	item := order.Items[0]
+	if item.Quantity != want.Quantity || item.UnitPrice != want.UnitPrice || item.Title != want.Title || item.AuthorName != want.AuthorName {
+		t.Errorf("order line = %d x %v %q %q, want %d x %v %q %q", item.Quantity, item.UnitPrice, item.Title, item.AuthorName,
+			want.Quantity, want.UnitPrice, want.Title, want.AuthorName)
+	}
+
+	if item.Book.Author.ID != 1 || item.Book.Author.LastName != "Lovelace" || !reflect.DeepEqual(item.Book.Genres, []string{"Science"}) {
+		t.Errorf("line book = %+v, want the catalog details", item.Book)
+	}
+	if order.Customer.Name != "Reader" || order.Customer.Address.Country != "FR" {
+		t.Errorf("customer = %+v, want the customer details", order.Customer)
+	}
+
+	// The order predates status histories and is given one
+	var statuses []string
+	for _, change := range order.History {
+		if !change.Timestamp.Equal(order.CreatedAt) || change.Note != migratedHistoryNote {
+			t.Errorf("status change = %+v, want one at the creation time with the migration note", change)
+		}
+		statuses = append(statuses, change.PreviousStatus+">"+change.NewStatus)
+	}
+	if got, want := strings.Join(statuses, " "), ">pending pending>paid"; got != want {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+}
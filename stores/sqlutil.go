@@ -0,0 +1,137 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlTimeLayout stores times as fixed-width UTC text, which stays readable
+// in ad-hoc queries and sorts chronologically so it can be range-indexed
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqlBatchSize bounds the number of IDs bound in one IN clause and the
+// number of orders read per page
+const sqlBatchSize = 500
+
+// formatSQLTime encodes a time for storage
+func formatSQLTime(t time.Time) string {
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+// parseSQLTime decodes a stored time
+func parseSQLTime(value string) (time.Time, error) {
+	t, err := time.Parse(sqlTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid stored time %q: %w", value, err)
+	}
+	return t, nil
+}
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withSQLTx runs fn in a transaction, committing if it succeeds. The
+// transaction is rolled back if ctx is cancelled before it commits.
+func withSQLTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// nextSQLID reserves the next ID of entity. IDs are never reused, matching
+// the in-memory stores.
+func nextSQLID(ctx context.Context, tx *sql.Tx, entity string) (int, error) {
+	id, err := readSQLSequence(ctx, tx, entity)
+	if err != nil {
+		return 0, err
+	}
+	if err := setSQLSequence(ctx, tx, entity, id+1); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// readSQLSequence returns the next ID of entity without reserving it
+func readSQLSequence(ctx context.Context, q sqlQuerier, entity string) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `SELECT next_id FROM id_sequences WHERE entity = ?`, entity).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s sequence: %w", entity, err)
+	}
+	return id, nil
+}
+
+// setSQLSequence sets the next ID of entity
+func setSQLSequence(ctx context.Context, q sqlQuerier, entity string, nextID int) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO id_sequences (entity, next_id) VALUES (?, ?)
+		ON CONFLICT (entity) DO UPDATE SET next_id = excluded.next_id`, entity, nextID)
+	if err != nil {
+		return fmt.Errorf("failed to update %s sequence: %w", entity, err)
+	}
+	return nil
+}
+
+// sqlPlaceholders returns n comma-separated placeholders for an IN clause
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// sqlIntArgs converts IDs to query arguments
+func sqlIntArgs(ids []int) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// chunkIDs splits ids into slices of at most sqlBatchSize
+func chunkIDs(ids []int) [][]int {
+	var chunks [][]int
+	for len(ids) > sqlBatchSize {
+		chunks = append(chunks, ids[:sqlBatchSize])
+		ids = ids[sqlBatchSize:]
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
+
+// checkRowsAffected turns an update or delete that matched no row into a
+// not found error
+func checkRowsAffected(result sql.Result, entity string, id int) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFoundError(entity, id)
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern; use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
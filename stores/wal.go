@@ -0,0 +1,408 @@
+package stores
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// walHeaderSize is the size of a record header: payload length and CRC-32
+const walHeaderSize = 8
+
+// opWALHeader marks the record that starts every log file. Its After field
+// holds the sequence number of the last record written before the file was
+// started.
+const opWALHeader = "header"
+
+// ErrWALMismatch is returned when the write-ahead log does not continue from
+// the snapshot that was loaded, e.g. after falling back to the backup
+// generation. Replaying it would leave out the records in between.
+var ErrWALMismatch = errors.New("write-ahead log does not continue from the snapshot")
+
+// walRecord is the payload of a write-ahead log record. Mutations are
+// numbered from 1 in Seq; records written before sequence numbers were
+// introduced have none.
+type walRecord struct {
+	Seq    int64           `json:"seq,omitempty"`
+	After  int64           `json:"after,omitempty"`
+	Entity string          `json:"entity"`
+	Op     string          `json:"op"`
+	ID     int             `json:"id"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Batch  []walRecord     `json:"batch,omitempty"`
+}
+
+// WALFilename returns the write-ahead log belonging to a database file
+func WALFilename(filename string) string {
+	return filename + ".wal"
+}
+
+// sealedWALFilename returns the log segment being folded into a snapshot
+func sealedWALFilename(walPath string) string {
+	return walPath + ".old"
+}
+
+// WAL is an append-only log of store mutations. Every mutation is appended
+// and synced before it is applied, so acknowledged writes survive a crash
+// between snapshots. Records are framed with their length and a CRC-32; a
+// torn record at the end of the log is discarded when the log is opened.
+// Every record carries a sequence number, which snapshots store to tell the
+// records they hold from the ones to replay.
+type WAL struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	seq    int64
+	broken error
+
+	// checkpointMu serializes checkpoints
+	checkpointMu sync.Mutex
+}
+
+// OpenWAL opens the log at path for appending, creating it if needed and
+// cutting off a torn final record left by a crash. Records are numbered on
+// from after, the sequence number LoadDatabase returned, or from the last
+// record in the log if that is higher.
+func OpenWAL(path string, after int64) (*WAL, error) {
+	seq := after
+	for _, segment := range []string{sealedWALFilename(path), path} {
+		if err := repairWAL(segment); err != nil {
+			return nil, err
+		}
+		records, _, err := readWAL(segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			seq = max(seq, record.Seq, record.After)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat write-ahead log: %w", err)
+	}
+	w := &WAL{path: path, file: file, size: info.Size(), seq: seq}
+	if w.size == 0 {
+		if err := w.writeHeader(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Sequence returns the sequence number of the last record appended. Read it
+// while writes are held off to know which records a snapshot holds.
+func (w *WAL) Sequence() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// Append logs a mutation and syncs it to disk. It matches MutationHook so it
+// can be registered on the stores directly.
+// A batch is written as a single record, so it is replayed whole or not at
+// all.
+func (w *WAL) Append(m Mutation) error {
+	record, err := newWALRecord(m)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.broken != nil {
+		return fmt.Errorf("write-ahead log unavailable: %w", w.broken)
+	}
+	record.Seq = w.seq + 1
+	if err := w.write(record); err != nil {
+		return err
+	}
+	w.seq = record.Seq
+	return nil
+}
+
+// writeHeader starts an empty log file with the sequence number it continues
+// from. The caller must hold w.mu, or own w exclusively.
+func (w *WAL) writeHeader() error {
+	return w.write(walRecord{Op: opWALHeader, After: w.seq})
+}
+
+// write frames a record, appends it and syncs the file. The caller must
+// hold w.mu, or own w exclusively.
+func (w *WAL) write(record walRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode write-ahead log record: %w", err)
+	}
+
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[walHeaderSize:], payload)
+
+	_, err = w.file.Write(frame)
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		// Drop the partial record so later appends do not follow garbage
+		if truncErr := w.file.Truncate(w.size); truncErr != nil {
+			w.broken = truncErr
+		}
+		return fmt.Errorf("failed to append to write-ahead log: %w", err)
+	}
+	w.size += int64(len(frame))
+	return nil
+}
+
+// newWALRecord encodes a mutation, and the mutations of a batch, as a record
+func newWALRecord(m Mutation) (walRecord, error) {
+	record := walRecord{Entity: m.Entity, Op: m.Op, ID: m.ID}
+	if m.Value != nil {
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return walRecord{}, fmt.Errorf("failed to encode %s %d for write-ahead log: %w", m.Entity, m.ID, err)
+		}
+		record.Value = value
+	}
+	for _, child := range m.Batch {
+		childRecord, err := newWALRecord(child)
+		if err != nil {
+			return walRecord{}, err
+		}
+		record.Batch = append(record.Batch, childRecord)
+	}
+	return record, nil
+}
+
+// Checkpoint seals the current log, runs save to write a snapshot and, if it
+// succeeds, deletes the sealed records. Mutations continue into a fresh log
+// while the snapshot is written; records the snapshot already holds are
+// skipped by their sequence number when the log is replayed.
+func (w *WAL) Checkpoint(save func() error) error {
+	w.checkpointMu.Lock()
+	defer w.checkpointMu.Unlock()
+
+	sealed := sealedWALFilename(w.path)
+	if err := w.seal(sealed); err != nil {
+		return err
+	}
+
+	if err := save(); err != nil {
+		// The sealed records stay on disk and are folded into the next checkpoint
+		return err
+	}
+
+	if err := os.Remove(sealed); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// seal moves the current records to the sealed segment and starts an empty log.
+// A sealed segment left by a failed checkpoint is extended instead of replaced.
+func (w *WAL) seal(sealed string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.broken != nil {
+		return fmt.Errorf("write-ahead log unavailable: %w", w.broken)
+	}
+
+	if _, err := os.Stat(sealed); err == nil {
+		if err := appendFile(sealed, w.path); err != nil {
+			return err
+		}
+		if err := w.file.Truncate(0); err != nil {
+			w.broken = err
+			return fmt.Errorf("failed to reset write-ahead log: %w", err)
+		}
+		w.size = 0
+		return w.writeHeader()
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close write-ahead log: %w", err)
+	}
+	if err := os.Rename(w.path, sealed); err != nil {
+		w.broken = err
+		return fmt.Errorf("failed to seal write-ahead log: %w", err)
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		w.broken = err
+		return fmt.Errorf("failed to reopen write-ahead log: %w", err)
+	}
+	w.file = file
+	w.size = 0
+	return w.writeHeader()
+}
+
+// appendFile appends the contents of src to dst and syncs dst
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open sealed write-ahead log: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to extend sealed write-ahead log: %w", err)
+	}
+	return out.Sync()
+}
+
+// readWAL decodes the records in a log segment. It returns the length of the
+// valid prefix; a torn or corrupt final record is left out, while corruption
+// followed by further data is reported as an error.
+func readWAL(path string) ([]walRecord, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+
+	var records []walRecord
+	var offset int64
+	size := int64(len(data))
+	for offset < size {
+		if size-offset < walHeaderSize {
+			break // torn header
+		}
+		length := int64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		checksum := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		end := offset + walHeaderSize + length
+		if end > size {
+			break // torn payload
+		}
+
+		payload := data[offset+walHeaderSize : end]
+		var record walRecord
+		if crc32.ChecksumIEEE(payload) != checksum || json.Unmarshal(payload, &record) != nil {
+			if end == size {
+				break // corrupt final record
+			}
+			return nil, 0, fmt.Errorf("corrupt write-ahead log record at offset %d in %s", offset, path)
+		}
+
+		records = append(records, record)
+		offset = end
+	}
+	return records, offset, nil
+}
+
+// repairWAL truncates a log segment to its valid prefix
+func repairWAL(path string) error {
+	_, valid, err := readWAL(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat write-ahead log: %w", err)
+	}
+	if info.Size() == valid {
+		return nil
+	}
+	if err := os.Truncate(path, valid); err != nil {
+		return fmt.Errorf("failed to discard torn write-ahead log record: %w", err)
+	}
+	return nil
+}
+
+// replayWAL applies the sealed and current log segments in order, skipping
+// the records up to sequence number after, which the snapshot already holds.
+// It returns the sequence number of the last record applied. A log that
+// starts past after, or skips a record, fails with ErrWALMismatch. Batches
+// are passed to apply one mutation at a time.
+func replayWAL(walPath string, after int64, apply func(walRecord) error) (int64, int, error) {
+	last, replayed := after, 0
+	for _, segment := range []string{sealedWALFilename(walPath), walPath} {
+		records, _, err := readWAL(segment)
+		if err != nil {
+			return last, replayed, err
+		}
+		for _, record := range records {
+			switch {
+			case record.Op == opWALHeader:
+				if record.After > last {
+					return last, replayed, fmt.Errorf("%w: %s starts after record %d, the snapshot holds records up to %d",
+						ErrWALMismatch, segment, record.After, last)
+				}
+				continue
+			case record.Seq == 0:
+				// Logs written before sequence numbers are replayed whole
+			case record.Seq <= last:
+				continue
+			case record.Seq != last+1:
+				return last, replayed, fmt.Errorf("%w: %s continues with record %d, the snapshot holds records up to %d",
+					ErrWALMismatch, segment, record.Seq, last)
+			default:
+				last = record.Seq
+			}
+			if err := applyWALRecord(record, apply); err != nil {
+				return last, replayed, err
+			}
+			replayed++
+		}
+	}
+	return last, replayed, nil
+}
+
+// countWALMutations returns the number of mutations in a log segment that a
+// snapshot holding the records up to sequence number after does not hold
+func countWALMutations(records []walRecord, after int64) int {
+	count := 0
+	for _, record := range records {
+		if record.Op != opWALHeader && (record.Seq == 0 || record.Seq > after) {
+			count++
+		}
+	}
+	return count
+}
+
+// applyWALRecord applies a record, expanding batches
+func applyWALRecord(record walRecord, apply func(walRecord) error) error {
+	if record.Op != OpBatch {
+		return apply(record)
+	}
+	for _, child := range record.Batch {
+		if err := applyWALRecord(child, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"online-bookstore-api/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// walRecordIDs returns "<entity>/<op>/<id>" for every record but the file
+// headers
+func walRecordIDs(records []walRecord) []string {
+	var ids []string
+	for _, record := range records {
+		if record.Op == opWALHeader {
+			continue
+		}
+		ids = append(ids, fmt.Sprintf("%s/%s/%d", record.Entity, record.Op, record.ID))
+	}
+	return ids
+}
+
+func appendMutations(t *testing.T, path string, mutations ...Mutation) {
+	t.Helper()
+	wal, err := OpenWAL(path, 0)
+	if err != nil {
+		t.Fatalf("OpenWAL error = %v", err)
+	}
+	defer wal.Close()
+	for _, m := range mutations {
+		if err := wal.Append(m); err != nil {
+			t.Fatalf("Append(%+v) error = %v", m, err)
+		}
+	}
+}
+
+func TestReadWAL(t *testing.T) {
+	mutations := []Mutation{
+		{Entity: EntityBook, Op: OpCreate, ID: 1, Value: map[string]int{"id": 1}},
+		{Entity: EntityBook, Op: OpUpdate, ID: 1, Value: map[string]int{"id": 1}},
+		{Entity: EntityAuthor, Op: OpDelete, ID: 2},
+	}
+
+	tests := []struct {
+		name    string
+		damage  func(data []byte) []byte
+		want    []string
+		wantCut bool // whether the valid prefix is shorter than the log written
+		wantErr bool
+	}{
+		{
+			name:   "intact",
+			damage: func(data []byte) []byte { return data },
+			want:   []string{"book/create/1", "book/update/1", "author/delete/2"},
+		},
+		{
+			name:   "torn header",
+			damage: func(data []byte) []byte { return append(data, 1, 2, 3) },
+			want:   []string{"book/create/1", "book/update/1", "author/delete/2"},
+		},
+		{
+			name:    "torn payload",
+			damage:  func(data []byte) []byte { return data[:len(data)-2] },
+			want:    []string{"book/create/1", "book/update/1"},
+			wantCut: true,
+		},
+		{
+			name: "corrupt final record",
+			damage: func(data []byte) []byte {
+				data[len(data)-2] ^= 0xff
+				return data
+			},
+			want:    []string{"book/create/1", "book/update/1"},
+			wantCut: true,
+		},
+		{
+			name: "corrupt record followed by data",
+			damage: func(data []byte) []byte {
+				data[walHeaderSize+1] ^= 0xff
+				return data
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.json.wal")
+			appendMutations(t, path, mutations...)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			full := int64(len(data))
+			if err := os.WriteFile(path, tt.damage(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			records, valid, err := readWAL(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readWAL error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := walRecordIDs(records); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readWAL records = %v, want %v", got, tt.want)
+			}
+			if cut := valid < full; cut != tt.wantCut || valid > full {
+				t.Errorf("readWAL valid = %d of %d bytes written, want cut %v", valid, full, tt.wantCut)
+			}
+		})
+	}
+}
+
+func TestReadWALMissingFile(t *testing.T) {
+	records, valid, err := readWAL(filepath.Join(t.TempDir(), "missing.wal"))
+	if err != nil || records != nil || valid != 0 {
+		t.Errorf("readWAL = %v, %d, %v; want no records and no error", records, valid, err)
+	}
+}
+
+func TestOpenWALRepairsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	appendMutations(t, path, Mutation{Entity: EntityBook, Op: OpDelete, ID: 1})
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{42, 0, 0, 0, 1})
+	file.Close()
+
+	// Records appended after the repair must not follow the torn bytes
+	appendMutations(t, path, Mutation{Entity: EntityBook, Op: OpDelete, ID: 2})
+	records, _, err := readWAL(path)
+	if err != nil {
+		t.Fatalf("readWAL error = %v", err)
+	}
+	want := []string{"book/delete/1", "book/delete/2"}
+	if got := walRecordIDs(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestReplayWAL(t *testing.T) {
+	batch := Mutation{Op: OpBatch, Batch: []Mutation{
+		{Entity: EntityBook, Op: OpUpdate, ID: 1, Value: map[string]int{"stock": 4}},
+		{Entity: EntityBook, Op: OpUpdate, ID: 2, Value: map[string]int{"stock": 0}},
+	}}
+
+	tests := []struct {
+		name         string
+		sealed       []Mutation
+		current      []Mutation
+		failOn       string
+		want         []string
+		wantReplayed int
+		wantErr      bool
+	}{
+		{
+			name:         "empty",
+			want:         nil,
+			wantReplayed: 0,
+		},
+		{
+			name:         "sealed segment first",
+			sealed:       []Mutation{{Entity: EntityAuthor, Op: OpCreate, ID: 1, Value: 1}},
+			current:      []Mutation{{Entity: EntityAuthor, Op: OpDelete, ID: 1}},
+			want:         []string{"author/create/1", "author/delete/1"},
+			wantReplayed: 2,
+		},
+		{
+			name:         "batch expanded",
+			current:      []Mutation{batch, {Entity: EntityOrder, Op: OpDelete, ID: 3}},
+			want:         []string{"book/update/1", "book/update/2", "order/delete/3"},
+			wantReplayed: 2,
+		},
+		{
+			name:         "apply error stops replay",
+			current:      []Mutation{{Entity: EntityOrder, Op: OpDelete, ID: 1}, batch, {Entity: EntityOrder, Op: OpDelete, ID: 3}},
+			failOn:       "book/update/2",
+			want:         []string{"order/delete/1", "book/update/1", "book/update/2"},
+			wantReplayed: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.json.wal")
+			if len(tt.sealed) > 0 {
+				appendMutations(t, sealedWALFilename(path), tt.sealed...)
+			}
+			if len(tt.current) > 0 {
+				appendMutations(t, path, tt.current...)
+			}
+
+			var got []string
+			errApply := errors.New("apply failed")
+			_, replayed, err := replayWAL(path, 0, func(record walRecord) error {
+				id := walRecordIDs([]walRecord{record})[0]
+				got = append(got, id)
+				if id == tt.failOn {
+					return errApply
+				}
+				return nil
+			})
+			if tt.wantErr != errors.Is(err, errApply) {
+				t.Fatalf("replayWAL error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applied %v, want %v", got, tt.want)
+			}
+			if replayed != tt.wantReplayed {
+				t.Errorf("replayed = %d, want %d", replayed, tt.wantReplayed)
+			}
+		})
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	wal, err := OpenWAL(path, 0)
+	if err != nil {
+		t.Fatalf("OpenWAL error = %v", err)
+	}
+	defer wal.Close()
+
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 1})
+	errSave := errors.New("save failed")
+	if err := wal.Checkpoint(func() error { return errSave }); !errors.Is(err, errSave) {
+		t.Fatalf("Checkpoint error = %v, want %v", err, errSave)
+	}
+
+	// A failed checkpoint keeps the sealed records for the next one
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 2})
+	var sealed []string
+	err = wal.Checkpoint(func() error {
+		records, _, err := readWAL(sealedWALFilename(path))
+		sealed = walRecordIDs(records)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Checkpoint error = %v", err)
+	}
+	if want := []string{"book/delete/1", "book/delete/2"}; !reflect.DeepEqual(sealed, want) {
+		t.Errorf("sealed records = %v, want %v", sealed, want)
+	}
+	if _, err := os.Stat(sealedWALFilename(path)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("sealed segment still exists after checkpoint: %v", err)
+	}
+
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 3})
+	records, _, err := readWAL(path)
+	if err != nil {
+		t.Fatalf("readWAL error = %v", err)
+	}
+	if got, want := walRecordIDs(records), []string{"book/delete/3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records after checkpoint = %v, want %v", got, want)
+	}
+}
+
+func TestLoadDatabaseReplaysWAL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "database.json")
+	wal, err := OpenWAL(WALFilename(filename), 0)
+	if err != nil {
+		t.Fatalf("OpenWAL error = %v", err)
+	}
+	ctx := context.Background()
+	books := NewInMemoryBookStore()
+	books.SetMutationHook(wal.Append)
+	for _, stock := range []int{5, 2, 7} {
+		if _, err := books.CreateBook(ctx, models.Book{Title: "Book", Stock: stock}); err != nil {
+			t.Fatalf("CreateBook error = %v", err)
+		}
+	}
+	if err := books.AdjustStock(ctx, map[int]int{1: -3, 2: -2}); err != nil {
+		t.Fatalf("AdjustStock error = %v", err)
+	}
+	if err := books.DeleteBook(ctx, 3); err != nil {
+		t.Fatalf("DeleteBook error = %v", err)
+	}
+	wal.Close()
+
+	loaded := NewInMemoryBookStore()
+	if _, err := LoadDatabase(filename, loaded); err != nil {
+		t.Fatalf("LoadDatabase error = %v", err)
+	}
+	want, _ := books.Snapshot()
+	got, _ := loaded.Snapshot()
+	if string(got.Records) != string(want.Records) || got.NextID != want.NextID {
+		t.Errorf("replayed books = %s next %d, want %s next %d", got.Records, got.NextID, want.Records, want.NextID)
+	}
+}
+
+func TestWALSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	wal, err := OpenWAL(path, 4)
+	if err != nil {
+		t.Fatalf("OpenWAL error = %v", err)
+	}
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 1})
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 2})
+	if err := wal.Checkpoint(func() error { return nil }); err != nil {
+		t.Fatalf("Checkpoint error = %v", err)
+	}
+	wal.Close()
+
+	// Numbering goes on from the log when it is ahead of the snapshot
+	wal, err = OpenWAL(path, 3)
+	if err != nil {
+		t.Fatalf("OpenWAL error = %v", err)
+	}
+	defer wal.Close()
+	if got := wal.Sequence(); got != 6 {
+		t.Errorf("Sequence after reopening = %d, want 6", got)
+	}
+	wal.Append(Mutation{Entity: EntityBook, Op: OpDelete, ID: 3})
+
+	records, _, err := readWAL(path)
+	if err != nil {
+		t.Fatalf("readWAL error = %v", err)
+	}
+	if len(records) != 2 || records[0].Op != opWALHeader || records[0].After != 6 || records[1].Seq != 7 {
+		t.Errorf("records = %+v, want a header after 6 and record 7", records)
+	}
+}
+
+func TestReplayWALSequence(t *testing.T) {
+	record := func(seq int64, id int) walRecord {
+		return walRecord{Seq: seq, Entity: EntityBook, Op: OpDelete, ID: id}
+	}
+	header := func(after int64) walRecord {
+		return walRecord{Op: opWALHeader, After: after}
+	}
+
+	tests := []struct {
+		name     string
+		after    int64
+		sealed   []walRecord
+		current  []walRecord
+		want     []string
+		wantLast int64
+		wantErr  bool
+	}{
+		{
+			name:     "records held by the snapshot skipped",
+			after:    2,
+			sealed:   []walRecord{header(0), record(1, 1), record(2, 2)},
+			current:  []walRecord{header(2), record(3, 3)},
+			want:     []string{"book/delete/3"},
+			wantLast: 3,
+		},
+		{
+			name:     "snapshot taken after the log was sealed",
+			after:    3,
+			current:  []walRecord{header(2), record(3, 3), record(4, 4)},
+			want:     []string{"book/delete/4"},
+			wantLast: 4,
+		},
+		{
+			name:     "unnumbered records replayed whole",
+			current:  []walRecord{record(0, 1), record(0, 2), record(1, 3)},
+			want:     []string{"book/delete/1", "book/delete/2", "book/delete/3"},
+			wantLast: 1,
+		},
+		{
+			name:     "log starts after the snapshot",
+			after:    2,
+			current:  []walRecord{header(5), record(6, 6)},
+			wantLast: 2,
+			wantErr:  true,
+		},
+		{
+			name:     "record missing",
+			after:    2,
+			current:  []walRecord{header(2), record(3, 3), record(5, 5)},
+			want:     []string{"book/delete/3"},
+			wantLast: 3,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.json.wal")
+			for _, segment := range []struct {
+				path    string
+				records []walRecord
+			}{{sealedWALFilename(path), tt.sealed}, {path, tt.current}} {
+				if len(segment.records) == 0 {
+					continue
+				}
+				file, err := os.Create(segment.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wal := &WAL{path: segment.path, file: file}
+				for _, record := range segment.records {
+					if err := wal.write(record); err != nil {
+						t.Fatal(err)
+					}
+				}
+				wal.Close()
+			}
+
+			var got []string
+			last, _, err := replayWAL(path, tt.after, func(record walRecord) error {
+				got = append(got, walRecordIDs([]walRecord{record})...)
+				return nil
+			})
+			if tt.wantErr != errors.Is(err, ErrWALMismatch) {
+				t.Fatalf("replayWAL error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applied %v, want %v", got, tt.want)
+			}
+			if last != tt.wantLast {
+				t.Errorf("last = %d, want %d", last, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestLoadDatabaseFallsBackToBackup(t *testing.T) {
+	errCrash := errors.New("crashed")
+	tests := []struct {
+		name string
+		// deleteSealed reports whether the second checkpoint gets to delete
+		// the sealed records once its snapshot is written
+		deleteSealed bool
+		wantStock    map[int]int
+		wantErr      bool
+	}{
+		{name: "log continues from the backup", wantStock: map[int]int{1: 4, 2: 2}},
+		{name: "log continues from the lost snapshot", deleteSealed: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			filename := filepath.Join(t.TempDir(), "database.json")
+			wal, err := OpenWAL(WALFilename(filename), 0)
+			if err != nil {
+				t.Fatalf("OpenWAL error = %v", err)
+			}
+			books := NewInMemoryBookStore()
+			books.SetMutationHook(wal.Append)
+			save := func() error {
+				data, err := CaptureDatabase(books)
+				if err != nil {
+					return err
+				}
+				data.Sequence = wal.Sequence()
+				return WriteDatabase(filename, data)
+			}
+
+			books.CreateBook(ctx, models.Book{Title: "First", Stock: 5})
+			if err := wal.Checkpoint(save); err != nil {
+				t.Fatalf("Checkpoint error = %v", err)
+			}
+			books.CreateBook(ctx, models.Book{Title: "Second", Stock: 2})
+			err = wal.Checkpoint(func() error {
+				if err := save(); err != nil || tt.deleteSealed {
+					return err
+				}
+				return errCrash
+			})
+			if err != nil && !errors.Is(err, errCrash) {
+				t.Fatalf("Checkpoint error = %v", err)
+			}
+			books.AdjustStock(ctx, map[int]int{1: -1})
+			wal.Close()
+
+			// The second snapshot is lost; the backup holds the first
+			if err := os.WriteFile(filename, []byte(`{"books": {`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := NewInMemoryBookStore()
+			sequence, err := LoadDatabase(filename, loaded)
+			if tt.wantErr {
+				if !errors.Is(err, ErrWALMismatch) {
+					t.Fatalf("LoadDatabase error = %v, want %v", err, ErrWALMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDatabase error = %v", err)
+			}
+			if sequence != 3 {
+				t.Errorf("sequence = %d, want 3", sequence)
+			}
+			all, _ := loaded.GetAllBooks(ctx)
+			stock := map[int]int{}
+			for _, book := range all {
+				stock[book.ID] = book.Stock
+			}
+			if !reflect.DeepEqual(stock, tt.wantStock) {
+				t.Errorf("restored stock = %v, want %v", stock, tt.wantStock)
+			}
+		})
+	}
+}